@@ -10,6 +10,7 @@ import (
 type Node interface {
 	TokenLieteral() string
 	String() string
+	Pos() token.Position
 }
 
 type Statement interface {
@@ -24,12 +25,24 @@ type Expression interface {
 
 type Program struct {
 	Statements []Statement
+	// Comments are the comments of the program, in source order
+	Comments []token.Comment
 }
 
 func (p *Program) TokenLieteral() string {
 	return ""
 }
 
+// Pos is the position of the first statement, a program built by hand can have nil ones
+func (p *Program) Pos() token.Position {
+	for _, statement := range p.Statements {
+		if statement != nil {
+			return statement.Pos()
+		}
+	}
+	return token.Position{}
+}
+
 func (p *Program) String() string {
 	var buffer bytes.Buffer
 	for _, statement := range p.Statements {
@@ -52,6 +65,10 @@ func (l *LetStatement) TokenLieteral() string {
 	return l.Token.Literal
 }
 
+func (l *LetStatement) Pos() token.Position {
+	return l.Token.Pos
+}
+
 func (l *LetStatement) String() string {
 	var buffer bytes.Buffer
 
@@ -76,6 +93,10 @@ func (r *ReturnStatement) TokenLieteral() string {
 	return r.Token.Literal
 }
 
+func (r *ReturnStatement) Pos() token.Position {
+	return r.Token.Pos
+}
+
 func (r *ReturnStatement) String() string {
 	var buffer bytes.Buffer
 
@@ -101,6 +122,10 @@ func (es *ExpressionStatement) TokenLieteral() string {
 	return es.Token.Literal
 }
 
+func (es *ExpressionStatement) Pos() token.Position {
+	return es.Token.Pos
+}
+
 func (ex *ExpressionStatement) String() string {
 	var buffer bytes.Buffer
 	buffer.WriteString(ex.Value.String())
@@ -119,6 +144,10 @@ func (i *Identifier) TokenLieteral() string {
 	return i.Token.Literal
 }
 
+func (i *Identifier) Pos() token.Position {
+	return i.Token.Pos
+}
+
 func (i *Identifier) String() string {
 	return i.Value
 }
@@ -134,6 +163,10 @@ func (i *Integer) TokenLieteral() string {
 	return i.Token.Literal
 }
 
+func (i *Integer) Pos() token.Position {
+	return i.Token.Pos
+}
+
 func (i *Integer) String() string {
 	return i.Token.Literal
 }
@@ -149,6 +182,10 @@ func (b *Boolean) TokenLieteral() string {
 	return b.Token.Literal
 }
 
+func (b *Boolean) Pos() token.Position {
+	return b.Token.Pos
+}
+
 func (b *Boolean) String() string {
 	return b.Token.Literal
 }
@@ -164,6 +201,10 @@ func (s *String) TokenLieteral() string {
 	return s.Token.Literal
 }
 
+func (s *String) Pos() token.Position {
+	return s.Token.Pos
+}
+
 func (s *String) String() string {
 	return s.Value
 }
@@ -180,6 +221,10 @@ func (p *PrefixExpression) TokenLieteral() string {
 	return p.Token.Literal
 }
 
+func (p *PrefixExpression) Pos() token.Position {
+	return p.Token.Pos
+}
+
 func (p *PrefixExpression) String() string {
 	var buffer bytes.Buffer
 	buffer.WriteString("(")
@@ -202,6 +247,10 @@ func (i *InfixExpression) TokenLieteral() string {
 	return i.Token.Literal
 }
 
+func (i *InfixExpression) Pos() token.Position {
+	return i.Token.Pos
+}
+
 func (i *InfixExpression) String() string {
 	var buffer bytes.Buffer
 	buffer.WriteString("(")
@@ -226,6 +275,10 @@ func (p *PostfixExpression) TokenLieteral() string {
 	return p.Token.Literal
 }
 
+func (p *PostfixExpression) Pos() token.Position {
+	return p.Token.Pos
+}
+
 func (p *PostfixExpression) String() string {
 	var buffer bytes.Buffer
 	buffer.WriteString("(")
@@ -235,9 +288,45 @@ func (p *PostfixExpression) String() string {
 	return buffer.String()
 }
 
+// SliceExpression is Left[Start:End]. Start and End are nil when they are omitted
+type SliceExpression struct {
+	Token token.Token
+	Left  Expression
+	Start Expression
+	End   Expression
+}
+
+func (s *SliceExpression) expressionNode() {}
+
+func (s *SliceExpression) TokenLieteral() string {
+	return s.Token.Literal
+}
+
+func (s *SliceExpression) Pos() token.Position {
+	return s.Token.Pos
+}
+
+func (s *SliceExpression) String() string {
+	var buffer bytes.Buffer
+	buffer.WriteString("(")
+	buffer.WriteString(s.Left.String())
+	buffer.WriteString(" [ ")
+	if s.Start != nil {
+		buffer.WriteString(s.Start.String())
+	}
+	buffer.WriteString(":")
+	if s.End != nil {
+		buffer.WriteString(s.End.String())
+	}
+	buffer.WriteString(")")
+	return buffer.String()
+}
+
 type BlockExpression struct {
 	Token      token.Token
 	Statements []Statement
+	// End is the position of the closing brace
+	End token.Position
 }
 
 func (b *BlockExpression) expressionNode() {}
@@ -246,6 +335,10 @@ func (b *BlockExpression) TokenLieteral() string {
 	return b.Token.Literal
 }
 
+func (b *BlockExpression) Pos() token.Position {
+	return b.Token.Pos
+}
+
 func (b *BlockExpression) String() string {
 	var buffer bytes.Buffer
 	buffer.WriteString("{")
@@ -271,6 +364,10 @@ func (i *IfExpression) TokenLieteral() string {
 	return i.Token.Literal
 }
 
+func (i *IfExpression) Pos() token.Position {
+	return i.Token.Pos
+}
+
 func (i *IfExpression) String() string {
 	var buffer bytes.Buffer
 	buffer.WriteString("if ")
@@ -297,6 +394,10 @@ func (f *FunctionExpression) TokenLieteral() string {
 	return f.Token.Literal
 }
 
+func (f *FunctionExpression) Pos() token.Position {
+	return f.Token.Pos
+}
+
 func (f *FunctionExpression) String() string {
 	var buffer bytes.Buffer
 	buffer.WriteString("(")
@@ -323,6 +424,8 @@ type CallExpression struct {
 	Token     token.Token
 	Function  Expression
 	Arguments []Expression
+	// End is the position of the closing parenthesis
+	End token.Position
 }
 
 func (c *CallExpression) expressionNode() {}
@@ -331,6 +434,10 @@ func (c *CallExpression) TokenLieteral() string {
 	return c.Token.Literal
 }
 
+func (c *CallExpression) Pos() token.Position {
+	return c.Token.Pos
+}
+
 func (c *CallExpression) String() string {
 	var buffer bytes.Buffer
 	buffer.WriteString(c.Function.String())
@@ -350,6 +457,8 @@ func (c *CallExpression) String() string {
 type ArrayLiteral struct {
 	Token    token.Token
 	Elements []Expression
+	// End is the position of the closing bracket
+	End token.Position
 }
 
 func (a *ArrayLiteral) expressionNode() {}
@@ -358,6 +467,10 @@ func (a *ArrayLiteral) TokenLieteral() string {
 	return a.Token.Literal
 }
 
+func (a *ArrayLiteral) Pos() token.Position {
+	return a.Token.Pos
+}
+
 func (a *ArrayLiteral) String() string {
 	var buffer bytes.Buffer
 	buffer.WriteString("[")
@@ -372,9 +485,19 @@ func (a *ArrayLiteral) String() string {
 	return buffer.String()
 }
 
+// HashPair is a key/value pair of a HashLiteral
+type HashPair struct {
+	Key   Expression
+	Value Expression
+}
+
+// HashLiteral keeps its pairs in source order so evaluating and compiling
+// the literal is deterministic
 type HashLiteral struct {
 	Token token.Token
-	Pair  map[Expression]Expression
+	Pairs []*HashPair
+	// End is the position of the closing brace
+	End token.Position
 }
 
 func (h *HashLiteral) expressionNode() {}
@@ -383,13 +506,17 @@ func (h *HashLiteral) TokenLieteral() string {
 	return h.Token.Literal
 }
 
+func (h *HashLiteral) Pos() token.Position {
+	return h.Token.Pos
+}
+
 func (h *HashLiteral) String() string {
 	var buffer bytes.Buffer
 	buffer.WriteString("{")
 
 	pairs := []string{}
-	for k, v := range h.Pair {
-		pairs = append(pairs, fmt.Sprintf("%s:%s", k.String(), v.String()))
+	for _, p := range h.Pairs {
+		pairs = append(pairs, fmt.Sprintf("%s:%s", p.Key.String(), p.Value.String()))
 	}
 	buffer.WriteString(strings.Join(pairs, ", "))
 	buffer.WriteString("}")
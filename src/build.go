@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"golang"
+	"io/ioutil"
+	"os"
+	"parser"
+	"path/filepath"
+	"strings"
+)
+
+// build translates a gorilla program into a go program, which go build makes a native binary of
+func build(args []string) int {
+	flags := flag.NewFlagSet("build", flag.ExitOnError)
+	target := flags.String("target", "go", "the language to translate the program into, only go is supported")
+	output := flags.String("o", "", "the file to write, the program file with the extension .go by default")
+	noOptimize := flags.Bool("no-optimize", false, "translate without constant folding and dead code elimination")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: gorilla build --target go [-o file.go] file.gor\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	if *target != "go" {
+		fmt.Fprintf(os.Stderr, "unsupported target %q\n", *target)
+		return 2
+	}
+
+	file := flags.Arg(0)
+	if *output == "" {
+		*output = strings.TrimSuffix(file, filepath.Ext(file)) + ".go"
+	}
+
+	input, err := ioutil.ReadFile(file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "read program failed: %s\n", err)
+		return 1
+	}
+
+	program, err := parser.New(string(input)).ParseProgram()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "parse program failed: %s\n", err)
+		return 1
+	}
+
+	t := golang.New()
+	if *noOptimize {
+		t.DisableOptimizations()
+	}
+	if err := t.Translate(program); err != nil {
+		fmt.Fprintf(os.Stderr, "compile program failed: %s\n", err)
+		return 1
+	}
+
+	src, err := t.Source("main")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "compile program failed: %s\n", err)
+		return 1
+	}
+
+	if err := ioutil.WriteFile(*output, src, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "write program failed: %s\n", err)
+		return 1
+	}
+	return 0
+}
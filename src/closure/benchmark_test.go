@@ -0,0 +1,52 @@
+package closure
+
+import (
+	"object"
+	"parser"
+	"testing"
+)
+
+var benchmarks = []struct {
+	name   string
+	input  string
+	expect string
+}{
+	{
+		name: "fib",
+		input: `
+		let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } };
+		fib(20)`,
+		expect: "6765",
+	},
+	{
+		name: "loop",
+		input: `
+		let loop = fn(i, sum) { if (i == 0) { sum } else { loop(i - 1, sum + i) } };
+		loop(100000, 0)`,
+		expect: "5000050000",
+	},
+}
+
+func BenchmarkRun(b *testing.B) {
+	for _, bench := range benchmarks {
+		b.Run(bench.name, func(b *testing.B) {
+			program, err := parser.New(bench.input).ParseProgram()
+			if err != nil {
+				b.Fatalf("parse failed: %s", err)
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				compiled, err := Compile(program, NewGlobals())
+				if err != nil {
+					b.Fatalf("compile failed: %s", err)
+				}
+
+				ret := compiled.Run()
+				if _, ok := ret.(*object.Error); ok || ret.Inspect() != bench.expect {
+					b.Fatalf("wrong result: %s", ret.Inspect())
+				}
+			}
+		})
+	}
+}
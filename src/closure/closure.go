@@ -0,0 +1,167 @@
+// Package closure runs programs by compiling their ast once into a tree of go closures.
+// Variables are resolved to slots ahead of time, so running a program neither switches
+// on node types like the evaluator nor decodes instructions like the vm. Its semantics
+// are the ones of the evaluator
+package closure
+
+import (
+	"ast"
+	"bytes"
+	"context"
+	"fmt"
+	"object"
+	"strings"
+)
+
+// MaxCallDepth is the number of nested function calls allowed, the same as the frames of the vm
+const MaxCallDepth = 1023
+
+// env holds the variables of one call of a function, or the globals. outer is the env
+// the function is defined in
+type env struct {
+	slots []object.Object
+	outer *env
+}
+
+// code is a node compiled into a closure, it evaluates the node in env. It returns nil
+// when the node does not finish with a value: the program failed with m.err, a return
+// statement leaves its function with m.ret, or a call in tail position leaves the
+// function for m.tail
+type code func(m *machine, env *env) object.Object
+
+type machine struct {
+	budget *object.Budget
+	depth  int
+	seed   object.HashSeed
+
+	err *object.Error
+	ret object.Object
+
+	tail     *Function
+	tailArgs []object.Object
+}
+
+// fail makes the program fail with err at the position of node
+func (m *machine) fail(err error, node ast.Node) object.Object {
+	m.err = &object.Error{Msg: err.Error(), Pos: node.Pos(), Err: err}
+	return nil
+}
+
+// failMsg makes the program fail with msg at the position of node
+func (m *machine) failMsg(msg string, node ast.Node) object.Object {
+	m.err = &object.Error{Msg: msg, Pos: node.Pos()}
+	return nil
+}
+
+// alloc accounts obj as allocated by node and returns it
+func (m *machine) alloc(obj object.Object, node ast.Node) object.Object {
+	if err := m.budget.Alloc(obj); err != nil {
+		return m.fail(err, node)
+	}
+	return obj
+}
+
+// function is a compiled function expression, a Function is made of it each time the expression is evaluated
+type function struct {
+	node *ast.FunctionExpression
+	body code
+	// numSlots is the number of the parameters and the locals of the function
+	numSlots int
+}
+
+// Function is the value of a function expression with the env it is defined in
+type Function struct {
+	fn  *function
+	env *env
+}
+
+func (f *Function) Type() object.ObjectType {
+	return object.FUNCTION_OBJ
+}
+
+func (f *Function) Inspect() string {
+	var buffer bytes.Buffer
+	buffer.WriteString("fn (")
+
+	params := []string{}
+	for _, param := range f.fn.node.Parameters {
+		params = append(params, param.Value)
+	}
+
+	buffer.WriteString(strings.Join(params, ", "))
+	buffer.WriteString(") ")
+	buffer.WriteString(f.fn.node.Body.String())
+
+	return buffer.String()
+}
+
+// call calls fn with args. Calls in tail position in the body of fn return to this
+// loop, which calls them in place of fn, so tail calls do not nest
+func (m *machine) call(fn *Function, args []object.Object, node ast.Node) object.Object {
+	if err := m.budget.CallDepth(m.depth+1, MaxCallDepth); err != nil {
+		return m.fail(err, node)
+	}
+	m.depth++
+	defer func() { m.depth-- }()
+
+	for {
+		env := &env{slots: make([]object.Object, fn.fn.numSlots), outer: fn.env}
+		copy(env.slots, args)
+
+		ret := fn.fn.body(m, env)
+		switch {
+		case ret != nil:
+			return ret
+		case m.ret != nil:
+			ret, m.ret = m.ret, nil
+			return ret
+		case m.tail != nil:
+			fn, args = m.tail, m.tailArgs
+			m.tail, m.tailArgs = nil, nil
+		default:
+			return nil
+		}
+	}
+}
+
+// Program is a program compiled into closures
+type Program struct {
+	code    code
+	globals *Globals
+	seed    object.HashSeed
+}
+
+// SetHashSeed makes the hashes made by the runs of p hash their keys with seed
+func (p *Program) SetHashSeed(seed object.HashSeed) {
+	p.seed = seed
+}
+
+// Run runs the program. It never panics, any failure is returned as an *object.Error
+// with the position of the node which failed
+func (p *Program) Run() object.Object {
+	return p.RunContext(context.Background(), object.Limits{})
+}
+
+// RunContext is like Run but stops when ctx is done or the program exceeds limits.
+// The Err of the returned *object.Error then wraps object.ErrCanceled or the error of the limit
+func (p *Program) RunContext(ctx context.Context, limits object.Limits) (result object.Object) {
+	m := &machine{budget: object.NewBudget(ctx, limits), seed: p.seed}
+
+	defer func() {
+		if r := recover(); r != nil {
+			result = &object.Error{Msg: fmt.Sprintf("internal error: %v", r)}
+		}
+	}()
+
+	p.globals.grow()
+	ret := p.code(m, p.globals.env)
+	switch {
+	case ret != nil:
+		return ret
+	case m.ret != nil:
+		// return at top level ends the program with its value
+		return m.ret
+	default:
+		return m.err
+	}
+}
@@ -0,0 +1,232 @@
+package closure
+
+import (
+	"context"
+	"errors"
+	"object"
+	"parser"
+	"resolver"
+	"strings"
+	"testing"
+	"time"
+	"token"
+)
+
+func run(input string, globals *Globals, limits object.Limits) (object.Object, error) {
+	program, err := parser.New(input).ParseProgram()
+	if err != nil {
+		return nil, err
+	}
+
+	compiled, err := Compile(program, globals)
+	if err, ok := err.(*resolver.Error); ok {
+		// an unbound identifier fails the program before it is run
+		return &object.Error{Msg: err.Msg, Pos: err.Pos}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return compiled.RunContext(context.Background(), limits), nil
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		input  string
+		expect string
+	}{
+		{"", "null"},
+		{"1 + 2 * 3 - 4 / 2 % 3", "5"},
+		{"9223372036854775807 + 1", "9223372036854775808"},
+		{`"a" + "b"`, "ab"},
+		{"let a = 1; let b = a + 1; [a, b]", "[1, 2]"},
+		{"let a = 1", "1"},
+		{"if (1 < 2) { 10 } else { 20 }", "10"},
+		{"if (1 > 2) { 10 }", "null"},
+		{"if (true) { let a = 1; }; a", "1"},
+		{`{"a": [1, 2][1:]}["a"]`, "[2]"},
+		{"return 1; 2", "1"},
+		{"let f = fn(x) { if (x) { return 1; } 2 }; [f(true), f(false)]", "[1, 2]"},
+		{"let add = fn(a) { fn(b) { fn(c) { a + b + c } } }; add(1)(2)(3)", "6"},
+		{"let f = fn(n) { if (n < 2) { n } else { f(n - 1) + f(n - 2) } }; f(15)", "610"},
+		{"let f = fn() { let g = fn(n) { if (n == 0) { 0 } else { g(n - 1) } }; g(3) }; f()", "0"},
+		{"let x = 1; let f = fn() { let x = x + 1; x }; [f(), x]", "[2, 1]"},
+		{"let a = fn() { b }; let b = 1; a()", "1"},
+		{"let x = 1; let f = fn() { x = x + 1 }; f(); f(); x", "3"},
+		{"let f = fn(n) { n = n + 1; let g = fn() { n }; g() }; f(1)", "2"},
+		{"let len = fn(x) { 0 }; len([1])", "0"},
+		{`len(push([1], 2))`, "2"},
+	}
+
+	for _, test := range tests {
+		actual, err := run(test.input, NewGlobals(), object.Limits{})
+		if err != nil {
+			t.Fatalf("compile program for input: %q failed. error is: %q", test.input, err)
+		}
+
+		if actual.Inspect() != test.expect {
+			t.Errorf("wrong result for input: %q. want=%s, got=%s", test.input, test.expect, actual.Inspect())
+		}
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	tests := []struct {
+		input  string
+		expect string
+		pos    token.Position
+	}{
+		{"true + 5", "unknown operator: true + 5", token.Position{Line: 1, Column: 6}},
+		{"1 / 0", "division by zero", token.Position{Line: 1, Column: 3}},
+		{"let a = 1;\nlet b = [1, 2];\nb[a + 2]", "index out of range [3] with length 2", token.Position{Line: 3, Column: 2}},
+		{"let f = fn(x) {\n  x + true\n};\nf(1)", "unknown operator: x + true", token.Position{Line: 2, Column: 5}},
+		{"{[1, fn(x){x}]: 1}", "key type in HashLiteral is not Hashable. got \"ARRAY\"", token.Position{Line: 1, Column: 1}},
+		{"-true", "minus operator can not be used as prefix operator for BOOLEAN", token.Position{Line: 1, Column: 1}},
+		{"fn(a, b) { a }(1)", "wrong number of arguments: want=2 got=1", token.Position{Line: 1, Column: 15}},
+		{"1()", "unknown function: 1", token.Position{Line: 1, Column: 2}},
+		{"b", "unbound identifier: b", token.Position{Line: 1, Column: 1}},
+		{"a = 2", "unbound identifier: a", token.Position{Line: 1, Column: 1}},
+		{"1 = 2", "can not assign to 1", token.Position{Line: 1, Column: 3}},
+		{"fn() { let a = a; a }()", "unbound identifier: a", token.Position{Line: 1, Column: 16}},
+		{"let counter = fn() { let n = 0; fn() { n = n + 1 } }", "can not assign to n, it is captured by a function", token.Position{Line: 1, Column: 40}},
+		{"fn() { let n = 0; let g = fn() { n }; n = 1 }", "can not assign to n, it is captured by a function", token.Position{Line: 1, Column: 39}},
+		{"fn() { let n = 0; let g = fn() { n }; let n = 1 }", "can not define n again, it is captured by a function", token.Position{Line: 1, Column: 43}},
+		{"len = 1", "can not assign to builtin len", token.Position{Line: 1, Column: 1}},
+		{`len(1)`, "argument to `len` not supported, got INTEGER", token.Position{Line: 1, Column: 4}},
+	}
+
+	for _, test := range tests {
+		actual, err := run(test.input, NewGlobals(), object.Limits{})
+		if err != nil {
+			t.Fatalf("compile program for input: %q failed. error is: %q", test.input, err)
+		}
+
+		error, ok := actual.(*object.Error)
+		if !ok {
+			t.Fatalf("need an error for input: %q. but got %s", test.input, actual.Inspect())
+		}
+
+		if error.Msg != test.expect {
+			t.Errorf("wrong error for input: %q. want=%q, got=%q", test.input, test.expect, error.Msg)
+		}
+
+		if error.Pos != test.pos {
+			t.Errorf("wrong error position for input: %q. want=%+v, got=%+v", test.input, test.pos, error.Pos)
+		}
+	}
+}
+
+func TestGlobals(t *testing.T) {
+	globals := NewGlobals()
+	inputs := []struct {
+		input  string
+		expect string
+	}{
+		{"let a = 1; let b = 0; let f = fn(x) { x + a + b }", "fn (x) {((x + a) + b); }"},
+		{"let b = 2", "2"},
+		{"f(3)", "6"},
+		{"let a = 10; f(3)", "15"},
+	}
+
+	for _, test := range inputs {
+		actual, err := run(test.input, globals, object.Limits{})
+		if err != nil {
+			t.Fatalf("compile program for input: %q failed. error is: %q", test.input, err)
+		}
+
+		if actual.Inspect() != test.expect {
+			t.Errorf("wrong result for input: %q. want=%s, got=%s", test.input, test.expect, actual.Inspect())
+		}
+	}
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []struct {
+		input  string
+		expect string
+	}{
+		{"let countDown = fn(n) { if (n == 0) { return 0; } countDown(n - 1) }; countDown(100000)", "0"},
+		{"let countDown = fn(n) { if (n == 0) { return 0; } return countDown(n - 1); }; countDown(100000)", "0"},
+		{"let even = fn(n) { if (n == 0) { true } else { odd(n - 1) } }; let odd = fn(n) { if (n == 0) { false } else { even(n - 1) } }; even(100001)", "false"},
+		{"let f = fn(arr) { len(arr) }; f([1, 2]) + 1", "3"},
+	}
+
+	for _, test := range tests {
+		actual, err := run(test.input, NewGlobals(), object.Limits{})
+		if err != nil {
+			t.Fatalf("compile program for input: %q failed. error is: %q", test.input, err)
+		}
+
+		if actual.Inspect() != test.expect {
+			t.Errorf("wrong result for input: %q. want=%s, got=%s", test.input, test.expect, actual.Inspect())
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	exponential := "let f = fn(n) { if (n == 0) { 0 } else { f(n - 1) + f(n - 1) } }; f(40)"
+
+	tests := []struct {
+		input  string
+		limits object.Limits
+		expect error
+	}{
+		{"let f = fn() { 1 + f() }; f()", object.Limits{}, object.ErrCallDepthLimit},
+		{"let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(100000)", object.Limits{}, object.ErrCallDepthLimit},
+		{"let f = fn(n) { 1 + f(n) }; f(1)", object.Limits{MaxCallDepth: 10}, object.ErrCallDepthLimit},
+		{"let f = fn(n) { f(n) }; f(1)", object.Limits{MaxInstructions: 1000}, object.ErrInstructionLimit},
+		{exponential, object.Limits{MaxInstructions: 1000}, object.ErrInstructionLimit},
+		{"[[1], [2], [3]]", object.Limits{MaxAllocations: 3}, object.ErrAllocationLimit},
+		{`let f = fn(s) { s + s + s }; f("abc")`, object.Limits{MaxAllocatedBytes: 50}, object.ErrMemoryLimit},
+		{exponential, object.Limits{Timeout: 10 * time.Millisecond}, object.ErrTimeLimit},
+	}
+
+	for _, test := range tests {
+		actual, err := run(test.input, NewGlobals(), test.limits)
+		if err != nil {
+			t.Fatalf("compile program for input: %q failed. error is: %q", test.input, err)
+		}
+
+		error, ok := actual.(*object.Error)
+		if !ok {
+			t.Fatalf("need an error for input: %q. but got %s", test.input, actual.Inspect())
+		}
+
+		if !errors.Is(error.Err, test.expect) {
+			t.Errorf("wrong error for input: %q. want=%q, got=%q", test.input, test.expect, error.Msg)
+		}
+	}
+
+	// the depth the vm allows
+	actual, _ := run("let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(1022)", NewGlobals(), object.Limits{})
+	if actual.Inspect() != "1022" {
+		t.Errorf("wrong result for deep recursion. got %s", actual.Inspect())
+	}
+}
+
+func TestNoPanics(t *testing.T) {
+	inputs := []string{
+		"fn(a) { a }()",
+		"fn(){}[0]",
+		"{}[[fn(){}]]",
+		"len()",
+		"push([])",
+		"return;",
+		"fn() { return; }()",
+		"!fn(){}",
+		"fn(){} + 1",
+		"[1, 2][:fn(){}]",
+		"{1: 2} < {1: 2}",
+		"let f = fn(x) { f(x + 1) }; f(0)",
+	}
+
+	for _, input := range inputs {
+		actual, err := run(input, NewGlobals(), object.Limits{MaxInstructions: 100000})
+		if err != nil {
+			continue
+		}
+
+		if error, ok := actual.(*object.Error); ok && strings.HasPrefix(error.Msg, "internal error") {
+			t.Errorf("run program for input: %q panicked: %s", input, error.Msg)
+		}
+	}
+}
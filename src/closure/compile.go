@@ -0,0 +1,572 @@
+package closure
+
+import (
+	"ast"
+	"fmt"
+	"object"
+	"resolver"
+)
+
+type compiler struct {
+	table *resolver.Table
+	// function tells whether a function is being compiled, or the statements of the program
+	function bool
+}
+
+// Compile compiles program into closures. The globals of program are kept in globals,
+// which can be shared with the programs compiled before
+func Compile(program *ast.Program, globals *Globals) (*Program, error) {
+	table, err := resolver.Resolve(program, globals, func(name string) bool {
+		return object.FindBuiltinByName(name) != nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	c := &compiler{table: table}
+
+	code, err := c.statements(program.Statements, false)
+	if err != nil {
+		return nil, err
+	}
+	return &Program{code: code, globals: globals}, nil
+}
+
+// statements compiles the statements of a program or a block, their value is the value
+// of the last one. The last one is in tail position if the block is. No statements
+// evaluate to NULL
+func (c *compiler) statements(statements []ast.Statement, tail bool) (code, error) {
+	if len(statements) == 0 {
+		return func(m *machine, env *env) object.Object {
+			return object.NULL
+		}, nil
+	}
+
+	codes := make([]code, len(statements))
+	for i, statement := range statements {
+		var err error
+		codes[i], err = c.statement(statement, tail && i == len(statements)-1)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the budget is stepped once per statement and once per call, which bounds any
+	// program as it can only repeat itself with calls
+	first, last := codes[:len(codes)-1], codes[len(codes)-1]
+	return func(m *machine, env *env) object.Object {
+		for i, code := range first {
+			if err := m.budget.Step(); err != nil {
+				return m.fail(err, statements[i])
+			}
+			if code(m, env) == nil {
+				return nil
+			}
+		}
+
+		if err := m.budget.Step(); err != nil {
+			return m.fail(err, statements[len(first)])
+		}
+		return last(m, env)
+	}, nil
+}
+
+func (c *compiler) statement(node ast.Statement, tail bool) (code, error) {
+	switch node := node.(type) {
+	case *ast.ExpressionStatement:
+		return c.expression(node.Value, tail)
+	case *ast.LetStatement:
+		return c.letStatement(node)
+	case *ast.ReturnStatement:
+		if node.Value == nil {
+			return func(m *machine, env *env) object.Object {
+				m.ret = object.NULL
+				return nil
+			}, nil
+		}
+
+		// a function returns the result of a call in its return statement right away
+		value, err := c.expression(node.Value, c.function)
+		if err != nil {
+			return nil, err
+		}
+
+		return func(m *machine, env *env) object.Object {
+			ret := value(m, env)
+			if ret != nil {
+				m.ret = ret
+			}
+			return nil
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown node type %T", node)
+	}
+}
+
+func (c *compiler) letStatement(node *ast.LetStatement) (code, error) {
+	addr, _ := c.table.Address(node.Name)
+	slot := addr.Slot
+
+	value, err := c.expression(node.Value, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(m *machine, env *env) object.Object {
+		ret := value(m, env)
+		if ret == nil {
+			return nil
+		}
+
+		env.slots[slot] = ret
+		return ret
+	}, nil
+}
+
+// expression compiles node. A call in tail position is made by the caller of the
+// function it is in, the call does not nest
+func (c *compiler) expression(node ast.Expression, tail bool) (code, error) {
+	if node == nil {
+		return nil, fmt.Errorf("can not compile nil node")
+	}
+
+	switch node := node.(type) {
+	case *ast.Integer:
+		obj := &object.Integer{Value: node.Value}
+		return func(m *machine, env *env) object.Object {
+			return obj
+		}, nil
+	case *ast.String:
+		obj := &object.String{Value: node.Value}
+		return func(m *machine, env *env) object.Object {
+			return obj
+		}, nil
+	case *ast.Boolean:
+		obj := object.NativeBooleanToBooleanObj(node.Value)
+		return func(m *machine, env *env) object.Object {
+			return obj
+		}, nil
+	case *ast.Identifier:
+		return c.identifier(node), nil
+	case *ast.PrefixExpression:
+		return c.prefixExpression(node)
+	case *ast.InfixExpression:
+		if node.Operator == "=" {
+			return c.assignExpression(node)
+		}
+		return c.infixExpression(node)
+	case *ast.SliceExpression:
+		return c.sliceExpression(node)
+	case *ast.IfExpression:
+		return c.ifExpression(node, tail)
+	case *ast.BlockExpression:
+		return c.statements(node.Statements, tail)
+	case *ast.ArrayLiteral:
+		return c.arrayLiteral(node)
+	case *ast.HashLiteral:
+		return c.hashLiteral(node)
+	case *ast.FunctionExpression:
+		return c.functionExpression(node)
+	case *ast.CallExpression:
+		return c.callExpression(node, tail)
+	default:
+		return nil, fmt.Errorf("unknown node type %T", node)
+	}
+}
+
+// envAt returns the env depth scopes out from env
+func envAt(env *env, depth int) *env {
+	for i := 0; i < depth; i++ {
+		env = env.outer
+	}
+	return env
+}
+
+func (c *compiler) identifier(node *ast.Identifier) code {
+	addr, ok := c.table.Address(node)
+	if !ok {
+		builtin := object.FindBuiltinByName(node.Value)
+		return func(m *machine, env *env) object.Object {
+			return builtin
+		}
+	}
+
+	// a slot is nil until its let statement is run
+	depth, slot := addr.Depth, addr.Slot
+	msg := fmt.Sprintf("unbound identifier: %s", node.Value)
+	switch depth {
+	case 0:
+		return func(m *machine, env *env) object.Object {
+			if val := env.slots[slot]; val != nil {
+				return val
+			}
+			return m.failMsg(msg, node)
+		}
+	case 1:
+		return func(m *machine, env *env) object.Object {
+			if val := env.outer.slots[slot]; val != nil {
+				return val
+			}
+			return m.failMsg(msg, node)
+		}
+	default:
+		return func(m *machine, env *env) object.Object {
+			if val := envAt(env, depth).slots[slot]; val != nil {
+				return val
+			}
+			return m.failMsg(msg, node)
+		}
+	}
+}
+
+func (c *compiler) assignExpression(node *ast.InfixExpression) (code, error) {
+	ident, ok := node.Left.(*ast.Identifier)
+	if !ok {
+		msg := fmt.Sprintf("can not assign to %s", node.Left.String())
+		return func(m *machine, env *env) object.Object {
+			return m.failMsg(msg, node)
+		}, nil
+	}
+
+	value, err := c.expression(node.Right, false)
+	if err != nil {
+		return nil, err
+	}
+
+	// only a variable defined can be assigned to
+	addr, ok := c.table.Address(ident)
+	depth, slot := addr.Depth, addr.Slot
+	msg := fmt.Sprintf("unbound identifier: %s", ident.Value)
+	return func(m *machine, env *env) object.Object {
+		ret := value(m, env)
+		if ret == nil {
+			return nil
+		}
+
+		if !ok {
+			return m.failMsg(msg, node)
+		}
+
+		target := envAt(env, depth)
+		if target.slots[slot] == nil {
+			return m.failMsg(msg, node)
+		}
+		target.slots[slot] = ret
+		return ret
+	}, nil
+}
+
+func (c *compiler) prefixExpression(node *ast.PrefixExpression) (code, error) {
+	if node.Operator != "!" && node.Operator != "-" {
+		msg := fmt.Sprintf("unknown operator: %s%s", node.Operator, node.Value.String())
+		return func(m *machine, env *env) object.Object {
+			return m.failMsg(msg, node)
+		}, nil
+	}
+
+	operand, err := c.expression(node.Value, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if node.Operator == "!" {
+		return func(m *machine, env *env) object.Object {
+			val := operand(m, env)
+			if val == nil {
+				return nil
+			}
+			return object.NativeBooleanToBooleanObj(val == object.FALSE || val == object.NULL)
+		}, nil
+	}
+
+	return func(m *machine, env *env) object.Object {
+		val := operand(m, env)
+		if val == nil {
+			return nil
+		}
+
+		ret, err := object.IntegerNegate(val)
+		if err != nil {
+			return m.fail(err, node)
+		}
+		return m.alloc(ret, node)
+	}, nil
+}
+
+func (c *compiler) infixExpression(node *ast.InfixExpression) (code, error) {
+	left, err := c.expression(node.Left, false)
+	if err != nil {
+		return nil, err
+	}
+
+	right, err := c.expression(node.Right, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(m *machine, env *env) object.Object {
+		l := left(m, env)
+		if l == nil {
+			return nil
+		}
+
+		r := right(m, env)
+		if r == nil {
+			return nil
+		}
+		return m.infix(node, l, r)
+	}, nil
+}
+
+// infix applies the operator of node to left and right like the evaluator
+func (m *machine) infix(node *ast.InfixExpression, left object.Object, right object.Object) object.Object {
+	switch {
+	case node.Operator == "[":
+		ret, err := object.Index(left, right)
+		if err != nil {
+			return m.fail(err, node)
+		}
+
+		if left.Type() == object.STRING_OBJ {
+			return m.alloc(ret, node)
+		}
+		return ret
+	case object.IsInteger(left) && object.IsInteger(right):
+		ret, err := object.IntegerInfix(node.Operator, left, right)
+		if err != nil {
+			return m.fail(err, node)
+		}
+		return m.alloc(ret, node)
+	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
+		l, r := left.(*object.String).Value, right.(*object.String).Value
+		switch node.Operator {
+		case "+":
+			return m.alloc(&object.String{Value: l + r}, node)
+		case "==":
+			return object.NativeBooleanToBooleanObj(l == r)
+		case "!=":
+			return object.NativeBooleanToBooleanObj(l != r)
+		default:
+			return m.failMsg(fmt.Sprintf("unknown operator: %q %s %q", l, node.Operator, r), node)
+		}
+	case node.Operator == "==":
+		return object.NativeBooleanToBooleanObj(object.Equals(left, right))
+	case node.Operator == "!=":
+		return object.NativeBooleanToBooleanObj(!object.Equals(left, right))
+	}
+
+	return m.failMsg(fmt.Sprintf("unknown operator: %s %s %s", node.Left.String(), node.Operator, node.Right.String()), node)
+}
+
+// sliceExpression compiles the collection and the bounds which are not omitted
+func (c *compiler) sliceExpression(node *ast.SliceExpression) (code, error) {
+	codes := make([]code, 3)
+	for i, bound := range []ast.Expression{node.Left, node.Start, node.End} {
+		if bound == nil {
+			continue
+		}
+
+		var err error
+		codes[i], err = c.expression(bound, false)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return func(m *machine, env *env) object.Object {
+		var values [3]object.Object
+		for i, code := range codes {
+			if code == nil {
+				continue
+			}
+
+			values[i] = code(m, env)
+			if values[i] == nil {
+				return nil
+			}
+		}
+
+		ret, err := object.Slice(values[0], values[1], values[2])
+		if err != nil {
+			return m.fail(err, node)
+		}
+		return m.alloc(ret, node)
+	}, nil
+}
+
+func (c *compiler) ifExpression(node *ast.IfExpression, tail bool) (code, error) {
+	condition, err := c.expression(node.Condition, false)
+	if err != nil {
+		return nil, err
+	}
+
+	then, err := c.statements(node.ThenBody.Statements, tail)
+	if err != nil {
+		return nil, err
+	}
+
+	otherwise := func(m *machine, env *env) object.Object {
+		return object.NULL
+	}
+	if node.ElseBody != nil {
+		otherwise, err = c.statements(node.ElseBody.Statements, tail)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return func(m *machine, env *env) object.Object {
+		val := condition(m, env)
+		if val == nil {
+			return nil
+		}
+
+		if val != object.FALSE && val != object.NULL {
+			return then(m, env)
+		}
+		return otherwise(m, env)
+	}, nil
+}
+
+func (c *compiler) expressions(nodes []ast.Expression) ([]code, error) {
+	codes := make([]code, len(nodes))
+	for i, node := range nodes {
+		var err error
+		codes[i], err = c.expression(node, false)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+// evaluate evaluates codes in order, it returns false when one of them does not finish with a value
+func evaluate(m *machine, env *env, codes []code) ([]object.Object, bool) {
+	values := make([]object.Object, len(codes))
+	for i, code := range codes {
+		values[i] = code(m, env)
+		if values[i] == nil {
+			return nil, false
+		}
+	}
+	return values, true
+}
+
+func (c *compiler) arrayLiteral(node *ast.ArrayLiteral) (code, error) {
+	elements, err := c.expressions(node.Elements)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(m *machine, env *env) object.Object {
+		values, ok := evaluate(m, env, elements)
+		if !ok {
+			return nil
+		}
+		return m.alloc(&object.Array{Elements: values}, node)
+	}, nil
+}
+
+// hashLiteral compiles keys and values in source order, key and value of a pair
+// are evaluated before the key is checked to be Hashable
+func (c *compiler) hashLiteral(node *ast.HashLiteral) (code, error) {
+	nodes := []ast.Expression{}
+	for _, pair := range node.Pairs {
+		nodes = append(nodes, pair.Key, pair.Value)
+	}
+
+	pairs, err := c.expressions(nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(m *machine, env *env) object.Object {
+		hash := object.NewSeededHashTable(m.seed)
+		for i := 0; i < len(pairs); i += 2 {
+			k := pairs[i](m, env)
+			if k == nil {
+				return nil
+			}
+
+			v := pairs[i+1](m, env)
+			if v == nil {
+				return nil
+			}
+
+			h, err := object.HashLiteralKey(m.seed, k)
+			if err != nil {
+				return m.fail(err, node)
+			}
+			hash.Set(h, k, v)
+		}
+		return m.alloc(hash, node)
+	}, nil
+}
+
+func (c *compiler) functionExpression(node *ast.FunctionExpression) (code, error) {
+	inFunction := c.function
+	c.function = true
+	defer func() { c.function = inFunction }()
+
+	body, err := c.statements(node.Body.Statements, true)
+	if err != nil {
+		return nil, err
+	}
+
+	// the arguments of a call are copied into the first slots, the ones of the parameters
+	fn := &function{node: node, body: body, numSlots: c.table.NumSlots(node)}
+	return func(m *machine, env *env) object.Object {
+		return m.alloc(&Function{fn: fn, env: env}, node)
+	}, nil
+}
+
+func (c *compiler) callExpression(node *ast.CallExpression, tail bool) (code, error) {
+	function, err := c.expression(node.Function, false)
+	if err != nil {
+		return nil, err
+	}
+
+	arguments, err := c.expressions(node.Arguments)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(m *machine, env *env) object.Object {
+		callee := function(m, env)
+		if callee == nil {
+			return nil
+		}
+
+		args, ok := evaluate(m, env, arguments)
+		if !ok {
+			return nil
+		}
+
+		if err := m.budget.Step(); err != nil {
+			return m.fail(err, node)
+		}
+
+		switch fn := callee.(type) {
+		case *Function:
+			if err := object.CheckArguments(len(fn.fn.node.Parameters), len(args)); err != nil {
+				return m.fail(err, node)
+			}
+
+			if tail {
+				m.tail, m.tailArgs = fn, args
+				return nil
+			}
+			return m.call(fn, args, node)
+		case *object.Builtin:
+			ret, allocated, err := object.CallBuiltin(fn, args)
+			if err != nil {
+				return m.fail(err, node)
+			}
+			if !allocated {
+				return ret
+			}
+			return m.alloc(ret, node)
+		default:
+			return m.failMsg(fmt.Sprintf("unknown function: %s", callee.Inspect()), node)
+		}
+	}, nil
+}
@@ -0,0 +1,40 @@
+package closure
+
+import "object"
+
+// Globals are the global variables shared by the programs compiled with them, so the
+// programs run one after another like in the repl can use the variables of each other
+type Globals struct {
+	names map[string]int
+	env   *env
+}
+
+func NewGlobals() *Globals {
+	return &Globals{names: map[string]int{}, env: &env{}}
+}
+
+// Define returns the slot of the global name, a new one if name is not defined yet
+func (g *Globals) Define(name string) int {
+	if slot, ok := g.names[name]; ok {
+		return slot
+	}
+
+	slot := len(g.names)
+	g.names[name] = slot
+	return slot
+}
+
+// Lookup returns the slot of the global name
+func (g *Globals) Lookup(name string) (int, bool) {
+	slot, ok := g.names[name]
+	return slot, ok
+}
+
+// grow makes room for the globals defined by the programs compiled last
+func (g *Globals) grow() {
+	if n := len(g.names); len(g.env.slots) < n {
+		slots := make([]object.Object, n)
+		copy(slots, g.env.slots)
+		g.env.slots = slots
+	}
+}
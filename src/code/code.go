@@ -19,11 +19,14 @@ const (
 	OpSubtraction
 	OpMultiply
 	OpDivide
+	OpModulo
 	OpMinus
 	OpEqual
 	OpNotEqual
 	OpGreaterThan
 	OpGreaterEqual
+	OpLessThan
+	OpLessEqual
 	OpBang
 	OpIndex
 	OpJumptNotTruethy
@@ -41,6 +44,27 @@ const (
 	OpClosure
 	OpGetFree
 	OpCurrentClosure
+	OpSlice
+	OpTailCall
+
+	// superinstructions the optimizer makes of common sequences, see compiler.optimizeInstructions
+
+	// OpAddLocalConstant is OpGetLocal, OpConstant, OpAdd
+	OpAddLocalConstant
+	// OpSubtractLocalConstant is OpGetLocal, OpConstant, OpSubtraction
+	OpSubtractLocalConstant
+	// OpJumpNotEqual is OpEqual, OpJumpNotTruethy
+	OpJumpNotEqual
+	// OpJumpEqual is OpNotEqual, OpJumpNotTruethy
+	OpJumpEqual
+	// OpJumpNotGreaterThan is OpGreaterThan, OpJumpNotTruethy
+	OpJumpNotGreaterThan
+	// OpJumpNotGreaterEqual is OpGreaterEqual, OpJumpNotTruethy
+	OpJumpNotGreaterEqual
+	// OpJumpNotLessThan is OpLessThan, OpJumpNotTruethy
+	OpJumpNotLessThan
+	// OpJumpNotLessEqual is OpLessEqual, OpJumpNotTruethy
+	OpJumpNotLessEqual
 )
 
 type Definition struct {
@@ -58,11 +82,14 @@ var definitionMap = map[OpCode]*Definition{
 	OpSubtraction:     &Definition{"OpSubtraction", []int{}},
 	OpMultiply:        &Definition{"OpMultiply", []int{}},
 	OpDivide:          &Definition{"OpDivide", []int{}},
+	OpModulo:          &Definition{"OpModulo", []int{}},
 	OpMinus:           &Definition{"OpMinus", []int{}},
 	OpEqual:           &Definition{"OpEqual", []int{}},
 	OpNotEqual:        &Definition{"OpNotEqual", []int{}},
 	OpGreaterThan:     &Definition{"OpGreaterThan", []int{}},
 	OpGreaterEqual:    &Definition{"OpGreaterEqual", []int{}},
+	OpLessThan:        &Definition{"OpLessThan", []int{}},
+	OpLessEqual:       &Definition{"OpLessEqual", []int{}},
 	OpBang:            &Definition{"OpBang", []int{}},
 	OpIndex:           &Definition{"OpIndex", []int{}},
 	OpJumptNotTruethy: &Definition{"OpJumpNotTruethy", []int{2}},
@@ -80,6 +107,32 @@ var definitionMap = map[OpCode]*Definition{
 	OpClosure:         &Definition{"OpClosure", []int{2, 1}},
 	OpGetFree:         &Definition{"OpGetFree", []int{1}},
 	OpCurrentClosure:  &Definition{"OpCurrentClosure", []int{}},
+	OpSlice:           &Definition{"OpSlice", []int{}},
+	OpTailCall:        &Definition{"OpTailCall", []int{1}},
+
+	OpAddLocalConstant:      &Definition{"OpAddLocalConstant", []int{1, 2}},
+	OpSubtractLocalConstant: &Definition{"OpSubtractLocalConstant", []int{1, 2}},
+	OpJumpNotEqual:          &Definition{"OpJumpNotEqual", []int{2}},
+	OpJumpEqual:             &Definition{"OpJumpEqual", []int{2}},
+	OpJumpNotGreaterThan:    &Definition{"OpJumpNotGreaterThan", []int{2}},
+	OpJumpNotGreaterEqual:   &Definition{"OpJumpNotGreaterEqual", []int{2}},
+	OpJumpNotLessThan:       &Definition{"OpJumpNotLessThan", []int{2}},
+	OpJumpNotLessEqual:      &Definition{"OpJumpNotLessEqual", []int{2}},
+}
+
+// Operators are the infix operators of object.Infix the binary instructions apply
+var Operators = map[OpCode]string{
+	OpAdd:          "+",
+	OpSubtraction:  "-",
+	OpMultiply:     "*",
+	OpDivide:       "/",
+	OpModulo:       "%",
+	OpEqual:        "==",
+	OpNotEqual:     "!=",
+	OpGreaterEqual: ">=",
+	OpGreaterThan:  ">",
+	OpLessEqual:    "<=",
+	OpLessThan:     "<",
 }
 
 func Lookup(code OpCode) (*Definition, error) {
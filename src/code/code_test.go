@@ -29,3 +29,24 @@ func TestMake(t *testing.T) {
 		}
 	}
 }
+
+func TestRegisterInstructionsString(t *testing.T) {
+	instructions := RegisterInstructions{
+		MakeRegister(ROpLoadConstant, 1, 65535),
+		MakeRegister(ROpAdd, 0, 1, 2),
+		MakeRegister(ROpSetGlobal, 3, 0),
+		MakeRegister(ROpJumpNotEqual, 0, 1, 7),
+		MakeRegister(ROpReturnNull),
+	}
+
+	expected := `0000 ROpLoadConstant r1 k65535
+0001 ROpAdd r0 r1 r2
+0002 ROpSetGlobal g3 r0
+0003 ROpJumpNotEqual r0 r1 7
+0004 ROpReturnNull
+`
+
+	if instructions.String() != expected {
+		t.Errorf("instructions wrongly formatted. want=%q, got=%q", expected, instructions.String())
+	}
+}
@@ -0,0 +1,58 @@
+package code
+
+import "sort"
+
+// LineStart is the first instruction of a statement starting at Line
+type LineStart struct {
+	Offset int
+	Line   int
+}
+
+// LineTable lists the instructions which statements start at, where a debugger stops
+// when it steps line by line. Entries are sorted by Offset
+type LineTable []LineStart
+
+// Add records a statement starting at line with the instruction at offset. Entries at or
+// after offset are dropped first, so of statements starting at the same instruction the
+// innermost one, which is added last, is kept
+func (t LineTable) Add(offset int, line int) LineTable {
+	return append(t.Truncate(offset), LineStart{Offset: offset, Line: line})
+}
+
+// Truncate drops entries for instructions starting at or after offset
+func (t LineTable) Truncate(offset int) LineTable {
+	i := len(t)
+	for i > 0 && t[i-1].Offset >= offset {
+		i--
+	}
+	return t[:i]
+}
+
+// Line returns the line of the statement starting at offset, false when no statement
+// starts there
+func (t LineTable) Line(offset int) (int, bool) {
+	i := sort.Search(len(t), func(i int) bool { return t[i].Offset >= offset })
+	if i == len(t) || t[i].Offset != offset {
+		return 0, false
+	}
+	return t[i].Line, true
+}
+
+// Lookup returns the line of the statement the instruction at offset belongs to
+func (t LineTable) Lookup(offset int) (int, bool) {
+	i := sort.Search(len(t), func(i int) bool { return t[i].Offset > offset })
+	if i == 0 {
+		return 0, false
+	}
+	return t[i-1].Line, true
+}
+
+// Has reports whether a statement starts at line
+func (t LineTable) Has(line int) bool {
+	for _, start := range t {
+		if start.Line == line {
+			return true
+		}
+	}
+	return false
+}
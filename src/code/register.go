@@ -0,0 +1,221 @@
+package code
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// RegisterOpCode is an instruction of the register vm. Instead of pushing and popping
+// a stack, an instruction names the registers of the current function it reads and
+// writes. A function keeps its parameters and locals in its first registers and its
+// temporary values after them
+type RegisterOpCode byte
+
+// RegisterInstruction is one instruction with up to three operands. Their meaning
+// is given by the operand kinds of the definition of Op
+type RegisterInstruction struct {
+	Op      RegisterOpCode
+	A, B, C uint16
+}
+
+type RegisterInstructions []RegisterInstruction
+
+// In the comments R is the registers, K the constants, G the globals and F the free variables
+const (
+	// ROpLoadConstant is R[A] = K[B]
+	ROpLoadConstant RegisterOpCode = iota
+	// ROpLoadTrue is R[A] = true
+	ROpLoadTrue
+	// ROpLoadFalse is R[A] = false
+	ROpLoadFalse
+	// ROpLoadNull is R[A] = null
+	ROpLoadNull
+	// ROpMove is R[A] = R[B]
+	ROpMove
+	// ROpGetGlobal is R[A] = G[B]
+	ROpGetGlobal
+	// ROpSetGlobal is G[A] = R[B]
+	ROpSetGlobal
+	// ROpGetBuiltin is R[A] = builtin B
+	ROpGetBuiltin
+	// ROpGetFree is R[A] = F[B]
+	ROpGetFree
+	// ROpCurrentClosure is R[A] = the closure running
+	ROpCurrentClosure
+	// ROpAdd and the other operators are R[A] = R[B] op R[C]
+	ROpAdd
+	ROpSubtraction
+	ROpMultiply
+	ROpDivide
+	ROpModulo
+	ROpEqual
+	ROpNotEqual
+	ROpGreaterThan
+	ROpGreaterEqual
+	ROpLessThan
+	ROpLessEqual
+	// ROpAddConstant is R[A] = R[B] + K[C]
+	ROpAddConstant
+	// ROpSubtractConstant is R[A] = R[B] - K[C]
+	ROpSubtractConstant
+	// ROpMinus is R[A] = -R[B]
+	ROpMinus
+	// ROpBang is R[A] = !R[B]
+	ROpBang
+	// ROpIndex is R[A] = R[B][R[C]]
+	ROpIndex
+	// ROpSlice is R[A] = R[B][R[C]:R[C+1]]
+	ROpSlice
+	// ROpArray is R[A] = [R[B], ... R[B+C-1]]
+	ROpArray
+	// ROpHash is R[A] = {R[B]: R[B+1], ... } of C pairs
+	ROpHash
+	// ROpClosure is R[A] = closure of the function K[B] with the free variables R[C]...
+	ROpClosure
+	// ROpCall is R[A] = R[A](R[A+1], ... R[A+B]). The callee takes R[A+1] as its first register
+	ROpCall
+	// ROpTailCall is ROpCall whose result is returned right away, the callee takes the frame of the caller
+	ROpTailCall
+	// ROpReturn returns R[A]
+	ROpReturn
+	// ROpReturnNull returns null
+	ROpReturnNull
+	// ROpJump goes to instruction A
+	ROpJump
+	// ROpJumpNotTruethy goes to instruction B if R[A] is not truethy
+	ROpJumpNotTruethy
+	// ROpJumpNotEqual goes to instruction C if R[A] == R[B] is false, the other jumps are alike
+	ROpJumpNotEqual
+	ROpJumpEqual
+	ROpJumpNotGreaterThan
+	ROpJumpNotGreaterEqual
+	ROpJumpNotLessThan
+	ROpJumpNotLessEqual
+	// ROpResult makes R[A] the result of the program, it is emitted for the expression statements of the main function
+	ROpResult
+)
+
+// Operand kinds of register instructions
+const (
+	// OperandRegister is a register of the current function
+	OperandRegister = 'r'
+	// OperandConstant is an index into the constants
+	OperandConstant = 'k'
+	// OperandGlobal is an index into the globals
+	OperandGlobal = 'g'
+	// OperandTarget is the index of the instruction a jump goes to
+	OperandTarget = 'j'
+	// OperandNumber is a count or an index of a builtin or a free variable
+	OperandNumber = 'n'
+)
+
+type RegisterDefinition struct {
+	Name string
+	// Operands are the kinds of the operands A, B and C
+	Operands string
+}
+
+var registerDefinitions = map[RegisterOpCode]*RegisterDefinition{
+	ROpLoadConstant:        &RegisterDefinition{"ROpLoadConstant", "rk"},
+	ROpLoadTrue:            &RegisterDefinition{"ROpLoadTrue", "r"},
+	ROpLoadFalse:           &RegisterDefinition{"ROpLoadFalse", "r"},
+	ROpLoadNull:            &RegisterDefinition{"ROpLoadNull", "r"},
+	ROpMove:                &RegisterDefinition{"ROpMove", "rr"},
+	ROpGetGlobal:           &RegisterDefinition{"ROpGetGlobal", "rg"},
+	ROpSetGlobal:           &RegisterDefinition{"ROpSetGlobal", "gr"},
+	ROpGetBuiltin:          &RegisterDefinition{"ROpGetBuiltin", "rn"},
+	ROpGetFree:             &RegisterDefinition{"ROpGetFree", "rn"},
+	ROpCurrentClosure:      &RegisterDefinition{"ROpCurrentClosure", "r"},
+	ROpAdd:                 &RegisterDefinition{"ROpAdd", "rrr"},
+	ROpSubtraction:         &RegisterDefinition{"ROpSubtraction", "rrr"},
+	ROpMultiply:            &RegisterDefinition{"ROpMultiply", "rrr"},
+	ROpDivide:              &RegisterDefinition{"ROpDivide", "rrr"},
+	ROpModulo:              &RegisterDefinition{"ROpModulo", "rrr"},
+	ROpEqual:               &RegisterDefinition{"ROpEqual", "rrr"},
+	ROpNotEqual:            &RegisterDefinition{"ROpNotEqual", "rrr"},
+	ROpGreaterThan:         &RegisterDefinition{"ROpGreaterThan", "rrr"},
+	ROpGreaterEqual:        &RegisterDefinition{"ROpGreaterEqual", "rrr"},
+	ROpLessThan:            &RegisterDefinition{"ROpLessThan", "rrr"},
+	ROpLessEqual:           &RegisterDefinition{"ROpLessEqual", "rrr"},
+	ROpAddConstant:         &RegisterDefinition{"ROpAddConstant", "rrk"},
+	ROpSubtractConstant:    &RegisterDefinition{"ROpSubtractConstant", "rrk"},
+	ROpMinus:               &RegisterDefinition{"ROpMinus", "rr"},
+	ROpBang:                &RegisterDefinition{"ROpBang", "rr"},
+	ROpIndex:               &RegisterDefinition{"ROpIndex", "rrr"},
+	ROpSlice:               &RegisterDefinition{"ROpSlice", "rrr"},
+	ROpArray:               &RegisterDefinition{"ROpArray", "rrn"},
+	ROpHash:                &RegisterDefinition{"ROpHash", "rrn"},
+	ROpClosure:             &RegisterDefinition{"ROpClosure", "rkr"},
+	ROpCall:                &RegisterDefinition{"ROpCall", "rn"},
+	ROpTailCall:            &RegisterDefinition{"ROpTailCall", "rn"},
+	ROpReturn:              &RegisterDefinition{"ROpReturn", "r"},
+	ROpReturnNull:          &RegisterDefinition{"ROpReturnNull", ""},
+	ROpJump:                &RegisterDefinition{"ROpJump", "j"},
+	ROpJumpNotTruethy:      &RegisterDefinition{"ROpJumpNotTruethy", "rj"},
+	ROpJumpNotEqual:        &RegisterDefinition{"ROpJumpNotEqual", "rrj"},
+	ROpJumpEqual:           &RegisterDefinition{"ROpJumpEqual", "rrj"},
+	ROpJumpNotGreaterThan:  &RegisterDefinition{"ROpJumpNotGreaterThan", "rrj"},
+	ROpJumpNotGreaterEqual: &RegisterDefinition{"ROpJumpNotGreaterEqual", "rrj"},
+	ROpJumpNotLessThan:     &RegisterDefinition{"ROpJumpNotLessThan", "rrj"},
+	ROpJumpNotLessEqual:    &RegisterDefinition{"ROpJumpNotLessEqual", "rrj"},
+	ROpResult:              &RegisterDefinition{"ROpResult", "r"},
+}
+
+func LookupRegister(op RegisterOpCode) (*RegisterDefinition, error) {
+	def, ok := registerDefinitions[op]
+	if !ok {
+		return nil, fmt.Errorf("can not find definition for register code %d", op)
+	}
+	return def, nil
+}
+
+// MakeRegister returns the instruction op with operands, missing operands are 0
+func MakeRegister(op RegisterOpCode, operands ...int) RegisterInstruction {
+	ins := RegisterInstruction{Op: op}
+	fields := []*uint16{&ins.A, &ins.B, &ins.C}
+	for i, operand := range operands {
+		if i < len(fields) {
+			*fields[i] = uint16(operand)
+		}
+	}
+	return ins
+}
+
+// Operands returns the operands of ins which its definition has
+func (ins RegisterInstruction) Operands() []int {
+	def, err := LookupRegister(ins.Op)
+	if err != nil {
+		return nil
+	}
+	return []int{int(ins.A), int(ins.B), int(ins.C)}[:len(def.Operands)]
+}
+
+func (ins RegisterInstruction) String() string {
+	def, err := LookupRegister(ins.Op)
+	if err != nil {
+		return fmt.Sprintf("Error: %s", err)
+	}
+
+	ret := def.Name
+	for i, operand := range ins.Operands() {
+		switch def.Operands[i] {
+		case OperandRegister:
+			ret += fmt.Sprintf(" r%d", operand)
+		case OperandConstant:
+			ret += fmt.Sprintf(" k%d", operand)
+		case OperandGlobal:
+			ret += fmt.Sprintf(" g%d", operand)
+		default:
+			ret += fmt.Sprintf(" %d", operand)
+		}
+	}
+	return ret
+}
+
+func (ins RegisterInstructions) String() string {
+	var out bytes.Buffer
+	for i, in := range ins {
+		fmt.Fprintf(&out, "%04d %s\n", i, in)
+	}
+	return out.String()
+}
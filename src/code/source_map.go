@@ -0,0 +1,39 @@
+package code
+
+import (
+	"sort"
+	"token"
+)
+
+// SourcePos is the position of the source which the instruction starting at Offset is compiled from
+type SourcePos struct {
+	Offset int
+	Pos    token.Position
+}
+
+// SourceMap maps instructions to source positions. Entries are sorted by Offset
+type SourceMap []SourcePos
+
+// Add records pos for the instruction starting at offset. Entries at or after offset are
+// dropped first, so an instruction replaced by a new one takes the new position
+func (m SourceMap) Add(offset int, pos token.Position) SourceMap {
+	return append(m.Truncate(offset), SourcePos{Offset: offset, Pos: pos})
+}
+
+// Truncate drops entries for instructions starting at or after offset
+func (m SourceMap) Truncate(offset int) SourceMap {
+	i := len(m)
+	for i > 0 && m[i-1].Offset >= offset {
+		i--
+	}
+	return m[:i]
+}
+
+// Lookup returns the position of the instruction which covers offset
+func (m SourceMap) Lookup(offset int) (token.Position, bool) {
+	i := sort.Search(len(m), func(i int) bool { return m[i].Offset > offset })
+	if i == 0 {
+		return token.Position{}, false
+	}
+	return m[i-1].Pos, true
+}
@@ -0,0 +1,80 @@
+package code
+
+import (
+	"testing"
+	"token"
+)
+
+func TestSourceMap(t *testing.T) {
+	var m SourceMap
+	m = m.Add(0, token.Position{Line: 1, Column: 1})
+	m = m.Add(3, token.Position{Line: 1, Column: 5})
+	m = m.Add(4, token.Position{Line: 2, Column: 1})
+	// the instruction at 4 is replaced
+	m = m.Add(4, token.Position{Line: 3, Column: 1})
+
+	tests := []struct {
+		offset int
+		pos    token.Position
+		ok     bool
+	}{
+		{-1, token.Position{}, false},
+		{0, token.Position{Line: 1, Column: 1}, true},
+		{2, token.Position{Line: 1, Column: 1}, true},
+		{3, token.Position{Line: 1, Column: 5}, true},
+		{4, token.Position{Line: 3, Column: 1}, true},
+		{10, token.Position{Line: 3, Column: 1}, true},
+	}
+
+	for _, test := range tests {
+		pos, ok := m.Lookup(test.offset)
+		if ok != test.ok || pos != test.pos {
+			t.Errorf("wrong position for offset %d. want=%+v (%t), got=%+v (%t)", test.offset, test.pos, test.ok, pos, ok)
+		}
+	}
+
+	m = m.Truncate(3)
+	if len(m) != 1 {
+		t.Fatalf("wrong length after truncate. want=1, got=%d", len(m))
+	}
+}
+
+func TestLineTable(t *testing.T) {
+	var lines LineTable
+	lines = lines.Add(0, 1)
+	lines = lines.Add(4, 2)
+	// a statement in the block of the one at 4 starts at the same instruction
+	lines = lines.Add(4, 3)
+	lines = lines.Add(9, 5)
+
+	if len(lines) != 3 {
+		t.Fatalf("wrong length. want=3, got=%d", len(lines))
+	}
+
+	tests := []struct {
+		offset int
+		line   int
+		start  bool
+	}{
+		{0, 1, true},
+		{2, 1, false},
+		{4, 3, true},
+		{8, 3, false},
+		{9, 5, true},
+		{12, 5, false},
+	}
+
+	for _, test := range tests {
+		line, ok := lines.Line(test.offset)
+		if ok != test.start || ok && line != test.line {
+			t.Errorf("wrong statement start at %d. want=%d (%t), got=%d (%t)", test.offset, test.line, test.start, line, ok)
+		}
+		if line, _ := lines.Lookup(test.offset); line != test.line {
+			t.Errorf("wrong line of the instruction at %d. want=%d, got=%d", test.offset, test.line, line)
+		}
+	}
+
+	if !lines.Has(3) || lines.Has(2) {
+		t.Errorf("wrong lines having statements. got=%+v", lines)
+	}
+}
@@ -5,21 +5,46 @@ import (
 	"code"
 	"fmt"
 	"object"
+	"token"
 )
 
+// Error is a compile error of the program, Pos is the position of the node it is about.
+// Errors of functions wrap the errors of their bodies, errors.As finds the Error
+type Error struct {
+	Msg string
+	Pos token.Position
+}
+
+func (e *Error) Error() string {
+	return e.Msg
+}
+
 type CompilationScope struct {
 	instructions     code.Instructions
+	sourceMap        code.SourceMap
+	lines            code.LineTable
 	localSymbolTable *SymbolTable
 
 	lastOpCodeStartPos       int
 	secondLastOpCodeStartPos int
 }
 
+// MaxConstants is the number of constants the 2 byte operand of OpConstant and OpClosure can address
+const MaxConstants = 1 << 16
+
 type Compiler struct {
-	constants []object.Object
+	constants *constantPool
 
 	scopes     []CompilationScope
 	scopeIndex int
+
+	// position of the node being compiled, recorded for every emitted instruction
+	pos token.Position
+
+	optimize bool
+
+	// bytecode is the result of Bytecode, until the next Compile
+	bytecode *Bytecode
 }
 
 func New() *Compiler {
@@ -35,7 +60,8 @@ func New() *Compiler {
 		lastOpCodeStartPos:       0,
 		secondLastOpCodeStartPos: 0,
 	}
-	return &Compiler{scopes: []CompilationScope{mainScope}, scopeIndex: 0, constants: []object.Object{}}
+	return &Compiler{scopes: []CompilationScope{mainScope}, scopeIndex: 0, constants: newConstantPool([]object.Object{}),
+		optimize: true}
 }
 
 func NewWithStates(constants []object.Object, symbolTable *SymbolTable) *Compiler {
@@ -46,7 +72,19 @@ func NewWithStates(constants []object.Object, symbolTable *SymbolTable) *Compile
 		secondLastOpCodeStartPos: 0,
 	}
 
-	return &Compiler{scopes: []CompilationScope{mainScope}, scopeIndex: 0, constants: constants}
+	return &Compiler{scopes: []CompilationScope{mainScope}, scopeIndex: 0, constants: newConstantPool(constants),
+		optimize: true}
+}
+
+// DisableOptimizations makes the compiler emit instructions for the program as it is written,
+// without constant folding, dead code elimination and the peephole pass
+func (c *Compiler) DisableOptimizations() {
+	c.optimize = false
+}
+
+// GlobalNames returns the names of the globals defined so far by their indexes
+func (c *Compiler) GlobalNames() []string {
+	return c.scopes[0].localSymbolTable.Names()
 }
 
 func (c *Compiler) currentScope() *CompilationScope {
@@ -77,9 +115,75 @@ func (c *Compiler) leaveScope() CompilationScope {
 	return lastScope
 }
 
-func (c *Compiler) addConstant(value object.Object) int {
-	c.constants = append(c.constants, value)
-	return len(c.constants) - 1
+type ConstantKey struct {
+	Type  object.ObjectType
+	Value string
+}
+
+// ConstantKeyOf returns the key for constants which can be shared, that is integers and strings
+func ConstantKeyOf(obj object.Object) (ConstantKey, bool) {
+	switch obj := obj.(type) {
+	case *object.Integer, *object.BigInteger:
+		return ConstantKey{Type: obj.Type(), Value: obj.Inspect()}, true
+	case *object.String:
+		return ConstantKey{Type: obj.Type(), Value: obj.Value}, true
+	default:
+		return ConstantKey{}, false
+	}
+}
+
+// constantPool holds the constants of a program with the indexes of the integer
+// and string constants, so each of them is added only once
+type constantPool struct {
+	constants []object.Object
+	indexes   map[ConstantKey]int
+}
+
+// newConstantPool makes a pool which reuses constants from former compilations
+func newConstantPool(constants []object.Object) *constantPool {
+	indexes := map[ConstantKey]int{}
+	for i, constant := range constants {
+		if key, ok := ConstantKeyOf(constant); ok {
+			indexes[key] = i
+		}
+	}
+	return &constantPool{constants: constants, indexes: indexes}
+}
+
+// add returns the index of value in the constants. An integer or a string
+// which is already there is not added again
+func (p *constantPool) add(value object.Object) (int, error) {
+	key, shared := ConstantKeyOf(value)
+	if shared {
+		if index, ok := p.indexes[key]; ok {
+			return index, nil
+		}
+	}
+
+	if len(p.constants) >= MaxConstants {
+		return 0, fmt.Errorf("too many constants: a program can have at most %d", MaxConstants)
+	}
+
+	p.constants = append(p.constants, value)
+	index := len(p.constants) - 1
+	if shared {
+		p.indexes[key] = index
+	}
+	return index, nil
+}
+
+func (c *Compiler) addConstant(value object.Object) (int, error) {
+	return c.constants.add(value)
+}
+
+func (c *Compiler) emitConstant(value object.Object) error {
+	index, err := c.addConstant(value)
+	if err != nil {
+		return err
+	}
+
+	c.emit(code.OpConstant, index)
+	return nil
 }
 
 func (c *Compiler) shiftLastOpCodeStartPos(lastOpCodeStartPos int) {
@@ -93,33 +197,29 @@ func (c *Compiler) emit(op code.OpCode, operands ...int) int {
 	startPos := len(c.currentInstructions())
 	newInstructions := append(c.currentInstructions(), ins...)
 	c.currentScope().instructions = newInstructions
+	c.currentScope().sourceMap = c.currentScope().sourceMap.Add(startPos, c.pos)
 	c.shiftLastOpCodeStartPos(startPos)
 	return startPos
 }
 
 func (c *Compiler) compileInfixExpression(node *ast.InfixExpression) error {
+	if value, ok := ConstantValue(node); ok && c.optimize {
+		return c.emitFolded(value)
+	}
+
 	op := node.Operator
-	var err error
-	if op == "<" || op == "<=" {
-		err = c.Compile(node.Right)
-		if err != nil {
-			return err
-		}
+	if op == "=" {
+		return c.compileAssignExpression(node)
+	}
 
-		err := c.Compile(node.Left)
-		if err != nil {
-			return err
-		}
-	} else {
-		err := c.Compile(node.Left)
-		if err != nil {
-			return err
-		}
+	err := c.Compile(node.Left)
+	if err != nil {
+		return err
+	}
 
-		err = c.Compile(node.Right)
-		if err != nil {
-			return err
-		}
+	err = c.Compile(node.Right)
+	if err != nil {
+		return err
 	}
 
 	switch node.Operator {
@@ -133,14 +233,20 @@ func (c *Compiler) compileInfixExpression(node *ast.InfixExpression) error {
 		c.emit(code.OpMultiply)
 	case "/":
 		c.emit(code.OpDivide)
+	case "%":
+		c.emit(code.OpModulo)
 	case "==":
 		c.emit(code.OpEqual)
 	case "!=":
 		c.emit(code.OpNotEqual)
-	case ">", "<":
+	case ">":
 		c.emit(code.OpGreaterThan)
-	case ">=", "<=":
+	case ">=":
 		c.emit(code.OpGreaterEqual)
+	case "<":
+		c.emit(code.OpLessThan)
+	case "<=":
+		c.emit(code.OpLessEqual)
 
 	default:
 		return fmt.Errorf("unknown operator %s", op)
@@ -148,6 +254,33 @@ func (c *Compiler) compileInfixExpression(node *ast.InfixExpression) error {
 	return nil
 }
 
+// compileAssignExpression sets the variable on the left to the value on the right, which
+// is the value of the assignment
+func (c *Compiler) compileAssignExpression(node *ast.InfixExpression) error {
+	ident, ok := node.Left.(*ast.Identifier)
+	if !ok {
+		return &Error{Msg: fmt.Sprintf("can not assign to %s", node.Left.String()), Pos: node.Pos()}
+	}
+
+	// checked after the value, a function in it capturing the variable would not see the assignment
+	if err := c.Compile(node.Right); err != nil {
+		return err
+	}
+
+	symbol, err := c.currentScope().localSymbolTable.Assign(ident.Value, ident.Pos())
+	if err != nil {
+		return err
+	}
+
+	if symbol.Scope == GlobalScope {
+		c.emit(code.OpSetGlobal, symbol.Index)
+	} else {
+		c.emit(code.OpSetLocal, symbol.Index)
+	}
+	c.loadSymbol(symbol)
+	return nil
+}
+
 func (c *Compiler) replaceOperands(opCodeStartPos int, newOperands ...int) {
 	codeToReplace := c.currentInstructions()[opCodeStartPos]
 	c.replaceInstructions(opCodeStartPos, code.OpCode(codeToReplace), newOperands...)
@@ -160,6 +293,14 @@ func (c *Compiler) replaceInstructions(opCodeStartPos int, op code.OpCode, newOp
 	}
 }
 
+// markLine records that statement starts with the next instruction, in the line table
+// of the current function
+func (c *Compiler) markLine(statement ast.Statement) {
+	if pos := statement.Pos(); pos.Line > 0 {
+		c.currentScope().lines = c.currentScope().lines.Add(len(c.currentInstructions()), pos.Line)
+	}
+}
+
 func (c *Compiler) lastOpIs(testOp code.OpCode) bool {
 	if len(c.currentInstructions()) == 0 {
 		return false
@@ -171,6 +312,8 @@ func (c *Compiler) lastOpIs(testOp code.OpCode) bool {
 
 func (c *Compiler) removeLastOp() {
 	c.currentScope().instructions = c.currentInstructions()[:c.currentScope().lastOpCodeStartPos]
+	c.currentScope().sourceMap = c.currentScope().sourceMap.Truncate(c.currentScope().lastOpCodeStartPos)
+	c.currentScope().lines = c.currentScope().lines.Truncate(c.currentScope().lastOpCodeStartPos)
 	c.currentScope().lastOpCodeStartPos = c.currentScope().secondLastOpCodeStartPos
 	c.currentScope().secondLastOpCodeStartPos = -1
 }
@@ -190,29 +333,116 @@ func (c *Compiler) loadSymbol(symbol Symbol) {
 	}
 }
 
+// markTailCalls replaces every OpCall whose result is returned right away with OpTailCall.
+// The result is returned right away when the call is followed by OpReturnValue, directly
+// or through the OpJump at the end of if branches
+func (c *Compiler) markTailCalls() {
+	ins := c.currentInstructions()
+	for pos := 0; pos < len(ins); {
+		op := code.OpCode(ins[pos])
+		def, err := code.Lookup(op)
+		if err != nil {
+			return
+		}
+
+		_, read := code.ReadOperand(def, ins[pos+1:])
+		next := pos + 1 + read
+		if op == code.OpCall && returnsAt(ins, next) {
+			ins[pos] = byte(code.OpTailCall)
+		}
+		pos = next
+	}
+}
+
+// returnsAt reports whether executing ins from pos reaches OpReturnValue
+// without any instruction other than OpJump
+func returnsAt(ins code.Instructions, pos int) bool {
+	// a jump never goes backwards, so following at most len(ins) jumps can not loop
+	for i := 0; i < len(ins) && pos < len(ins); i++ {
+		switch code.OpCode(ins[pos]) {
+		case code.OpReturnValue:
+			return true
+		case code.OpJump:
+			pos = int(code.ReadUint16(ins[pos+1:]))
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// keepBlockValue makes sure the block just compiled leaves its value on the stack
+func (c *Compiler) keepBlockValue(block *ast.BlockExpression) {
+	statements := LiveStatements(block.Statements, c.optimize)
+	if len(statements) == 0 {
+		c.emit(code.OpNull)
+		return
+	}
+
+	switch last := statements[len(statements)-1].(type) {
+	case *ast.ExpressionStatement:
+		c.removeLastOp()
+	case *ast.ReturnStatement:
+	case *ast.LetStatement:
+		c.loadLetValue(last)
+	}
+}
+
+// loadLetValue loads the value bound by the let statement just compiled, which is the
+// value of the let statement
+func (c *Compiler) loadLetValue(let *ast.LetStatement) {
+	symbol, _ := c.currentScope().localSymbolTable.Resolve(let.Name.Value)
+	c.loadSymbol(symbol)
+}
+
 func (c *Compiler) Compile(node ast.Node) error {
+	if node == nil {
+		return fmt.Errorf("can not compile nil node")
+	}
+	c.bytecode = nil
+
+	if pos := node.Pos(); pos.Line > 0 {
+		outer := c.pos
+		c.pos = pos
+		defer func() { c.pos = outer }()
+	}
+
 	switch node := node.(type) {
 	case *ast.Program:
-		for _, statement := range node.Statements {
+		statements := LiveStatements(node.Statements, c.optimize)
+		for _, statement := range statements {
 			err := c.Compile(statement)
 			if err != nil {
 				return err
 			}
 		}
+
+		// the value of the program is the value popped last
+		if len(statements) > 0 {
+			if let, ok := statements[len(statements)-1].(*ast.LetStatement); ok {
+				c.loadLetValue(let)
+				c.emit(code.OpPop)
+			}
+		}
 	case *ast.BlockExpression:
-		for _, statement := range node.Statements {
+		for _, statement := range LiveStatements(node.Statements, c.optimize) {
 			err := c.Compile(statement)
 			if err != nil {
 				return err
 			}
 		}
 	case *ast.ExpressionStatement:
+		c.markLine(node)
 		err := c.Compile(node.Value)
 		if err != nil {
 			return err
 		}
 		c.emit(code.OpPop)
 	case *ast.PrefixExpression:
+		if value, ok := ConstantValue(node); ok && c.optimize {
+			return c.emitFolded(value)
+		}
+
 		err := c.Compile(node.Value)
 		if err != nil {
 			return err
@@ -231,7 +461,47 @@ func (c *Compiler) Compile(node ast.Node) error {
 		if err != nil {
 			return err
 		}
+	case *ast.SliceExpression:
+		err := c.Compile(node.Left)
+		if err != nil {
+			return err
+		}
+
+		// an omitted bound is passed to OpSlice as null
+		for _, bound := range []ast.Expression{node.Start, node.End} {
+			if bound == nil {
+				c.emit(code.OpNull)
+				continue
+			}
+
+			err = c.Compile(bound)
+			if err != nil {
+				return err
+			}
+		}
+
+		c.emit(code.OpSlice)
 	case *ast.IfExpression:
+		if condition, ok := ConstantValue(node.Condition); ok && c.optimize {
+			// only the branch taken is compiled
+			body := node.ElseBody
+			if condition != object.FALSE && condition != object.NULL {
+				body = node.ThenBody
+			}
+
+			if body == nil {
+				c.emit(code.OpNull)
+				break
+			}
+
+			err := c.Compile(body)
+			if err != nil {
+				return err
+			}
+			c.keepBlockValue(body)
+			break
+		}
+
 		err := c.Compile(node.Condition)
 		if err != nil {
 			return err
@@ -244,9 +514,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 
 		// remove last OpPop to keep the last value of ThenBody in stack
-		if c.lastOpIs(code.OpPop) {
-			c.removeLastOp()
-		}
+		c.keepBlockValue(node.ThenBody)
 
 		jumpPos := c.emit(code.OpJump, 9999)
 		endOfThenBody := len(c.currentInstructions())
@@ -264,18 +532,27 @@ func (c *Compiler) Compile(node ast.Node) error {
 			}
 
 			// same reason as remove last OpPop from ThenBody
-			c.removeLastOp()
+			c.keepBlockValue(node.ElseBody)
 		}
 
 		endOfElseBody := len(c.currentInstructions())
 		c.replaceOperands(jumpPos, endOfElseBody)
 	case *ast.LetStatement:
-		symbol := c.currentScope().localSymbolTable.Define(node.Name.Value)
+		c.markLine(node)
+		table := c.currentScope().localSymbolTable
+		defined := table.Defines(node.Name.Value)
+		symbol := table.Define(node.Name.Value)
 		err := c.Compile(node.Value)
 		if err != nil {
 			return err
 		}
 
+		if defined {
+			if err := table.Redefine(node.Name.Value, node.Name.Pos()); err != nil {
+				return err
+			}
+		}
+
 		if symbol.Scope == GlobalScope {
 			c.emit(code.OpSetGlobal, symbol.Index)
 		} else {
@@ -293,29 +570,31 @@ func (c *Compiler) Compile(node ast.Node) error {
 		c.emit(code.OpArray, len(node.Elements))
 	case *ast.HashLiteral:
 		var err error
-		for k, v := range node.Pair {
-			err = c.Compile(k)
+		for _, pair := range node.Pairs {
+			err = c.Compile(pair.Key)
 			if err != nil {
 				return err
 			}
-			err = c.Compile(v)
+			err = c.Compile(pair.Value)
 			if err != nil {
 				return err
 			}
 		}
 
-		c.emit(code.OpHash, len(node.Pair))
+		c.emit(code.OpHash, len(node.Pairs))
 	case *ast.Identifier:
 		identifier := node.Value
 		symbol, ok := c.currentScope().localSymbolTable.Resolve(identifier)
 		if !ok {
-			return fmt.Errorf("undefined variable %s", identifier)
+			return &Error{Msg: fmt.Sprintf("undefined variable %s", identifier), Pos: node.Pos()}
 		}
 
 		c.loadSymbol(symbol)
 	case *ast.Integer:
-		v := &object.Integer{Value: node.Value}
-		c.emit(code.OpConstant, c.addConstant(v))
+		err := c.emitConstant(&object.Integer{Value: node.Value})
+		if err != nil {
+			return err
+		}
 	case *ast.Boolean:
 		if node.Value {
 			c.emit(code.OpTrue)
@@ -323,9 +602,17 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpFalse)
 		}
 	case *ast.String:
-		v := &object.String{Value: node.Value}
-		c.emit(code.OpConstant, c.addConstant(v))
+		err := c.emitConstant(&object.String{Value: node.Value})
+		if err != nil {
+			return err
+		}
 	case *ast.ReturnStatement:
+		c.markLine(node)
+		if node.Value == nil {
+			c.emit(code.OpReturn)
+			break
+		}
+
 		err := c.Compile(node.Value)
 		if err != nil {
 			return err
@@ -334,7 +621,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 	case *ast.FunctionExpression:
 		c.enterScope()
 
-		if node.Name.Value != "" {
+		if node.Name != nil {
 			c.currentScope().localSymbolTable.DefineFunctionName(node.Name.Value)
 		}
 
@@ -344,29 +631,54 @@ func (c *Compiler) Compile(node ast.Node) error {
 
 		err := c.Compile(node.Body)
 		if err != nil {
-			return fmt.Errorf("compile function %s failed", node.Name)
+			return fmt.Errorf("compile function %s failed: %w", node.Name, err)
 		}
 
 		if c.lastOpIs(code.OpPop) {
 			c.replaceInstructions(c.currentScope().lastOpCodeStartPos, code.OpReturnValue)
+		} else if statements := LiveStatements(node.Body.Statements, c.optimize); len(statements) > 0 {
+			if let, ok := statements[len(statements)-1].(*ast.LetStatement); ok {
+				c.loadLetValue(let)
+				c.emit(code.OpReturnValue)
+			}
 		}
 
 		if !c.lastOpIs(code.OpReturnValue) {
 			c.emit(code.OpReturn)
 		}
 
+		if c.optimize {
+			scope := c.currentScope()
+			scope.instructions, scope.sourceMap, scope.lines = optimizeInstructions(scope.instructions, scope.sourceMap, scope.lines)
+		}
+		c.markTailCalls()
+
 		scope := c.leaveScope()
 		numLocals := scope.localSymbolTable.numDefinitions
 		frees := scope.localSymbolTable.FreeSymbols
 
-		for _, s := range frees {
+		freeNames := make([]string, len(frees))
+		for i, s := range frees {
 			c.loadSymbol(s)
+			freeNames[i] = s.Name
 		}
 
 		fn := &object.CompiledFunction{Instructions: scope.instructions,
+			SourceMap:     scope.sourceMap,
+			Lines:         scope.lines,
 			NumLocals:     numLocals,
-			NumParameters: len(node.Parameters)}
-		c.emit(code.OpClosure, c.addConstant(fn), len(frees))
+			NumParameters: len(node.Parameters),
+			LocalNames:    scope.localSymbolTable.Names(),
+			FreeNames:     freeNames,
+			Pos:           node.Pos()}
+		if node.Name != nil {
+			fn.Name = node.Name.Value
+		}
+		index, err := c.addConstant(fn)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpClosure, index, len(frees))
 	case *ast.CallExpression:
 		err := c.Compile(node.Function)
 		if err != nil {
@@ -389,10 +701,21 @@ func (c *Compiler) Compile(node ast.Node) error {
 }
 
 func (c *Compiler) Bytecode() *Bytecode {
-	return &Bytecode{Instructions: c.currentInstructions(), Constants: c.constants}
+	if c.bytecode != nil {
+		return c.bytecode
+	}
+
+	ins, sourceMap, lines := c.currentInstructions(), c.currentScope().sourceMap, c.currentScope().lines
+	if c.optimize {
+		ins, sourceMap, lines = optimizeInstructions(ins, sourceMap, lines)
+	}
+	c.bytecode = &Bytecode{Instructions: ins, SourceMap: sourceMap, Lines: lines, Constants: c.constants.constants}
+	return c.bytecode
 }
 
 type Bytecode struct {
 	Instructions code.Instructions
+	SourceMap    code.SourceMap
+	Lines        code.LineTable
 	Constants    []object.Object
 }
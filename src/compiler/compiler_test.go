@@ -3,10 +3,12 @@ package compiler
 import (
 	"ast"
 	"code"
+	"errors"
 	"fmt"
 	"object"
 	"parser"
 	"testing"
+	"token"
 )
 
 type compileTestCase struct {
@@ -146,6 +148,16 @@ func testConstants(expectConstants []interface{}, actualConstans []object.Object
 
 func runTests(t *testing.T, tests []compileTestCase) {
 	t.Helper()
+	runTestsWithOptimization(t, tests, false)
+}
+
+func runOptimizedTests(t *testing.T, tests []compileTestCase) {
+	t.Helper()
+	runTestsWithOptimization(t, tests, true)
+}
+
+func runTestsWithOptimization(t *testing.T, tests []compileTestCase, optimize bool) {
+	t.Helper()
 
 	for _, test := range tests {
 		program, err := parse(test.input)
@@ -154,6 +166,9 @@ func runTests(t *testing.T, tests []compileTestCase) {
 		}
 
 		c := New()
+		if !optimize {
+			c.DisableOptimizations()
+		}
 		err = c.Compile(program)
 		if err != nil {
 			t.Errorf("compile input %s failed %s", test.input, err)
@@ -185,6 +200,15 @@ func TestCompileIntegerArithmetic(t *testing.T) {
 			code.Make(code.OpMinus),
 			code.Make(code.OpPop)},
 			[]interface{}{3}},
+		{"7 % 3",
+			[]code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpModulo),
+				code.Make(code.OpPop)},
+			[]interface{}{
+				7,
+				3}},
 		{"-1 + 2",
 			[]code.Instructions{
 				code.Make(code.OpConstant, 0),
@@ -228,16 +252,15 @@ func TestCompileIntegerArithmetic(t *testing.T) {
 			}},
 		{"4 - 4 * 15 / 2",
 			[]code.Instructions{
+				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
-				code.Make(code.OpConstant, 2),
 				code.Make(code.OpMultiply),
-				code.Make(code.OpConstant, 3),
+				code.Make(code.OpConstant, 2),
 				code.Make(code.OpDivide),
 				code.Make(code.OpSubtraction),
 				code.Make(code.OpPop)},
 			[]interface{}{
-				4,
 				4,
 				15,
 				2,
@@ -272,7 +295,7 @@ func TestCompileBoolean(t *testing.T) {
 			code.Make(code.OpFalse),
 			code.Make(code.OpConstant, 3),
 			code.Make(code.OpConstant, 4),
-			code.Make(code.OpGreaterThan),
+			code.Make(code.OpLessThan),
 			code.Make(code.OpNotEqual),
 			code.Make(code.OpEqual),
 			code.Make(code.OpPop)},
@@ -280,21 +303,21 @@ func TestCompileBoolean(t *testing.T) {
 				15,
 				221,
 				236,
-				103,
 				68,
+				103,
 			}},
 
 		{"(68 - 25) <= 236", []code.Instructions{
 			code.Make(code.OpConstant, 0),
 			code.Make(code.OpConstant, 1),
-			code.Make(code.OpConstant, 2),
 			code.Make(code.OpSubtraction),
-			code.Make(code.OpGreaterEqual),
+			code.Make(code.OpConstant, 2),
+			code.Make(code.OpLessEqual),
 			code.Make(code.OpPop)},
 			[]interface{}{
-				236,
 				68,
 				25,
+				236,
 			}},
 		{"(68 - 25) > 21", []code.Instructions{
 			code.Make(code.OpConstant, 0),
@@ -387,6 +410,8 @@ func TestGetSetGlobal(t *testing.T) {
 				code.Make(code.OpSetGlobal, 0),
 				code.Make(code.OpConstant, 1),
 				code.Make(code.OpSetGlobal, 1),
+				code.Make(code.OpGetGlobal, 1),
+				code.Make(code.OpPop),
 			},
 			[]interface{}{
 				1,
@@ -425,6 +450,77 @@ func TestGetSetGlobal(t *testing.T) {
 	runTests(t, tests)
 }
 
+func TestAssignment(t *testing.T) {
+	tests := []compileTestCase{
+		{`let a = 1;
+		  a = 2;`,
+			[]code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+			},
+			[]interface{}{
+				1,
+				2,
+			},
+		},
+		{`fn(a) { a = a + 1 }`,
+			[]code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+			[]interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpAdd),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+		},
+	}
+
+	runTests(t, tests)
+}
+
+func TestAssignmentErrors(t *testing.T) {
+	tests := []struct {
+		input  string
+		expect string
+		pos    token.Position
+	}{
+		{"1 = 2", "can not assign to 1", token.Position{Line: 1, Column: 3}},
+		{"a = 2", "undefined variable a", token.Position{Line: 1, Column: 1}},
+		{"len = 2", "can not assign to builtin len", token.Position{Line: 1, Column: 1}},
+		{"fn(a) { fn() { a = 2 } }", "can not assign to a, it is captured by a function", token.Position{Line: 1, Column: 16}},
+		{"fn(a) { let f = fn() { a }; a = 2 }", "can not assign to a, it is captured by a function", token.Position{Line: 1, Column: 29}},
+		{"fn(a) { let f = fn() { a }; let a = 2 }", "can not define a again, it is captured by a function", token.Position{Line: 1, Column: 33}},
+		{"fn() { let f = fn() { f = 1 } }", "can not assign to f, it is captured by a function", token.Position{Line: 1, Column: 23}},
+	}
+
+	for _, test := range tests {
+		program, err := parse(test.input)
+		if err != nil {
+			t.Fatalf("parse input %s failed %s", test.input, err)
+		}
+
+		var cerr *Error
+		err = New().Compile(program)
+		if !errors.As(err, &cerr) {
+			t.Fatalf("expect an Error for input: %s. got %v", test.input, err)
+		}
+		if cerr.Msg != test.expect || cerr.Pos != test.pos {
+			t.Errorf("wrong error for input: %s. want=%q at %+v, got=%q at %+v", test.input, test.expect, test.pos, cerr.Msg, cerr.Pos)
+		}
+	}
+}
+
 func TestArray(t *testing.T) {
 	tests := []compileTestCase{
 		{`[]`,
@@ -477,13 +573,59 @@ func TestArray(t *testing.T) {
 				code.Make(code.OpAdd),
 				code.Make(code.OpArray, 4),
 				code.Make(code.OpConstant, 5),
-				code.Make(code.OpConstant, 6),
+				code.Make(code.OpConstant, 0),
 				code.Make(code.OpAdd),
 				code.Make(code.OpIndex),
 				code.Make(code.OpPop),
 			},
 			[]interface{}{
-				1, 2, 15, "hello", "world", 26, 1,
+				1, 2, 15, "hello", "world", 26,
+			},
+		},
+	}
+
+	runTests(t, tests)
+}
+
+func TestSlice(t *testing.T) {
+	tests := []compileTestCase{
+		{`[1,2,3][1:2]`,
+			[]code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpArray, 3),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSlice),
+				code.Make(code.OpPop),
+			},
+			[]interface{}{
+				1, 2, 3,
+			},
+		},
+		{`"hello"[2:]`,
+			[]code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpNull),
+				code.Make(code.OpSlice),
+				code.Make(code.OpPop),
+			},
+			[]interface{}{
+				"hello", 2,
+			},
+		},
+		{`"hello"[:]`,
+			[]code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpNull),
+				code.Make(code.OpNull),
+				code.Make(code.OpSlice),
+				code.Make(code.OpPop),
+			},
+			[]interface{}{
+				"hello",
 			},
 		},
 	}
@@ -493,26 +635,43 @@ func TestArray(t *testing.T) {
 
 func TestHash(t *testing.T) {
 	tests := []compileTestCase{
-		//{`{}`,
-		//	[]code.Instructions{
-		//		code.Make(code.OpHash, 0),
-		//		code.Make(code.OpPop),
-		//	},
-		//	[]interface{}{},
-		//},
-		//{`{1:2,3:4}`,
-		//	[]code.Instructions{
-		//		code.Make(code.OpConstant, 0),
-		//		code.Make(code.OpConstant, 1),
-		//		code.Make(code.OpConstant, 2),
-		//		code.Make(code.OpConstant, 3),
-		//		code.Make(code.OpHash, 2),
-		//		code.Make(code.OpPop),
-		//	},
-		//	[]interface{}{
-		//		1, 2, 3, 4,
-		//	},
-		//},
+		{`{}`,
+			[]code.Instructions{
+				code.Make(code.OpHash, 0),
+				code.Make(code.OpPop),
+			},
+			[]interface{}{},
+		},
+		{`{1:2,3:4}`,
+			[]code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpHash, 2),
+				code.Make(code.OpPop),
+			},
+			[]interface{}{
+				1, 2, 3, 4,
+			},
+		},
+		{`{"b":1 + 2,"a":3 * 4}`,
+			[]code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpAdd),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpConstant, 4),
+				code.Make(code.OpConstant, 5),
+				code.Make(code.OpMultiply),
+				code.Make(code.OpHash, 2),
+				code.Make(code.OpPop),
+			},
+			[]interface{}{
+				"b", 1, 2, "a", 3, 4,
+			},
+		},
 		{`{1:2,3:4,5:6}[3]`,
 			[]code.Instructions{
 				code.Make(code.OpConstant, 0),
@@ -522,12 +681,12 @@ func TestHash(t *testing.T) {
 				code.Make(code.OpConstant, 4),
 				code.Make(code.OpConstant, 5),
 				code.Make(code.OpHash, 3),
-				code.Make(code.OpConstant, 6),
+				code.Make(code.OpConstant, 2),
 				code.Make(code.OpIndex),
 				code.Make(code.OpPop),
 			},
 			[]interface{}{
-				1, 2, 3, 4, 5, 6, 3,
+				1, 2, 3, 4, 5, 6,
 			},
 		},
 	}
@@ -806,7 +965,7 @@ func TestBuiltin(t *testing.T) {
 					Instructions: code.FlattenInstructions([]code.Instructions{
 						code.Make(code.OpGetBuiltin, 0),
 						code.Make(code.OpArray, 0),
-						code.Make(code.OpCall, 1),
+						code.Make(code.OpTailCall, 1),
 						code.Make(code.OpReturnValue),
 					}),
 					NumLocals:     0,
@@ -951,7 +1110,6 @@ func TestRecursiveFunctions(t *testing.T) {
            wrapper();
            `,
 			expectConstants: []interface{}{
-				0,
 				0,
 				1,
 				[]code.Instructions{
@@ -959,28 +1117,27 @@ func TestRecursiveFunctions(t *testing.T) {
 					code.Make(code.OpConstant, 0),
 					code.Make(code.OpEqual),
 					code.Make(code.OpJumptNotTruethy, 16),
-					code.Make(code.OpConstant, 1),
+					code.Make(code.OpConstant, 0),
 					code.Make(code.OpReturnValue),
 					code.Make(code.OpJump, 25),
 					code.Make(code.OpCurrentClosure),
 					code.Make(code.OpGetLocal, 0),
-					code.Make(code.OpConstant, 2),
+					code.Make(code.OpConstant, 1),
 					code.Make(code.OpSubtraction),
-					code.Make(code.OpCall, 1),
+					code.Make(code.OpTailCall, 1),
 					code.Make(code.OpReturnValue),
 				},
-				1,
 				[]code.Instructions{
-					code.Make(code.OpClosure, 3, 0),
+					code.Make(code.OpClosure, 2, 0),
 					code.Make(code.OpSetLocal, 0),
 					code.Make(code.OpGetLocal, 0),
-					code.Make(code.OpConstant, 4),
-					code.Make(code.OpCall, 1),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpTailCall, 1),
 					code.Make(code.OpReturnValue),
 				},
 			},
 			expectInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 5, 0),
+				code.Make(code.OpClosure, 3, 0),
 				code.Make(code.OpSetGlobal, 0),
 				code.Make(code.OpGetGlobal, 0),
 				code.Make(code.OpCall, 0),
@@ -991,3 +1148,241 @@ func TestRecursiveFunctions(t *testing.T) {
 
 	runTests(t, tests)
 }
+
+func TestTailCalls(t *testing.T) {
+	tests := []compileTestCase{
+		{
+			input: `fn(f) { f(1) + 1 }`,
+			expectConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpCall, 1),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `fn(f) { return f(1); }`,
+			expectConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpTailCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `fn(f) { if (true) { f(1) } else { f(2) } }`,
+			expectConstants: []interface{}{
+				1,
+				2,
+				[]code.Instructions{
+					code.Make(code.OpTrue),
+					code.Make(code.OpJumptNotTruethy, 14),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpTailCall, 1),
+					code.Make(code.OpJump, 21),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpTailCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runTests(t, tests)
+}
+
+func TestConstantDeduplication(t *testing.T) {
+	tests := []compileTestCase{
+		{
+			input:           `1; 1; "a"; "a"; 1`,
+			expectConstants: []interface{}{1, "a"},
+			expectInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `fn() { 1 }; fn() { 1 }`,
+			expectConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runTests(t, tests)
+}
+
+func TestConstantsReusedByNewWithStates(t *testing.T) {
+	symbolTable := NewSymbolTable()
+	constants := []object.Object{}
+	for _, input := range []string{`let a = 1; "s"`, `a + 1; "s"; 2`} {
+		program, err := parse(input)
+		if err != nil {
+			t.Fatalf("parse input %s failed %s", input, err)
+		}
+
+		c := NewWithStates(constants, symbolTable)
+		err = c.Compile(program)
+		if err != nil {
+			t.Fatalf("compile input %s failed %s", input, err)
+		}
+		constants = c.Bytecode().Constants
+	}
+
+	err := testConstants([]interface{}{1, "s", 2}, constants)
+	if err != nil {
+		t.Errorf("constants are not reused: %s", err)
+	}
+}
+
+func TestTooManyConstants(t *testing.T) {
+	constants := make([]object.Object, MaxConstants)
+	for i := range constants {
+		constants[i] = &object.Integer{Value: int64(i)}
+	}
+
+	program, err := parse(`1; 65536`)
+	if err != nil {
+		t.Fatalf("parse failed %s", err)
+	}
+
+	c := NewWithStates(constants, NewSymbolTable())
+	err = c.Compile(program)
+	if err == nil {
+		t.Fatalf("expect an error for more than %d constants", MaxConstants)
+	}
+
+	expect := "too many constants: a program can have at most 65536"
+	if err.Error() != expect {
+		t.Errorf("wrong error. want=%q got=%q", expect, err)
+	}
+}
+
+func TestUndefinedVariablePosition(t *testing.T) {
+	program, err := parse("let f = fn(x) {\n\tx + y\n};")
+	if err != nil {
+		t.Fatalf("parse failed %s", err)
+	}
+
+	err = New().Compile(program)
+	expect := "compile function f failed: undefined variable y"
+	if err == nil || err.Error() != expect {
+		t.Fatalf("wrong error. want=%q got=%v", expect, err)
+	}
+
+	var cerr *Error
+	if !errors.As(err, &cerr) {
+		t.Fatalf("error %q does not wrap an Error", err)
+	}
+	if cerr.Msg != "undefined variable y" || cerr.Pos != (token.Position{Line: 2, Column: 6}) {
+		t.Errorf("wrong Error. got=%q at %+v", cerr.Msg, cerr.Pos)
+	}
+}
+
+func TestLineTablesAndNames(t *testing.T) {
+	input := `let a = 1;
+let f = fn(x, y) {
+	let z = x + a;
+	fn() {
+		z + y
+	}
+};
+f(1, 2)`
+
+	for _, optimize := range []bool{false, true} {
+		program, err := parse(input)
+		if err != nil {
+			t.Fatalf("parse failed %s", err)
+		}
+
+		c := New()
+		if !optimize {
+			c.DisableOptimizations()
+		}
+		if err := c.Compile(program); err != nil {
+			t.Fatalf("compile failed %s", err)
+		}
+		bytecode := c.Bytecode()
+
+		lines := func(table code.LineTable) []int {
+			ret := []int{}
+			for _, start := range table {
+				ret = append(ret, start.Line)
+			}
+			return ret
+		}
+		if got := lines(bytecode.Lines); fmt.Sprint(got) != "[1 2 8]" {
+			t.Errorf("wrong lines of the program (optimize=%t). got=%v", optimize, got)
+		}
+
+		var outer, inner *object.CompiledFunction
+		for _, constant := range bytecode.Constants {
+			if fn, ok := constant.(*object.CompiledFunction); ok {
+				if fn.Name == "f" {
+					outer = fn
+				} else {
+					inner = fn
+				}
+			}
+		}
+		if outer == nil || inner == nil {
+			t.Fatalf("functions not found in the constants")
+		}
+
+		if got := lines(outer.Lines); fmt.Sprint(got) != "[3 4]" {
+			t.Errorf("wrong lines of f (optimize=%t). got=%v", optimize, got)
+		}
+		if got := lines(inner.Lines); fmt.Sprint(got) != "[5]" {
+			t.Errorf("wrong lines of the inner function (optimize=%t). got=%v", optimize, got)
+		}
+		if fmt.Sprint(outer.LocalNames) != "[x y z]" || len(outer.FreeNames) != 0 {
+			t.Errorf("wrong names of f. locals=%v, frees=%v", outer.LocalNames, outer.FreeNames)
+		}
+		if len(inner.LocalNames) != 0 || fmt.Sprint(inner.FreeNames) != "[z y]" {
+			t.Errorf("wrong names of the inner function. locals=%v, frees=%v", inner.LocalNames, inner.FreeNames)
+		}
+	}
+}
@@ -0,0 +1,347 @@
+package compiler
+
+import (
+	"ast"
+	"code"
+	"object"
+	"token"
+)
+
+// ConstantValue returns the value of node if it can be computed at compile time.
+// It folds with the same functions of object the vm and the evaluator use, so a folded
+// expression has the value it would have at run time. An expression which fails,
+// like a division by zero, is left to fail at run time
+func ConstantValue(node ast.Expression) (object.Object, bool) {
+	switch node := node.(type) {
+	case *ast.Integer:
+		return &object.Integer{Value: node.Value}, true
+	case *ast.String:
+		return &object.String{Value: node.Value}, true
+	case *ast.Boolean:
+		return object.NativeBooleanToBooleanObj(node.Value), true
+	case *ast.PrefixExpression:
+		value, ok := ConstantValue(node.Value)
+		if !ok {
+			return nil, false
+		}
+
+		switch node.Operator {
+		case "!":
+			return object.NativeBooleanToBooleanObj(value == object.FALSE || value == object.NULL), true
+		case "-":
+			ret, err := object.IntegerNegate(value)
+			return ret, err == nil
+		}
+	case *ast.InfixExpression:
+		if node.Operator == "=" || node.Operator == "[" {
+			return nil, false
+		}
+
+		left, ok := ConstantValue(node.Left)
+		if !ok {
+			return nil, false
+		}
+
+		right, ok := ConstantValue(node.Right)
+		if !ok {
+			return nil, false
+		}
+		return constantInfix(node.Operator, left, right)
+	}
+	return nil, false
+}
+
+func constantInfix(operator string, left object.Object, right object.Object) (object.Object, bool) {
+	ret, err := object.Infix(operator, left, right)
+	return ret, err == nil
+}
+
+// emitFolded emits the instruction which pushes the folded value obj
+func (c *Compiler) emitFolded(obj object.Object) error {
+	switch obj {
+	case object.TRUE:
+		c.emit(code.OpTrue)
+	case object.FALSE:
+		c.emit(code.OpFalse)
+	case object.NULL:
+		c.emit(code.OpNull)
+	default:
+		return c.emitConstant(obj)
+	}
+	return nil
+}
+
+// LiveStatements drops the statements after a return statement when optimizing, they are never executed
+func LiveStatements(statements []ast.Statement, optimize bool) []ast.Statement {
+	if !optimize {
+		return statements
+	}
+
+	for i, statement := range statements {
+		if _, ok := statement.(*ast.ReturnStatement); ok {
+			return statements[:i+1]
+		}
+	}
+	return statements
+}
+
+type instruction struct {
+	pos      int
+	op       code.OpCode
+	operands []int
+	removed  bool
+	// sourceOffset is the instruction whose source position this one takes, a superinstruction
+	// takes the position of the instruction which may fail
+	sourceOffset int
+}
+
+// compareJumps are the superinstructions for a comparison followed by OpJumpNotTruethy
+var compareJumps = map[code.OpCode]code.OpCode{
+	code.OpEqual:        code.OpJumpNotEqual,
+	code.OpNotEqual:     code.OpJumpEqual,
+	code.OpGreaterThan:  code.OpJumpNotGreaterThan,
+	code.OpGreaterEqual: code.OpJumpNotGreaterEqual,
+	code.OpLessThan:     code.OpJumpNotLessThan,
+	code.OpLessEqual:    code.OpJumpNotLessEqual,
+}
+
+// localConstantOps are the superinstructions for OpGetLocal, OpConstant and an operator
+var localConstantOps = map[code.OpCode]code.OpCode{
+	code.OpAdd:         code.OpAddLocalConstant,
+	code.OpSubtraction: code.OpSubtractLocalConstant,
+}
+
+func isJump(op code.OpCode) bool {
+	switch op {
+	case code.OpJump, code.OpJumptNotTruethy,
+		code.OpJumpNotEqual, code.OpJumpEqual, code.OpJumpNotGreaterThan, code.OpJumpNotGreaterEqual,
+		code.OpJumpNotLessThan, code.OpJumpNotLessEqual:
+		return true
+	default:
+		return false
+	}
+}
+
+func decodeInstructions(ins code.Instructions) ([]instruction, bool) {
+	var ret []instruction
+	for pos := 0; pos < len(ins); {
+		def, err := code.Lookup(code.OpCode(ins[pos]))
+		if err != nil {
+			return nil, false
+		}
+
+		operands, read := code.ReadOperand(def, ins[pos+1:])
+		ret = append(ret, instruction{pos: pos, op: code.OpCode(ins[pos]), operands: operands, sourceOffset: pos})
+		pos += 1 + read
+	}
+	return ret, true
+}
+
+// peephole is a pass over the instructions of one function. It rewrites
+//   - OpTrue, OpJumpNotTruethy into nothing
+//   - OpFalse or OpNull, OpJumpNotTruethy into OpJump
+//   - OpJump to another OpJump into a jump to the final target
+//
+// and removes jumps to the next instruction and instructions which can not be reached.
+// At last common sequences are fused into superinstructions. Jump targets and the
+// source map are moved along with the remaining instructions
+type peephole struct {
+	ins []instruction
+	// index holds the index of the instruction at each position
+	index map[int]int
+}
+
+func newPeephole(ins []instruction) *peephole {
+	index := make(map[int]int, len(ins))
+	for i, in := range ins {
+		index[in.pos] = i
+	}
+	return &peephole{ins: ins, index: index}
+}
+
+// resolve returns the index of the first remaining instruction at or after pos,
+// which is where a jump to pos goes. It is len(p.ins) for the end of the function
+func (p *peephole) resolve(pos int) int {
+	i, ok := p.index[pos]
+	if !ok {
+		return len(p.ins)
+	}
+	if p.ins[i].removed {
+		return p.next(i)
+	}
+	return i
+}
+
+func (p *peephole) next(i int) int {
+	for i++; i < len(p.ins) && p.ins[i].removed; i++ {
+	}
+	return i
+}
+
+// targets returns the indexes of the instructions remaining jumps go to
+func (p *peephole) targets() map[int]bool {
+	ret := map[int]bool{}
+	for _, in := range p.ins {
+		if !in.removed && isJump(in.op) {
+			ret[p.resolve(in.operands[0])] = true
+		}
+	}
+	return ret
+}
+
+// remove removes the instruction i. A jump to it goes to the next remaining
+// instruction, which becomes a target in its place
+func (p *peephole) remove(i int, targets map[int]bool) {
+	p.ins[i].removed = true
+	if targets[i] {
+		targets[p.next(i)] = true
+	}
+}
+
+// rewrite is one pass applying the rewrites, it reports whether there was one to apply.
+// The targets are found once for the pass and kept up to date by the rewrites
+func (p *peephole) rewrite() bool {
+	targets := p.targets()
+	rewritten := false
+	reachable := true
+	for i := range p.ins {
+		in := &p.ins[i]
+		if in.removed {
+			continue
+		}
+
+		if targets[i] {
+			reachable = true
+		}
+		if !reachable {
+			p.remove(i, targets)
+			rewritten = true
+			continue
+		}
+
+		next := p.next(i)
+		switch in.op {
+		case code.OpTrue, code.OpFalse, code.OpNull:
+			if next == len(p.ins) || p.ins[next].op != code.OpJumptNotTruethy || targets[next] {
+				break
+			}
+
+			rewritten = true
+			if in.op == code.OpTrue {
+				p.remove(i, targets)
+				p.remove(next, targets)
+			} else {
+				p.remove(i, targets)
+				p.ins[next].op = code.OpJump
+			}
+		case code.OpJump:
+			target := p.resolve(in.operands[0])
+			if target == next {
+				p.remove(i, targets)
+				rewritten = true
+				break
+			}
+
+			if target < len(p.ins) && p.ins[target].op == code.OpJump && target != i && p.ins[target].operands[0] != in.operands[0] {
+				in.operands[0] = p.ins[target].operands[0]
+				targets[p.resolve(in.operands[0])] = true
+				rewritten = true
+			}
+			reachable = false
+		case code.OpReturnValue, code.OpReturn:
+			reachable = false
+		}
+	}
+	return rewritten
+}
+
+// fuse replaces common sequences of instructions with superinstructions. An instruction
+// a jump goes to is never fused into the one before it
+func (p *peephole) fuse() {
+	targets := p.targets()
+	for i := p.next(-1); i < len(p.ins); i = p.next(i) {
+		in := &p.ins[i]
+		second := p.next(i)
+		if second == len(p.ins) || targets[second] {
+			continue
+		}
+
+		if op, ok := compareJumps[in.op]; ok && p.ins[second].op == code.OpJumptNotTruethy {
+			in.op = op
+			in.operands = p.ins[second].operands
+			p.ins[second].removed = true
+			continue
+		}
+
+		third := p.next(second)
+		if in.op != code.OpGetLocal || p.ins[second].op != code.OpConstant || third == len(p.ins) || targets[third] {
+			continue
+		}
+
+		if op, ok := localConstantOps[p.ins[third].op]; ok {
+			in.op = op
+			in.operands = []int{in.operands[0], p.ins[second].operands[0]}
+			in.sourceOffset = p.ins[third].pos
+			p.ins[second].removed = true
+			p.ins[third].removed = true
+		}
+	}
+}
+
+// optimizeInstructions returns ins, sourceMap and lines after the peephole pass
+func optimizeInstructions(ins code.Instructions, sourceMap code.SourceMap, lines code.LineTable) (code.Instructions, code.SourceMap, code.LineTable) {
+	decoded, ok := decodeInstructions(ins)
+	if !ok {
+		return ins, sourceMap, lines
+	}
+
+	p := newPeephole(decoded)
+	// every pass removes an instruction or shortens a jump chain
+	for i := 0; i < 2*len(p.ins) && p.rewrite(); i++ {
+	}
+	p.fuse()
+
+	// place the remaining instructions and find where each jump goes
+	newPos := make([]int, len(p.ins)+1)
+	length := 0
+	for i, in := range p.ins {
+		newPos[i] = length
+		if !in.removed {
+			length += len(code.Make(in.op, in.operands...))
+		}
+	}
+	newPos[len(p.ins)] = length
+
+	out := make(code.Instructions, 0, length)
+	for _, in := range p.ins {
+		if in.removed {
+			continue
+		}
+
+		operands := in.operands
+		if isJump(in.op) {
+			operands = []int{newPos[p.resolve(in.operands[0])]}
+		}
+		out = append(out, code.Make(in.op, operands...)...)
+	}
+
+	positions := map[int]token.Position{}
+	for _, entry := range sourceMap {
+		positions[entry.Offset] = entry.Pos
+	}
+
+	var newSourceMap code.SourceMap
+	for i, in := range p.ins {
+		pos, ok := positions[in.sourceOffset]
+		if ok && !in.removed {
+			newSourceMap = append(newSourceMap, code.SourcePos{Offset: newPos[i], Pos: pos})
+		}
+	}
+
+	// a statement whose first instruction is removed starts at the next remaining one
+	var newLines code.LineTable
+	for _, start := range lines {
+		newLines = newLines.Add(newPos[p.resolve(start.Offset)], start.Line)
+	}
+	return out, newSourceMap, newLines
+}
@@ -0,0 +1,327 @@
+package compiler
+
+import (
+	"code"
+	"testing"
+	"token"
+)
+
+func TestConstantFolding(t *testing.T) {
+	tests := []compileTestCase{
+		{
+			input:           "1 + 2 * 3",
+			expectConstants: []interface{}{7},
+			expectInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:           "-(1 - 2) % 3",
+			expectConstants: []interface{}{1},
+			expectInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:           `"a" + "b" == "ab"`,
+			expectConstants: []interface{}{},
+			expectInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:           `!(1 < 2) != "a"`,
+			expectConstants: []interface{}{},
+			expectInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:           "1 / 0",
+			expectConstants: []interface{}{1, 0},
+			expectInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpDivide),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn(x) { x + (2 * 3) }",
+			expectConstants: []interface{}{
+				6,
+				[]code.Instructions{
+					code.Make(code.OpAddLocalConstant, 0, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runOptimizedTests(t, tests)
+}
+
+func TestDeadCodeElimination(t *testing.T) {
+	tests := []compileTestCase{
+		{
+			input:           "if (true) { 10 } else { 20 }; 3333",
+			expectConstants: []interface{}{10, 3333},
+			expectInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:           "if (1 > 2) { 10 }",
+			expectConstants: []interface{}{},
+			expectInstructions: []code.Instructions{
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn() { return 1; 2 }",
+			expectConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn(x) { if (x) { return 1; } else { return 2; } }",
+			expectConstants: []interface{}{
+				1,
+				2,
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpJumptNotTruethy, 9),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runOptimizedTests(t, tests)
+}
+
+func TestPeephole(t *testing.T) {
+	tests := []struct {
+		input  []code.Instructions
+		expect []code.Instructions
+	}{
+		{
+			input: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpJumptNotTruethy, 7),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+			expect: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: []code.Instructions{
+				code.Make(code.OpFalse),
+				code.Make(code.OpJumptNotTruethy, 7),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+			expect: []code.Instructions{
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: []code.Instructions{
+				code.Make(code.OpGetLocal, 0),
+				code.Make(code.OpJumptNotTruethy, 8),
+				code.Make(code.OpJump, 9),
+				code.Make(code.OpNull),
+				code.Make(code.OpJump, 12),
+				code.Make(code.OpPop),
+			},
+			expect: []code.Instructions{
+				code.Make(code.OpGetLocal, 0),
+				code.Make(code.OpJumptNotTruethy, 8),
+				code.Make(code.OpJump, 9),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: []code.Instructions{
+				code.Make(code.OpGetLocal, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSubtraction),
+				code.Make(code.OpPop),
+			},
+			expect: []code.Instructions{
+				code.Make(code.OpSubtractLocalConstant, 0, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// a chain of jumps is followed to the end, in the passes the targets change
+			input: []code.Instructions{
+				code.Make(code.OpGetLocal, 0),
+				code.Make(code.OpJumptNotTruethy, 11),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpJump, 12),
+				code.Make(code.OpNull),
+				code.Make(code.OpJump, 16),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+			expect: []code.Instructions{
+				code.Make(code.OpGetLocal, 0),
+				code.Make(code.OpJumptNotTruethy, 11),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpJump, 12),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// a jump target is not fused into the instruction before it
+			input: []code.Instructions{
+				code.Make(code.OpGetLocal, 0),
+				code.Make(code.OpJumptNotTruethy, 7),
+				code.Make(code.OpGetLocal, 1),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+			},
+			expect: []code.Instructions{
+				code.Make(code.OpGetLocal, 0),
+				code.Make(code.OpJumptNotTruethy, 7),
+				code.Make(code.OpGetLocal, 1),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		ins := code.FlattenInstructions(test.input)
+		var sourceMap code.SourceMap
+		for pos, line := 0, 1; pos < len(ins); line++ {
+			sourceMap = sourceMap.Add(pos, token.Position{Line: line})
+			def, _ := code.Lookup(code.OpCode(ins[pos]))
+			_, read := code.ReadOperand(def, ins[pos+1:])
+			pos += 1 + read
+		}
+
+		actual, actualSourceMap, _ := optimizeInstructions(ins, sourceMap, nil)
+		err := testInstructions(code.FlattenInstructions(test.expect), actual)
+		if err != nil {
+			t.Errorf("wrong instructions after peephole. %s", err)
+		}
+
+		// the last instruction keeps its position
+		last, _ := actualSourceMap.Lookup(len(actual) - 1)
+		if last.Line != len(test.input) {
+			t.Errorf("wrong position for the last instruction. want line %d, got %d", len(test.input), last.Line)
+		}
+	}
+}
+
+func TestSuperinstructions(t *testing.T) {
+	tests := []compileTestCase{
+		{
+			input: `fn(n) { if (n == 0) { 1 } else { n - 1 } }`,
+			expectConstants: []interface{}{
+				0,
+				1,
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpJumpNotEqual, 14),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpJump, 18),
+					code.Make(code.OpSubtractLocalConstant, 0, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:           `let a = 1; if (a != 2) { a }; if (a > 2) { a }; if (2 >= a) { a }`,
+			expectConstants: []interface{}{1, 2},
+			expectInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpJumpEqual, 21),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpJump, 22),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpJumpNotGreaterThan, 38),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpJump, 39),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpJumpNotGreaterEqual, 55),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpJump, 56),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runOptimizedTests(t, tests)
+}
+
+func TestBytecodeIsCached(t *testing.T) {
+	c := New()
+	for _, input := range []string{"if (true) { 1 }", "2"} {
+		program, err := parse(input)
+		if err != nil {
+			t.Fatalf("parse program failed. %s", err)
+		}
+
+		before := c.bytecode
+		if err := c.Compile(program); err != nil {
+			t.Fatalf("compile program for input: %q failed. error is: %q", input, err)
+		}
+
+		bytecode := c.Bytecode()
+		if bytecode == before {
+			t.Errorf("Bytecode for input: %q returned the bytecode before Compile", input)
+		}
+		if c.Bytecode() != bytecode {
+			t.Errorf("Bytecode for input: %q optimized the instructions again", input)
+		}
+	}
+}
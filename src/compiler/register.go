@@ -0,0 +1,844 @@
+package compiler
+
+import (
+	"ast"
+	"code"
+	"fmt"
+	"object"
+	"token"
+)
+
+// tempBase is the first register of the temporary values while a function is compiled.
+// How many locals a function has is known only at its end, then its temporary registers
+// are placed right after its locals
+const tempBase = 1 << 15
+
+// MaxRegisters is the number of registers the 2 byte operands of register instructions can address
+const MaxRegisters = 1 << 16
+
+type registerScope struct {
+	instructions code.RegisterInstructions
+	sourceMap    code.SourceMap
+	symbolTable  *SymbolTable
+
+	// temps is the number of temporary registers in use, maxTemps is the most in use at once
+	temps    int
+	maxTemps int
+
+	// defining holds the locals whose let statement is being compiled, they can not be read yet
+	defining map[int]bool
+}
+
+// RegisterCompiler compiles a program for the register vm. It resolves names like
+// Compiler and shares its constant pool, so both can run the same programs
+type RegisterCompiler struct {
+	constants *constantPool
+
+	scopes []*registerScope
+
+	// position of the node being compiled, recorded for every emitted instruction
+	pos token.Position
+
+	optimize bool
+}
+
+type RegisterBytecode struct {
+	Instructions code.RegisterInstructions
+	SourceMap    code.SourceMap
+	Constants    []object.Object
+	NumRegisters int
+}
+
+func NewRegisterCompiler() *RegisterCompiler {
+	symbol := NewSymbolTable()
+
+	for i, v := range object.Builtins {
+		symbol.DefineBuiltin(i, v.Name)
+	}
+
+	return NewRegisterCompilerWithStates([]object.Object{}, symbol)
+}
+
+func NewRegisterCompilerWithStates(constants []object.Object, symbolTable *SymbolTable) *RegisterCompiler {
+	mainScope := &registerScope{symbolTable: symbolTable, defining: map[int]bool{}}
+	return &RegisterCompiler{constants: newConstantPool(constants), scopes: []*registerScope{mainScope}, optimize: true}
+}
+
+// DisableOptimizations makes the compiler emit instructions for the program as it is written,
+// without constant folding, dead code elimination and the instructions with a constant operand
+// or a comparison in a jump
+func (c *RegisterCompiler) DisableOptimizations() {
+	c.optimize = false
+}
+
+func (c *RegisterCompiler) scope() *registerScope {
+	return c.scopes[len(c.scopes)-1]
+}
+
+func (c *RegisterCompiler) enterScope() {
+	scope := &registerScope{symbolTable: NewEnclosedSymbolTable(c.scope().symbolTable), defining: map[int]bool{}}
+	c.scopes = append(c.scopes, scope)
+}
+
+func (c *RegisterCompiler) leaveScope() *registerScope {
+	scope := c.scope()
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	return scope
+}
+
+func (c *RegisterCompiler) emit(op code.RegisterOpCode, operands ...int) int {
+	scope := c.scope()
+	pos := len(scope.instructions)
+	scope.instructions = append(scope.instructions, code.MakeRegister(op, operands...))
+	scope.sourceMap = scope.sourceMap.Add(pos, c.pos)
+	return pos
+}
+
+// setTarget makes the jump at pos go to target
+func (c *RegisterCompiler) setTarget(pos int, target int) {
+	ins := &c.scope().instructions[pos]
+	switch ins.Op {
+	case code.ROpJump:
+		ins.A = uint16(target)
+	case code.ROpJumpNotTruethy:
+		ins.B = uint16(target)
+	default:
+		ins.C = uint16(target)
+	}
+}
+
+// temps allocates n consecutive temporary registers and returns the first of them
+func (c *RegisterCompiler) temps(n int) (int, error) {
+	scope := c.scope()
+	if scope.temps+n >= tempBase {
+		return 0, fmt.Errorf("too many temporary values: a function can have at most %d", tempBase)
+	}
+
+	reg := tempBase + scope.temps
+	scope.temps += n
+	if scope.temps > scope.maxTemps {
+		scope.maxTemps = scope.temps
+	}
+	return reg, nil
+}
+
+func (c *RegisterCompiler) temp() (int, error) {
+	return c.temps(1)
+}
+
+// freeTemps releases the temporary registers allocated since mark was taken
+func (c *RegisterCompiler) freeTemps(mark int) {
+	c.scope().temps = mark
+}
+
+func (c *RegisterCompiler) isTopTemp(reg int) bool {
+	return reg == tempBase+c.scope().temps-1
+}
+
+func (c *RegisterCompiler) at(node ast.Node) func() {
+	outer := c.pos
+	if pos := node.Pos(); pos.Line > 0 {
+		c.pos = pos
+	}
+	return func() { c.pos = outer }
+}
+
+// Compile compiles program into the main function
+func (c *RegisterCompiler) Compile(program *ast.Program) error {
+	for _, statement := range LiveStatements(program.Statements, c.optimize) {
+		err := c.statement(statement)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *RegisterCompiler) statement(node ast.Statement) error {
+	defer c.at(node)()
+
+	mark := c.scope().temps
+	defer c.freeTemps(mark)
+
+	switch node := node.(type) {
+	case *ast.ExpressionStatement:
+		reg, err := c.operand(node.Value)
+		if err != nil {
+			return err
+		}
+
+		if len(c.scopes) == 1 {
+			c.emit(code.ROpResult, reg)
+		}
+	case *ast.LetStatement:
+		table := c.scope().symbolTable
+		defined := table.Defines(node.Name.Value)
+		symbol := table.Define(node.Name.Value)
+		if symbol.Scope == GlobalScope {
+			reg, err := c.operand(node.Value)
+			if err != nil {
+				return err
+			}
+			c.emit(code.ROpSetGlobal, symbol.Index, reg)
+
+			if len(c.scopes) == 1 {
+				c.emit(code.ROpResult, reg)
+			}
+			break
+		}
+
+		if symbol.Index >= tempBase {
+			return fmt.Errorf("too many local variables: a function can have at most %d", tempBase)
+		}
+
+		c.scope().defining[symbol.Index] = true
+		err := c.expression(node.Value, symbol.Index)
+		delete(c.scope().defining, symbol.Index)
+		if err != nil {
+			return err
+		}
+
+		if defined {
+			return table.Redefine(node.Name.Value, node.Name.Pos())
+		}
+	case *ast.ReturnStatement:
+		if node.Value == nil {
+			c.emit(code.ROpReturnNull)
+			break
+		}
+
+		reg, err := c.operand(node.Value)
+		if err != nil {
+			return err
+		}
+		c.emit(code.ROpReturn, reg)
+	default:
+		return fmt.Errorf("unknown node type %T", node)
+	}
+	return nil
+}
+
+// block compiles block and puts its value into target
+func (c *RegisterCompiler) block(block *ast.BlockExpression, target int) error {
+	defer c.at(block)()
+
+	statements := LiveStatements(block.Statements, c.optimize)
+	if len(statements) == 0 {
+		c.emit(code.ROpLoadNull, target)
+		return nil
+	}
+
+	for _, statement := range statements[:len(statements)-1] {
+		err := c.statement(statement)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch last := statements[len(statements)-1].(type) {
+	case *ast.ExpressionStatement:
+		defer c.at(last)()
+		return c.expression(last.Value, target)
+	case *ast.ReturnStatement:
+		return c.statement(last)
+	case *ast.LetStatement:
+		// a block ending with a let statement is evaluated to the value bound
+		err := c.statement(last)
+		if err != nil {
+			return err
+		}
+
+		symbol, _ := c.scope().symbolTable.Resolve(last.Name.Value)
+		c.loadSymbol(symbol, target)
+		return nil
+	default:
+		return fmt.Errorf("unknown node type %T", last)
+	}
+}
+
+// operand returns a register holding the value of node. A local is read from its own
+// register, any other value is put into a new temporary register
+func (c *RegisterCompiler) operand(node ast.Expression) (int, error) {
+	if identifier, ok := node.(*ast.Identifier); ok {
+		symbol, ok := c.scope().symbolTable.Resolve(identifier.Value)
+		if ok && symbol.Scope == LocalScope && !c.scope().defining[symbol.Index] {
+			return symbol.Index, nil
+		}
+	}
+
+	reg, err := c.temp()
+	if err != nil {
+		return 0, err
+	}
+	return reg, c.expression(node, reg)
+}
+
+// keep returns a register holding the value of reg once the nodes evaluated next are.
+// A local read from its own register is copied when one of them assigns it
+func (c *RegisterCompiler) keep(reg int, next ...ast.Expression) (int, error) {
+	assigned := false
+	for _, node := range next {
+		assigned = assigned || node != nil && assigns(node)
+	}
+	if reg >= tempBase || !assigned {
+		return reg, nil
+	}
+
+	temp, err := c.temp()
+	if err != nil {
+		return 0, err
+	}
+	c.emit(code.ROpMove, temp, reg)
+	return temp, nil
+}
+
+// assigns reports whether evaluating node assigns a variable. The bodies of the functions
+// in node are not evaluated with it
+func assigns(node ast.Node) bool {
+	switch node := node.(type) {
+	case *ast.InfixExpression:
+		return node.Operator == "=" || assigns(node.Left) || assigns(node.Right)
+	case *ast.PrefixExpression:
+		return assigns(node.Value)
+	case *ast.SliceExpression:
+		return assigns(node.Left) || node.Start != nil && assigns(node.Start) || node.End != nil && assigns(node.End)
+	case *ast.IfExpression:
+		return assigns(node.Condition) || assigns(node.ThenBody) || node.ElseBody != nil && assigns(node.ElseBody)
+	case *ast.BlockExpression:
+		for _, statement := range node.Statements {
+			if assigns(statement) {
+				return true
+			}
+		}
+	case *ast.ExpressionStatement:
+		return assigns(node.Value)
+	case *ast.LetStatement:
+		return assigns(node.Value)
+	case *ast.ReturnStatement:
+		return node.Value != nil && assigns(node.Value)
+	case *ast.CallExpression:
+		if assigns(node.Function) {
+			return true
+		}
+		for _, argument := range node.Arguments {
+			if assigns(argument) {
+				return true
+			}
+		}
+	case *ast.ArrayLiteral:
+		for _, element := range node.Elements {
+			if assigns(element) {
+				return true
+			}
+		}
+	case *ast.HashLiteral:
+		for _, pair := range node.Pairs {
+			if assigns(pair.Key) || assigns(pair.Value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// loadFolded puts the folded value obj into target
+func (c *RegisterCompiler) loadFolded(obj object.Object, target int) error {
+	switch obj {
+	case object.TRUE:
+		c.emit(code.ROpLoadTrue, target)
+	case object.FALSE:
+		c.emit(code.ROpLoadFalse, target)
+	case object.NULL:
+		c.emit(code.ROpLoadNull, target)
+	default:
+		index, err := c.constants.add(obj)
+		if err != nil {
+			return err
+		}
+		c.emit(code.ROpLoadConstant, target, index)
+	}
+	return nil
+}
+
+func (c *RegisterCompiler) loadSymbol(symbol Symbol, target int) {
+	switch symbol.Scope {
+	case GlobalScope:
+		c.emit(code.ROpGetGlobal, target, symbol.Index)
+	case BuiltinScope:
+		c.emit(code.ROpGetBuiltin, target, symbol.Index)
+	case FreeScope:
+		c.emit(code.ROpGetFree, target, symbol.Index)
+	case LocalScope:
+		// the move fails for a local which is not defined yet
+		c.emit(code.ROpMove, target, symbol.Index)
+	case Function:
+		c.emit(code.ROpCurrentClosure, target)
+	}
+}
+
+// expression compiles node and puts its value into target
+func (c *RegisterCompiler) expression(node ast.Expression, target int) error {
+	if node == nil {
+		return fmt.Errorf("can not compile nil node")
+	}
+	defer c.at(node)()
+
+	mark := c.scope().temps
+	defer c.freeTemps(mark)
+
+	switch node := node.(type) {
+	case *ast.Integer:
+		return c.loadFolded(&object.Integer{Value: node.Value}, target)
+	case *ast.String:
+		return c.loadFolded(&object.String{Value: node.Value}, target)
+	case *ast.Boolean:
+		return c.loadFolded(object.NativeBooleanToBooleanObj(node.Value), target)
+	case *ast.Identifier:
+		symbol, ok := c.scope().symbolTable.Resolve(node.Value)
+		if !ok {
+			return fmt.Errorf("undefined variable %s", node.Value)
+		}
+		c.loadSymbol(symbol, target)
+	case *ast.PrefixExpression:
+		if value, ok := ConstantValue(node); ok && c.optimize {
+			return c.loadFolded(value, target)
+		}
+
+		reg, err := c.operand(node.Value)
+		if err != nil {
+			return err
+		}
+
+		switch node.Operator {
+		case "-":
+			c.emit(code.ROpMinus, target, reg)
+		case "!":
+			c.emit(code.ROpBang, target, reg)
+		default:
+			return fmt.Errorf("unsupported prefix operator %s", node.Operator)
+		}
+	case *ast.InfixExpression:
+		return c.infix(node, target)
+	case *ast.SliceExpression:
+		coll, err := c.operand(node.Left)
+		if err != nil {
+			return err
+		}
+
+		coll, err = c.keep(coll, node.Start, node.End)
+		if err != nil {
+			return err
+		}
+
+		// the bounds are in two registers in a row, an omitted bound is null
+		start, err := c.temps(2)
+		if err != nil {
+			return err
+		}
+		for i, bound := range []ast.Expression{node.Start, node.End} {
+			if bound == nil {
+				c.emit(code.ROpLoadNull, start+i)
+				continue
+			}
+
+			err = c.expression(bound, start+i)
+			if err != nil {
+				return err
+			}
+		}
+
+		c.emit(code.ROpSlice, target, coll, start)
+	case *ast.IfExpression:
+		return c.ifExpression(node, target)
+	case *ast.ArrayLiteral:
+		first, err := c.temps(len(node.Elements))
+		if err != nil {
+			return err
+		}
+
+		for i, e := range node.Elements {
+			err = c.expression(e, first+i)
+			if err != nil {
+				return err
+			}
+		}
+		c.emit(code.ROpArray, target, first, len(node.Elements))
+	case *ast.HashLiteral:
+		first, err := c.temps(2 * len(node.Pairs))
+		if err != nil {
+			return err
+		}
+
+		for i, pair := range node.Pairs {
+			err = c.expression(pair.Key, first+2*i)
+			if err != nil {
+				return err
+			}
+
+			err = c.expression(pair.Value, first+2*i+1)
+			if err != nil {
+				return err
+			}
+		}
+		c.emit(code.ROpHash, target, first, len(node.Pairs))
+	case *ast.FunctionExpression:
+		return c.function(node, target)
+	case *ast.CallExpression:
+		// the function and the arguments are in registers in a row, the result replaces the function
+		fn := target
+		if !c.isTopTemp(target) {
+			var err error
+			fn, err = c.temp()
+			if err != nil {
+				return err
+			}
+		}
+
+		err := c.expression(node.Function, fn)
+		if err != nil {
+			return err
+		}
+
+		for _, argument := range node.Arguments {
+			reg, err := c.temp()
+			if err != nil {
+				return err
+			}
+
+			err = c.expression(argument, reg)
+			if err != nil {
+				return err
+			}
+		}
+
+		c.emit(code.ROpCall, fn, len(node.Arguments))
+		if fn != target {
+			c.emit(code.ROpMove, target, fn)
+		}
+	default:
+		return fmt.Errorf("unknown node type %T", node)
+	}
+	return nil
+}
+
+var registerOperators = map[string]code.RegisterOpCode{
+	"[":  code.ROpIndex,
+	"+":  code.ROpAdd,
+	"-":  code.ROpSubtraction,
+	"*":  code.ROpMultiply,
+	"/":  code.ROpDivide,
+	"%":  code.ROpModulo,
+	"==": code.ROpEqual,
+	"!=": code.ROpNotEqual,
+	">":  code.ROpGreaterThan,
+	">=": code.ROpGreaterEqual,
+	"<":  code.ROpLessThan,
+	"<=": code.ROpLessEqual,
+}
+
+// registerJumps are the jumps which compare two registers, they jump when the comparison is false
+var registerJumps = map[string]code.RegisterOpCode{
+	"==": code.ROpJumpNotEqual,
+	"!=": code.ROpJumpEqual,
+	">":  code.ROpJumpNotGreaterThan,
+	">=": code.ROpJumpNotGreaterEqual,
+	"<":  code.ROpJumpNotLessThan,
+	"<=": code.ROpJumpNotLessEqual,
+}
+
+// operands puts the operands of node into registers
+func (c *RegisterCompiler) operands(node *ast.InfixExpression) (int, int, error) {
+	left, err := c.operand(node.Left)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	left, err = c.keep(left, node.Right)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	right, err := c.operand(node.Right)
+	if err != nil {
+		return 0, 0, err
+	}
+	return left, right, nil
+}
+
+func (c *RegisterCompiler) infix(node *ast.InfixExpression, target int) error {
+	if value, ok := ConstantValue(node); ok && c.optimize {
+		return c.loadFolded(value, target)
+	}
+
+	if node.Operator == "=" {
+		return c.assign(node, target)
+	}
+
+	op, ok := registerOperators[node.Operator]
+	if !ok {
+		return fmt.Errorf("unknown operator %s", node.Operator)
+	}
+
+	// adding and subtracting a constant reads it right from the constants
+	if value, ok := ConstantValue(node.Right); ok && c.optimize && (node.Operator == "+" || node.Operator == "-") &&
+		(object.IsInteger(value) || value.Type() == object.STRING_OBJ) {
+		left, err := c.operand(node.Left)
+		if err != nil {
+			return err
+		}
+
+		index, err := c.constants.add(value)
+		if err != nil {
+			return err
+		}
+
+		if node.Operator == "+" {
+			c.emit(code.ROpAddConstant, target, left, index)
+		} else {
+			c.emit(code.ROpSubtractConstant, target, left, index)
+		}
+		return nil
+	}
+
+	left, right, err := c.operands(node)
+	if err != nil {
+		return err
+	}
+	c.emit(op, target, left, right)
+	return nil
+}
+
+// assign sets the variable on the left of node to the value on the right, which is
+// also put into target
+func (c *RegisterCompiler) assign(node *ast.InfixExpression, target int) error {
+	ident, ok := node.Left.(*ast.Identifier)
+	if !ok {
+		return &Error{Msg: fmt.Sprintf("can not assign to %s", node.Left.String()), Pos: node.Pos()}
+	}
+
+	// a local is computed right into its register, the assignment is checked after
+	// the value like by Compiler
+	symbol, ok := c.scope().symbolTable.Resolve(ident.Value)
+	if ok && symbol.Scope == LocalScope {
+		if err := c.expression(node.Right, symbol.Index); err != nil {
+			return err
+		}
+		if _, err := c.scope().symbolTable.Assign(ident.Value, ident.Pos()); err != nil {
+			return err
+		}
+
+		if symbol.Index != target {
+			c.emit(code.ROpMove, target, symbol.Index)
+		}
+		return nil
+	}
+
+	reg, err := c.operand(node.Right)
+	if err != nil {
+		return err
+	}
+
+	symbol, err = c.scope().symbolTable.Assign(ident.Value, ident.Pos())
+	if err != nil {
+		return err
+	}
+	c.emit(code.ROpSetGlobal, symbol.Index, reg)
+	if reg != target {
+		c.emit(code.ROpMove, target, reg)
+	}
+	return nil
+}
+
+// condition emits the jump which is taken when condition is not truethy and returns
+// its position. A comparison is made by the jump itself
+func (c *RegisterCompiler) condition(condition ast.Expression) (int, error) {
+	mark := c.scope().temps
+	defer c.freeTemps(mark)
+
+	if infix, ok := condition.(*ast.InfixExpression); ok && c.optimize {
+		if jump, ok := registerJumps[infix.Operator]; ok {
+			defer c.at(infix)()
+
+			left, right, err := c.operands(infix)
+			if err != nil {
+				return 0, err
+			}
+			return c.emit(jump, left, right, 0), nil
+		}
+	}
+
+	reg, err := c.operand(condition)
+	if err != nil {
+		return 0, err
+	}
+	return c.emit(code.ROpJumpNotTruethy, reg, 0), nil
+}
+
+func (c *RegisterCompiler) ifExpression(node *ast.IfExpression, target int) error {
+	if condition, ok := ConstantValue(node.Condition); ok && c.optimize {
+		// only the branch taken is compiled
+		body := node.ElseBody
+		if condition != object.FALSE && condition != object.NULL {
+			body = node.ThenBody
+		}
+
+		if body == nil {
+			c.emit(code.ROpLoadNull, target)
+			return nil
+		}
+		return c.block(body, target)
+	}
+
+	jumpNotTruethy, err := c.condition(node.Condition)
+	if err != nil {
+		return err
+	}
+
+	err = c.block(node.ThenBody, target)
+	if err != nil {
+		return err
+	}
+
+	jump := c.emit(code.ROpJump, 0)
+	c.setTarget(jumpNotTruethy, len(c.scope().instructions))
+
+	if node.ElseBody == nil {
+		c.emit(code.ROpLoadNull, target)
+	} else {
+		err = c.block(node.ElseBody, target)
+		if err != nil {
+			return err
+		}
+	}
+
+	c.setTarget(jump, len(c.scope().instructions))
+	return nil
+}
+
+func (c *RegisterCompiler) function(node *ast.FunctionExpression, target int) error {
+	c.enterScope()
+
+	if node.Name != nil {
+		c.scope().symbolTable.DefineFunctionName(node.Name.Value)
+	}
+
+	for _, parameter := range node.Parameters {
+		c.scope().symbolTable.Define(parameter.Value)
+	}
+
+	result, err := c.temp()
+	if err != nil {
+		return err
+	}
+
+	err = c.block(node.Body, result)
+	if err != nil {
+		return fmt.Errorf("compile function %s failed: %s", node.Name, err)
+	}
+
+	statements := LiveStatements(node.Body.Statements, c.optimize)
+	if len(statements) == 0 {
+		c.emit(code.ROpReturn, result)
+	} else if _, ok := statements[len(statements)-1].(*ast.ReturnStatement); !ok {
+		c.emit(code.ROpReturn, result)
+	}
+
+	scope := c.leaveScope()
+	numLocals := scope.symbolTable.numDefinitions
+	ins, err := placeTemps(scope.instructions, numLocals, scope.maxTemps)
+	if err != nil {
+		return err
+	}
+	markRegisterTailCalls(ins)
+
+	frees := scope.symbolTable.FreeSymbols
+	fn := &object.RegisterFunction{Instructions: ins,
+		SourceMap:     scope.sourceMap,
+		NumRegisters:  numLocals + scope.maxTemps,
+		NumParameters: len(node.Parameters),
+		NumFree:       len(frees)}
+	index, err := c.constants.add(fn)
+	if err != nil {
+		return err
+	}
+
+	// the free variables are in registers in a row
+	first, err := c.temps(len(frees))
+	if err != nil {
+		return err
+	}
+	for i, s := range frees {
+		c.loadSymbol(s, first+i)
+	}
+
+	c.emit(code.ROpClosure, target, index, first)
+	return nil
+}
+
+// placeTemps returns ins with the temporary registers placed after the numLocals locals
+func placeTemps(ins code.RegisterInstructions, numLocals int, numTemps int) (code.RegisterInstructions, error) {
+	if numLocals+numTemps > MaxRegisters {
+		return nil, fmt.Errorf("too many registers: a function can have at most %d", MaxRegisters)
+	}
+
+	ret := make(code.RegisterInstructions, len(ins))
+	for i, in := range ins {
+		def, err := code.LookupRegister(in.Op)
+		if err != nil {
+			return nil, err
+		}
+
+		operands := []*uint16{&in.A, &in.B, &in.C}
+		for j, kind := range def.Operands {
+			if kind == code.OperandRegister && *operands[j] >= tempBase {
+				*operands[j] = *operands[j] - tempBase + uint16(numLocals)
+			}
+		}
+		ret[i] = in
+	}
+	return ret, nil
+}
+
+// markRegisterTailCalls replaces every ROpCall whose result is returned right away with ROpTailCall
+func markRegisterTailCalls(ins code.RegisterInstructions) {
+	for i, in := range ins {
+		if in.Op == code.ROpCall && returnsRegisterAt(ins, i+1, int(in.A)) {
+			ins[i].Op = code.ROpTailCall
+		}
+	}
+}
+
+// returnsRegisterAt reports whether executing ins from pos returns the value of reg
+// without any instruction other than moving the value and ROpJump
+func returnsRegisterAt(ins code.RegisterInstructions, pos int, reg int) bool {
+	// a jump never goes backwards, so following at most len(ins) instructions can not loop
+	for i := 0; i < len(ins) && pos < len(ins); i++ {
+		in := ins[pos]
+		switch in.Op {
+		case code.ROpReturn:
+			return int(in.A) == reg
+		case code.ROpMove:
+			if int(in.B) != reg {
+				return false
+			}
+			reg = int(in.A)
+			pos++
+		case code.ROpJump:
+			pos = int(in.A)
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+func (c *RegisterCompiler) Bytecode() (*RegisterBytecode, error) {
+	scope := c.scopes[0]
+	ins, err := placeTemps(scope.instructions, 0, scope.maxTemps)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RegisterBytecode{Instructions: ins, SourceMap: scope.sourceMap,
+		Constants: c.constants.constants, NumRegisters: scope.maxTemps}, nil
+}
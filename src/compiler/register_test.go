@@ -0,0 +1,210 @@
+package compiler
+
+import (
+	"code"
+	"object"
+	"strings"
+	"testing"
+)
+
+type registerTestCase struct {
+	input    string
+	optimize bool
+	expect   code.RegisterInstructions
+	// functions are the instructions of the functions in the constants, in their order
+	functions []code.RegisterInstructions
+}
+
+func runRegisterTests(t *testing.T, tests []registerTestCase) {
+	t.Helper()
+
+	for _, test := range tests {
+		program, err := parse(test.input)
+		if err != nil {
+			t.Fatalf("parse input %s failed %s", test.input, err)
+		}
+
+		c := NewRegisterCompiler()
+		if !test.optimize {
+			c.DisableOptimizations()
+		}
+		err = c.Compile(program)
+		if err != nil {
+			t.Fatalf("compile input %s failed %s", test.input, err)
+		}
+
+		bytecode, err := c.Bytecode()
+		if err != nil {
+			t.Fatalf("compile input %s failed %s", test.input, err)
+		}
+
+		if bytecode.Instructions.String() != test.expect.String() {
+			t.Errorf("wrong instructions for input: %s. want:\n%s\ngot:\n%s", test.input, test.expect, bytecode.Instructions)
+		}
+
+		functions := []code.RegisterInstructions{}
+		for _, constant := range bytecode.Constants {
+			if fn, ok := constant.(*object.RegisterFunction); ok {
+				functions = append(functions, fn.Instructions)
+			}
+		}
+		if len(functions) != len(test.functions) {
+			t.Fatalf("wrong number of functions for input: %s. want=%d, got=%d", test.input, len(test.functions), len(functions))
+		}
+		for i, fn := range functions {
+			if fn.String() != test.functions[i].String() {
+				t.Errorf("wrong instructions of function %d for input: %s. want:\n%s\ngot:\n%s", i, test.input, test.functions[i], fn)
+			}
+		}
+	}
+}
+
+func TestRegisterCompiler(t *testing.T) {
+	tests := []registerTestCase{
+		{
+			input: "1 + 2",
+			expect: code.RegisterInstructions{
+				code.MakeRegister(code.ROpLoadConstant, 1, 0),
+				code.MakeRegister(code.ROpLoadConstant, 2, 1),
+				code.MakeRegister(code.ROpAdd, 0, 1, 2),
+				code.MakeRegister(code.ROpResult, 0),
+			},
+		},
+		{
+			input:    "1 + 2",
+			optimize: true,
+			expect: code.RegisterInstructions{
+				code.MakeRegister(code.ROpLoadConstant, 0, 0),
+				code.MakeRegister(code.ROpResult, 0),
+			},
+		},
+		{
+			input: "let a = [1, true]; a[0]",
+			expect: code.RegisterInstructions{
+				code.MakeRegister(code.ROpLoadConstant, 1, 0),
+				code.MakeRegister(code.ROpLoadTrue, 2),
+				code.MakeRegister(code.ROpArray, 0, 1, 2),
+				code.MakeRegister(code.ROpSetGlobal, 0, 0),
+				code.MakeRegister(code.ROpResult, 0),
+				code.MakeRegister(code.ROpGetGlobal, 1, 0),
+				code.MakeRegister(code.ROpLoadConstant, 2, 1),
+				code.MakeRegister(code.ROpIndex, 0, 1, 2),
+				code.MakeRegister(code.ROpResult, 0),
+			},
+		},
+		{
+			input: "fn(a) { let b = a; a + b }",
+			expect: code.RegisterInstructions{
+				code.MakeRegister(code.ROpClosure, 0, 0, 1),
+				code.MakeRegister(code.ROpResult, 0),
+			},
+			functions: []code.RegisterInstructions{
+				{
+					code.MakeRegister(code.ROpMove, 1, 0),
+					code.MakeRegister(code.ROpAdd, 2, 0, 1),
+					code.MakeRegister(code.ROpReturn, 2),
+				},
+			},
+		},
+	}
+
+	runRegisterTests(t, tests)
+}
+
+func TestRegisterCompilerOptimizations(t *testing.T) {
+	tests := []registerTestCase{
+		{
+			input:    "fn(a) { a - 1 }",
+			optimize: true,
+			expect: code.RegisterInstructions{
+				code.MakeRegister(code.ROpClosure, 0, 1, 1),
+				code.MakeRegister(code.ROpResult, 0),
+			},
+			functions: []code.RegisterInstructions{
+				{
+					code.MakeRegister(code.ROpSubtractConstant, 1, 0, 0),
+					code.MakeRegister(code.ROpReturn, 1),
+				},
+			},
+		},
+		{
+			input:    "fn(a) { if (a < 1) { 2 } }",
+			optimize: true,
+			expect: code.RegisterInstructions{
+				code.MakeRegister(code.ROpClosure, 0, 2, 1),
+				code.MakeRegister(code.ROpResult, 0),
+			},
+			functions: []code.RegisterInstructions{
+				{
+					code.MakeRegister(code.ROpLoadConstant, 2, 0),
+					code.MakeRegister(code.ROpJumpNotLessThan, 0, 2, 4),
+					code.MakeRegister(code.ROpLoadConstant, 1, 1),
+					code.MakeRegister(code.ROpJump, 5),
+					code.MakeRegister(code.ROpLoadNull, 1),
+					code.MakeRegister(code.ROpReturn, 1),
+				},
+			},
+		},
+	}
+
+	runRegisterTests(t, tests)
+}
+
+func TestRegisterTailCalls(t *testing.T) {
+	tests := []registerTestCase{
+		{
+			input: "let f = fn(n) { f(n) }",
+			expect: code.RegisterInstructions{
+				code.MakeRegister(code.ROpClosure, 0, 0, 1),
+				code.MakeRegister(code.ROpSetGlobal, 0, 0),
+				code.MakeRegister(code.ROpResult, 0),
+			},
+			functions: []code.RegisterInstructions{
+				{
+					code.MakeRegister(code.ROpCurrentClosure, 1),
+					code.MakeRegister(code.ROpMove, 2, 0),
+					code.MakeRegister(code.ROpTailCall, 1, 1),
+					code.MakeRegister(code.ROpReturn, 1),
+				},
+			},
+		},
+		{
+			// the call is not in tail position, its result is added to
+			input: "let f = fn(n) { f(n) + 1 }",
+			expect: code.RegisterInstructions{
+				code.MakeRegister(code.ROpClosure, 0, 1, 1),
+				code.MakeRegister(code.ROpSetGlobal, 0, 0),
+				code.MakeRegister(code.ROpResult, 0),
+			},
+			functions: []code.RegisterInstructions{
+				{
+					code.MakeRegister(code.ROpCurrentClosure, 2),
+					code.MakeRegister(code.ROpMove, 3, 0),
+					code.MakeRegister(code.ROpCall, 2, 1),
+					code.MakeRegister(code.ROpLoadConstant, 3, 0),
+					code.MakeRegister(code.ROpAdd, 1, 2, 3),
+					code.MakeRegister(code.ROpReturn, 1),
+				},
+			},
+		},
+	}
+
+	runRegisterTests(t, tests)
+}
+
+func TestTooManyRegisters(t *testing.T) {
+	elements := make([]string, tempBase)
+	for i := range elements {
+		elements[i] = "1"
+	}
+
+	program, err := parse("[" + strings.Join(elements, ", ") + "]")
+	if err != nil {
+		t.Fatalf("parse program failed. %s", err)
+	}
+
+	err = NewRegisterCompiler().Compile(program)
+	if err == nil || !strings.HasPrefix(err.Error(), "too many temporary values") {
+		t.Fatalf("expect too many temporary values error, got %v", err)
+	}
+}
@@ -1,5 +1,10 @@
 package compiler
 
+import (
+	"fmt"
+	"token"
+)
+
 type SymbolScope string
 
 const (
@@ -22,6 +27,9 @@ type SymbolTable struct {
 	Scope          SymbolScope
 	FreeSymbols    []Symbol
 
+	// captured holds the locals of t the functions in it have read as free variables
+	captured map[string]bool
+
 	outer *SymbolTable
 }
 
@@ -36,7 +44,26 @@ func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
 	return table
 }
 
+// NewFunctionSymbolTable makes the symbol table of a compiled function in outer, with
+// its locals and free variables at their indexes. Code compiled with it runs in the
+// frame of a call of the function
+func NewFunctionSymbolTable(outer *SymbolTable, localNames []string, freeNames []string) *SymbolTable {
+	table := NewEnclosedSymbolTable(outer)
+	table.DefineNames(localNames)
+	for i, name := range freeNames {
+		table.FreeSymbols = append(table.FreeSymbols, Symbol{Name: name})
+		table.store[name] = Symbol{Name: name, Index: i, Scope: FreeScope}
+	}
+	return table
+}
+
+// Define defines name in the scope of t. A name defined in t already keeps its index,
+// so its new value can be computed from its old one
 func (t *SymbolTable) Define(name string) Symbol {
+	if s, ok := t.store[name]; ok && s.Scope == t.Scope {
+		return s
+	}
+
 	s := Symbol{Name: name, Index: t.numDefinitions, Scope: t.Scope}
 
 	t.store[name] = s
@@ -44,6 +71,35 @@ func (t *SymbolTable) Define(name string) Symbol {
 	return s
 }
 
+// NumDefinitions returns the number of the names defined in the scope of t
+func (t *SymbolTable) NumDefinitions() int {
+	return t.numDefinitions
+}
+
+// Names returns the names defined in the scope of t by their indexes
+func (t *SymbolTable) Names() []string {
+	names := make([]string, t.numDefinitions)
+	for name, s := range t.store {
+		if s.Scope == t.Scope && s.Index < len(names) {
+			names[s.Index] = name
+		}
+	}
+	return names
+}
+
+// DefineNames defines names in the scope of t at their indexes, like Names returns
+// them. The index of an empty name is left unused
+func (t *SymbolTable) DefineNames(names []string) {
+	for i, name := range names {
+		if name != "" {
+			t.store[name] = Symbol{Name: name, Index: i, Scope: t.Scope}
+		}
+	}
+	if len(names) > t.numDefinitions {
+		t.numDefinitions = len(names)
+	}
+}
+
 func (t *SymbolTable) DefineBuiltin(index int, name string) Symbol {
 	s := Symbol{Name: name, Index: index, Scope: BuiltinScope}
 	t.store[name] = s
@@ -78,8 +134,61 @@ func (t *SymbolTable) Resolve(name string) (Symbol, bool) {
 			return s, ok
 		}
 
+		if s.Scope == LocalScope {
+			if t.outer.captured == nil {
+				t.outer.captured = map[string]bool{}
+			}
+			t.outer.captured[name] = true
+		}
+
 		free := t.defineFree(s)
 		return free, true
 	}
 	return s, ok
 }
+
+// Defines reports whether name is defined in the scope of t
+func (t *SymbolTable) Defines(name string) bool {
+	s, ok := t.store[name]
+	return ok && s.Scope == t.Scope
+}
+
+// Assign resolves name as the target of an assignment at pos. A function holds copies
+// of the variables it captures, so a free variable can not be assigned, nor a local
+// once a function has captured it
+func (t *SymbolTable) Assign(name string, pos token.Position) (Symbol, error) {
+	s, ok := t.Resolve(name)
+	if !ok {
+		return s, &Error{Msg: fmt.Sprintf("undefined variable %s", name), Pos: pos}
+	}
+
+	switch s.Scope {
+	case GlobalScope:
+		return s, nil
+	case LocalScope:
+		if t.captured[name] {
+			return s, &Error{Msg: fmt.Sprintf("can not assign to %s, it is captured by a function", name), Pos: pos}
+		}
+		return s, nil
+	case BuiltinScope:
+		return s, &Error{Msg: fmt.Sprintf("can not assign to builtin %s", name), Pos: pos}
+	case Function:
+		// the name of the function is the variable of its let statement
+		if t.outer != nil && t.outer.Scope == GlobalScope {
+			if global, ok := t.outer.Resolve(name); ok && global.Scope == GlobalScope {
+				return global, nil
+			}
+		}
+	}
+	return s, &Error{Msg: fmt.Sprintf("can not assign to %s, it is captured by a function", name), Pos: pos}
+}
+
+// Redefine checks the let statement at pos defining name again in the scope of t,
+// once its value is compiled. Like an assignment, it can not give a new value to a
+// local a function has captured
+func (t *SymbolTable) Redefine(name string, pos token.Position) error {
+	if t.Scope == LocalScope && t.captured[name] {
+		return &Error{Msg: fmt.Sprintf("can not define %s again, it is captured by a function", name), Pos: pos}
+	}
+	return nil
+}
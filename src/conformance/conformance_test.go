@@ -0,0 +1,323 @@
+package conformance
+
+import (
+	"closure"
+	"compiler"
+	"context"
+	"evaluator"
+	"object"
+	"parser"
+	"strings"
+	"testing"
+	"vm"
+)
+
+// limits keeps a failing program from running forever on any backend
+var limits = object.Limits{MaxInstructions: 10000000}
+
+// backend runs a program and returns its result, or an error if it fails
+type backend struct {
+	name string
+	run  func(input string) (object.Object, error)
+}
+
+func evaluate(input string) (object.Object, error) {
+	program, err := parser.New(input).ParseProgram()
+	if err != nil {
+		return nil, err
+	}
+
+	ret := evaluator.EvalContext(context.Background(), program, object.NewEnvironment(), limits)
+	if err, ok := ret.(*object.Error); ok {
+		return nil, errorOf(err)
+	}
+	return ret, nil
+}
+
+func runVM(optimize bool) func(input string) (object.Object, error) {
+	return func(input string) (object.Object, error) {
+		program, err := parser.New(input).ParseProgram()
+		if err != nil {
+			return nil, err
+		}
+
+		c := compiler.New()
+		if !optimize {
+			c.DisableOptimizations()
+		}
+		if err := c.Compile(program); err != nil {
+			return nil, err
+		}
+
+		v := vm.New(c.Bytecode())
+		if err := v.RunContext(context.Background(), limits); err != nil {
+			return nil, err
+		}
+		return v.StackLastTop(), nil
+	}
+}
+
+func runRegisterVM(optimize bool) func(input string) (object.Object, error) {
+	return func(input string) (object.Object, error) {
+		program, err := parser.New(input).ParseProgram()
+		if err != nil {
+			return nil, err
+		}
+
+		c := compiler.NewRegisterCompiler()
+		if !optimize {
+			c.DisableOptimizations()
+		}
+		if err := c.Compile(program); err != nil {
+			return nil, err
+		}
+
+		bytecode, err := c.Bytecode()
+		if err != nil {
+			return nil, err
+		}
+
+		v := vm.NewRegister(bytecode)
+		if err := v.RunContext(context.Background(), limits); err != nil {
+			return nil, err
+		}
+		return v.StackLastTop(), nil
+	}
+}
+
+func runClosures(input string) (object.Object, error) {
+	program, err := parser.New(input).ParseProgram()
+	if err != nil {
+		return nil, err
+	}
+
+	compiled, err := closure.Compile(program, closure.NewGlobals())
+	if err != nil {
+		return nil, err
+	}
+
+	ret := compiled.RunContext(context.Background(), limits)
+	if err, ok := ret.(*object.Error); ok {
+		return nil, errorOf(err)
+	}
+	return ret, nil
+}
+
+type evalError struct {
+	err *object.Error
+}
+
+func (e evalError) Error() string {
+	return e.err.Inspect()
+}
+
+func errorOf(err *object.Error) error {
+	return evalError{err}
+}
+
+var backends = []backend{
+	{"evaluator", evaluate},
+	{"vm", runVM(true)},
+	{"vm/unoptimized", runVM(false)},
+	{"register", runRegisterVM(true)},
+	{"register/unoptimized", runRegisterVM(false)},
+	{"closure", runClosures},
+}
+
+// results are programs with the result they have on every backend. The backends differ
+// in how they show functions, programs here avoid it
+var results = []struct {
+	input  string
+	expect string
+}{
+	// integers
+	{"1 + 2 * 3 - 4 / 2 % 3", "5"},
+	{"-(1 - 2) * -3", "-3"},
+	{"7 % 3 + -7 % 3", "0"},
+	{"9223372036854775807 + 1", "9223372036854775808"},
+	{"9223372036854775807 + 1 - 1", "9223372036854775807"},
+	{"-9223372036854775807 - 2", "-9223372036854775809"},
+	{"4611686018427387904 * 4 / 4", "4611686018427387904"},
+	{"[1 < 2, 1 <= 1, 2 > 1, 1 >= 2, 1 == 1, 1 != 1]", "[true, true, true, false, true, false]"},
+
+	// booleans and null
+	{"[!true, !false, !!1, !0]", "[false, true, true, false]"},
+	{"true == true", "true"},
+	{"true != false", "true"},
+	{"if (false) { 1 }", "null"},
+	{"[1 == true, \"1\" == 1, [] == {}]", "[false, false, false]"},
+
+	// strings
+	{`"a" + "b" + "c"`, "abc"},
+	{`["a" == "a", "a" != "b"]`, "[true, true]"},
+	{`"hello"[1]`, "e"},
+	{`"hello"[1:3]`, "el"},
+	{`len("hello")`, "5"},
+
+	// arrays and hashes
+	{"[1, 2 + 3, [4]]", "[1, 5, [4]]"},
+	{"[1, 2, 3][-1]", "3"},
+	{"[1, 2, 3][1:]", "[2, 3]"},
+	{"[1, 2, 3][:-1]", "[1, 2]"},
+	{"[1, [2]] == [1, [2]]", "true"},
+	{`{"a": 1, "b": 2}["b"]`, "2"},
+	{`{"a": 1}["c"]`, "null"},
+	{"{[1, 2]: 3}[[1, 2]]", "3"},
+	{"{1: 2} == {1: 2}", "true"},
+	{`{"a": [1, {"b": 2}]}["a"][1]["b"]`, "2"},
+	{"[first([1, 2]), last([1, 2]), rest([1, 2]), push([1], 2)]", "[1, 2, [2], [1, 2]]"},
+	{"[first([]), last([]), rest([])]", "[null, null, null]"},
+
+	// conditionals and blocks
+	{"if (1 < 2) { 10 } else { 20 }", "10"},
+	{"if (0) { 1 } else { 2 }", "1"},
+	{`if ("") { 1 } else { 2 }`, "1"},
+	{"if (true) { let a = 1; a + 1 }", "2"},
+	{"if (true) { 1; 2 }", "2"},
+	{"if (true) { let b = 1; }", "1"},
+
+	// variables
+	{"let a = 1; let b = a + 1; a + b", "3"},
+	{"let a = 1; let a = a + 1; a", "2"},
+	{"let a = 1", "1"},
+	{"1; let a = 2", "2"},
+
+	// assignment
+	{"let a = 1; a = 2; a", "2"},
+	{"let a = 1; let f = fn() { a = a + 1 }; f(); f(); a", "3"},
+	{"let f = fn(n) { n = n * 2; let g = fn() { n }; g() }; f(3)", "6"},
+	{"let f = fn() { f = 1; 2 }; [f(), f]", "[2, 1]"},
+	{"let f = fn(x) { [x, x = 2, x] }; f(1)", "[1, 2, 2]"},
+	{"let f = fn(x) { x + (x = 5) }; f(1)", "6"},
+	{"let a = 0; [(a = 1) < (a = 2), a]", "[true, 2]"},
+	{"let a = 0; [(a = 2) <= (a = 1), a]", "[false, 1]"},
+
+	// the operands of comparisons are evaluated from left to right
+	{"let a = 0; let f = fn(x) { a = a * 10 + x; x }; [f(1) < f(2), f(3) <= f(3), f(5) > f(4), a]", "[true, true, true, 123354]"},
+	{"let a = 0; let f = fn(x) { a = a * 10 + x; x }; if (f(2) < f(1)) { 0 } else { a }", "21"},
+	{"let a = 0; let f = fn(x) { a = a * 10 + x; x }; if (f(2) <= f(1)) { 0 } else { a }", "21"},
+	{"let f = fn(x) { if (x < (x = 0)) { x } else { 1 } }; f(1)", "1"},
+	{"let f = fn(x) { if ((x = 0) <= x) { x } else { 1 } }; f(5)", "0"},
+
+	// functions and closures
+	{"let f = fn(a, b) { let c = a + b; c * 2 }; f(1, 2)", "6"},
+	{"let f = fn() { }; f()", "null"},
+	{"let f = fn() { let a = 1; }; f()", "1"},
+	{"let f = fn(x) { let a = x * 2 }; f(2)", "4"},
+	{"let f = fn() { return; 1 }; f()", "null"},
+	{"let f = fn(x) { if (x) { return 1; } else { return 2; } 3 }; [f(true), f(false)]", "[1, 2]"},
+	{"let add = fn(a) { fn(b) { fn(c) { a + b + c } } }; add(1)(2)(3)", "6"},
+	{"let f = fn(x) { let g = fn() { x }; g }; f(1)() + f(2)()", "3"},
+	{"let a = 1; let f = fn(b) { let c = 3; fn(d) { a + b + c + d } }; f(2)(4)", "10"},
+	{"let apply = fn(f, x) { f(x) }; apply(fn(x) { x * 2 }, 21)", "42"},
+	{"let f = fn(a, b) { [b, a] }; let a = 1; f(a + 1, a)", "[1, 2]"},
+	{"let f = fn() { let x = 1; let g = fn() { x + 1 }; g() }; f()", "2"},
+
+	// recursion
+	{"let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } }; fib(15)", "610"},
+	{"let f = fn() { let g = fn(n) { if (n == 0) { 0 } else { g(n - 1) } }; g(3) }; f()", "0"},
+	{"let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(500)", "500"},
+	{"let countDown = fn(n) { if (n == 0) { return 0; } countDown(n - 1) }; countDown(100000)", "0"},
+	{"let loop = fn(i, sum) { if (i == 0) { sum } else { loop(i - 1, sum + i) } }; loop(10000, 0)", "50005000"},
+	{`
+	let map = fn(arr, f) {
+		let iter = fn(arr, acc) {
+			if (len(arr) == 0) { acc } else { iter(rest(arr), push(acc, f(first(arr)))) }
+		};
+		iter(arr, [])
+	};
+	map([1, 2, 3], fn(x) { x * x })`, "[1, 4, 9]"},
+
+	// return
+	{"return 1 + 1; 3", "2"},
+	{"if (true) { return 1; }; 2", "1"},
+	{"let f = fn() { if (true) { if (true) { return 1; } return 2; } 3 }; f()", "1"},
+}
+
+// failures are programs which fail on every backend, the errors are not compared
+var failures = []string{
+	"1 / 0",
+	"1 % 0",
+	"-true",
+	"true + 1",
+	`"a" - "b"`,
+	"[1, 2, 3][3]",
+	"[1, 2, 3][2:1]",
+	"1[0]",
+	`"abc"["a"]`,
+	"{[1, fn(x){x}]: 1}",
+	"fn(a, b) { a }(1)",
+	"fn(a) { a }(1, 2)",
+	"1()",
+	"a",
+	"fn() { let a = a; a }()",
+	"let f = fn() { 1 + f() }; f()",
+	"let f = fn(x) { x + true }; f(1)",
+	"len(1)",
+	`len("one", "two")`,
+	"first(1)",
+	"let f = fn() { push(1, 1) }; f()",
+	"1 = 2",
+	"b = 1",
+	"len = 1",
+	"let f = fn() { let a = 1; fn() { a = 2 } }; f()()",
+	"let f = fn() { let a = 1; let g = fn() { a }; a = 2; g() }; f()",
+	"let f = fn() { let a = 1; let g = fn() { a }; let a = 2; g() }; f()",
+}
+
+// runtimeErrors are programs which fail with the same error on every backend, the error of
+// the operand evaluated first
+var runtimeErrors = []struct {
+	input  string
+	expect string
+}{
+	{"[1][5] < len(1)", "index out of range [5] with length 1"},
+	{"[1][5] <= len(1)", "index out of range [5] with length 1"},
+	{"[1][5] > len(1)", "index out of range [5] with length 1"},
+	{"len(1) < [1][5]", "argument to `len` not supported"},
+	{"let f = fn(x) { if ([x][5] < len(x)) { 1 } }; f(1)", "index out of range [5] with length 1"},
+	{"let f = fn(x) { if ([x][5] <= len(x)) { 1 } }; f(1)", "index out of range [5] with length 1"},
+}
+
+func TestResults(t *testing.T) {
+	for _, test := range results {
+		for _, backend := range backends {
+			actual, err := backend.run(test.input)
+			if err != nil {
+				t.Errorf("%s: run program for input: %q failed. error is: %s", backend.name, test.input, err)
+				continue
+			}
+
+			if actual.Inspect() != test.expect {
+				t.Errorf("%s: wrong result for input: %q. want=%s, got=%s", backend.name, test.input, test.expect, actual.Inspect())
+			}
+		}
+	}
+}
+
+func TestFailures(t *testing.T) {
+	for _, input := range failures {
+		for _, backend := range backends {
+			actual, err := backend.run(input)
+			if err == nil {
+				t.Errorf("%s: expect error for input: %q. got %s", backend.name, input, actual.Inspect())
+			}
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for _, test := range runtimeErrors {
+		for _, backend := range backends {
+			actual, err := backend.run(test.input)
+			if err == nil {
+				t.Errorf("%s: expect error for input: %q. got %s", backend.name, test.input, actual.Inspect())
+				continue
+			}
+
+			if !strings.Contains(err.Error(), test.expect) {
+				t.Errorf("%s: wrong error for input: %q. want=%s, got=%s", backend.name, test.input, test.expect, err)
+			}
+		}
+	}
+}
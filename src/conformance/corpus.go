@@ -0,0 +1,42 @@
+package conformance
+
+// CrashCorpus holds programs which used to crash a backend. The fuzz targets of the
+// backends start from it, and every program in it must either run or fail with an
+// error on each of them
+var CrashCorpus = []string{
+	"",
+	"fn(a, b) { a + b }(1)",
+	"fn(a) { a }()",
+	"fn() {}()",
+	"{[1, fn(x){x}]: 1}",
+	"{fn(x){x}: 1}",
+	"1[0]",
+	"fn(){}[0]",
+	"{}[[fn(){}]]",
+	"1()",
+	"len()",
+	"len(1, 2)",
+	"push([])",
+	"return 1; 2",
+	"return;",
+	"fn() { return; }()",
+	"if (true) {}",
+	"if (false) { 1 }",
+	"if (true) { let a = 1; }",
+	"fn() { if (true) { let a = 1; } }()",
+	"let f = fn() { f() }; f()",
+	"let f = fn() { 1 + f() }; f()",
+	"let f = fn(x) { f(x + 1) }; f(0)",
+	"-fn(){}",
+	"!fn(){}",
+	"fn(){} + 1",
+	"[1, 2][:fn(){}]",
+	"\"a\" - \"b\"",
+	"{1: 2} < {1: 2}",
+	"9223372036854775807 * 9223372036854775807 % 0",
+	"let a = fn() { b }; let b = 1; a()",
+	"fn() { let a = a; a }()",
+	"1 = 2",
+	"a = 2",
+	"\x8a",
+}
@@ -0,0 +1,5 @@
+// Package conformance holds the tests every backend has to pass: the evaluator, the vm,
+// the register vm and the closures. A program of the suite must give the same result,
+// or fail, on each of them. Translated to go, it must end like it does on the vm, with
+// the same result or the same error
+package conformance
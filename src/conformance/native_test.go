@@ -0,0 +1,169 @@
+package conformance
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang"
+	"io/ioutil"
+	"object"
+	"os"
+	"os/exec"
+	"parser"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// outcome is how a program ends, its result or its error
+type outcome struct {
+	Result string
+	Err    string
+}
+
+// nativeMain runs the translated programs p0, p1... and writes their outcomes as json
+const nativeMain = `package main
+
+import (
+	"encoding/json"
+	"native"
+	"os"
+%s)
+
+func main() {
+	programs := []native.Program{%s}
+	outcomes := []map[string]string{}
+	for _, program := range programs {
+		result, err := native.Run(program)
+		if err != nil {
+			outcomes = append(outcomes, map[string]string{"Err": err.Error()})
+			continue
+		}
+		outcomes = append(outcomes, map[string]string{"Result": result.Inspect()})
+	}
+	json.NewEncoder(os.Stdout).Encode(outcomes)
+}
+`
+
+// runNative translates the programs of inputs to go, builds them into one binary and
+// returns their outcomes. A program which fails to translate has the error of the translation
+func runNative(t *testing.T, inputs []string, optimize bool) []outcome {
+	gopath := os.Getenv("GOPATH")
+	if _, err := exec.LookPath("go"); err != nil || gopath == "" {
+		t.Skip("go build with a GOPATH is needed to build translated programs")
+	}
+
+	dir, err := ioutil.TempDir("", "gorilla-native")
+	if err != nil {
+		t.Fatalf("make temp dir failed: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	outcomes := make([]outcome, len(inputs))
+	imports, programs := "", ""
+	for i, input := range inputs {
+		program, err := parser.New(input).ParseProgram()
+		if err != nil {
+			t.Fatalf("parse program for input: %q failed. error is: %q", input, err.Error())
+		}
+
+		tr := golang.New()
+		if !optimize {
+			tr.DisableOptimizations()
+		}
+		if err := tr.Translate(program); err != nil {
+			outcomes[i].Err = err.Error()
+			continue
+		}
+
+		pkg := fmt.Sprintf("p%d", i)
+		src, err := tr.Source(pkg)
+		if err != nil {
+			t.Fatalf("translate program for input: %q failed. error is: %s", input, err)
+		}
+
+		pkgDir := filepath.Join(dir, "src", "nativeprograms", pkg)
+		if err := os.MkdirAll(pkgDir, 0755); err != nil {
+			t.Fatalf("make package dir failed: %s", err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(pkgDir, "program.go"), src, 0644); err != nil {
+			t.Fatalf("write program failed: %s", err)
+		}
+
+		imports += fmt.Sprintf("\t%q\n", "nativeprograms/"+pkg)
+		programs += pkg + ".Program, "
+	}
+
+	mainSrc := fmt.Sprintf(nativeMain, imports, programs)
+	if err := ioutil.WriteFile(filepath.Join(dir, "src", "nativeprograms", "main.go"), []byte(mainSrc), 0644); err != nil {
+		t.Fatalf("write main failed: %s", err)
+	}
+
+	binary := filepath.Join(dir, "programs")
+	cmd := exec.Command("go", "build", "-o", binary, "nativeprograms")
+	cmd.Env = append(os.Environ(), "GOPATH="+dir+string(os.PathListSeparator)+gopath, "GO111MODULE=off")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("build translated programs failed: %s\n%s", err, out)
+	}
+
+	out, err := exec.Command(binary).Output()
+	if err != nil {
+		t.Fatalf("run translated programs failed: %s", err)
+	}
+
+	ran := []outcome{}
+	if err := json.NewDecoder(bytes.NewReader(out)).Decode(&ran); err != nil {
+		t.Fatalf("decode outcomes failed: %s", err)
+	}
+
+	for i := range outcomes {
+		if outcomes[i].Err == "" {
+			outcomes[i], ran = ran[0], ran[1:]
+		}
+	}
+	return outcomes
+}
+
+// TestNativeMatchesVM checks every program of the suite translated to go ends like it does on the vm
+func TestNativeMatchesVM(t *testing.T) {
+	if testing.Short() {
+		t.Skip("building the translated programs is slow")
+	}
+
+	inputs := []string{}
+	for _, test := range results {
+		inputs = append(inputs, test.input)
+	}
+	inputs = append(inputs, failures...)
+	for _, test := range runtimeErrors {
+		inputs = append(inputs, test.input)
+	}
+
+	for _, optimize := range []bool{true, false} {
+		outcomes := runNative(t, inputs, optimize)
+		for i, input := range inputs {
+			actual := outcomes[i]
+
+			ret, err := runVM(optimize)(input)
+			var expect outcome
+			if err != nil {
+				expect.Err = err.Error()
+			} else {
+				expect.Result = ret.Inspect()
+			}
+
+			// the translated program has no value stack, it fails with the limit of the call depth instead
+			if errors.Is(err, object.ErrStackLimit) {
+				if !strings.Contains(actual.Err, object.ErrCallDepthLimit.Error()) {
+					t.Errorf("expect call depth error for input: %q. got %+v", input, actual)
+				}
+				continue
+			}
+
+			if actual != expect {
+				t.Errorf("wrong outcome for input: %q with optimize=%t. want=%+v, got=%+v", input, optimize, expect, actual)
+			}
+		}
+	}
+}
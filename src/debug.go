@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"repl"
+	"vm"
+)
+
+// debugProgram runs a gorilla program on the vm under the debugger. The program is
+// compiled without optimizations, so each statement keeps its instructions
+func debugProgram(args []string) int {
+	flags := flag.NewFlagSet("debug", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: gorilla debug file.gor\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	input, err := ioutil.ReadFile(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "read program failed: %s\n", err)
+		return 1
+	}
+	source := string(input)
+
+	bytecode, globalNames, err := compileProgram(source, false)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 1
+	}
+
+	if err := repl.Debug(os.Stdin, os.Stdout, source, bytecode, globalNames); err != nil {
+		if errors.Is(err, vm.ErrQuit) {
+			return 0
+		}
+		fmt.Fprintf(os.Stderr, "vm run program failed: %s\n", err)
+		return 1
+	}
+	return 0
+}
@@ -2,100 +2,316 @@ package evaluator
 
 import (
 	"ast"
+	"context"
 	"fmt"
 	"object"
+	"resolver"
 )
 
 var (
-	TRUE  = &object.Boolean{Value: true}
-	FALSE = &object.Boolean{Value: false}
-	NULL  = &object.Internal_Null{}
+	TRUE  = object.TRUE
+	FALSE = object.FALSE
+	NULL  = object.NULL
 )
 
 type Evaluable interface {
 	Eval() object.Object
 }
 
+// MaxCallDepth is the number of nested function calls the evaluator allows,
+// the same as the frames of the vm
+const MaxCallDepth = 1023
+
+// frame is a node being evaluated. The evaluator keeps frames on its own stack
+// instead of recursing on the go stack, so deep recursion of a program is reported
+// as an error instead of crashing the process
+type frame struct {
+	node  ast.Node
+	env   *object.Environment
+	table *resolver.Table
+
+	// step counts the sub nodes evaluated so far, values holds the ones to keep
+	step   int
+	values []object.Object
+
+	// function marks the frame whose value is the result of a function call.
+	// A return statement finishes the frames up to and including it
+	function bool
+	// name is the name of the function called, known only when there are hooks
+	name string
+}
+
+// evaluation is a run of an Evaluator
+type evaluation struct {
+	budget    *object.Budget
+	frames    []frame
+	callDepth int
+
+	hooks object.Hooks
+	seed  object.HashSeed
+}
+
+// Evaluator evaluates programs, calling the Hooks set by SetHooks and hashing the keys of
+// the hashes they make with the seed set by SetHashSeed
+type Evaluator struct {
+	hooks object.Hooks
+	seed  object.HashSeed
+}
+
+func New() *Evaluator {
+	return &Evaluator{}
+}
+
+// SetHooks makes the runs of ev call h, nil removes them. object.MultiHooks combines
+// several Hooks into one
+func (ev *Evaluator) SetHooks(h object.Hooks) {
+	ev.hooks = h
+}
+
+// SetHashSeed makes the hashes made by the runs of ev hash their keys with seed
+func (ev *Evaluator) SetHashSeed(seed object.HashSeed) {
+	ev.seed = seed
+}
+
+// Eval evaluates node in env. It never panics, any failure is returned as an *object.Error
+// with the position of the node which failed
 func Eval(node ast.Node, env *object.Environment) object.Object {
-	switch node := node.(type) {
+	return New().EvalContext(context.Background(), node, env, object.Limits{})
+}
+
+// EvalContext is like Eval but stops when ctx is done or the program exceeds limits.
+// The Err of the returned *object.Error then wraps object.ErrCanceled or the error of the limit
+func EvalContext(ctx context.Context, node ast.Node, env *object.Environment, limits object.Limits) object.Object {
+	return New().EvalContext(ctx, node, env, limits)
+}
+
+// EvalContext is like the EvalContext of the package, with the hooks and the hash seed of ev
+func (ev *Evaluator) EvalContext(ctx context.Context, node ast.Node, env *object.Environment, limits object.Limits) (result object.Object) {
+	e := &evaluation{budget: object.NewBudget(ctx, limits), hooks: ev.hooks, seed: ev.seed}
+
+	if e.hooks != nil {
+		e.hooks.OnStart()
+		// deferred first, so it sees the errors of panics too
+		defer func() {
+			if err, ok := result.(*object.Error); ok {
+				e.hooks.OnError(err)
+			} else {
+				e.hooks.OnEnd(result)
+			}
+		}()
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			if len(e.frames) > 0 {
+				node = e.frames[len(e.frames)-1].node
+			}
+			result = &object.Error{Msg: fmt.Sprintf("internal error: %v", r), Pos: node.Pos()}
+		}
+	}()
+
+	if node == nil {
+		return newError("can not evaluate nil node")
+	}
+
+	table, err := resolver.Resolve(node, env, isBuiltin)
+	if err != nil {
+		err := err.(*resolver.Error)
+		return &object.Error{Msg: err.Msg, Pos: err.Pos}
+	}
+
+	if err := e.push(node, env); err != nil {
+		return err
+	}
+	e.frames[0].table = table
+	return e.run()
+}
+
+func isBuiltin(name string) bool {
+	return object.FindBuiltinByName(name) != nil
+}
+
+// push starts evaluating node on top of the current frame
+func (e *evaluation) push(node ast.Node, env *object.Environment) object.Object {
+	if node == nil {
+		return newError("can not evaluate nil node")
+	}
+
+	if err := e.budget.Step(); err != nil {
+		return errorAt(err, node)
+	}
+	if e.hooks != nil {
+		if err := e.hooks.OnInstruction(node.Pos()); err != nil {
+			return errorAt(err, node)
+		}
+	}
+
+	// the sub nodes are in the program of the node they are pushed by
+	var table *resolver.Table
+	if len(e.frames) > 0 {
+		table = e.frames[len(e.frames)-1].table
+	}
+	e.frames = append(e.frames, frame{node: node, env: env, table: table})
+	return nil
+}
+
+// replace evaluates node in place of the current frame, whose value is the value of node.
+// This keeps the frames from growing for nodes in tail position
+func (e *evaluation) replace(node ast.Node, env *object.Environment) object.Object {
+	if node == nil {
+		return newError("can not evaluate nil node")
+	}
+
+	if err := e.budget.Step(); err != nil {
+		return errorAt(err, node)
+	}
+	if e.hooks != nil {
+		if err := e.hooks.OnInstruction(node.Pos()); err != nil {
+			return errorAt(err, node)
+		}
+	}
+
+	top := &e.frames[len(e.frames)-1]
+	*top = frame{node: node, env: env, table: top.table, function: top.function, name: top.name}
+	return nil
+}
+
+// pop finishes the current frame with result
+func (e *evaluation) pop(result object.Object) frame {
+	top := e.frames[len(e.frames)-1]
+	e.frames = e.frames[:len(e.frames)-1]
+	if top.function {
+		e.callDepth--
+		if e.hooks != nil {
+			e.hooks.OnReturn(top.name, result)
+		}
+	}
+	return top
+}
+
+// run evaluates the frames until all of them are finished and returns the value of the first one
+func (e *evaluation) run() object.Object {
+	// value is the value of the frame finished last, it is passed to the frame below it
+	var value object.Object
+
+	for len(e.frames) > 0 {
+		top := &e.frames[len(e.frames)-1]
+		ret := e.step(top, value)
+		value = nil
+		if ret == nil {
+			// the frame pushed or replaced itself with a sub node
+			continue
+		}
+
+		switch ret := ret.(type) {
+		case *object.Error:
+			if ret.Pos.Line == 0 {
+				ret.Pos = e.frames[len(e.frames)-1].node.Pos()
+			}
+			e.frames = e.frames[:0]
+			return ret
+		case *object.ReturnValue:
+			for len(e.frames) > 0 {
+				if f := e.pop(ret.Value); f.function {
+					break
+				}
+			}
+			value = ret.Value
+		default:
+			e.pop(ret)
+			value = ret
+		}
+	}
+
+	return value
+}
+
+// step continues evaluating f with the value of its sub node evaluated last, which is nil
+// the first time. It returns the value of f when f is finished, or nil when it pushed or
+// replaced itself with a sub node
+func (e *evaluation) step(f *frame, value object.Object) object.Object {
+	switch node := f.node.(type) {
 	case *ast.Program:
-		return evalProgram(node.Statements, env)
+		return e.stepStatements(f, node.Statements)
 	case *ast.BlockExpression:
-		return evalBlockStatements(node.Statements, env)
+		return e.stepStatements(f, node.Statements)
 	case *ast.ExpressionStatement:
-		return Eval(node.Value, env)
+		return e.replace(node.Value, f.env)
 	case *ast.LetStatement:
-		return evalLetStatement(node, env)
+		return e.stepLetStatement(f, node, value)
 	case *ast.ReturnStatement:
-		return evalReturnStatement(node, env)
+		return e.stepReturnStatement(f, node, value)
 	case *ast.PrefixExpression:
-		return evalPrefixExpression(node, env)
+		return e.stepPrefixExpression(f, node, value)
 	case *ast.InfixExpression:
-		return evalInfixExpression(node, env)
+		return e.stepInfixExpression(f, node, value)
+	case *ast.SliceExpression:
+		return e.stepSliceExpression(f, node, value)
 	case *ast.IfExpression:
-		return evalIfExpression(node, env)
+		return e.stepIfExpression(f, node, value)
 	case *ast.CallExpression:
-		return evalCallExpression(node, env)
+		return e.stepCallExpression(f, node, value)
 	case *ast.Integer:
-		return &object.Integer{Value: node.Value}
+		return e.alloc(&object.Integer{Value: node.Value})
 	case *ast.String:
-		return &object.String{Value: node.Value}
+		return e.alloc(&object.String{Value: node.Value})
 	case *ast.Boolean:
 		return nativeBoolToBooleanObj(node.Value)
 	case *ast.ArrayLiteral:
-		elems := []object.Object{}
-		for _, ex := range node.Elements {
-			elem := Eval(ex, env)
-			if IsError(elem) {
-				return elem
-			}
-
-			elems = append(elems, elem)
-		}
-		return &object.Array{Elements: elems}
+		return e.stepArrayLiteral(f, node, value)
 	case *ast.HashLiteral:
-		pairs := make(map[object.HashKey]object.HashPair)
-		for kx, vx := range node.Pair {
-			k := Eval(kx, env)
-			if IsError(k) {
-				return k
-			}
-
-			v := Eval(vx, env)
-			if IsError(v) {
-				return v
-			}
-
-			h, ok := k.(object.Hashable)
-			if !ok {
-				return newError(fmt.Sprintf("key type in HashLiteral is not Hashable. got %q", k.Type()))
-			}
-			pairs[h.Hash()] = object.HashPair{Key: k, Value: v}
-		}
-		return &object.HashTable{Pair: pairs}
+		return e.stepHashLiteral(f, node, value)
 	case *ast.FunctionExpression:
-		return evalFunctionExpression(node, env)
+		fn := &object.Function{Body: node.Body, Parameters: node.Parameters, Env: f.env, NumSlots: f.table.NumSlots(node), Table: f.table, Pos: node.Pos()}
+		if node.Name != nil {
+			fn.Name = node.Name.Value
+		}
+		return e.alloc(fn)
 	case *ast.Identifier:
-		val, ok := env.Get(node.Value)
-		if ok {
+		if addr, ok := f.table.Address(node); !ok {
+			if builtin := object.FindBuiltinByName(node.Value); builtin != nil {
+				return builtin
+			}
+		} else if val := f.env.GetAt(addr.Depth, addr.Slot); val != nil {
 			return val
 		}
 
-		builtin := object.FindBuiltinByName(node.Value)
-		if builtin != nil {
-			return builtin
-		}
-
-		return newError(fmt.Sprintf("unbind identifier: %s", val))
+		return newError(fmt.Sprintf("unbound identifier: %s", node.Value))
 	default:
 		return newError(fmt.Sprintf("unknown node type %T", node))
 	}
 }
 
+// functionName names fn by the name it is bound to, or by its position
+func functionName(fn *object.Function) string {
+	if fn.Name != "" {
+		return fn.Name
+	}
+	return fmt.Sprintf("fn@%d:%d", fn.Pos.Line, fn.Pos.Column)
+}
+
 func newError(msg string) *object.Error {
 	return &object.Error{Msg: msg}
 }
 
+func errorOf(err error) *object.Error {
+	return &object.Error{Msg: err.Error(), Err: err}
+}
+
+func errorAt(err error, node ast.Node) *object.Error {
+	ret := errorOf(err)
+	ret.Pos = node.Pos()
+	return ret
+}
+
+// alloc accounts obj as allocated and returns it, or an error if that exceeds the limits
+func (e *evaluation) alloc(obj object.Object) object.Object {
+	if err := e.budget.Alloc(obj); err != nil {
+		return errorOf(err)
+	}
+	return obj
+}
+
 func IsError(obj object.Object) bool {
 	return obj != nil && obj.Type() == object.ERROR_OBJ
 }
@@ -108,116 +324,182 @@ func nativeBoolToBooleanObj(v bool) *object.Boolean {
 	return FALSE
 }
 
-func evalProgram(statements []ast.Statement, env *object.Environment) object.Object {
-	var result object.Object
-	for _, statement := range statements {
-		result = Eval(statement, env)
-
-		if IsError(result) {
-			return result
-		}
-
-		if val, ok := result.(*object.ReturnValue); ok {
-			return val.Value
-		}
+// stepStatements evaluates the statements of a program or a block in order. The last one
+// replaces the frame, it is in tail position. No statements evaluates to NULL
+func (e *evaluation) stepStatements(f *frame, statements []ast.Statement) object.Object {
+	if len(statements) == 0 {
+		return NULL
 	}
-	return result
-}
 
-func evalBlockStatements(statements []ast.Statement, env *object.Environment) object.Object {
-	var result object.Object
-	for _, statement := range statements {
-		result = Eval(statement, env)
-		if IsError(result) {
-			return result
-		}
-		if result.Type() == object.RETURN_OBJ {
-			return result
-		}
+	f.step++
+	if f.step == len(statements) {
+		return e.replace(statements[f.step-1], f.env)
 	}
-	return result
+	return e.push(statements[f.step-1], f.env)
 }
 
-func evalLetStatement(node *ast.LetStatement, env *object.Environment) object.Object {
-	val := Eval(node.Value, env)
-	if IsError(val) {
-		return val
+func (e *evaluation) stepLetStatement(f *frame, node *ast.LetStatement, value object.Object) object.Object {
+	if f.step == 0 {
+		f.step++
+		return e.push(node.Value, f.env)
 	}
 
-	env.Set(node.Name.Value, val)
-	return val
+	addr, _ := f.table.Address(node.Name)
+	f.env.SetAt(0, addr.Slot, value)
+	return value
 }
 
-func evalFunctionExpression(node *ast.FunctionExpression, env *object.Environment) object.Object {
-	return &object.Function{Body: node.Body, Parameters: node.Parameters, Env: env}
+func (e *evaluation) stepReturnStatement(f *frame, node *ast.ReturnStatement, value object.Object) object.Object {
+	if node.Value == nil {
+		return &object.ReturnValue{Value: NULL}
+	}
+
+	if f.step == 0 {
+		f.step++
+		return e.push(node.Value, f.env)
+	}
+	return &object.ReturnValue{Value: value}
 }
 
-func evalCallExpression(node *ast.CallExpression, env *object.Environment) object.Object {
-	function := Eval(node.Function, env)
-	if IsError(function) {
-		return function
+// stepCallExpression evaluates the function and then the arguments. The body of a called
+// function replaces the frame, so the frames do not grow for a call in tail position
+func (e *evaluation) stepCallExpression(f *frame, node *ast.CallExpression, value object.Object) object.Object {
+	if f.step > 0 {
+		f.values = append(f.values, value)
 	}
 
-	params, err := evalArguments(node.Arguments, env)
-	if err != nil {
-		return err
+	if f.step <= len(node.Arguments) {
+		var next ast.Node = node.Function
+		if f.step > 0 {
+			next = node.Arguments[f.step-1]
+		}
+
+		f.step++
+		return e.push(next, f.env)
 	}
 
+	function, params := f.values[0], f.values[1:]
 	switch fn := function.(type) {
-
 	case *object.Function:
-		newEnv := object.NewNestedEnvironment(fn.Env)
+		if err := object.CheckArguments(len(fn.Parameters), len(params)); err != nil {
+			return errorOf(err)
+		}
+		if e.hooks != nil {
+			if f.function {
+				e.hooks.OnReturn(f.name, nil)
+			}
+			f.name = functionName(fn)
+			if err := e.hooks.OnCall(f.name, params); err != nil {
+				return errorOf(err)
+			}
+		}
 
-		for i, param := range fn.Parameters {
-			p := params[i]
-			newEnv.Set(param.Value, p)
+		if !f.function {
+			if err := e.budget.CallDepth(e.callDepth+1, MaxCallDepth); err != nil {
+				return errorOf(err)
+			}
+			e.callDepth++
 		}
 
-		ret := Eval(fn.Body, newEnv)
+		// the evaluator only makes functions with the tables of the resolver
+		table := fn.Table.(*resolver.Table)
+		newEnv := object.NewNestedEnvironment(fn.Env, fn.NumSlots)
+		for i, param := range fn.Parameters {
+			addr, _ := table.Address(param)
+			newEnv.SetAt(0, addr.Slot, params[i])
+		}
 
-		if val, ok := ret.(*object.ReturnValue); ok {
-			return val.Value
+		if err := e.replace(fn.Body, newEnv); err != nil {
+			return err
 		}
-		return ret
+		e.frames[len(e.frames)-1].function = true
+		e.frames[len(e.frames)-1].table = table
+		return nil
 	case *object.Builtin:
-		return fn.Fn(params...)
+		if e.hooks != nil {
+			if err := e.hooks.OnBuiltinCall(object.BuiltinName(fn), params); err != nil {
+				return errorOf(err)
+			}
+		}
+		ret, allocated, err := object.CallBuiltin(fn, params)
+		if e.hooks != nil {
+			e.hooks.OnBuiltinReturn(object.BuiltinName(fn), ret)
+		}
+		if err != nil {
+			return errorOf(err)
+		}
+		if !allocated {
+			return ret
+		}
+		return e.alloc(ret)
 	default:
 		return newError(fmt.Sprintf("unknown function: %s", function.Inspect()))
 	}
 }
 
-func evalArguments(args []ast.Expression, env *object.Environment) ([]object.Object, object.Object) {
-	var params []object.Object
-	for _, arg := range args {
-		p := Eval(arg, env)
-		if IsError(p) {
-			return nil, p
-		}
+func (e *evaluation) stepArrayLiteral(f *frame, node *ast.ArrayLiteral, value object.Object) object.Object {
+	if f.step > 0 {
+		f.values = append(f.values, value)
+	}
 
-		params = append(params, p)
+	if f.step < len(node.Elements) {
+		f.step++
+		return e.push(node.Elements[f.step-1], f.env)
 	}
-	return params, nil
+
+	elems := make([]object.Object, len(f.values))
+	copy(elems, f.values)
+	return e.alloc(&object.Array{Elements: elems})
 }
 
-func evalPrefixExpression(node *ast.PrefixExpression, env *object.Environment) object.Object {
-	var obj object.Object
-	switch node.Operator {
-	case "!":
-		obj = Eval(node.Value, env)
-		if IsError(obj) {
-			return obj
+// stepHashLiteral evaluates keys and values in source order, key and value of a pair
+// are evaluated before the key is checked to be Hashable
+func (e *evaluation) stepHashLiteral(f *frame, node *ast.HashLiteral, value object.Object) object.Object {
+	if f.step > 0 {
+		f.values = append(f.values, value)
+	}
+
+	if f.step%2 == 0 && f.step > 0 {
+		k := f.values[f.step-2]
+		if _, err := object.HashLiteralKey(e.seed, k); err != nil {
+			return errorOf(err)
 		}
-		return evalBangOperator(obj)
-	case "-":
-		obj = Eval(node.Value, env)
-		if IsError(obj) {
-			return obj
+	}
+
+	if f.step < 2*len(node.Pairs) {
+		pair := node.Pairs[f.step/2]
+		next := pair.Key
+		if f.step%2 == 1 {
+			next = pair.Value
 		}
 
-		return evalPrefixMinusOperator(obj)
+		f.step++
+		return e.push(next, f.env)
 	}
 
-	return newError(fmt.Sprintf("unknown operator: %s%s", node.Operator, node.Value.String()))
+	hash := object.NewSeededHashTable(e.seed)
+	for i := 0; i < len(f.values); i += 2 {
+		k, v := f.values[i], f.values[i+1]
+		h, _ := hash.KeyOf(k)
+		hash.Set(h, k, v)
+	}
+	return e.alloc(hash)
+}
+
+func (e *evaluation) stepPrefixExpression(f *frame, node *ast.PrefixExpression, value object.Object) object.Object {
+	if node.Operator != "!" && node.Operator != "-" {
+		return newError(fmt.Sprintf("unknown operator: %s%s", node.Operator, node.Value.String()))
+	}
+
+	if f.step == 0 {
+		f.step++
+		return e.push(node.Value, f.env)
+	}
+
+	if node.Operator == "!" {
+		return evalBangOperator(value)
+	}
+	return e.evalPrefixMinusOperator(value)
 }
 
 func evalBangOperator(obj object.Object) object.Object {
@@ -233,130 +515,133 @@ func evalBangOperator(obj object.Object) object.Object {
 	}
 }
 
-func evalPrefixMinusOperator(obj object.Object) object.Object {
-	integer, ok := obj.(*object.Integer)
+func (e *evaluation) evalPrefixMinusOperator(obj object.Object) object.Object {
+	ret, err := object.IntegerNegate(obj)
+	if err != nil {
+		return errorOf(err)
+	}
+	return e.alloc(ret)
+}
+
+func (e *evaluation) stepAssignExpression(f *frame, node *ast.InfixExpression, value object.Object) object.Object {
+	ident, ok := node.Left.(*ast.Identifier)
 	if !ok {
-		return newError(fmt.Sprintf("minus operator can not be used as prefix operator for %T", obj))
+		return newError(fmt.Sprintf("can not assign to %s", node.Left.String()))
+	}
+
+	if f.step == 0 {
+		f.step++
+		return e.push(node.Right, f.env)
 	}
 
-	return &object.Integer{Value: -integer.Value}
+	addr, ok := f.table.Address(ident)
+	if !ok || f.env.GetAt(addr.Depth, addr.Slot) == nil {
+		return newError(fmt.Sprintf("unbound identifier: %s", ident.Value))
+	}
+	return f.env.SetAt(addr.Depth, addr.Slot, value)
 }
 
-func evalInfixExpression(node *ast.InfixExpression, env *object.Environment) object.Object {
-	left := Eval(node.Left, env)
-	if IsError(left) {
-		return left
+func (e *evaluation) stepInfixExpression(f *frame, node *ast.InfixExpression, value object.Object) object.Object {
+	if node.Operator == "=" {
+		return e.stepAssignExpression(f, node, value)
 	}
 
-	right := Eval(node.Right, env)
-	if IsError(right) {
-		return right
+	switch f.step {
+	case 0:
+		f.step++
+		return e.push(node.Left, f.env)
+	case 1:
+		f.values = append(f.values, value)
+		f.step++
+		return e.push(node.Right, f.env)
 	}
 
+	left, right := f.values[0], value
 	switch {
 	case node.Operator == "[":
-		return evalIndexExpression(left, right)
-	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
-		return evalIntegerInfixExpression(node.Operator, left, right)
+		return e.evalIndexExpression(left, right)
+	case object.IsInteger(left) && object.IsInteger(right):
+		return e.evalIntegerInfixExpression(node.Operator, left, right)
 	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
-		return evalStringInfixExpression(node.Operator, left, right)
+		return e.evalStringInfixExpression(node.Operator, left, right)
 	case node.Operator == "==":
-		return nativeBoolToBooleanObj(left == right)
+		return nativeBoolToBooleanObj(object.Equals(left, right))
 	case node.Operator == "!=":
-		return nativeBoolToBooleanObj(left != right)
+		return nativeBoolToBooleanObj(!object.Equals(left, right))
 	}
 
 	return newError(fmt.Sprintf("unknown operator: %s %s %s", node.Left.String(), node.Operator, node.Right.String()))
 }
 
-func evalIndexExpression(left object.Object, right object.Object) object.Object {
-	switch l := left.(type) {
-	case *object.Array:
-		index, ok := right.(*object.Integer)
-		if !ok {
-			newError(fmt.Sprintf("expect Integer for Array Index, got %q", right.Type()))
-		}
-		return l.Elements[index.Value]
-	case *object.HashTable:
-		h, ok := right.(object.Hashable)
-		if !ok {
-			return newError(fmt.Sprintf("expect Hashable for HashTable key, got %q", right.Type()))
-		}
-		v, ok := l.Pair[h.Hash()]
-		if ok {
-			return v.Value
+func (e *evaluation) evalIndexExpression(left object.Object, right object.Object) object.Object {
+	ret, err := object.Index(left, right)
+	if err != nil {
+		return errorOf(err)
+	}
+
+	if left.Type() == object.STRING_OBJ {
+		return e.alloc(ret)
+	}
+	return ret
+}
+
+// stepSliceExpression evaluates the collection and then the bounds which are not omitted
+func (e *evaluation) stepSliceExpression(f *frame, node *ast.SliceExpression, value object.Object) object.Object {
+	if f.step > 0 {
+		f.values = append(f.values, value)
+	}
+
+	bounds := []ast.Expression{node.Left, node.Start, node.End}
+	for f.step < len(bounds) {
+		next := bounds[f.step]
+		f.step++
+		if next != nil {
+			return e.push(next, f.env)
 		}
-		return NULL
-	default:
-		return newError(fmt.Sprintf("unsupported type for index operator, got %q", left.Type()))
+		f.values = append(f.values, nil)
 	}
+
+	ret, err := object.Slice(f.values[0], f.values[1], f.values[2])
+	if err != nil {
+		return errorOf(err)
+	}
+	return e.alloc(ret)
 }
 
-func evalIntegerInfixExpression(operator string, left object.Object, right object.Object) object.Object {
-	leftInt := left.(*object.Integer)
-	rightInt := right.(*object.Integer)
-	switch operator {
-	case "+":
-		return &object.Integer{Value: leftInt.Value + rightInt.Value}
-	case "-":
-		return &object.Integer{Value: leftInt.Value - rightInt.Value}
-	case "*":
-		return &object.Integer{Value: leftInt.Value * rightInt.Value}
-	case "/":
-		return &object.Integer{Value: leftInt.Value / rightInt.Value}
-	case "<":
-		return &object.Boolean{Value: leftInt.Value < rightInt.Value}
-	case ">":
-		return &object.Boolean{Value: leftInt.Value > rightInt.Value}
-	case "==":
-		return nativeBoolToBooleanObj(leftInt.Value == rightInt.Value)
-	case "!=":
-		return nativeBoolToBooleanObj(leftInt.Value != rightInt.Value)
-	default:
-		return newError(fmt.Sprintf("unknown operator: %d %s %d", leftInt, operator, rightInt))
+func (e *evaluation) evalIntegerInfixExpression(operator string, left object.Object, right object.Object) object.Object {
+	ret, err := object.IntegerInfix(operator, left, right)
+	if err != nil {
+		return errorOf(err)
 	}
+	return e.alloc(ret)
 }
 
-func evalStringInfixExpression(operator string, left object.Object, right object.Object) object.Object {
+func (e *evaluation) evalStringInfixExpression(operator string, left object.Object, right object.Object) object.Object {
 	leftStr := left.(*object.String)
 	rightStr := right.(*object.String)
 	switch operator {
 	case "+":
-		return &object.String{Value: leftStr.Value + rightStr.Value}
+		return e.alloc(&object.String{Value: leftStr.Value + rightStr.Value})
 	case "==":
 		return nativeBoolToBooleanObj(leftStr.Value == rightStr.Value)
 	case "!=":
 		return nativeBoolToBooleanObj(leftStr.Value != rightStr.Value)
 	default:
-		return newError(fmt.Sprintf("unknown operator: %s %s %s", leftStr, operator, rightStr))
+		return newError(fmt.Sprintf("unknown operator: %q %s %q", leftStr.Value, operator, rightStr.Value))
 	}
 }
 
-func evalIfExpression(node *ast.IfExpression, env *object.Environment) object.Object {
-	con := Eval(node.Condition, env)
-	if IsError(con) {
-		return con
+// stepIfExpression evaluates the condition, then the chosen body replaces the frame
+func (e *evaluation) stepIfExpression(f *frame, node *ast.IfExpression, value object.Object) object.Object {
+	if f.step == 0 {
+		f.step++
+		return e.push(node.Condition, f.env)
 	}
 
-	var ret object.Object
-	ret = NULL
-	if con != FALSE && con != NULL {
-		ret = Eval(node.ThenBody, env)
+	if value != FALSE && value != NULL {
+		return e.replace(node.ThenBody, f.env)
 	} else if node.ElseBody != nil {
-		ret = Eval(node.ElseBody, env)
-	}
-	return ret
-}
-
-func evalReturnStatement(node *ast.ReturnStatement, env *object.Environment) object.Object {
-	if node.Value != nil {
-		ret := Eval(node.Value, env)
-		if IsError(ret) {
-			return ret
-		}
-
-		return &object.ReturnValue{Value: ret}
+		return e.replace(node.ElseBody, f.env)
 	}
-
-	return &object.ReturnValue{Value: NULL}
+	return NULL
 }
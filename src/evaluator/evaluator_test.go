@@ -1,10 +1,18 @@
 package evaluator
 
 import (
+	"ast"
+	"conformance"
+	"context"
+	"errors"
 	"fmt"
+	"math/big"
 	"object"
 	"parser"
+	"strings"
 	"testing"
+	"time"
+	"token"
 )
 
 func TestEvalIntegerValue(t *testing.T) {
@@ -29,6 +37,38 @@ func TestEvalIntegerValue(t *testing.T) {
 	}
 }
 
+func TestEvalBigIntegerValue(t *testing.T) {
+	bigInt := func(s string) *big.Int {
+		v, _ := new(big.Int).SetString(s, 10)
+		return v
+	}
+
+	tests := []struct {
+		input  string
+		expect interface{}
+	}{
+		{"9223372036854775807 + 1", bigInt("9223372036854775808")},
+		{"-9223372036854775807 - 2", bigInt("-9223372036854775809")},
+		{"4611686018427387904 * 4", bigInt("18446744073709551616")},
+		{"-(-9223372036854775807 - 1)", bigInt("9223372036854775808")},
+		{"(-9223372036854775807 - 1) / -1", bigInt("9223372036854775808")},
+		{"9223372036854775807 + 1 - 1", 9223372036854775807},
+		{"(9223372036854775807 * 3) / 3", 9223372036854775807},
+		{"(9223372036854775807 * 10 + 7) % 10", 7},
+		{"9223372036854775807 * 2 > 9223372036854775807", true},
+		{"9223372036854775807 + 1 == 9223372036854775807 + 1", true},
+		{"{9223372036854775807 + 1: 1}[9223372036854775807 + 1]", 1},
+		{"7 % 3", 1},
+		{"-7 % 3", -1},
+		{"3 <= 3", true},
+		{"4 >= 5", false},
+	}
+
+	for _, test := range tests {
+		assertEvalResultEqual(t, test.input, test.expect)
+	}
+}
+
 func TestEvalBooleanValue(t *testing.T) {
 	tests := []struct {
 		input  string
@@ -129,6 +169,32 @@ func TestArrayExpression(t *testing.T) {
 	}
 }
 
+func TestIndexAndSliceExpression(t *testing.T) {
+	tests := []struct {
+		input  string
+		expect interface{}
+	}{
+		{"[1, 2, 3][-1]", 3},
+		{"[1, 2, 3][-3]", 1},
+		{"len([1, 2, 3, 4][1:3])", 2},
+		{"[1, 2, 3, 4][1:3][0]", 2},
+		{"[1, 2, 3, 4][-2:][1]", 4},
+		{"len([1, 2, 3, 4][:0])", 0},
+		{"[1, 2, 3, 4][:] == [1, 2, 3, 4]", true},
+		{`"hello"[1]`, "e"},
+		{`"hello"[-1]`, "o"},
+		{`"hello"[2:]`, "llo"},
+		{`"hello"[:-2]`, "hel"},
+		{`"hello"[5:]`, ""},
+		{`"你好世界"[1]`, "好"},
+		{`"你好世界"[1:3]`, "好世"},
+	}
+
+	for _, test := range tests {
+		assertEvalResultEqual(t, test.input, test.expect)
+	}
+}
+
 func TestHashExpression(t *testing.T) {
 	tests := []struct {
 		input  string
@@ -136,6 +202,46 @@ func TestHashExpression(t *testing.T) {
 	}{
 		{"{1: \"haha\", \"hoho\":2}[\"hoho\"]", 2},
 		{"{1: \"haha\", \"hoho\":2}[1]", "haha"},
+		{"{1: \"first\", 1: \"second\"}[1]", "second"},
+		{"let x = 1; {x: x = x + 1, x: x = x * 10}[2]", 20},
+	}
+
+	for _, test := range tests {
+		assertEvalResultEqual(t, test.input, test.expect)
+	}
+}
+
+func TestStructuralEquality(t *testing.T) {
+	tests := []struct {
+		input  string
+		expect interface{}
+	}{
+		{"[1, 2, 3] == [1, 2, 3]", true},
+		{"[1, 2, 3] == [1, 2]", false},
+		{"[1, [2, \"a\"]] == [1, [2, \"a\"]]", true},
+		{"[1, [2, \"a\"]] != [1, [2, \"b\"]]", true},
+		{"{1: [1], \"a\": true} == {\"a\": true, 1: [1]}", true},
+		{"{1: [1], \"a\": true} == {\"a\": false, 1: [1]}", false},
+		{"{1: 2} == {2: 2}", false},
+		{"[1] == {1: 1}", false},
+		{"(1 < 2) == true", true},
+	}
+
+	for _, test := range tests {
+		assertEvalResultEqual(t, test.input, test.expect)
+	}
+}
+
+func TestCompositeHashKey(t *testing.T) {
+	tests := []struct {
+		input  string
+		expect interface{}
+	}{
+		{"{[1, 2]: \"a\", [2, 1]: \"b\"}[[1, 2]]", "a"},
+		{"{[1, 2]: \"a\", [2, 1]: \"b\"}[[2, 1]]", "b"},
+		{"let k = [\"x\", [true, 3]]; {k: 42}[[\"x\", [true, 3]]]", 42},
+		{"{[]: 1}[[]]", 1},
+		{"{[1, 2]: \"a\"}[[1, \"2\"]]", NULL},
 	}
 
 	for _, test := range tests {
@@ -176,6 +282,25 @@ func TestEvalError(t *testing.T) {
 		{"true + 5", "unknown operator: true + 5"},
 		{"true - true", "unknown operator: true - true"},
 		{"return true - true", "unknown operator: true - true"},
+		{"{[1, fn(x){x}]: 1}", "key type in HashLiteral is not Hashable. got \"ARRAY\""},
+		{"[1, 2, 3][3]", "index out of range [3] with length 3"},
+		{"[1, 2, 3][-4]", "index out of range [-4] with length 3"},
+		{"[][0]", "index out of range [0] with length 0"},
+		{`"abc"[10]`, "index out of range [10] with length 3"},
+		{`"abc"["a"]`, "expect Integer for String Index, got \"STRING\""},
+		{"[1, 2, 3][2:1]", "slice bounds out of range [2:1] with length 3"},
+		{"[1, 2, 3][:5]", "slice bounds out of range [:5] with length 3"},
+		{"[1, 2, 3][-5:]", "slice bounds out of range [-5:] with length 3"},
+		{"1[0:1]", "unsupported type for slice operator, got \"INTEGER\""},
+		{"1[0]", "unsupported type for index operator, got \"INTEGER\""},
+		{"1 / 0", "division by zero"},
+		{"1 % 0", "modulo by zero"},
+		{"(9223372036854775807 + 1) / 0", "division by zero"},
+		{"-true", "minus operator can not be used as prefix operator for BOOLEAN"},
+		{"fn(a, b) { a }(1)", "wrong number of arguments: want=2 got=1"},
+		{"fn(a) { a }(1, 2)", "wrong number of arguments: want=1 got=2"},
+		{"b", "unbound identifier: b"},
+		{`"a" - "b"`, `unknown operator: "a" - "b"`},
 	}
 
 	for _, test := range tests {
@@ -198,6 +323,95 @@ func TestEvalError(t *testing.T) {
 	}
 }
 
+func TestEvalErrorPosition(t *testing.T) {
+	tests := []struct {
+		input string
+		pos   token.Position
+	}{
+		{"1 / 0", token.Position{Line: 1, Column: 3}},
+		{"let a = 1;\nlet b = [1, 2];\nb[a + 2]", token.Position{Line: 3, Column: 2}},
+		{"let f = fn(x) {\n  x + true\n};\nf(1)", token.Position{Line: 2, Column: 5}},
+	}
+
+	for _, test := range tests {
+		program, err := parser.New(test.input).ParseProgram()
+		if err != nil {
+			t.Fatalf("parse program for input: %q failed. error is: %q", test.input, err.Error())
+		}
+
+		actual := Eval(program, object.NewEnvironment())
+		error, ok := actual.(*object.Error)
+		if !ok {
+			t.Fatalf("need an error for input: %q. but got %T", test.input, actual)
+		}
+
+		if error.Pos != test.pos {
+			t.Errorf("wrong error position for input: %q. want=%+v, got=%+v", test.input, test.pos, error.Pos)
+		}
+	}
+}
+
+func TestEvalIncompleteTree(t *testing.T) {
+	pos := token.Position{Line: 2, Column: 1}
+	tests := []*ast.Program{
+		{Statements: []ast.Statement{nil, &ast.ExpressionStatement{Token: token.Token{Pos: pos}}}},
+		{Statements: []ast.Statement{&ast.LetStatement{Token: token.Token{Pos: pos}, Name: &ast.Identifier{Value: "a"}}}},
+	}
+
+	for i, program := range tests {
+		actual := Eval(program, object.NewEnvironment())
+		error, ok := actual.(*object.Error)
+		if !ok {
+			t.Fatalf("need an error for program %d. but got %T", i, actual)
+		}
+		if strings.HasPrefix(error.Msg, "internal error") {
+			t.Errorf("evaluate program %d panicked: %s", i, error.Msg)
+		}
+		if error.Pos != pos {
+			t.Errorf("wrong error position for program %d. want=%+v, got=%+v", i, pos, error.Pos)
+		}
+	}
+}
+
+var crashLimits = object.Limits{MaxInstructions: 1000000}
+
+func evalWithoutPanic(input string) error {
+	program, err := parser.New(input).ParseProgram()
+	if err != nil {
+		return nil
+	}
+
+	actual := EvalContext(context.Background(), program, object.NewEnvironment(), crashLimits)
+	if actual == nil {
+		return fmt.Errorf("evaluate program for input: %q returned nil", input)
+	}
+
+	if error, ok := actual.(*object.Error); ok && strings.HasPrefix(error.Msg, "internal error") {
+		return fmt.Errorf("evaluate program for input: %q panicked: %s", input, error.Inspect())
+	}
+	return nil
+}
+
+func TestCrashCorpus(t *testing.T) {
+	for _, input := range conformance.CrashCorpus {
+		if err := evalWithoutPanic(input); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func FuzzEval(f *testing.F) {
+	for _, input := range conformance.CrashCorpus {
+		f.Add(input)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		if err := evalWithoutPanic(input); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
 func TestLetStatement(t *testing.T) {
 	tests := []struct {
 		input  string
@@ -229,6 +443,8 @@ func assertEvalResultEqual(t *testing.T, input string, expect interface{}) {
 		err = testCompareInteger(t, actual, int64(v))
 	case int64:
 		err = testCompareInteger(t, actual, v)
+	case *big.Int:
+		err = testCompareBigInteger(t, actual, v)
 	case bool:
 		err = testCompareBoolean(t, actual, v)
 	case string:
@@ -283,6 +499,19 @@ func testCompareInteger(t *testing.T, actual object.Object, expect int64) error
 	return nil
 }
 
+func testCompareBigInteger(t *testing.T, actual object.Object, expect *big.Int) error {
+	integer, ok := actual.(*object.BigInteger)
+	if !ok {
+		return fmt.Errorf("evaluated object is not big integer. got %T", actual)
+	}
+
+	if integer.Value.Cmp(expect) != 0 {
+		return fmt.Errorf("evaluated object is not %s. got %s", expect, integer.Value)
+	}
+
+	return nil
+}
+
 func testCompareBoolean(t *testing.T, actual object.Object, expect bool) error {
 	boolean, ok := actual.(*object.Boolean)
 	if !ok {
@@ -316,3 +545,103 @@ func testCompareString(t *testing.T, actual object.Object, expect string) error
 
 	return nil
 }
+
+func TestLimits(t *testing.T) {
+	exponential := "let f = fn(n) { if (n == 0) { 0 } else { f(n - 1) + f(n - 1) } }; f(40)"
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		input  string
+		ctx    context.Context
+		limits object.Limits
+		expect error
+	}{
+		{"let f = fn() { 1 + f() }; f()", context.Background(), object.Limits{}, object.ErrCallDepthLimit},
+		{"let f = fn(n) { 1 + f(n) }; f(1)", context.Background(), object.Limits{MaxCallDepth: 10}, object.ErrCallDepthLimit},
+		{exponential, context.Background(), object.Limits{MaxInstructions: 1000}, object.ErrInstructionLimit},
+		{"[1, 2, 3] + [4]", context.Background(), object.Limits{MaxAllocations: 3}, object.ErrAllocationLimit},
+		{`"abc" + "def" + "ghi"`, context.Background(), object.Limits{MaxAllocatedBytes: 50}, object.ErrMemoryLimit},
+		{exponential, context.Background(), object.Limits{Timeout: 10 * time.Millisecond}, object.ErrTimeLimit},
+		{exponential, canceled, object.Limits{}, object.ErrCanceled},
+	}
+
+	for _, test := range tests {
+		program, err := parser.New(test.input).ParseProgram()
+		if err != nil {
+			t.Fatalf("parse program for input: %q failed. error is: %q", test.input, err.Error())
+		}
+
+		actual := EvalContext(test.ctx, program, object.NewEnvironment(), test.limits)
+		error, ok := actual.(*object.Error)
+		if !ok {
+			t.Fatalf("need an error for input: %q. but got %T", test.input, actual)
+		}
+
+		if !errors.Is(error.Err, test.expect) {
+			t.Errorf("wrong error for input: %q. want=%q, got=%q", test.input, test.expect, error.Msg)
+		}
+	}
+}
+
+func TestLimitsNotExceeded(t *testing.T) {
+	input := "let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(100)"
+	program, err := parser.New(input).ParseProgram()
+	if err != nil {
+		t.Fatalf("parse program for input: %q failed. error is: %q", input, err.Error())
+	}
+
+	limits := object.Limits{MaxInstructions: 100000, MaxCallDepth: 101, MaxAllocations: 1000, Timeout: time.Minute}
+	actual := EvalContext(context.Background(), program, object.NewEnvironment(), limits)
+	if err := testCompareInteger(t, actual, 100); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []struct {
+		input  string
+		expect interface{}
+	}{
+		{`
+          let sum = fn(arr, acc) {
+              if (len(arr) == 0) {
+                  acc
+              } else {
+                  sum(rest(arr), acc + first(arr))
+              }
+          };
+          let build = fn(arr, n) {
+              if (n == 0) { return arr; }
+              build(push(arr, n), n - 1)
+          };
+          sum(build([], 5000), 0);
+          `, 12502500},
+		{"let countDown = fn(n) { if (n == 0) { return 0; } countDown(n - 1) }; countDown(100000);", 0},
+		{"let f = fn(arr) { len(arr) }; f([1, 2]) + 1", 3},
+	}
+
+	for _, test := range tests {
+		assertEvalResultEqual(t, test.input, test.expect)
+	}
+}
+
+func TestDeepRecursion(t *testing.T) {
+	// the same depth the vm allows
+	assertEvalResultEqual(t, "let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(1022)", 1022)
+
+	program, err := parser.New("let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(100000)").ParseProgram()
+	if err != nil {
+		t.Fatalf("parse program failed. error is: %q", err.Error())
+	}
+
+	actual := Eval(program, object.NewEnvironment())
+	error, ok := actual.(*object.Error)
+	if !ok {
+		t.Fatalf("need an error for deep recursion. but got %T", actual)
+	}
+
+	if !errors.Is(error.Err, object.ErrCallDepthLimit) {
+		t.Errorf("wrong error for deep recursion. got %q", error.Msg)
+	}
+}
@@ -0,0 +1,162 @@
+package evaluator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"object"
+	"parser"
+	"reflect"
+	"strings"
+	"testing"
+	"token"
+)
+
+// hooksInput calls a function, a builtin in a function, a function in tail position
+// and a function which fails, in this order
+const hooksInput = `
+let add = fn(a, b) { a + b };
+let size = fn(xs) { len(xs) + 0 };
+let loop = fn(n) { if (n == 0) { 0 } else { loop(n - 1) } };
+add(1, 2);
+size([1, "two"]);
+loop(2);
+fn() { 1 + true }();
+`
+
+// recorder records the start, the end, the calls, the returns and the errors of a run and fails the instruction
+// number failAt, if not 0
+type recorder struct {
+	object.NopHooks
+	events       []string
+	instructions int
+	failAt       int
+}
+
+var errStopped = errors.New("stopped by the hooks")
+
+func (r *recorder) OnStart() {
+	r.events = append(r.events, "start")
+}
+
+func (r *recorder) OnEnd(result object.Object) {
+	r.events = append(r.events, fmt.Sprintf("end %s", result.Inspect()))
+}
+
+func (r *recorder) OnInstruction(pos token.Position) error {
+	r.instructions++
+	if r.instructions == r.failAt {
+		return errStopped
+	}
+	return nil
+}
+
+func (r *recorder) OnCall(name string, args []object.Object) error {
+	r.events = append(r.events, fmt.Sprintf("call %s (%s)", name, inspectAll(args)))
+	return nil
+}
+
+func (r *recorder) OnReturn(name string, result object.Object) {
+	if result == nil {
+		r.events = append(r.events, fmt.Sprintf("leave %s", name))
+		return
+	}
+	r.events = append(r.events, fmt.Sprintf("return %s %s", name, result.Inspect()))
+}
+
+func (r *recorder) OnBuiltinCall(name string, args []object.Object) error {
+	r.events = append(r.events, fmt.Sprintf("builtin %s (%s)", name, inspectAll(args)))
+	return nil
+}
+
+func (r *recorder) OnBuiltinReturn(name string, result object.Object) {
+	r.events = append(r.events, fmt.Sprintf("builtin return %s %s", name, result.Inspect()))
+}
+
+func (r *recorder) OnError(err error) {
+	r.events = append(r.events, fmt.Sprintf("error %s", err))
+}
+
+func inspectAll(objs []object.Object) string {
+	inspected := []string{}
+	for _, obj := range objs {
+		inspected = append(inspected, obj.Inspect())
+	}
+	return strings.Join(inspected, ", ")
+}
+
+func runHooked(t *testing.T, input string, h object.Hooks) error {
+	t.Helper()
+
+	program, err := parser.New(input).ParseProgram()
+	if err != nil {
+		t.Fatalf("parse program failed. error is: %q", err.Error())
+	}
+
+	ev := New()
+	ev.SetHooks(h)
+	result := ev.EvalContext(context.Background(), program, object.NewEnvironment(), object.Limits{})
+	if err, ok := result.(*object.Error); ok {
+		return err
+	}
+	return nil
+}
+
+func TestHooks(t *testing.T) {
+	r := &recorder{}
+	err := runHooked(t, hooksInput, r)
+	if err == nil {
+		t.Fatalf("expected the program to fail")
+	}
+
+	// the same events as the vm
+	expected := []string{
+		"start",
+		"call add (1, 2)",
+		"return add 3",
+		"call size ([1, two])",
+		"builtin len ([1, two])",
+		"builtin return len 2",
+		"return size 2",
+		"call loop (2)",
+		"leave loop",
+		"call loop (1)",
+		"leave loop",
+		"call loop (0)",
+		"return loop 0",
+		"call fn@8:1 ()",
+		"error " + err.Error(),
+	}
+	if !reflect.DeepEqual(r.events, expected) {
+		t.Errorf("wrong events.\nwant=%q\ngot=%q", expected, r.events)
+	}
+	if r.instructions == 0 {
+		t.Errorf("no instructions seen by the hooks")
+	}
+}
+
+func TestHooksStop(t *testing.T) {
+	r := &recorder{failAt: 10}
+	err := runHooked(t, hooksInput, r)
+	if !errors.Is(err, errStopped) {
+		t.Fatalf("wrong error. want=%q, got=%v", errStopped, err)
+	}
+	if r.instructions != 10 {
+		t.Errorf("the run went on after the hooks failed. got=%d instructions", r.instructions)
+	}
+	if last := r.events[len(r.events)-1]; last != "error "+err.Error() {
+		t.Errorf("wrong last event. want=%q, got=%q", "error "+err.Error(), last)
+	}
+}
+
+func TestHooksEnd(t *testing.T) {
+	r := &recorder{}
+	if err := runHooked(t, "let f = fn(x) { x * 2 }; f(3)", r); err != nil {
+		t.Fatalf("run failed. error is: %q", err)
+	}
+
+	expected := []string{"start", "call f (3)", "return f 6", "end 6"}
+	if !reflect.DeepEqual(r.events, expected) {
+		t.Errorf("wrong events.\nwant=%q\ngot=%q", expected, r.events)
+	}
+}
@@ -0,0 +1,140 @@
+package evaluator
+
+import (
+	"object"
+	"parser"
+	"testing"
+	"token"
+)
+
+func TestResolveErrors(t *testing.T) {
+	tests := []struct {
+		input  string
+		expect string
+		pos    token.Position
+	}{
+		{"let a = 1;\nlet b = a + c;", "unbound identifier: c", token.Position{Line: 2, Column: 13}},
+		{"let f = fn() { g };\n1 / 0", "unbound identifier: g", token.Position{Line: 1, Column: 16}},
+		{"let f = fn(x) { x };\nx", "unbound identifier: x", token.Position{Line: 2, Column: 1}},
+		{"1 / 0;\nunknown = 1", "unbound identifier: unknown", token.Position{Line: 2, Column: 1}},
+		{"let f = fn(x) {\n  fn() { x = 1 }\n}", "can not assign to x, it is captured by a function", token.Position{Line: 2, Column: 10}},
+		{"let f = fn(x) { let g = fn() { x }; x = 1 }", "can not assign to x, it is captured by a function", token.Position{Line: 1, Column: 37}},
+		{"let f = fn(x) { let g = fn() { x }; let x = 1 }", "can not define x again, it is captured by a function", token.Position{Line: 1, Column: 41}},
+		{"len = 1", "can not assign to builtin len", token.Position{Line: 1, Column: 1}},
+	}
+
+	for _, test := range tests {
+		program, err := parser.New(test.input).ParseProgram()
+		if err != nil {
+			t.Fatalf("parse program for input: %q failed. error is: %q", test.input, err.Error())
+		}
+
+		env := object.NewEnvironment()
+		actual := Eval(program, env)
+		error, ok := actual.(*object.Error)
+		if !ok {
+			t.Fatalf("need an error for input: %q. but got %T", test.input, actual)
+		}
+
+		if error.Msg != test.expect || error.Pos != test.pos {
+			t.Errorf("wrong error for input: %q. want=%s at %+v, got=%s at %+v", test.input, test.expect, test.pos, error.Msg, error.Pos)
+		}
+
+		// reported before any statement is run
+		if val, ok := env.Get("a"); ok {
+			t.Errorf("expect a not to be set for input: %q. got %s", test.input, val.Inspect())
+		}
+	}
+}
+
+func TestResolveScopes(t *testing.T) {
+	tests := []struct {
+		input  string
+		expect interface{}
+	}{
+		{"let x = 1; let x = x + 1; x", 2},
+		{"let x = 1; let f = fn() { let x = x + 10; x }; f() + x", 12},
+		{"let f = fn(x) { let x = x * 2; x }; f(3)", 6},
+		{"let f = fn() { g() }; let g = fn() { 5 }; f()", 5},
+		{"let f = fn() { let g = fn(n) { if (n == 0) { 0 } else { n + g(n - 1) } }; g(4) }; f()", 10},
+		{"let a = 1; let f = fn() { a = a + 1 }; f(); f(); a", 3},
+		{"let f = fn(x) { if (x) { let y = 1 }; y }; f(true)", 1},
+		{"let len = fn(x) { 42 }; len([])", 42},
+	}
+
+	for _, test := range tests {
+		assertEvalResultEqual(t, test.input, test.expect)
+	}
+}
+
+func TestResolveUnboundAtRuntime(t *testing.T) {
+	tests := []string{
+		"x; let x = 1",
+		"let x = x + 1",
+		"let f = fn() { y }; f(); let y = 1",
+		"let f = fn(x) { if (x) { let y = 1 }; y }; f(false)",
+	}
+
+	for _, input := range tests {
+		program, err := parser.New(input).ParseProgram()
+		if err != nil {
+			t.Fatalf("parse program for input: %q failed. error is: %q", input, err.Error())
+		}
+
+		actual := Eval(program, object.NewEnvironment())
+		error, ok := actual.(*object.Error)
+		if !ok || error.Msg[:len("unbound identifier")] != "unbound identifier" {
+			t.Errorf("need an unbound identifier error for input: %q. but got %s", input, actual.Inspect())
+		}
+	}
+}
+
+func TestResolveWithGlobals(t *testing.T) {
+	env := object.NewEnvironment()
+	inputs := []string{
+		"let a = 1;",
+		"let f = fn(x) { x + a };",
+		"a = 10;",
+	}
+
+	for _, input := range inputs {
+		program, err := parser.New(input).ParseProgram()
+		if err != nil {
+			t.Fatalf("parse program for input: %q failed. error is: %q", input, err.Error())
+		}
+
+		if actual := Eval(program, env); IsError(actual) {
+			t.Fatalf("evaluate for input: %q failed. error is: %s", input, actual.Inspect())
+		}
+	}
+
+	program, err := parser.New("f(5)").ParseProgram()
+	if err != nil {
+		t.Fatalf("parse program failed. error is: %q", err.Error())
+	}
+
+	if err := testCompareInteger(t, Eval(program, env), 15); err != nil {
+		t.Errorf("evaluate with globals failed. error is: %s", err)
+	}
+}
+
+func TestResolveSharedProgram(t *testing.T) {
+	program, err := parser.New("let f = fn(n) { if (n == 0) { 0 } else { n + f(n - 1) } }; f(100)").ParseProgram()
+	if err != nil {
+		t.Fatalf("parse program failed. error is: %q", err.Error())
+	}
+
+	// the addresses are kept by each evaluation, not in the ast
+	results := make(chan object.Object)
+	for i := 0; i < 4; i++ {
+		go func() {
+			results <- Eval(program, object.NewEnvironment())
+		}()
+	}
+
+	for i := 0; i < 4; i++ {
+		if err := testCompareInteger(t, <-results, 5050); err != nil {
+			t.Errorf("evaluate shared program failed. error is: %s", err)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package evaluator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"instrument"
+	"object"
+	"parser"
+	"reflect"
+	"testing"
+)
+
+func TestTrace(t *testing.T) {
+	input := `
+let add = fn(a, b) { a + b };
+let size = fn(xs) { len(xs) + 0 };
+let loop = fn(n) { if (n == 0) { 0 } else { loop(n - 1) } };
+add(1, 2);
+size([1, "two"]);
+loop(2);
+fn() { 1 + true }();
+`
+	// the calls in tail position end the span of their caller, like in the vm
+	expected := []string{
+		"B main ()",
+		"B add (1, 2)",
+		"E",
+		"B size ([1, two])",
+		"B len ([1, two])",
+		"E",
+		"E",
+		"B loop (2)",
+		"E",
+		"B loop (1)",
+		"E",
+		"B loop (0)",
+		"E",
+		"B fn@8:1 ()",
+		"E",
+		"E",
+	}
+
+	program, err := parser.New(input).ParseProgram()
+	if err != nil {
+		t.Fatalf("parse program failed. error is: %q", err.Error())
+	}
+
+	var buf bytes.Buffer
+	tracer := instrument.NewTracer(&buf)
+	ev := New()
+	ev.SetHooks(tracer)
+	if result := ev.EvalContext(context.Background(), program, object.NewEnvironment(), object.Limits{}); !IsError(result) {
+		t.Fatalf("expected the program to fail. got=%s", result.Inspect())
+	}
+	if tracer.Depth() != 0 {
+		t.Errorf("spans left open by the run. got=%d", tracer.Depth())
+	}
+	if err := tracer.Close(); err != nil {
+		t.Fatalf("close failed. error is: %q", err)
+	}
+
+	events := []struct {
+		Ph   string
+		Name string
+		Args map[string]string
+	}{}
+	if err := json.Unmarshal(buf.Bytes(), &events); err != nil {
+		t.Fatalf("trace is not a JSON array of events. error is: %q, trace is %q", err, buf.String())
+	}
+
+	spans := []string{}
+	for _, event := range events {
+		if event.Ph == "E" {
+			spans = append(spans, "E")
+			continue
+		}
+		spans = append(spans, fmt.Sprintf("%s %s (%s)", event.Ph, event.Name, event.Args["args"]))
+	}
+	if !reflect.DeepEqual(spans, expected) {
+		t.Errorf("wrong spans.\nwant=%q\ngot=%q", expected, spans)
+	}
+}
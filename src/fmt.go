@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"format"
+	"io/ioutil"
+	"os"
+)
+
+// formatFiles formats gorilla programs, it writes them to stdout or back to their files
+func formatFiles(args []string) int {
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	write := flags.Bool("w", false, "write the formatted programs back to their files instead of to stdout")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: gorilla fmt [-w] files...\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	status := 0
+	for _, file := range flags.Args() {
+		input, err := ioutil.ReadFile(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "read program failed: %s\n", err)
+			status = 1
+			continue
+		}
+
+		out, err := format.Source(input)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: parse program failed: %s\n", file, err)
+			status = 1
+			continue
+		}
+
+		if !*write {
+			os.Stdout.Write(out)
+			continue
+		}
+		if bytes.Equal(out, input) {
+			continue
+		}
+		if err := ioutil.WriteFile(file, out, 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "write program failed: %s\n", err)
+			status = 1
+		}
+	}
+	return status
+}
@@ -0,0 +1,654 @@
+// Package format formats gorilla programs in their canonical layout: statements and
+// blocks indented by tabs, one space around infix operators and after commas, only the
+// parentheses the grammar needs, and arrays, hashes and calls broken into a line per
+// element when they do not fit into MaxWidth. Comments and single blank lines between
+// statements are kept. Formatting a formatted program changes nothing, and the
+// formatted program parses to the same syntax tree as the original one
+package format
+
+import (
+	"ast"
+	"lexer"
+	"parser"
+	"strings"
+	"token"
+)
+
+// MaxWidth is the width of the lines arrays, hashes and calls are kept on
+const MaxWidth = 100
+
+// tabWidth is the width of a tab indenting a line, for MaxWidth
+const tabWidth = 4
+
+// Source formats the program in src
+func Source(src []byte) ([]byte, error) {
+	input := strings.Replace(string(src), "\r\n", "\n", -1)
+
+	program, err := parser.New(input).ParseProgram()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(Program(program, input)), nil
+}
+
+// Program formats program parsed from source, which tells where the blank lines and
+// the comments on their own lines are
+func Program(program *ast.Program, source string) string {
+	p := &printer{
+		lines:    strings.Split(source, "\n"),
+		comments: map[ast.Node]*comments{},
+		letNames: map[*ast.Identifier]bool{},
+		cache:    map[cacheKey]string{},
+	}
+	p.assignComments(program)
+
+	out := p.statements(program, program.Statements, true)
+	if out == "" {
+		return ""
+	}
+	return out + "\n"
+}
+
+type printer struct {
+	lines  []string
+	indent int
+
+	// comments holds the comments of the program and of each block
+	comments map[ast.Node]*comments
+	// letNames holds the names of the let statements
+	letNames map[*ast.Identifier]bool
+
+	// cache holds the expressions formatted so far, an expression is formatted again
+	// for each layout of the lists it is in
+	cache map[cacheKey]string
+}
+
+type cacheKey struct {
+	node   ast.Node
+	col    int
+	indent int
+}
+
+// comments are the comments of a list of statements, or of the items of an array, a
+// hash or a call. leading holds the comments on their own lines before each statement,
+// trailing the comments after each statement on its last line and end the comments after
+// the last statement
+type comments struct {
+	leading  map[int][]token.Comment
+	trailing map[int][]token.Comment
+	end      []token.Comment
+}
+
+// assignComments gives each comment to the innermost block, array, hash or call it is in,
+// and there to the statement or item it precedes or follows on the same line. It also
+// finds the names of the let statements
+func (p *printer) assignComments(program *ast.Program) {
+	type scope struct {
+		node       ast.Node
+		items      []ast.Node
+		start, end token.Position
+	}
+
+	scopes := []scope{}
+	walk(program, func(node ast.Node) {
+		switch node := node.(type) {
+		case *ast.BlockExpression:
+			scopes = append(scopes, scope{node: node, items: statementNodes(node.Statements), start: node.Token.Pos, end: node.End})
+		case *ast.CallExpression:
+			scopes = append(scopes, scope{node: node, items: expressionNodes(node.Arguments), start: node.Token.Pos, end: node.End})
+		case *ast.ArrayLiteral:
+			scopes = append(scopes, scope{node: node, items: expressionNodes(node.Elements), start: node.Token.Pos, end: node.End})
+		case *ast.HashLiteral:
+			scopes = append(scopes, scope{node: node, items: pairNodes(node.Pairs), start: node.Token.Pos, end: node.End})
+		case *ast.LetStatement:
+			p.letNames[node.Name] = true
+		}
+	})
+
+	for _, c := range program.Comments {
+		// scopes are walked outside in, so the last scope containing c is the innermost
+		owner := scope{node: program, items: statementNodes(program.Statements)}
+		for _, s := range scopes {
+			if s.start.Before(c.Pos) && c.Pos.Before(s.end) {
+				owner = s
+			}
+		}
+
+		cs, ok := p.comments[owner.node]
+		if !ok {
+			cs = &comments{leading: map[int][]token.Comment{}, trailing: map[int][]token.Comment{}}
+			p.comments[owner.node] = cs
+		}
+
+		// k is the statement or item c is in or follows
+		k := -1
+		for i, item := range owner.items {
+			if !c.Pos.Before(item.Pos()) {
+				k = i
+			}
+		}
+
+		switch {
+		case k >= 0 && !p.ownLine(c):
+			cs.trailing[k] = append(cs.trailing[k], c)
+		case k+1 < len(owner.items):
+			cs.leading[k+1] = append(cs.leading[k+1], c)
+		default:
+			cs.end = append(cs.end, c)
+		}
+	}
+}
+
+func statementNodes(statements []ast.Statement) []ast.Node {
+	nodes := make([]ast.Node, len(statements))
+	for i, stmt := range statements {
+		nodes[i] = stmt
+	}
+	return nodes
+}
+
+func expressionNodes(expressions []ast.Expression) []ast.Node {
+	nodes := make([]ast.Node, len(expressions))
+	for i, e := range expressions {
+		nodes[i] = e
+	}
+	return nodes
+}
+
+func pairNodes(pairs []*ast.HashPair) []ast.Node {
+	nodes := make([]ast.Node, len(pairs))
+	for i, pair := range pairs {
+		nodes[i] = pairNode{pair}
+	}
+	return nodes
+}
+
+// ownLine tells whether nothing but white spaces precede c on its line
+func (p *printer) ownLine(c token.Comment) bool {
+	line := []rune(p.line(c.Pos.Line))
+	if c.Pos.Column-1 > len(line) {
+		return false
+	}
+	return strings.TrimSpace(string(line[:c.Pos.Column-1])) == ""
+}
+
+func (p *printer) line(n int) string {
+	if n < 1 || n > len(p.lines) {
+		return ""
+	}
+	return p.lines[n-1]
+}
+
+// blankBefore tells whether the line before line n is blank
+func (p *printer) blankBefore(n int) bool {
+	return n > 1 && strings.TrimSpace(p.line(n-1)) == ""
+}
+
+func (p *printer) tabs() string {
+	return strings.Repeat("\t", p.indent)
+}
+
+// statements formats the statements of owner, a line each at the current indentation
+func (p *printer) statements(owner ast.Node, statements []ast.Statement, program bool) string {
+	cs := p.comments[owner]
+	if cs == nil {
+		cs = &comments{}
+	}
+
+	texts := make([]string, len(statements))
+	for i, stmt := range statements {
+		texts[i] = p.statement(stmt, p.indent*tabWidth)
+	}
+
+	var b strings.Builder
+	first := true
+	// item starts a line, after a blank line if there is one before line in the source
+	item := func(line int) {
+		if !first {
+			b.WriteString("\n")
+			if p.blankBefore(line) {
+				b.WriteString("\n")
+			}
+		}
+		first = false
+		b.WriteString(p.tabs())
+	}
+
+	for i, stmt := range statements {
+		for _, c := range cs.leading[i] {
+			item(c.Pos.Line)
+			b.WriteString(c.Text)
+		}
+
+		item(stmt.Pos().Line)
+		b.WriteString(texts[i])
+		if p.needsSemicolon(stmt, i, texts, program) {
+			b.WriteString(";")
+		}
+
+		for j, c := range cs.trailing[i] {
+			if j == 0 {
+				b.WriteString(" " + c.Text)
+				continue
+			}
+			// a comment ends its line, the others go on the lines after it
+			item(c.Pos.Line)
+			b.WriteString(c.Text)
+		}
+	}
+
+	for _, c := range cs.end {
+		item(c.Pos.Line)
+		b.WriteString(c.Text)
+	}
+	return b.String()
+}
+
+// needsSemicolon tells whether the statement i ends with a semicolon. Let and return
+// statements always do. An expression statement does not at the end of a block, and
+// an if expression only does when the next statement would continue it
+func (p *printer) needsSemicolon(stmt ast.Statement, i int, texts []string, program bool) bool {
+	es, ok := stmt.(*ast.ExpressionStatement)
+	if !ok {
+		return true
+	}
+
+	last := i == len(texts)-1
+	if _, ok := es.Value.(*ast.IfExpression); ok {
+		return !last && strings.IndexByte("([-", texts[i+1][0]) >= 0
+	}
+	return !last || program
+}
+
+// statement formats stmt starting at col, without its semicolon
+func (p *printer) statement(stmt ast.Statement, col int) string {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		prefix := "let " + stmt.Name.Value + " = "
+		return prefix + p.expr(stmt.Value, col+len(prefix))
+	case *ast.ReturnStatement:
+		if stmt.Value == nil {
+			return "return"
+		}
+		return "return " + p.expr(stmt.Value, col+len("return "))
+	case *ast.ExpressionStatement:
+		return p.expr(stmt.Value, col)
+	default:
+		return stmt.String()
+	}
+}
+
+func (p *printer) expr(e ast.Expression, col int) string {
+	key := cacheKey{node: e, col: col, indent: p.indent}
+	if s, ok := p.cache[key]; ok {
+		return s
+	}
+
+	s := p.format(e, col)
+	p.cache[key] = s
+	return s
+}
+
+func (p *printer) format(e ast.Expression, col int) string {
+	switch e := e.(type) {
+	case *ast.Identifier:
+		return e.Value
+	case *ast.Integer:
+		return e.Token.Literal
+	case *ast.Boolean:
+		if e.Value {
+			return "true"
+		}
+		return "false"
+	case *ast.String:
+		return quote(e.Value)
+	case *ast.PrefixExpression:
+		operand := p.operand(e.Value, col+len(e.Operator), needsParensInPrefix(e.Value))
+		return e.Operator + operand
+	case *ast.PostfixExpression:
+		return p.operand(e.Left, col, needsParensAsPrimary(e.Left)) + e.Operator
+	case *ast.InfixExpression:
+		return p.infix(e, col)
+	case *ast.SliceExpression:
+		left := p.operand(e.Left, col, needsParensAsPrimary(e.Left))
+		s := left + "["
+		if e.Start != nil {
+			s += p.expr(e.Start, lastColumn(col, s))
+		}
+		s += ":"
+		if e.End != nil {
+			s += p.expr(e.End, lastColumn(col, s))
+		}
+		return s + "]"
+	case *ast.IfExpression:
+		s := "if (" + p.expr(e.Condition, col+len("if (")) + ") "
+		s += p.block(e.ThenBody, lastColumn(col, s))
+		if e.ElseBody != nil {
+			s += " else "
+			s += p.block(e.ElseBody, lastColumn(col, s))
+		}
+		return s
+	case *ast.FunctionExpression:
+		return p.function(e, col)
+	case *ast.CallExpression:
+		callee := p.operand(e.Function, col, needsParensAsPrimary(e.Function))
+		return callee + p.list(e, "(", ")", expressionNodes(e.Arguments), lastColumn(col, callee))
+	case *ast.ArrayLiteral:
+		return p.list(e, "[", "]", expressionNodes(e.Elements), col)
+	case *ast.HashLiteral:
+		return p.list(e, "{", "}", pairNodes(e.Pairs), col)
+	case *ast.BlockExpression:
+		return p.block(e, col)
+	default:
+		return e.String()
+	}
+}
+
+// pairNode is a pair of a hash literal in a list
+type pairNode struct {
+	*ast.HashPair
+}
+
+func (n pairNode) TokenLieteral() string { return "" }
+func (n pairNode) String() string        { return n.Key.String() + ": " + n.Value.String() }
+func (n pairNode) Pos() token.Position   { return n.Key.Pos() }
+
+// function formats fn. A function bound by a let statement is named by the parser with the
+// name of the let statement, it is written without it
+func (p *printer) function(fn *ast.FunctionExpression, col int) string {
+	s := "fn"
+	if fn.Name != nil && !p.letNames[fn.Name] {
+		s += " " + fn.Name.Value
+	}
+
+	params := make([]string, len(fn.Parameters))
+	for i, param := range fn.Parameters {
+		params[i] = param.Value
+	}
+	s += "(" + strings.Join(params, ", ") + ") "
+	return s + p.block(fn.Body, lastColumn(col, s))
+}
+
+func (p *printer) infix(e *ast.InfixExpression, col int) string {
+	if e.Operator == "[" {
+		left := p.operand(e.Left, col, needsParensAsPrimary(e.Left))
+		return left + "[" + p.expr(e.Right, lastColumn(col, left)+1) + "]"
+	}
+
+	prec := precedence(e.Operator)
+	// the operators of a precedence are left associative
+	left := p.operand(e.Left, col, needsParensAsOperand(e.Left, prec, false))
+	s := left + " " + e.Operator + " "
+	return s + p.operand(e.Right, lastColumn(col, s), needsParensAsOperand(e.Right, prec, true))
+}
+
+// operand formats e starting at col, in parentheses if parens is set
+func (p *printer) operand(e ast.Expression, col int, parens bool) string {
+	if parens {
+		return "(" + p.expr(e, col+1) + ")"
+	}
+	return p.expr(e, col)
+}
+
+func isIndex(e ast.Expression) bool {
+	infix, ok := e.(*ast.InfixExpression)
+	return ok && infix.Operator == "["
+}
+
+// needsParensAsOperand tells whether e needs parentheses as an operand of an operator of
+// precedence prec. A prefix operator takes the operators of higher precedence than its
+// own into its operand, like -a * b is -(a * b)
+func needsParensAsOperand(e ast.Expression, prec int, right bool) bool {
+	switch e := e.(type) {
+	case *ast.InfixExpression:
+		if e.Operator == "[" {
+			return false
+		}
+		if right {
+			return precedence(e.Operator) <= prec
+		}
+		return precedence(e.Operator) < prec
+	case *ast.PrefixExpression:
+		return precedence(e.Operator) < prec
+	}
+	return false
+}
+
+// needsParensInPrefix tells whether e needs parentheses as the operand of a prefix
+// operator. Operators are always put in them, to tell -(a * b) from (-a) * b
+func needsParensInPrefix(e ast.Expression) bool {
+	switch e.(type) {
+	case *ast.InfixExpression:
+		return !isIndex(e)
+	case *ast.PrefixExpression, *ast.PostfixExpression:
+		return true
+	}
+	return false
+}
+
+// needsParensAsPrimary tells whether e needs parentheses when it is called, indexed,
+// sliced or incremented
+func needsParensAsPrimary(e ast.Expression) bool {
+	switch e.(type) {
+	case *ast.InfixExpression:
+		return !isIndex(e)
+	case *ast.PrefixExpression, *ast.PostfixExpression, *ast.FunctionExpression, *ast.IfExpression:
+		return true
+	}
+	return false
+}
+
+var precedences = map[string]int{}
+
+// precedence is the precedence of the operator op, as the parser sees it
+func precedence(op string) int {
+	prec, ok := precedences[op]
+	if !ok {
+		prec = lexer.New(op, nil).NextToken().Precedence()
+		precedences[op] = prec
+	}
+	return prec
+}
+
+// list formats the items of owner, the elements of an array, the pairs of a hash or the
+// arguments of a call, between open and close. They are kept on a line if it fits into
+// MaxWidth and they have no comments, else each of them is put on its own line, followed
+// by a comma and the comments after it
+func (p *printer) list(owner ast.Node, open string, close string, items []ast.Node, col int) string {
+	cs, commented := p.comments[owner]
+	if len(items) == 0 && !commented {
+		return open + close
+	}
+
+	if !commented {
+		s := open
+		fits := true
+		for i, item := range items {
+			if i > 0 {
+				s += ", "
+			}
+			text := p.item(item, lastColumn(col, s))
+			// only the last element can span lines, like a function
+			if i < len(items)-1 && strings.Contains(text, "\n") {
+				fits = false
+				break
+			}
+			s += text
+		}
+		s += close
+
+		if fits && col+width(firstLine(s)) <= MaxWidth {
+			return s
+		}
+		cs = &comments{}
+	}
+
+	p.indent++
+	s := open + "\n"
+	for i, item := range items {
+		for _, c := range cs.leading[i] {
+			s += p.tabs() + c.Text + "\n"
+		}
+		s += p.tabs() + p.item(item, p.indent*tabWidth) + ","
+		for j, c := range cs.trailing[i] {
+			if j == 0 {
+				s += " " + c.Text
+				continue
+			}
+			// a comment ends its line, the others go on the lines after it
+			s += "\n" + p.tabs() + c.Text
+		}
+		s += "\n"
+	}
+	for _, c := range cs.end {
+		s += p.tabs() + c.Text + "\n"
+	}
+	p.indent--
+	return s + p.tabs() + close
+}
+
+func (p *printer) item(item ast.Node, col int) string {
+	if pair, ok := item.(pairNode); ok {
+		key := p.expr(pair.Key, col)
+		s := key + ": "
+		return s + p.expr(pair.Value, lastColumn(col, s))
+	}
+	return p.expr(item.(ast.Expression), col)
+}
+
+// block formats b. A block of a single expression or return statement is kept on a
+// line if it fits into MaxWidth and has no comments
+func (p *printer) block(b *ast.BlockExpression, col int) string {
+	_, commented := p.comments[b]
+	if len(b.Statements) == 0 && !commented {
+		return "{}"
+	}
+
+	if len(b.Statements) == 1 && !commented {
+		var s string
+		switch stmt := b.Statements[0].(type) {
+		case *ast.ExpressionStatement:
+			s = "{ " + p.statement(stmt, col+2) + " }"
+		case *ast.ReturnStatement:
+			s = "{ " + p.statement(stmt, col+2) + "; }"
+		}
+		if s != "" && !strings.Contains(s, "\n") && col+width(s) <= MaxWidth {
+			return s
+		}
+	}
+
+	p.indent++
+	body := p.statements(b, b.Statements, false)
+	p.indent--
+	return "{\n" + body + "\n" + p.tabs() + "}"
+}
+
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
+
+// width is the width of line, with the tabs indenting it
+func width(line string) int {
+	tabs := len(line) - len(strings.TrimLeft(line, "\t"))
+	return tabs*tabWidth + len([]rune(line)) - tabs
+}
+
+// lastColumn is the column after s, which starts at col
+func lastColumn(col int, s string) int {
+	i := strings.LastIndexByte(s, '\n')
+	if i < 0 {
+		return col + len([]rune(s))
+	}
+	return width(s[i+1:])
+}
+
+// quote writes s as a string literal, with the escapes the lexer reads
+func quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '\\':
+			b.WriteString(`\\`)
+		case '"':
+			b.WriteString(`\"`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// walk calls fn for node and then for its sub nodes, in source order
+func walk(node ast.Node, fn func(ast.Node)) {
+	if node == nil {
+		return
+	}
+	fn(node)
+
+	switch node := node.(type) {
+	case *ast.Program:
+		for _, stmt := range node.Statements {
+			walk(stmt, fn)
+		}
+	case *ast.BlockExpression:
+		for _, stmt := range node.Statements {
+			walk(stmt, fn)
+		}
+	case *ast.ExpressionStatement:
+		walk(node.Value, fn)
+	case *ast.LetStatement:
+		walk(node.Value, fn)
+	case *ast.ReturnStatement:
+		if node.Value != nil {
+			walk(node.Value, fn)
+		}
+	case *ast.PrefixExpression:
+		walk(node.Value, fn)
+	case *ast.InfixExpression:
+		walk(node.Left, fn)
+		walk(node.Right, fn)
+	case *ast.PostfixExpression:
+		walk(node.Left, fn)
+	case *ast.SliceExpression:
+		walk(node.Left, fn)
+		if node.Start != nil {
+			walk(node.Start, fn)
+		}
+		if node.End != nil {
+			walk(node.End, fn)
+		}
+	case *ast.IfExpression:
+		walk(node.Condition, fn)
+		walk(node.ThenBody, fn)
+		if node.ElseBody != nil {
+			walk(node.ElseBody, fn)
+		}
+	case *ast.FunctionExpression:
+		walk(node.Body, fn)
+	case *ast.CallExpression:
+		walk(node.Function, fn)
+		for _, arg := range node.Arguments {
+			walk(arg, fn)
+		}
+	case *ast.ArrayLiteral:
+		for _, elem := range node.Elements {
+			walk(elem, fn)
+		}
+	case *ast.HashLiteral:
+		for _, pair := range node.Pairs {
+			walk(pair.Key, fn)
+			walk(pair.Value, fn)
+		}
+	}
+}
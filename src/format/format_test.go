@@ -0,0 +1,219 @@
+package format
+
+import (
+	"parser"
+	"testing"
+)
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let   a=1+2*3", "let a = 1 + 2 * 3;\n"},
+		{"a+b;c", "a + b;\nc;\n"},
+		{`"a\"b\\c\n\t"`, `"a\"b\\c\n\t";` + "\n"},
+		{"(1 + 2) * 3; 1 + (2 * 3); a - (b - c); (a - b) - c", "(1 + 2) * 3;\n1 + 2 * 3;\na - (b - c);\na - b - c;\n"},
+		// a prefix operator takes the operators of higher precedence into its operand
+		{"-a * b; (-a) * b; a * -b; a == -b; !a == b; -(-a)", "-(a * b);\n(-a) * b;\na * (-b);\na == -b;\n!a == b;\n-(-a);\n"},
+		{"a = b = c; a = (b = c); x += 1; a || b && c", "a = b = c;\na = (b = c);\nx += 1;\na || b && c;\n"},
+		{"xs[1]; xs[1:]; xs[:2]; xs[:]; (a + b)[0]; f(1)(2); x++", "xs[1];\nxs[1:];\nxs[:2];\nxs[:];\n(a + b)[0];\nf(1)(2);\nx++;\n"},
+		{"[1,2,3]; {}; []; {\"a\":1, 2:[]}", "[1, 2, 3];\n{};\n[];\n{\"a\": 1, 2: []};\n"},
+		{"fn(x){x}(1); let f = fn g(a, b) { a }; fn h() {}", "(fn(x) { x })(1);\nlet f = fn(a, b) { a };\nfn h() {};\n"},
+		{
+			"let f = fn(x) { let y = x; return y; }",
+			"let f = fn(x) {\n\tlet y = x;\n\treturn y;\n};\n",
+		},
+		{"if (a) { b } else { c }; d", "if (a) { b } else { c }\nd;\n"},
+		// a statement starting with a parenthesis would call the if expression before it
+		{"if (a) { b }; (c + d) * e", "if (a) { b };\n(c + d) * e;\n"},
+		{"if (a) { b }; [c]", "if (a) { b };\n[c];\n"},
+		{"if (a) { b }; -c", "if (a) { b };\n-c;\n"},
+		{"fn() { if (a) { return 1; } 2 }", "fn() {\n\tif (a) { return 1; }\n\t2\n};\n"},
+		{"let a = 1;\n\n\n\nlet b = 2;\nlet c = 3;", "let a = 1;\n\nlet b = 2;\nlet c = 3;\n"},
+		{"", ""},
+		{
+			"let h = {\"aaaaaaaaaaaaaaaaaaaa\": 1111111111111, \"bbbbbbbbbbbbbbbbbbbbbbbbbb\": 2222222222222, \"cccccccccc\": 3};",
+			"let h = {\n\t\"aaaaaaaaaaaaaaaaaaaa\": 1111111111111,\n\t\"bbbbbbbbbbbbbbbbbbbbbbbbbb\": 2222222222222,\n\t\"cccccccccc\": 3,\n};\n",
+		},
+		{
+			"map(xs, fn(x) { let y = x * 2; y })",
+			"map(xs, fn(x) {\n\tlet y = x * 2;\n\ty\n});\n",
+		},
+		{
+			"let f = fn() { [aaaaaaaaaaaaaaaaaaaa, bbbbbbbbbbbbbbbbbbbbbbbbbb, cccccccccccccccccccccccccc, ddddddddddddddddd] }",
+			"let f = fn() {\n\t[\n\t\taaaaaaaaaaaaaaaaaaaa,\n\t\tbbbbbbbbbbbbbbbbbbbbbbbbbb,\n\t\tcccccccccccccccccccccccccc,\n\t\tddddddddddddddddd,\n\t]\n};\n",
+		},
+	}
+
+	for _, tt := range tests {
+		out, err := Source([]byte(tt.input))
+		if err != nil {
+			t.Errorf("format %q failed. error is: %q", tt.input, err)
+			continue
+		}
+		if string(out) != tt.expected {
+			t.Errorf("wrong format of %q.\nwant=%q\ngot=%q", tt.input, tt.expected, out)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// fib computes
+// fibonacci numbers
+let fib = fn(n) { // n >= 0
+	// the first two are themselves
+	if (n < 2) { return n; }   // base
+	fib(n - 1) + fib(n - 2) // recursion
+	// done
+}; // fib
+
+
+let xs = [
+	1, // one
+	2, // two
+];
+// end
+`
+	expected := `// fib computes
+// fibonacci numbers
+let fib = fn(n) {
+	// n >= 0
+	// the first two are themselves
+	if (n < 2) { return n; } // base
+	fib(n - 1) + fib(n - 2) // recursion
+	// done
+}; // fib
+
+let xs = [
+	1, // one
+	2, // two
+];
+// end
+`
+
+	out, err := Source([]byte(input))
+	if err != nil {
+		t.Fatalf("format failed. error is: %q", err)
+	}
+	if string(out) != expected {
+		t.Errorf("wrong format.\nwant=%q\ngot=%q", expected, out)
+	}
+}
+
+func TestListComments(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			"let h = {\n\t// the name\n\t\"name\": \"x\",\n\t\"size\": 1 // bytes\n};",
+			"let h = {\n\t// the name\n\t\"name\": \"x\",\n\t\"size\": 1, // bytes\n};\n",
+		},
+		{
+			"f(a, // first\n  b, c);",
+			"f(\n\ta, // first\n\tb,\n\tc,\n);\n",
+		},
+		{
+			"f(a,\n\t// then\n\tb);",
+			"f(\n\ta,\n\t// then\n\tb,\n);\n",
+		},
+		{
+			"let xs = [1, 2 // two\n// no more\n];",
+			"let xs = [\n\t1,\n\t2, // two\n\t// no more\n];\n",
+		},
+		{
+			"let xs = [\n\t[1, // one\n\t2],\n\t3\n];",
+			"let xs = [\n\t[\n\t\t1, // one\n\t\t2,\n\t],\n\t3,\n];\n",
+		},
+		{
+			"f(fn() {\n\t// inside\n\t1\n}, 2);",
+			"f(\n\tfn() {\n\t\t// inside\n\t\t1\n\t},\n\t2,\n);\n",
+		},
+	}
+
+	for _, tt := range tests {
+		out, err := Source([]byte(tt.input))
+		if err != nil {
+			t.Errorf("format %q failed. error is: %q", tt.input, err)
+			continue
+		}
+		if string(out) != tt.expected {
+			t.Errorf("wrong format of %q.\nwant=%q\ngot=%q", tt.input, tt.expected, out)
+		}
+	}
+}
+
+// programs is a corpus formatted by TestIdempotent and TestRoundTrip
+var programs = []string{
+	`let fib=fn(n){if(n<2){return n;}   fib(n-1)+fib(n-2)}; fib(10)`,
+	`let xs = [1,2,3, "a\"b\n", true, {"k":1,"v":[1,2]}]; xs[1:][0]`,
+	`puts(-(1+2)*3, -a*b, (-a)*b, a-(b-c), (a-b)-c, !x, x++, (a+b)[0], xs[1:], xs[:2], fn(x){x}(1))`,
+	"if (a) { b } else { c }\n(fn() { 1 })();",
+	`let f = fn g(x) { x }; let h = fn k(y) { k(y) }(1); fn named() { 1 }`,
+	`map(xs, fn(x) { let y = x * 2; y + 1 }); a = b = c; a = (b = c); x += 1; x <<= 2; y--`,
+	`let counter = fn() { let c = 0; fn() { c += 1; c } }; let next = counter(); next(); next()`,
+	`let deep = [[[[[[[[[[[[[[[[[[[[1]]]]]]]]]]]]]]]]]]]]; deep[0][0]`,
+	`a * -b * c; a + -b * c; -a[0]; !a[0]; -(a++); (!a)++; -f(1); (-f)(1)`,
+	`let big = {"aaaaaaaaaaaaaaaaa": [111111111111, 222222222222, 333333333333, 444444444444, 555555555555, 666666666666], "b": fn(x) { if (x) { return [1, 2, 3]; } else { return {"x": x, "y": [x, x, x, x, x, x, x, x, x, x, x, x, x, x, x, x, x, x]}; } }}`,
+	"// only a comment",
+	"let a = 1; // one\n// two\n\n// three\nlet b = 2;\n// four\n",
+	"fn() {\n\t// nothing\n}",
+	"let f = fn(x) {\n\tlet a = [\n\t\tx, // first\n\t\tx,\n\t];\n\n\t// result\n\ta\n};",
+	"if (a) {\n} else {\n\t// empty\n}\n-1",
+	"let h = {\n\t\"a\": 1, // one\n\t// then\n\t\"b\": [2, // two\n\t3],\n};\nf(h, // the hash\n\t1);",
+}
+
+func TestIdempotent(t *testing.T) {
+	for _, input := range programs {
+		once, err := Source([]byte(input))
+		if err != nil {
+			t.Errorf("format %q failed. error is: %q", input, err)
+			continue
+		}
+
+		twice, err := Source(once)
+		if err != nil {
+			t.Errorf("format %q failed. error is: %q", once, err)
+			continue
+		}
+		if string(twice) != string(once) {
+			t.Errorf("formatting %q again changes it.\nonce=%q\ntwice=%q", input, once, twice)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, input := range programs {
+		original, err := parser.New(input).ParseProgram()
+		if err != nil {
+			t.Fatalf("parse %q failed. error is: %q", input, err)
+		}
+
+		out, err := Source([]byte(input))
+		if err != nil {
+			t.Errorf("format %q failed. error is: %q", input, err)
+			continue
+		}
+
+		formatted, err := parser.New(string(out)).ParseProgram()
+		if err != nil {
+			t.Errorf("parse formatted %q failed. error is: %q", out, err)
+			continue
+		}
+
+		// String writes the structure of the tree, with the parentheses of each operator
+		if formatted.String() != original.String() {
+			t.Errorf("formatted %q parses to another tree.\nwant=%q\ngot=%q", input, original.String(), formatted.String())
+		}
+		if len(formatted.Comments) != len(original.Comments) {
+			t.Errorf("formatted %q lost comments. want=%d, got=%d", input, len(original.Comments), len(formatted.Comments))
+		}
+	}
+}
+
+func TestSourceError(t *testing.T) {
+	if _, err := Source([]byte("let = 1")); err == nil {
+		t.Errorf("expected an error for an invalid program")
+	}
+}
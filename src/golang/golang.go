@@ -0,0 +1,700 @@
+// Package golang translates gorilla programs into go source, which runs them with the
+// runtime of the native package. The go program gives the result of the vm
+package golang
+
+import (
+	"ast"
+	"bytes"
+	"compiler"
+	"fmt"
+	"go/format"
+	"object"
+	"sort"
+	"strconv"
+	"token"
+)
+
+// goOpCodes are the instructions of the vm the infix operators are run as
+var goOpCodes = map[string]string{
+	"+":  "code.OpAdd",
+	"-":  "code.OpSubtraction",
+	"*":  "code.OpMultiply",
+	"/":  "code.OpDivide",
+	"%":  "code.OpModulo",
+	"==": "code.OpEqual",
+	"!=": "code.OpNotEqual",
+	">":  "code.OpGreaterThan",
+	">=": "code.OpGreaterEqual",
+	"<":  "code.OpLessThan",
+	"<=": "code.OpLessEqual",
+}
+
+// goScope is the go function a gorilla function, or the main program, is translated into
+type goScope struct {
+	symbolTable *compiler.SymbolTable
+	body        bytes.Buffer
+	temps       int
+	// main is the scope of the main program, whose statements set the result of the program
+	main bool
+}
+
+// Translator translates a program into go source which runs it with the runtime of
+// the native package. It resolves names like compiler.Compiler and makes the same choices when
+// optimizing, so the go program gives the result of the vm: variables have the same
+// indexes, errors are reported at the same positions and calls in tail position are
+// made in place of their caller. The go program has no value stack, a deep recursion
+// fails with the limit of the call depth where the vm may run out of stack first
+type Translator struct {
+	scopes []*goScope
+
+	// functions are the go functions of the function expressions of the program
+	functions []*bytes.Buffer
+
+	constants     map[compiler.ConstantKey]string
+	constantDecls []string
+
+	positions map[token.Position]int
+	imports   map[string]bool
+
+	// position of the node being translated, errors of the go code for it are reported there
+	pos token.Position
+
+	optimize bool
+}
+
+func New() *Translator {
+	symbol := compiler.NewSymbolTable()
+
+	for i, v := range object.Builtins {
+		symbol.DefineBuiltin(i, v.Name)
+	}
+
+	mainScope := &goScope{symbolTable: symbol, main: true}
+	return &Translator{
+		scopes:    []*goScope{mainScope},
+		constants: map[compiler.ConstantKey]string{},
+		positions: map[token.Position]int{},
+		imports:   map[string]bool{"native": true, "object": true, "token": true},
+		optimize:  true,
+	}
+}
+
+// DisableOptimizations makes the translator translate the program as it is written,
+// without constant folding and dead code elimination, like compiler.Compiler does
+func (t *Translator) DisableOptimizations() {
+	t.optimize = false
+}
+
+// Translate translates program. It is checked with compiler.Compiler first, so a program
+// the vm can not run fails with the error of compiler.Compiler
+func (t *Translator) Translate(program *ast.Program) error {
+	check := compiler.New()
+	if !t.optimize {
+		check.DisableOptimizations()
+	}
+	if err := check.Compile(program); err != nil {
+		return err
+	}
+
+	for _, statement := range compiler.LiveStatements(program.Statements, t.optimize) {
+		value, err := t.statement(statement)
+		if err != nil {
+			return err
+		}
+
+		// the value of a statement of the main program is the result of the program so far
+		if value != "" {
+			t.emit("result = %s", value)
+		}
+	}
+	return nil
+}
+
+// Source returns the go source of the compiled program in package pkg. It declares
+// the program as Program, a package main also gets a main function which runs it
+func (t *Translator) Source(pkg string) ([]byte, error) {
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by gorilla build. DO NOT EDIT.\n\npackage %s\n\n", pkg)
+
+	imports := []string{}
+	for path := range t.imports {
+		imports = append(imports, strconv.Quote(path))
+	}
+	sort.Strings(imports)
+	fmt.Fprintf(&out, "import (\n%s\n)\n\n", joinLines(imports))
+
+	if pkg == "main" {
+		out.WriteString("func main() {\nnative.Main(Program)\n}\n\n")
+	}
+
+	main := t.scopes[0]
+	fmt.Fprintf(&out, "// g holds the global variables\nvar g [%d]object.Object\n\n", main.symbolTable.NumDefinitions())
+
+	positions := make([]string, len(t.positions))
+	for pos, i := range t.positions {
+		positions[i] = fmt.Sprintf("{Line: %d, Column: %d},", pos.Line, pos.Column)
+	}
+	fmt.Fprintf(&out, "// pos holds the positions errors are reported at\nvar pos = [...]token.Position{\n%s\n}\n\n", joinLines(positions))
+
+	if len(t.constantDecls) > 0 {
+		fmt.Fprintf(&out, "var (\n%s\n)\n\n", joinLines(t.constantDecls))
+	}
+
+	out.WriteString("func Program(rt *native.Runtime) object.Object {\nvar result object.Object\n")
+	out.Write(main.body.Bytes())
+	out.WriteString("return result\n}\n")
+
+	for _, fn := range t.functions {
+		out.WriteString("\n")
+		out.Write(fn.Bytes())
+	}
+
+	src, err := format.Source(out.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("format go source failed: %s", err)
+	}
+	return src, nil
+}
+
+func joinLines(lines []string) string {
+	var buffer bytes.Buffer
+	for i, line := range lines {
+		if i > 0 {
+			buffer.WriteString("\n")
+		}
+		buffer.WriteString(line)
+	}
+	return buffer.String()
+}
+
+func (t *Translator) scope() *goScope {
+	return t.scopes[len(t.scopes)-1]
+}
+
+// at makes node the node being translated, the returned function restores the former one
+func (t *Translator) at(node ast.Node) func() {
+	outer := t.pos
+	if pos := node.Pos(); pos.Line > 0 {
+		t.pos = pos
+	}
+	return func() { t.pos = outer }
+}
+
+func (t *Translator) emit(format string, args ...interface{}) {
+	fmt.Fprintf(&t.scope().body, format, args...)
+	t.scope().body.WriteString("\n")
+}
+
+// temp declares a new temporary variable holding value and returns it
+func (t *Translator) temp(format string, args ...interface{}) string {
+	scope := t.scope()
+	scope.temps++
+	name := fmt.Sprintf("t%d", scope.temps)
+	t.emit("%s := %s", name, fmt.Sprintf(format, args...))
+	return name
+}
+
+// position returns the go expression of the position of the node being translated
+func (t *Translator) position() string {
+	i, ok := t.positions[t.pos]
+	if !ok {
+		i = len(t.positions)
+		t.positions[t.pos] = i
+	}
+	return fmt.Sprintf("pos[%d]", i)
+}
+
+// constant returns the package variable holding obj, integers and strings are declared once
+func (t *Translator) constant(obj object.Object) string {
+	key, shared := compiler.ConstantKeyOf(obj)
+	if name, ok := t.constants[key]; ok && shared {
+		return name
+	}
+
+	name := fmt.Sprintf("c%d", len(t.constantDecls))
+	var value string
+	switch obj := obj.(type) {
+	case *object.Integer:
+		value = fmt.Sprintf("&object.Integer{Value: %d}", obj.Value)
+	case *object.BigInteger:
+		value = fmt.Sprintf("native.BigInteger(%q)", obj.Value.String())
+	case *object.String:
+		value = fmt.Sprintf("&object.String{Value: %s}", strconv.Quote(obj.Value))
+	}
+
+	t.constantDecls = append(t.constantDecls, fmt.Sprintf("%s = %s", name, value))
+	if shared {
+		t.constants[key] = name
+	}
+	return name
+}
+
+// folded returns the go expression of the folded value obj
+func (t *Translator) folded(obj object.Object) string {
+	switch obj {
+	case object.TRUE:
+		return "object.TRUE"
+	case object.FALSE:
+		return "object.FALSE"
+	case object.NULL:
+		return "object.NULL"
+	default:
+		return t.constant(obj)
+	}
+}
+
+// statement translates node and returns the go expression of its value, which is empty for a return statement
+func (t *Translator) statement(node ast.Statement) (string, error) {
+	defer t.at(node)()
+
+	switch node := node.(type) {
+	case *ast.LetStatement:
+		symbol := t.scope().symbolTable.Define(node.Name.Value)
+		value, err := t.expression(node.Value)
+		if err != nil {
+			return "", err
+		}
+
+		if symbol.Scope == compiler.GlobalScope {
+			t.emit("g[%d] = %s", symbol.Index, value)
+		} else {
+			t.emit("l[%d] = %s", symbol.Index, value)
+		}
+		return value, nil
+	case *ast.ExpressionStatement:
+		return t.expression(node.Value)
+	case *ast.ReturnStatement:
+		if node.Value == nil {
+			t.emit("return object.NULL")
+			return "", nil
+		}
+
+		if !t.scope().main {
+			return "", t.tail(node.Value)
+		}
+
+		// return at top level ends the program with its value
+		value, err := t.expression(node.Value)
+		if err != nil {
+			return "", err
+		}
+		t.emit("return %s", value)
+		return "", nil
+	default:
+		return "", fmt.Errorf("unknown node type %T", node)
+	}
+}
+
+// block translates the statements of block but the last one, which is returned
+func (t *Translator) block(block *ast.BlockExpression) (ast.Statement, error) {
+	statements := compiler.LiveStatements(block.Statements, t.optimize)
+	if len(statements) == 0 {
+		return nil, nil
+	}
+
+	for _, statement := range statements[:len(statements)-1] {
+		value, err := t.statement(statement)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := statement.(*ast.ExpressionStatement); ok {
+			t.emit("_ = %s", value)
+		}
+	}
+	return statements[len(statements)-1], nil
+}
+
+// blockValue translates block and returns the go expression of its value, which is
+// empty when the block ends with a return statement
+func (t *Translator) blockValue(block *ast.BlockExpression) (string, error) {
+	last, err := t.block(block)
+	if err != nil || last == nil {
+		return "object.NULL", err
+	}
+
+	value, err := t.statement(last)
+	if err != nil {
+		return "", err
+	}
+
+	if _, ok := last.(*ast.ReturnStatement); ok {
+		return "", nil
+	}
+	// a block ending with a let statement is evaluated to the value bound
+	return value, nil
+}
+
+// tailBlock translates block in tail position of a function, the function returns its value
+func (t *Translator) tailBlock(block *ast.BlockExpression) error {
+	last, err := t.block(block)
+	if err != nil {
+		return err
+	}
+
+	switch last := last.(type) {
+	case nil:
+		t.emit("return object.NULL")
+	case *ast.ExpressionStatement:
+		defer t.at(last)()
+		return t.tail(last.Value)
+	case *ast.ReturnStatement:
+		_, err := t.statement(last)
+		return err
+	default:
+		value, err := t.statement(last)
+		if err != nil {
+			return err
+		}
+		t.emit("return %s", value)
+	}
+	return nil
+}
+
+// tail translates node in tail position of a function, the function returns its value.
+// A call in tail position is made in place of the function
+func (t *Translator) tail(node ast.Expression) error {
+	defer t.at(node)()
+
+	switch node := node.(type) {
+	case *ast.CallExpression:
+		callee, args, err := t.call(node)
+		if err != nil {
+			return err
+		}
+		t.emit("return rt.TailCall(%s, %s%s)", callee, t.position(), args)
+		return nil
+	case *ast.IfExpression:
+		if condition, ok := compiler.ConstantValue(node.Condition); ok && t.optimize {
+			// only the branch taken is translated
+			body := node.ElseBody
+			if condition != object.FALSE && condition != object.NULL {
+				body = node.ThenBody
+			}
+
+			if body == nil {
+				t.emit("return object.NULL")
+				return nil
+			}
+			return t.tailBlock(body)
+		}
+
+		condition, err := t.expression(node.Condition)
+		if err != nil {
+			return err
+		}
+
+		t.emit("if native.Truethy(%s) {", condition)
+		if err := t.tailBlock(node.ThenBody); err != nil {
+			return err
+		}
+		t.emit("} else {")
+		if node.ElseBody == nil {
+			t.emit("return object.NULL")
+		} else if err := t.tailBlock(node.ElseBody); err != nil {
+			return err
+		}
+		t.emit("}")
+		return nil
+	default:
+		value, err := t.expression(node)
+		if err != nil {
+			return err
+		}
+		t.emit("return %s", value)
+		return nil
+	}
+}
+
+// expression translates node and returns the go expression of its value. The go
+// expression has no side effects, the ones of node are made by the statements emitted
+// for it, in the order the vm makes them
+func (t *Translator) expression(node ast.Expression) (string, error) {
+	defer t.at(node)()
+
+	switch node := node.(type) {
+	case *ast.Integer:
+		return t.constant(&object.Integer{Value: node.Value}), nil
+	case *ast.String:
+		return t.constant(&object.String{Value: node.Value}), nil
+	case *ast.Boolean:
+		return t.folded(object.NativeBooleanToBooleanObj(node.Value)), nil
+	case *ast.PrefixExpression:
+		if value, ok := compiler.ConstantValue(node); ok && t.optimize {
+			return t.folded(value), nil
+		}
+
+		value, err := t.expression(node.Value)
+		if err != nil {
+			return "", err
+		}
+
+		switch node.Operator {
+		case "-":
+			return t.temp("rt.Minus(%s, %s)", value, t.position()), nil
+		case "!":
+			return t.temp("rt.Bang(%s)", value), nil
+		default:
+			return "", fmt.Errorf("unsupported prefix operator %s", node.Operator)
+		}
+	case *ast.InfixExpression:
+		return t.infixExpression(node)
+	case *ast.SliceExpression:
+		left, err := t.expression(node.Left)
+		if err != nil {
+			return "", err
+		}
+
+		// an omitted bound is passed as null
+		bounds := []string{}
+		for _, bound := range []ast.Expression{node.Start, node.End} {
+			if bound == nil {
+				bounds = append(bounds, "object.NULL")
+				continue
+			}
+
+			value, err := t.expression(bound)
+			if err != nil {
+				return "", err
+			}
+			bounds = append(bounds, value)
+		}
+		return t.temp("rt.Slice(%s, %s, %s, %s)", left, bounds[0], bounds[1], t.position()), nil
+	case *ast.IfExpression:
+		return t.ifExpression(node)
+	case *ast.Identifier:
+		symbol, ok := t.scope().symbolTable.Resolve(node.Value)
+		if !ok {
+			return "", fmt.Errorf("undefined variable %s", node.Value)
+		}
+		return t.load(symbol), nil
+	case *ast.ArrayLiteral:
+		elems, err := t.expressions(node.Elements)
+		if err != nil {
+			return "", err
+		}
+		return t.temp("rt.Array(%s)", joinArgs(elems)), nil
+	case *ast.HashLiteral:
+		pairs := []string{}
+		for _, pair := range node.Pairs {
+			values, err := t.expressions([]ast.Expression{pair.Key, pair.Value})
+			if err != nil {
+				return "", err
+			}
+			pairs = append(pairs, values...)
+		}
+
+		args := ""
+		if len(pairs) > 0 {
+			args = ", " + joinArgs(pairs)
+		}
+		return t.temp("rt.Hash(%s%s)", t.position(), args), nil
+	case *ast.FunctionExpression:
+		return t.function(node)
+	case *ast.CallExpression:
+		callee, args, err := t.call(node)
+		if err != nil {
+			return "", err
+		}
+		return t.temp("rt.Call(%s, %s%s)", callee, t.position(), args), nil
+	default:
+		return "", fmt.Errorf("unknown node type %T", node)
+	}
+}
+
+func (t *Translator) expressions(nodes []ast.Expression) ([]string, error) {
+	values := []string{}
+	for _, node := range nodes {
+		value, err := t.expression(node)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+func joinArgs(args []string) string {
+	var buffer bytes.Buffer
+	for i, arg := range args {
+		if i > 0 {
+			buffer.WriteString(", ")
+		}
+		buffer.WriteString(arg)
+	}
+	return buffer.String()
+}
+
+func (t *Translator) infixExpression(node *ast.InfixExpression) (string, error) {
+	if value, ok := compiler.ConstantValue(node); ok && t.optimize {
+		return t.folded(value), nil
+	}
+
+	if node.Operator == "=" {
+		return t.assign(node)
+	}
+
+	values, err := t.expressions([]ast.Expression{node.Left, node.Right})
+	if err != nil {
+		return "", err
+	}
+
+	if node.Operator == "[" {
+		return t.temp("rt.Index(%s, %s, %s)", values[0], values[1], t.position()), nil
+	}
+
+	op, ok := goOpCodes[node.Operator]
+	if !ok {
+		return "", fmt.Errorf("unknown operator %s", node.Operator)
+	}
+	t.imports["code"] = true
+
+	return t.temp("rt.Binary(%s, %s, %s, %s)", op, values[0], values[1], t.position()), nil
+}
+
+// assign sets the variable on the left of node to the value on the right, which is the
+// value of the assignment
+func (t *Translator) assign(node *ast.InfixExpression) (string, error) {
+	ident, ok := node.Left.(*ast.Identifier)
+	if !ok {
+		return "", fmt.Errorf("can not assign to %s", node.Left.String())
+	}
+
+	value, err := t.expression(node.Right)
+	if err != nil {
+		return "", err
+	}
+
+	symbol, err := t.scope().symbolTable.Assign(ident.Value, ident.Pos())
+	if err != nil {
+		return "", err
+	}
+
+	if symbol.Scope == compiler.GlobalScope {
+		t.emit("g[%d] = %s", symbol.Index, value)
+	} else {
+		t.emit("l[%d] = %s", symbol.Index, value)
+	}
+	return value, nil
+}
+
+func (t *Translator) ifExpression(node *ast.IfExpression) (string, error) {
+	if condition, ok := compiler.ConstantValue(node.Condition); ok && t.optimize {
+		// only the branch taken is translated
+		body := node.ElseBody
+		if condition != object.FALSE && condition != object.NULL {
+			body = node.ThenBody
+		}
+
+		if body == nil {
+			return "object.NULL", nil
+		}
+
+		value, err := t.blockValue(body)
+		if err != nil || value != "" {
+			return value, err
+		}
+		// the block returned, its value is never used
+		return "object.NULL", nil
+	}
+
+	condition, err := t.expression(node.Condition)
+	if err != nil {
+		return "", err
+	}
+
+	scope := t.scope()
+	scope.temps++
+	result := fmt.Sprintf("t%d", scope.temps)
+	t.emit("var %s object.Object", result)
+
+	t.emit("if native.Truethy(%s) {", condition)
+	if err := t.branch(result, node.ThenBody); err != nil {
+		return "", err
+	}
+	t.emit("} else {")
+	if node.ElseBody == nil {
+		t.emit("%s = object.NULL", result)
+	} else if err := t.branch(result, node.ElseBody); err != nil {
+		return "", err
+	}
+	t.emit("}")
+	return result, nil
+}
+
+// branch translates the body of a branch of an if expression, which sets result to its value
+func (t *Translator) branch(result string, body *ast.BlockExpression) error {
+	value, err := t.blockValue(body)
+	if err != nil {
+		return err
+	}
+
+	if value != "" {
+		t.emit("%s = %s", result, value)
+	}
+	return nil
+}
+
+func (t *Translator) call(node *ast.CallExpression) (string, string, error) {
+	values, err := t.expressions(append([]ast.Expression{node.Function}, node.Arguments...))
+	if err != nil {
+		return "", "", err
+	}
+
+	args := ""
+	if len(values) > 1 {
+		args = ", " + joinArgs(values[1:])
+	}
+	return values[0], args, nil
+}
+
+// load returns the go expression of the value of the variable symbol
+func (t *Translator) load(symbol compiler.Symbol) string {
+	switch symbol.Scope {
+	case compiler.GlobalScope:
+		return t.temp("rt.Global(g[%d], %d, %s)", symbol.Index, symbol.Index, t.position())
+	case compiler.LocalScope:
+		return t.temp("rt.Local(l[%d], %d, %s)", symbol.Index, symbol.Index, t.position())
+	case compiler.FreeScope:
+		return fmt.Sprintf("self.Free[%d]", symbol.Index)
+	case compiler.BuiltinScope:
+		return fmt.Sprintf("object.FindBuiltinByIndex(%d)", symbol.Index)
+	default:
+		return "self"
+	}
+}
+
+// function translates node into a go function and returns the closure of it
+func (t *Translator) function(node *ast.FunctionExpression) (string, error) {
+	fn := &bytes.Buffer{}
+	name := fmt.Sprintf("fn%d", len(t.functions))
+	t.functions = append(t.functions, fn)
+
+	scope := &goScope{symbolTable: compiler.NewEnclosedSymbolTable(t.scope().symbolTable)}
+	t.scopes = append(t.scopes, scope)
+
+	if node.Name != nil {
+		scope.symbolTable.DefineFunctionName(node.Name.Value)
+	}
+
+	for _, parameter := range node.Parameters {
+		scope.symbolTable.Define(parameter.Value)
+	}
+
+	if err := t.tailBlock(node.Body); err != nil {
+		return "", err
+	}
+	t.scopes = t.scopes[:len(t.scopes)-1]
+
+	fmt.Fprintf(fn, "func %s(rt *native.Runtime, self *native.Closure, args []object.Object) object.Object {\n", name)
+	fmt.Fprintf(fn, "var l [%d]object.Object\ncopy(l[:], args)\n", scope.symbolTable.NumDefinitions())
+	fn.Write(scope.body.Bytes())
+	fn.WriteString("}\n")
+
+	// free variables are loaded when the closure is made
+	frees := []string{}
+	for _, s := range scope.symbolTable.FreeSymbols {
+		frees = append(frees, t.load(s))
+	}
+	return t.temp("&native.Closure{Fn: %s, NumParameters: %d, Free: []object.Object{%s}}", name, len(node.Parameters), joinArgs(frees)), nil
+}
@@ -0,0 +1,139 @@
+package golang
+
+import (
+	"ast"
+	"compiler"
+	goparser "go/parser"
+	gotoken "go/token"
+	"parser"
+	"strings"
+	"testing"
+)
+
+func parse(input string) (*ast.Program, error) {
+	return parser.New(input).ParseProgram()
+}
+
+func translate(t *testing.T, input string, optimize bool) string {
+	t.Helper()
+
+	program, err := parse(input)
+	if err != nil {
+		t.Fatalf("parse input %s failed %s", input, err)
+	}
+
+	tr := New()
+	if !optimize {
+		tr.DisableOptimizations()
+	}
+	if err := tr.Translate(program); err != nil {
+		t.Fatalf("translate input %s failed %s", input, err)
+	}
+
+	src, err := tr.Source("main")
+	if err != nil {
+		t.Fatalf("translate input %s failed %s", input, err)
+	}
+
+	if _, err := goparser.ParseFile(gotoken.NewFileSet(), "program.go", src, 0); err != nil {
+		t.Fatalf("parse go source of input %s failed %s\n%s", input, err, src)
+	}
+	return string(src)
+}
+
+func TestTranslate(t *testing.T) {
+	tests := []struct {
+		input    string
+		optimize bool
+		// contains are lines the go source must have, without their indentation
+		contains []string
+		// excludes are lines the go source must not have
+		excludes []string
+	}{
+		{
+			input:    "1 + 2",
+			optimize: true,
+			contains: []string{"c0 = &object.Integer{Value: 3}", "result = c0"},
+			excludes: []string{`"code"`},
+		},
+		{
+			input:    "1 + 2",
+			contains: []string{"t1 := rt.Binary(code.OpAdd, c0, c1, pos[0])", "result = t1"},
+		},
+		{
+			input:    "let a = 1; a < 2",
+			contains: []string{"t1 := rt.Global(g[0], 0, pos[0])", "t2 := rt.Binary(code.OpLessThan, t1, c1, pos[1])"},
+		},
+		{
+			input:    "let f = fn(n) { f(n - 1) }",
+			optimize: true,
+			contains: []string{"return rt.TailCall(self, pos[2], t2)"},
+		},
+		{
+			// the call is not in tail position, its result is added to
+			input:    "let f = fn(n) { f(n) + 1 }",
+			optimize: true,
+			contains: []string{"t2 := rt.Call(self, pos[1], t1)"},
+			excludes: []string{"rt.TailCall"},
+		},
+		{
+			input:    "let f = fn(a) { fn() { a } }",
+			optimize: true,
+			contains: []string{"t2 := &native.Closure{Fn: fn1, NumParameters: 0, Free: []object.Object{t1}}", "return self.Free[0]"},
+		},
+		{
+			input:    "if (true) { 1 } else { x }",
+			optimize: true,
+			contains: []string{"result = c0"},
+			excludes: []string{"native.Truethy"},
+		},
+		{
+			input:    "let a = 1; return a; a + 1",
+			optimize: true,
+			contains: []string{"return t1"},
+			excludes: []string{"code.OpAdd"},
+		},
+	}
+
+	for _, test := range tests {
+		src := translate(t, test.input, test.optimize)
+
+		lines := map[string]bool{}
+		for _, line := range strings.Split(src, "\n") {
+			lines[strings.TrimSpace(line)] = true
+		}
+
+		for _, line := range test.contains {
+			if !lines[line] {
+				t.Errorf("expect line %q in go source for input: %s. got\n%s", line, test.input, src)
+			}
+		}
+		for _, line := range test.excludes {
+			if strings.Contains(src, line) {
+				t.Errorf("expect no %q in go source for input: %s. got\n%s", line, test.input, src)
+			}
+		}
+	}
+}
+
+func TestTranslateErrors(t *testing.T) {
+	tests := []string{
+		"a",
+		"let f = fn() { b }",
+		"let f = fn() { let a = 1; fn() { a = 2 } }",
+		"[1] = 2",
+	}
+
+	for _, input := range tests {
+		program, err := parse(input)
+		if err != nil {
+			t.Fatalf("parse input %s failed %s", input, err)
+		}
+
+		expect := compiler.New().Compile(program)
+		actual := New().Translate(program)
+		if expect == nil || actual == nil || actual.Error() != expect.Error() {
+			t.Errorf("expect the error of compiler.Compiler for input: %s. want=%v, got=%v", input, expect, actual)
+		}
+	}
+}
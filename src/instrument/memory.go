@@ -0,0 +1,125 @@
+package instrument
+
+import (
+	"fmt"
+	"io"
+	"object"
+	"sort"
+	"token"
+)
+
+// MemProfiler counts the objects a vm allocates, by their type and by the site of the
+// instruction allocating them. Unboxed integers, booleans and null are not allocated.
+// A vm counts the objects of its runs into the MemProfiler set by its SetMemProfiler
+type MemProfiler struct {
+	Types map[object.ObjectType]*Allocations
+	Sites map[Site]*Allocations
+
+	// Input tells the sites of the programs run one after another apart, like the
+	// inputs of the repl, it is the Input of the sites recorded
+	Input int
+}
+
+// Site is where objects are allocated: the position of the instruction in the function
+// named Function of the program Input
+type Site struct {
+	Input    int
+	Function string
+	Pos      token.Position
+}
+
+// Allocations counts objects and their estimated size, see object.SizeOf
+type Allocations struct {
+	Count int64
+	Bytes int64
+}
+
+// Add counts obj
+func (a *Allocations) Add(obj object.Object) {
+	a.Count++
+	a.Bytes += object.SizeOf(obj)
+}
+
+func NewMemProfiler() *MemProfiler {
+	return &MemProfiler{
+		Types: map[object.ObjectType]*Allocations{},
+		Sites: map[Site]*Allocations{},
+	}
+}
+
+// Record counts obj allocated by the instruction at pos of the function named function
+func (m *MemProfiler) Record(obj object.Object, function string, pos token.Position) {
+	a, ok := m.Types[obj.Type()]
+	if !ok {
+		a = &Allocations{}
+		m.Types[obj.Type()] = a
+	}
+	a.Add(obj)
+
+	site := Site{Input: m.Input, Function: function, Pos: pos}
+	a, ok = m.Sites[site]
+	if !ok {
+		a = &Allocations{}
+		m.Sites[site] = a
+	}
+	a.Add(obj)
+}
+
+// maxMemSites is the number of allocation sites WriteReport shows
+const maxMemSites = 10
+
+// WriteReport writes the allocations by type and the sites which allocated the most bytes
+func (m *MemProfiler) WriteReport(w io.Writer) {
+	fmt.Fprintf(w, "allocations:\n")
+	WriteAllocations(w, m.Types)
+
+	sites := []Site{}
+	for site := range m.Sites {
+		sites = append(sites, site)
+	}
+	sort.Slice(sites, func(i, j int) bool {
+		a, b := sites[i], sites[j]
+		if m.Sites[a].Bytes != m.Sites[b].Bytes {
+			return m.Sites[a].Bytes > m.Sites[b].Bytes
+		}
+		if a.Input != b.Input {
+			return a.Input < b.Input
+		}
+		if a.Pos.Line != b.Pos.Line {
+			return a.Pos.Line < b.Pos.Line
+		}
+		if a.Pos.Column != b.Pos.Column {
+			return a.Pos.Column < b.Pos.Column
+		}
+		return a.Function < b.Function
+	})
+
+	fmt.Fprintf(w, "\nallocation sites:\n%10s %12s  %s\n", "count", "bytes", "site")
+	for i, site := range sites {
+		if i == maxMemSites {
+			break
+		}
+
+		input := ""
+		if site.Input > 0 {
+			input = fmt.Sprintf("input %d, ", site.Input)
+		}
+		fmt.Fprintf(w, "%10d %12d  %s%s at line: %d, column: %d\n", m.Sites[site].Count, m.Sites[site].Bytes,
+			input, site.Function, site.Pos.Line, site.Pos.Column)
+	}
+}
+
+// WriteAllocations writes types as a table of the count and the bytes of each type
+func WriteAllocations(w io.Writer, types map[object.ObjectType]*Allocations) {
+	names := []string{}
+	for t := range types {
+		names = append(names, string(t))
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(w, "%10s %12s  %s\n", "count", "bytes", "type")
+	for _, name := range names {
+		a := types[object.ObjectType(name)]
+		fmt.Fprintf(w, "%10d %12d  %s\n", a.Count, a.Bytes, name)
+	}
+}
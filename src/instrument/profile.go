@@ -0,0 +1,307 @@
+package instrument
+
+import (
+	"code"
+	"fmt"
+	"io"
+	"object"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Profiler collects statistics while a vm runs: the calls of each function with the time
+// spent in them, how often each instruction is run and the time spent running it, and the
+// same for each source line. The time between two instructions is accounted to the first
+// of them, so the time of a builtin is accounted to the instruction calling it. A vm
+// profiles its runs into the Profiler set by its SetProfiler, which is also the Hooks
+// telling it about the calls
+type Profiler struct {
+	object.NopHooks
+
+	// Functions are the profiles of the functions by their names
+	Functions map[string]*FunctionProfile
+	OpCodes   map[code.OpCode]*Counter
+	Lines     map[int]*Counter
+
+	Instructions int64
+	Total        time.Duration
+
+	stack []profileFrame
+	start time.Time
+	// stacks are the stacks of calls starting with each function, see WriteFolded
+	stacks map[*FunctionProfile]*callStack
+
+	// last is the instruction run last, it is accounted the time until the next one
+	last     time.Time
+	lastOp   code.OpCode
+	lastLine int
+}
+
+// FunctionProfile is the profile of a function. Inclusive is the time from calling the
+// function until it returns, Exclusive leaves out the time of the functions it calls
+type FunctionProfile struct {
+	Name      string
+	Calls     int64
+	Inclusive time.Duration
+	Exclusive time.Duration
+
+	// active is the number of the calls of the function on the stack, the time of the
+	// inner ones is part of the inclusive time of the outermost one
+	active int
+}
+
+// Counter counts how often something is run and the time spent running it
+type Counter struct {
+	Count int64
+	Time  time.Duration
+}
+
+type profileFrame struct {
+	fn    *FunctionProfile
+	start time.Time
+	// stack is the stack of calls up to and including this one
+	stack *callStack
+}
+
+// callStack is a stack of calls with the time spent in it. The stacks made by calls from
+// it are its children, so the key of each stack is built once, from the key of its parent
+type callStack struct {
+	// key is the names of the functions of the stack joined by ;
+	key      string
+	time     time.Duration
+	children map[*FunctionProfile]*callStack
+}
+
+// child returns the stack of a call of fn among children, the stacks of the calls made
+// from the stack keyed parent. It is made on the first such call
+func child(children map[*FunctionProfile]*callStack, fn *FunctionProfile, parent string) *callStack {
+	s, ok := children[fn]
+	if !ok {
+		key := fn.Name
+		if parent != "" {
+			key = parent + ";" + fn.Name
+		}
+		s = &callStack{key: key, children: map[*FunctionProfile]*callStack{}}
+		children[fn] = s
+	}
+	return s
+}
+
+func NewProfiler() *Profiler {
+	return &Profiler{
+		Functions: map[string]*FunctionProfile{},
+		OpCodes:   map[code.OpCode]*Counter{},
+		Lines:     map[int]*Counter{},
+		stacks:    map[*FunctionProfile]*callStack{},
+	}
+}
+
+func (p *Profiler) function(name string) *FunctionProfile {
+	profile, ok := p.Functions[name]
+	if !ok {
+		profile = &FunctionProfile{Name: name}
+		p.Functions[name] = profile
+	}
+	return profile
+}
+
+// OnStart starts profiling a run of the main function
+func (p *Profiler) OnStart() {
+	p.start = time.Now()
+	p.last = p.start
+	p.lastOp, p.lastLine = 0, 0
+	p.enterAt("main", p.start)
+}
+
+// OnEnd stops profiling a run, the calls not returned yet are finished by it
+func (p *Profiler) OnEnd(result object.Object) {
+	now := time.Now()
+	for len(p.stack) > 0 {
+		p.leaveAt(now)
+	}
+	p.Total += now.Sub(p.start)
+}
+
+func (p *Profiler) OnError(err error) {
+	p.OnEnd(nil)
+}
+
+// account accounts the time until now to the instruction run last
+func (p *Profiler) account(now time.Time) {
+	elapsed := now.Sub(p.last)
+	p.last = now
+	if p.lastOp == 0 && p.lastLine == 0 || len(p.stack) == 0 {
+		return
+	}
+
+	p.opCounter(p.lastOp).Time += elapsed
+	if p.lastLine > 0 {
+		p.lineCounter(p.lastLine).Time += elapsed
+	}
+
+	top := &p.stack[len(p.stack)-1]
+	top.fn.Exclusive += elapsed
+	top.stack.time += elapsed
+}
+
+func (p *Profiler) opCounter(op code.OpCode) *Counter {
+	c, ok := p.OpCodes[op]
+	if !ok {
+		c = &Counter{}
+		p.OpCodes[op] = c
+	}
+	return c
+}
+
+func (p *Profiler) lineCounter(line int) *Counter {
+	c, ok := p.Lines[line]
+	if !ok {
+		c = &Counter{}
+		p.Lines[line] = c
+	}
+	return c
+}
+
+// Instruction accounts the instruction op which is about to run, line is its source
+// line or 0 if it has none
+func (p *Profiler) Instruction(op code.OpCode, line int) {
+	p.account(time.Now())
+
+	p.Instructions++
+	p.opCounter(op).Count++
+	p.lastOp, p.lastLine = op, 0
+	if line > 0 {
+		p.lineCounter(line).Count++
+		p.lastLine = line
+	}
+}
+
+// OnCall starts a call of the function name
+func (p *Profiler) OnCall(name string, args []object.Object) error {
+	p.enterAt(name, time.Now())
+	return nil
+}
+
+func (p *Profiler) enterAt(name string, now time.Time) {
+	p.account(now)
+
+	profile := p.function(name)
+	profile.Calls++
+	profile.active++
+
+	var stack *callStack
+	if len(p.stack) > 0 {
+		parent := p.stack[len(p.stack)-1].stack
+		stack = child(parent.children, profile, parent.key)
+	} else {
+		stack = child(p.stacks, profile, "")
+	}
+	p.stack = append(p.stack, profileFrame{fn: profile, start: now, stack: stack})
+}
+
+// OnReturn finishes the call made last
+func (p *Profiler) OnReturn(name string, result object.Object) {
+	p.leaveAt(time.Now())
+}
+
+func (p *Profiler) leaveAt(now time.Time) {
+	p.account(now)
+
+	top := p.stack[len(p.stack)-1]
+	p.stack = p.stack[:len(p.stack)-1]
+	top.fn.active--
+	if top.fn.active == 0 {
+		top.fn.Inclusive += now.Sub(top.start)
+	}
+}
+
+// WriteReport writes the statistics as text. The hottest source lines are shown with
+// their text when source is the source of the program
+func (p *Profiler) WriteReport(w io.Writer, source string) {
+	fmt.Fprintf(w, "total time: %s, %d instructions\n", p.Total, p.Instructions)
+
+	functions := []*FunctionProfile{}
+	for _, fn := range p.Functions {
+		functions = append(functions, fn)
+	}
+	sort.Slice(functions, func(i, j int) bool {
+		if functions[i].Exclusive != functions[j].Exclusive {
+			return functions[i].Exclusive > functions[j].Exclusive
+		}
+		return functions[i].Name < functions[j].Name
+	})
+
+	fmt.Fprintf(w, "\nfunctions:\n%10s %14s %14s  %s\n", "calls", "inclusive", "exclusive", "function")
+	for _, fn := range functions {
+		fmt.Fprintf(w, "%10d %14s %14s  %s\n", fn.Calls, fn.Inclusive, fn.Exclusive, fn.Name)
+	}
+
+	ops := []code.OpCode{}
+	for op := range p.OpCodes {
+		ops = append(ops, op)
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		if p.OpCodes[ops[i]].Count != p.OpCodes[ops[j]].Count {
+			return p.OpCodes[ops[i]].Count > p.OpCodes[ops[j]].Count
+		}
+		return ops[i] < ops[j]
+	})
+
+	fmt.Fprintf(w, "\nopcodes:\n%10s %14s  %s\n", "count", "time", "opcode")
+	for _, op := range ops {
+		name := fmt.Sprintf("%d", op)
+		if def, err := code.Lookup(op); err == nil {
+			name = def.Name
+		}
+		fmt.Fprintf(w, "%10d %14s  %s\n", p.OpCodes[op].Count, p.OpCodes[op].Time, name)
+	}
+
+	lines := []int{}
+	for line := range p.Lines {
+		lines = append(lines, line)
+	}
+	sort.Slice(lines, func(i, j int) bool {
+		if p.Lines[lines[i]].Time != p.Lines[lines[j]].Time {
+			return p.Lines[lines[i]].Time > p.Lines[lines[j]].Time
+		}
+		return lines[i] < lines[j]
+	})
+
+	sourceLines := strings.Split(source, "\n")
+	fmt.Fprintf(w, "\nhot lines:\n%10s %14s  %s\n", "count", "time", "line")
+	for i, line := range lines {
+		if i == maxHotLines {
+			break
+		}
+
+		text := ""
+		if source != "" && line <= len(sourceLines) {
+			text = "  " + strings.TrimSpace(sourceLines[line-1])
+		}
+		fmt.Fprintf(w, "%10d %14s  %d%s\n", p.Lines[line].Count, p.Lines[line].Time, line, text)
+	}
+}
+
+// maxHotLines is the number of source lines WriteReport shows
+const maxHotLines = 20
+
+// WriteFolded writes the stacks of calls in the folded format of flame graph tools, a
+// line for each stack with the names of the functions joined by ; and the time spent
+// in the stack in microseconds
+func (p *Profiler) WriteFolded(w io.Writer) {
+	stacks := []*callStack{}
+	var collect func(children map[*FunctionProfile]*callStack)
+	collect = func(children map[*FunctionProfile]*callStack) {
+		for _, s := range children {
+			stacks = append(stacks, s)
+			collect(s.children)
+		}
+	}
+	collect(p.stacks)
+	sort.Slice(stacks, func(i, j int) bool { return stacks[i].key < stacks[j].key })
+
+	for _, s := range stacks {
+		fmt.Fprintf(w, "%s %d\n", s.key, s.time.Microseconds())
+	}
+}
@@ -0,0 +1,231 @@
+// Package instrument observes programs while they run: the Tracer writes the calls of a
+// run as a trace, the Profiler measures where a vm spends its time and the MemProfiler
+// counts the objects a vm allocates
+package instrument
+
+import (
+	"encoding/json"
+	"io"
+	"object"
+	"strings"
+	"time"
+)
+
+// Tracer writes the calls of a program as the trace events of the Chrome trace viewer,
+// a span for each call of a function or a builtin. It is the Hooks a run is traced with,
+// see object.MultiHooks to combine it with others. A Tracer is not safe for concurrent use, a run traces into it while it runs
+type Tracer struct {
+	object.NopHooks
+
+	w     io.Writer
+	start time.Time
+	err   error
+
+	// depth is the number of spans begun and not ended yet, runs are the depths the runs
+	// traced into t started at, the innermost last
+	depth  int
+	runs   []int
+	events int
+}
+
+// traceEvent is an event in the JSON format of the Chrome trace viewer, ts is in microseconds
+type traceEvent struct {
+	Name string            `json:"name,omitempty"`
+	Cat  string            `json:"cat,omitempty"`
+	Ph   string            `json:"ph"`
+	Ts   float64           `json:"ts"`
+	Pid  int               `json:"pid"`
+	Tid  int               `json:"tid"`
+	Args map[string]string `json:"args,omitempty"`
+}
+
+// Categories of the spans of a Tracer
+const (
+	TraceFunction = "function"
+	TraceBuiltin  = "builtin"
+)
+
+// maxTraceArgument is the length an argument is cut to in the summary of the arguments of a span
+const maxTraceArgument = 40
+
+// NewTracer makes a Tracer writing to w. The trace is a JSON array of events, which is
+// complete once Close is called
+func NewTracer(w io.Writer) *Tracer {
+	return &Tracer{w: w, start: time.Now()}
+}
+
+// OnStart begins the span of the main program
+func (t *Tracer) OnStart() {
+	t.runs = append(t.runs, t.depth)
+	t.begin("main", TraceFunction, nil)
+}
+
+// OnEnd ends the spans of the run, the calls it did not return from when it failed
+func (t *Tracer) OnEnd(result object.Object) {
+	if len(t.runs) == 0 {
+		return
+	}
+	t.endTo(t.runs[len(t.runs)-1])
+	t.runs = t.runs[:len(t.runs)-1]
+}
+
+func (t *Tracer) OnError(err error) {
+	t.OnEnd(nil)
+}
+
+func (t *Tracer) OnCall(name string, args []object.Object) error {
+	t.begin(name, TraceFunction, args)
+	return nil
+}
+
+func (t *Tracer) OnReturn(name string, result object.Object) {
+	t.end()
+}
+
+func (t *Tracer) OnBuiltinCall(name string, args []object.Object) error {
+	t.begin(name, TraceBuiltin, args)
+	return nil
+}
+
+func (t *Tracer) OnBuiltinReturn(name string, result object.Object) {
+	t.end()
+}
+
+// begin begins a span of a call of name with args, which ends with the next end
+func (t *Tracer) begin(name string, category string, args []object.Object) {
+	t.depth++
+	t.write(traceEvent{Name: name, Cat: category, Ph: "B", Args: map[string]string{"args": summarize(args)}})
+}
+
+// end ends the span begun last
+func (t *Tracer) end() {
+	if t.depth == 0 {
+		return
+	}
+	t.depth--
+	t.write(traceEvent{Ph: "E"})
+}
+
+// Depth is the number of spans begun and not ended yet
+func (t *Tracer) Depth() int {
+	return t.depth
+}
+
+// endTo ends the spans begun after there were depth of them
+func (t *Tracer) endTo(depth int) {
+	for t.depth > depth {
+		t.end()
+	}
+}
+
+// Close ends the open spans and completes the trace. It returns the first error writing it
+func (t *Tracer) Close() error {
+	t.endTo(0)
+	t.runs = nil
+	if t.err == nil {
+		if t.events == 0 {
+			_, t.err = io.WriteString(t.w, "[")
+		}
+		if t.err == nil {
+			_, t.err = io.WriteString(t.w, "\n]\n")
+		}
+	}
+	return t.err
+}
+
+func (t *Tracer) write(event traceEvent) {
+	if t.err != nil {
+		return
+	}
+
+	event.Ts = float64(time.Since(t.start).Nanoseconds()) / 1000
+	event.Pid, event.Tid = 1, 1
+	data, err := json.Marshal(event)
+	if err != nil {
+		t.err = err
+		return
+	}
+
+	sep := ",\n"
+	if t.events == 0 {
+		sep = "[\n"
+	}
+	t.events++
+	_, t.err = io.WriteString(t.w, sep+string(data))
+}
+
+// summarize summarizes args, each of them is cut to maxTraceArgument. An argument is
+// written only up to the cut, so a call costs the same with small and large arguments
+func summarize(args []object.Object) string {
+	var b strings.Builder
+	for i, arg := range args {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+
+		w := summaryWriter{b: &b}
+		w.object(arg)
+		if w.full {
+			b.WriteString("...")
+		}
+	}
+	return b.String()
+}
+
+// summaryWriter writes an object like its Inspect, up to maxTraceArgument runes
+type summaryWriter struct {
+	b *strings.Builder
+	n int
+	// full is set once there is more than maxTraceArgument runes to write
+	full bool
+}
+
+func (w *summaryWriter) write(s string) {
+	if w.full {
+		return
+	}
+	for _, r := range s {
+		if w.n == maxTraceArgument {
+			w.full = true
+			return
+		}
+		w.b.WriteRune(r)
+		w.n++
+	}
+}
+
+// object writes obj, the elements of arrays and hashes are written one by one until w is full
+func (w *summaryWriter) object(obj object.Object) {
+	switch obj := obj.(type) {
+	case *object.String:
+		w.write(obj.Value)
+	case *object.Array:
+		w.write("[")
+		for i, elem := range obj.Elements {
+			if w.full {
+				return
+			}
+			if i > 0 {
+				w.write(", ")
+			}
+			w.object(elem)
+		}
+		w.write("]")
+	case *object.HashTable:
+		w.write("{")
+		for i, pair := range obj.Pairs() {
+			if w.full {
+				return
+			}
+			if i > 0 {
+				w.write(", ")
+			}
+			w.object(pair.Key)
+			w.write(":")
+			w.object(pair.Value)
+		}
+		w.write("}")
+	default:
+		w.write(obj.Inspect())
+	}
+}
@@ -0,0 +1,123 @@
+package instrument
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"object"
+	"strings"
+	"testing"
+)
+
+func TestTracer(t *testing.T) {
+	var buf bytes.Buffer
+	tracer := NewTracer(&buf)
+
+	long := &object.String{Value: strings.Repeat("a", 100)}
+	tracer.OnStart()
+	tracer.OnCall("f", []object.Object{&object.Integer{Value: 1}, object.TRUE, long})
+	tracer.OnBuiltinCall("len", []object.Object{long})
+	tracer.OnBuiltinReturn("len", &object.Integer{Value: 100})
+	if tracer.Depth() != 2 {
+		t.Errorf("wrong depth. want=2, got=%d", tracer.Depth())
+	}
+	// a failed run leaves its spans open, Close ends them
+	if err := tracer.Close(); err != nil {
+		t.Fatalf("close failed. error is: %q", err)
+	}
+
+	events := []traceEvent{}
+	if err := json.Unmarshal(buf.Bytes(), &events); err != nil {
+		t.Fatalf("trace is not a JSON array of events. error is: %q, trace is %q", err, buf.String())
+	}
+
+	expected := []struct {
+		ph   string
+		name string
+		cat  string
+		args string
+	}{
+		{"B", "main", TraceFunction, ""},
+		{"B", "f", TraceFunction, "1, true, " + strings.Repeat("a", maxTraceArgument) + "..."},
+		{"B", "len", TraceBuiltin, strings.Repeat("a", maxTraceArgument) + "..."},
+		{"E", "", "", ""},
+		{"E", "", "", ""},
+		{"E", "", "", ""},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("wrong number of events. want=%d, got=%d", len(expected), len(events))
+	}
+
+	for i, tt := range expected {
+		event := events[i]
+		if event.Ph != tt.ph || event.Name != tt.name || event.Cat != tt.cat || event.Args["args"] != tt.args {
+			t.Errorf("wrong event %d. want=%+v, got=%+v", i, tt, event)
+		}
+		if i > 0 && event.Ts < events[i-1].Ts {
+			t.Errorf("event %d is before event %d", i, i-1)
+		}
+	}
+}
+
+func TestTracerEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	if err := NewTracer(&buf).Close(); err != nil {
+		t.Fatalf("close failed. error is: %q", err)
+	}
+
+	events := []traceEvent{}
+	if err := json.Unmarshal(buf.Bytes(), &events); err != nil || len(events) != 0 {
+		t.Errorf("wrong empty trace %q", buf.String())
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestTracerWriteError(t *testing.T) {
+	tracer := NewTracer(failingWriter{})
+	tracer.OnStart()
+	tracer.OnEnd(object.NULL)
+
+	if err := tracer.Close(); err == nil || err.Error() != "disk full" {
+		t.Errorf("wrong error. want=%q, got=%v", "disk full", err)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	hash := object.NewHashTable()
+	for _, pair := range []object.HashPair{
+		{Key: &object.String{Value: "a"}, Value: &object.Array{Elements: []object.Object{&object.Integer{Value: 1}}}},
+		{Key: &object.Integer{Value: 2}, Value: object.TRUE},
+	} {
+		hash.Set(pair.Key.(object.Hashable).Hash(object.HashSeed{}), pair.Key, pair.Value)
+	}
+
+	many := &object.Array{}
+	for i := 0; i < 100000; i++ {
+		many.Elements = append(many.Elements, &object.Integer{Value: int64(i)})
+	}
+
+	tests := []struct {
+		args     []object.Object
+		expected string
+	}{
+		{[]object.Object{}, ""},
+		{[]object.Object{&object.Integer{Value: 1}, object.NULL}, "1, null"},
+		// short arguments are written like Inspect writes them
+		{[]object.Object{hash}, hash.Inspect()},
+		{[]object.Object{&object.Array{Elements: []object.Object{hash, &object.String{Value: "b"}}}}, "[" + hash.Inspect() + ", b]"},
+		{[]object.Object{&object.String{Value: strings.Repeat("é", maxTraceArgument)}}, strings.Repeat("é", maxTraceArgument)},
+		{[]object.Object{&object.String{Value: strings.Repeat("é", maxTraceArgument+1)}, object.TRUE}, strings.Repeat("é", maxTraceArgument) + "..., true"},
+		{[]object.Object{many}, many.Inspect()[:maxTraceArgument] + "..."},
+	}
+
+	for _, tt := range tests {
+		if actual := summarize(tt.args); actual != tt.expected {
+			t.Errorf("wrong summary. want=%q, got=%q", tt.expected, actual)
+		}
+	}
+}
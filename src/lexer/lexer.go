@@ -20,6 +20,8 @@ type Lexer struct {
 	pos token.Position // the position for current reading character in the input string
 
 	ErrorCount int
+	// Comments holds the comments scanned so far, in the order of the input
+	Comments []token.Comment
 }
 
 // New create a Lexer to scan the input string
@@ -219,10 +221,40 @@ func (l *Lexer) readRune() {
 	}
 }
 
+// skipWhiteSpaces skips the white spaces and the comments before the next token
 func (l *Lexer) skipWhiteSpaces() {
-	for l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r' {
+	for {
+		switch {
+		case l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r':
+			l.readRune()
+		case l.ch == '/' && l.peekByte() == '/':
+			l.readComment()
+		default:
+			return
+		}
+	}
+}
+
+// peekByte returns the byte after the current character, 0 at the end of the input
+func (l *Lexer) peekByte() byte {
+	if l.readOffset >= len(l.input) {
+		return 0
+	}
+	return l.input[l.readOffset]
+}
+
+func (l *Lexer) readComment() {
+	pos := l.pos
+	start := l.offset
+	for l.ch != '\n' && l.ch != '\r' && l.ch != 0 {
 		l.readRune()
 	}
+
+	end := l.offset
+	if l.ch == 0 {
+		end = len(l.input)
+	}
+	l.Comments = append(l.Comments, token.Comment{Pos: pos, Text: string(l.input[start:end])})
 }
 
 func newToken(tokenType token.TokenType, literal string) token.Token {
@@ -327,6 +327,36 @@ func TestOperatorToken(t *testing.T) {
 	}
 }
 
+func TestComments(t *testing.T) {
+	input := `// first
+let a = 10 / 2; // half
+// last`
+
+	l := New(input, nil)
+	testLexer(t, l, token.LET, "let", 2, 1)
+	testLexer(t, l, token.IDENT, "a", 2, 5)
+	testLexer(t, l, token.ASSIGN, "=", 2, 7)
+	testLexer(t, l, token.INT, "10", 2, 9)
+	testLexer(t, l, token.DIVIDE, "/", 2, 12)
+	testLexer(t, l, token.INT, "2", 2, 14)
+	testLexer(t, l, token.SEMICOLON, ";", 2, 15)
+	testLexer(t, l, token.EOF, "", 3, 8)
+
+	expected := []token.Comment{
+		{Pos: token.Position{Line: 1, Column: 1}, Text: "// first"},
+		{Pos: token.Position{Line: 2, Column: 17}, Text: "// half"},
+		{Pos: token.Position{Line: 3, Column: 1}, Text: "// last"},
+	}
+	if len(l.Comments) != len(expected) {
+		t.Fatalf("wrong number of comments. want=%d, got=%d", len(expected), len(l.Comments))
+	}
+	for i, c := range expected {
+		if l.Comments[i] != c {
+			t.Errorf("wrong comment %d. want=%+v, got=%+v", i, c, l.Comments[i])
+		}
+	}
+}
+
 func TestLexerError(t *testing.T) {
 	tests := []struct {
 		input    string
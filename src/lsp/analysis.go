@@ -0,0 +1,285 @@
+package lsp
+
+import (
+	"ast"
+	"compiler"
+	"object"
+	"strings"
+	"token"
+)
+
+// scope is a symbol table of the compiler with the identifiers which define its names.
+// Functions get their own scopes, from the fn keyword to their closing brace
+type scope struct {
+	table *compiler.SymbolTable
+	defs  map[string]*definition
+	outer *scope
+	depth int
+
+	start token.Position
+	end   token.Position
+}
+
+func (s *scope) contains(pos token.Position) bool {
+	return s.outer == nil || !pos.Before(s.start) && !s.end.Before(pos)
+}
+
+// definition is the identifier of a let, of a parameter or of a named function. value
+// is the function of let-bound and named functions
+type definition struct {
+	ident  *ast.Identifier
+	symbol compiler.Symbol
+	scope  *scope
+	value  *ast.FunctionExpression
+}
+
+// reference is an identifier of the program, definitions included. def is nil for
+// builtins
+type reference struct {
+	ident  *ast.Identifier
+	symbol compiler.Symbol
+	def    *definition
+}
+
+// analysis resolves the identifiers of a program the way the compiler does: a let
+// defines its name before its value, a function defines its own name and then its
+// parameters in a new scope
+type analysis struct {
+	refs        []*reference
+	definitions []*definition
+	symbols     []*documentSymbol
+
+	scope *scope
+	// symbols of the let-bound function being walked, nil at the top level
+	parent *documentSymbol
+}
+
+// documentSymbol is a let-bound function, with the ones defined in its body
+type documentSymbol struct {
+	let      *ast.LetStatement
+	fn       *ast.FunctionExpression
+	children []*documentSymbol
+}
+
+func analyze(program *ast.Program) *analysis {
+	table := compiler.NewSymbolTable()
+	for i, b := range object.Builtins {
+		table.DefineBuiltin(i, b.Name)
+	}
+
+	a := &analysis{scope: &scope{table: table, defs: map[string]*definition{}}}
+	a.walk(program)
+	return a
+}
+
+func (a *analysis) walk(node ast.Node) {
+	switch node := node.(type) {
+	case *ast.Program:
+		for _, statement := range node.Statements {
+			a.walk(statement)
+		}
+	case *ast.BlockExpression:
+		for _, statement := range node.Statements {
+			a.walk(statement)
+		}
+	case *ast.ExpressionStatement:
+		a.walk(node.Value)
+	case *ast.ReturnStatement:
+		if node.Value != nil {
+			a.walk(node.Value)
+		}
+	case *ast.LetStatement:
+		fn, _ := node.Value.(*ast.FunctionExpression)
+		a.define(node.Name, a.scope.table.Define(node.Name.Value), fn)
+		if fn == nil {
+			a.walk(node.Value)
+			break
+		}
+
+		symbol := &documentSymbol{let: node, fn: fn}
+		if a.parent == nil {
+			a.symbols = append(a.symbols, symbol)
+		} else {
+			a.parent.children = append(a.parent.children, symbol)
+		}
+
+		outer := a.parent
+		a.parent = symbol
+		a.walk(fn)
+		a.parent = outer
+	case *ast.Identifier:
+		a.resolve(node)
+	case *ast.PrefixExpression:
+		a.walk(node.Value)
+	case *ast.InfixExpression:
+		a.walk(node.Left)
+		a.walk(node.Right)
+	case *ast.PostfixExpression:
+		a.walk(node.Left)
+	case *ast.SliceExpression:
+		a.walk(node.Left)
+		if node.Start != nil {
+			a.walk(node.Start)
+		}
+		if node.End != nil {
+			a.walk(node.End)
+		}
+	case *ast.IfExpression:
+		a.walk(node.Condition)
+		a.walk(node.ThenBody)
+		if node.ElseBody != nil {
+			a.walk(node.ElseBody)
+		}
+	case *ast.FunctionExpression:
+		outer := a.scope
+		a.scope = &scope{
+			table: compiler.NewEnclosedSymbolTable(outer.table),
+			defs:  map[string]*definition{},
+			outer: outer,
+			depth: outer.depth + 1,
+			start: node.Pos(),
+			end:   node.Body.End,
+		}
+
+		if node.Name != nil {
+			symbol := a.scope.table.DefineFunctionName(node.Name.Value)
+			if def, ok := outer.defs[node.Name.Value]; ok && def.ident == node.Name {
+				// the name of a let-bound function is defined by the let
+				a.scope.defs[node.Name.Value] = def
+			} else {
+				a.define(node.Name, symbol, node)
+			}
+		}
+		for _, parameter := range node.Parameters {
+			a.define(parameter, a.scope.table.Define(parameter.Value), nil)
+		}
+
+		a.walk(node.Body)
+		a.scope = outer
+	case *ast.CallExpression:
+		a.walk(node.Function)
+		for _, argument := range node.Arguments {
+			a.walk(argument)
+		}
+	case *ast.ArrayLiteral:
+		for _, element := range node.Elements {
+			a.walk(element)
+		}
+	case *ast.HashLiteral:
+		for _, pair := range node.Pairs {
+			a.walk(pair.Key)
+			a.walk(pair.Value)
+		}
+	}
+}
+
+func (a *analysis) define(ident *ast.Identifier, symbol compiler.Symbol, value *ast.FunctionExpression) {
+	def := &definition{ident: ident, symbol: symbol, scope: a.scope, value: value}
+	a.scope.defs[ident.Value] = def
+	a.definitions = append(a.definitions, def)
+	a.refs = append(a.refs, &reference{ident: ident, symbol: symbol, def: def})
+}
+
+// resolve records the symbol of an identifier, undefined ones are left to the compiler
+func (a *analysis) resolve(ident *ast.Identifier) {
+	symbol, ok := a.scope.table.Resolve(ident.Value)
+	if !ok {
+		return
+	}
+
+	ref := &reference{ident: ident, symbol: symbol}
+	if symbol.Scope != compiler.BuiltinScope {
+		for s := a.scope; s != nil; s = s.outer {
+			if def, ok := s.defs[ident.Value]; ok {
+				ref.def = def
+				break
+			}
+		}
+	}
+	a.refs = append(a.refs, ref)
+}
+
+// referenceAt returns the identifier at pos, pos can also be right after it
+func (a *analysis) referenceAt(pos token.Position) *reference {
+	for _, ref := range a.refs {
+		start := ref.ident.Pos()
+		if start.Line == pos.Line && start.Column <= pos.Column && pos.Column <= start.Column+identLength(ref.ident) {
+			return ref
+		}
+	}
+	return nil
+}
+
+// references returns the identifiers referring to what ref refers to
+func (a *analysis) references(ref *reference, includeDeclaration bool) []*reference {
+	refs := []*reference{}
+	for _, other := range a.refs {
+		if ref.def == nil {
+			if other.def == nil && other.ident.Value == ref.ident.Value {
+				refs = append(refs, other)
+			}
+			continue
+		}
+
+		if other.def == ref.def && (includeDeclaration || other.ident != ref.def.ident) {
+			refs = append(refs, other)
+		}
+	}
+	return refs
+}
+
+// visible returns the definitions which can be referred to at pos, the innermost one
+// of each name
+func (a *analysis) visible(pos token.Position) []*definition {
+	byName := map[string]*definition{}
+	names := []string{}
+	for _, def := range a.definitions {
+		if !def.scope.contains(pos) || !def.ident.Pos().Before(pos) {
+			continue
+		}
+
+		other, ok := byName[def.ident.Value]
+		if !ok {
+			names = append(names, def.ident.Value)
+		}
+		if !ok || def.scope.depth >= other.scope.depth {
+			byName[def.ident.Value] = def
+		}
+	}
+
+	defs := []*definition{}
+	for _, name := range names {
+		defs = append(defs, byName[name])
+	}
+	return defs
+}
+
+func identLength(ident *ast.Identifier) int {
+	return len([]rune(ident.Value))
+}
+
+// scopeName is how the hover of an identifier names the scope of its symbol
+func scopeName(scope compiler.SymbolScope) string {
+	switch scope {
+	case compiler.GlobalScope:
+		return "global"
+	case compiler.LocalScope:
+		return "local"
+	case compiler.FreeScope:
+		return "free"
+	case compiler.BuiltinScope:
+		return "builtin"
+	case compiler.Function:
+		return "function"
+	}
+	return strings.ToLower(string(scope))
+}
+
+// signature is the fn keyword and the parameters of fn
+func signature(fn *ast.FunctionExpression) string {
+	parameters := []string{}
+	for _, parameter := range fn.Parameters {
+		parameters = append(parameters, parameter.Value)
+	}
+	return "fn(" + strings.Join(parameters, ", ") + ")"
+}
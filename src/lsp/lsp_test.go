@@ -0,0 +1,283 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const counter = `let counter = fn(step) {
+	let c = 0;
+	let next = fn() { c + step };
+	next
+};
+let n = counter(1);
+push([n(), "😀"], n);
+`
+
+type session struct {
+	input bytes.Buffer
+	id    int
+}
+
+func (s *session) send(t *testing.T, method string, params interface{}) int {
+	content, err := json.Marshal(params)
+	if err != nil {
+		t.Fatalf("marshal params failed: %s", err)
+	}
+
+	msg := &message{Method: method, Params: content}
+	if !strings.HasPrefix(method, "textDocument/did") && method != "initialized" && method != "exit" {
+		s.id++
+		id := json.RawMessage(strconv.Itoa(s.id))
+		msg.ID = &id
+	}
+	if err := writeMessage(&s.input, msg); err != nil {
+		t.Fatalf("write message failed: %s", err)
+	}
+	return s.id
+}
+
+func position(uri string, line, character int) map[string]interface{} {
+	return map[string]interface{}{
+		"textDocument": map[string]string{"uri": uri},
+		"position":     Position{Line: line, Character: character},
+		"context":      map[string]bool{"includeDeclaration": true},
+	}
+}
+
+func open(uri, text string) map[string]interface{} {
+	return map[string]interface{}{"textDocument": map[string]string{"uri": uri, "languageId": "gorilla", "text": text}}
+}
+
+// run serves the messages sent, it returns the results by the ids of the requests and
+// the diagnostics by the uris of the documents
+func (s *session) run(t *testing.T) (map[string]json.RawMessage, map[string][]Diagnostic) {
+	s.send(t, "shutdown", nil)
+	s.send(t, "exit", nil)
+
+	out := &bytes.Buffer{}
+	if err := NewServer(&s.input, out).Serve(); err != nil {
+		t.Fatalf("serve failed: %s", err)
+	}
+
+	results := map[string]json.RawMessage{}
+	diagnostics := map[string][]Diagnostic{}
+	r := bufio.NewReader(out)
+	for {
+		content, err := readMessage(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("read message failed: %s", err)
+		}
+
+		msg := &message{}
+		if err := json.Unmarshal(content, msg); err != nil {
+			t.Fatalf("unmarshal %s failed: %s", content, err)
+		}
+		if msg.Error != nil {
+			t.Errorf("request %s failed: %s", *msg.ID, msg.Error.Message)
+		}
+		if msg.Method == "textDocument/publishDiagnostics" {
+			var params publishDiagnosticsParams
+			json.Unmarshal(msg.Params, &params)
+			diagnostics[params.URI] = params.Diagnostics
+			continue
+		}
+		results[string(*msg.ID)] = msg.Result
+	}
+	return results, diagnostics
+}
+
+func result(t *testing.T, results map[string]json.RawMessage, id int, v interface{}) {
+	content, ok := results[strconv.Itoa(id)]
+	if !ok {
+		t.Fatalf("no response to request %d", id)
+	}
+	if err := json.Unmarshal(content, v); err != nil {
+		t.Fatalf("unmarshal result %s failed: %s", content, err)
+	}
+}
+
+func TestHover(t *testing.T) {
+	tests := []struct {
+		line      int
+		character int
+		expected  string
+	}{
+		{0, 5, "(global) counter = fn(step)"},
+		{1, 5, "(local) c"},
+		{2, 19, "(free) c"},
+		{2, 24, "(free) step"},
+		{3, 2, "(local) next = fn()"},
+		{5, 9, "(global) counter = fn(step)"},
+		{6, 0, "(builtin) push"},
+		{6, 18, "(global) n"},
+	}
+
+	s := &session{}
+	s.send(t, "initialize", map[string]interface{}{})
+	s.send(t, "textDocument/didOpen", open("file:///counter.gor", counter))
+	ids := []int{}
+	for _, tt := range tests {
+		ids = append(ids, s.send(t, "textDocument/hover", position("file:///counter.gor", tt.line, tt.character)))
+	}
+	results, _ := s.run(t)
+
+	for i, tt := range tests {
+		var hover Hover
+		result(t, results, ids[i], &hover)
+		expected := "```gorilla\n" + tt.expected + "\n```"
+		if hover.Contents.Value != expected {
+			t.Errorf("wrong hover at %d:%d. want=%q, got=%q", tt.line, tt.character, expected, hover.Contents.Value)
+		}
+	}
+}
+
+func TestDefinitionAndReferences(t *testing.T) {
+	s := &session{}
+	s.send(t, "textDocument/didOpen", open("file:///counter.gor", counter))
+	definition := s.send(t, "textDocument/definition", position("file:///counter.gor", 2, 19))
+	builtin := s.send(t, "textDocument/definition", position("file:///counter.gor", 6, 1))
+	references := s.send(t, "textDocument/references", position("file:///counter.gor", 6, 6))
+	results, _ := s.run(t)
+
+	var location Location
+	result(t, results, definition, &location)
+	expected := Range{Start: Position{Line: 1, Character: 5}, End: Position{Line: 1, Character: 6}}
+	if location.Range != expected {
+		t.Errorf("wrong definition. want=%+v, got=%+v", expected, location.Range)
+	}
+
+	var none *Location
+	result(t, results, builtin, &none)
+	if none != nil {
+		t.Errorf("expected no definition of a builtin, got=%+v", none)
+	}
+
+	var locations []Location
+	result(t, results, references, &locations)
+	lines := []int{}
+	for _, l := range locations {
+		lines = append(lines, l.Range.Start.Line)
+	}
+	if len(lines) != 3 || lines[0] != 5 || lines[1] != 6 || lines[2] != 6 {
+		t.Fatalf("wrong references of n. got lines %v", lines)
+	}
+	// the emoji before the last one takes two utf-16 code units
+	if locations[2].Range.Start.Character != 18 || locations[2].Range.End.Character != 19 {
+		t.Errorf("wrong range of the last reference. got=%+v", locations[2].Range)
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	s := &session{}
+	s.send(t, "textDocument/didOpen", open("file:///counter.gor", counter))
+	inner := s.send(t, "textDocument/completion", position("file:///counter.gor", 2, 20))
+	// a text which does not parse keeps the names of the last one which did
+	s.send(t, "textDocument/didChange", map[string]interface{}{
+		"textDocument":   map[string]string{"uri": "file:///counter.gor"},
+		"contentChanges": []map[string]string{{"text": counter + "push("}},
+	})
+	typing := s.send(t, "textDocument/completion", position("file:///counter.gor", 7, 5))
+	results, _ := s.run(t)
+
+	labels := func(id int) map[string]string {
+		var items []CompletionItem
+		result(t, results, id, &items)
+		labels := map[string]string{}
+		for _, item := range items {
+			labels[item.Label] = item.Detail
+		}
+		return labels
+	}
+
+	got := labels(inner)
+	for label, detail := range map[string]string{"c": "local", "step": "local", "next": "fn()", "counter": "fn(step)", "len": "builtin"} {
+		if got[label] != detail {
+			t.Errorf("wrong completion of %s. want=%q, got=%q", label, detail, got[label])
+		}
+	}
+	if _, ok := got["n"]; ok {
+		t.Errorf("n is completed before its definition")
+	}
+
+	got = labels(typing)
+	if got["n"] != "global" || got["counter"] != "fn(step)" {
+		t.Errorf("wrong completions while typing. got=%v", got)
+	}
+}
+
+func TestDocumentSymbols(t *testing.T) {
+	s := &session{}
+	s.send(t, "textDocument/didOpen", open("file:///counter.gor", counter))
+	id := s.send(t, "textDocument/documentSymbol", map[string]interface{}{"textDocument": map[string]string{"uri": "file:///counter.gor"}})
+	results, _ := s.run(t)
+
+	var symbols []DocumentSymbol
+	result(t, results, id, &symbols)
+	if len(symbols) != 1 || symbols[0].Name != "counter" || symbols[0].Detail != "fn(step)" {
+		t.Fatalf("wrong symbols. got=%+v", symbols)
+	}
+	expected := Range{Start: Position{Line: 0, Character: 0}, End: Position{Line: 4, Character: 1}}
+	if symbols[0].Range != expected {
+		t.Errorf("wrong range of counter. want=%+v, got=%+v", expected, symbols[0].Range)
+	}
+	if len(symbols[0].Children) != 1 || symbols[0].Children[0].Name != "next" {
+		t.Errorf("wrong children of counter. got=%+v", symbols[0].Children)
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected []Diagnostic
+	}{
+		{counter, []Diagnostic{}},
+		{"let a = 1;\nlet f = fn() { a + missing };", []Diagnostic{{
+			Range:    Range{Start: Position{Line: 1, Character: 19}, End: Position{Line: 1, Character: 26}},
+			Severity: SeverityError,
+			Source:   "gorilla",
+			Message:  "undefined variable missing",
+		}}},
+		{"let = 1", []Diagnostic{{
+			Range:    Range{Start: Position{Line: 0, Character: 4}, End: Position{Line: 0, Character: 5}},
+			Severity: SeverityError,
+			Source:   "gorilla",
+			Message:  `expectd token type is "IDENT", got "=" at line: 1, column: 5`,
+		}}},
+	}
+
+	s := &session{}
+	for i, tt := range tests {
+		s.send(t, "textDocument/didOpen", open(string(rune('a'+i)), tt.text))
+	}
+	_, diagnostics := s.run(t)
+
+	for i, tt := range tests {
+		got := diagnostics[string(rune('a'+i))]
+		if len(got) != len(tt.expected) {
+			t.Errorf("wrong diagnostics of %q. want=%+v, got=%+v", tt.text, tt.expected, got)
+			continue
+		}
+		for j := range got {
+			if got[j] != tt.expected[j] {
+				t.Errorf("wrong diagnostic of %q. want=%+v, got=%+v", tt.text, tt.expected[j], got[j])
+			}
+		}
+	}
+}
+
+func TestExitWithoutShutdown(t *testing.T) {
+	s := &session{}
+	s.send(t, "exit", nil)
+	if err := NewServer(&s.input, &bytes.Buffer{}).Serve(); err != ErrExitWithoutShutdown {
+		t.Errorf("wrong error. want=%q, got=%v", ErrExitWithoutShutdown, err)
+	}
+}
@@ -0,0 +1,396 @@
+// Package lsp is a language server for gorilla programs. It speaks the language server
+// protocol over a stream, reports the errors of the parser and the compiler and
+// resolves identifiers with the symbol tables of the compiler
+package lsp
+
+import (
+	"bufio"
+	"compiler"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"object"
+	"parser"
+	"sort"
+	"strings"
+	"token"
+	"unicode/utf16"
+)
+
+// ErrExitWithoutShutdown is returned by Serve when the client exits without asking the
+// server to shut down first
+var ErrExitWithoutShutdown = errors.New("exit without shutdown")
+
+type Server struct {
+	in  *bufio.Reader
+	out io.Writer
+
+	docs     map[string]*document
+	shutdown bool
+}
+
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{in: bufio.NewReader(in), out: out, docs: map[string]*document{}}
+}
+
+// Serve handles the messages of the client until it exits
+func (s *Server) Serve() error {
+	for {
+		content, err := readMessage(s.in)
+		if err != nil {
+			return err
+		}
+
+		msg := &message{}
+		if err := json.Unmarshal(content, msg); err != nil {
+			if err := s.respond(nil, nil, &responseError{Code: codeParseError, Message: err.Error()}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				return ErrExitWithoutShutdown
+			}
+			return nil
+		}
+
+		result, rerr := s.handle(msg)
+		if msg.ID == nil {
+			continue
+		}
+		if err := s.respond(msg.ID, result, rerr); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) respond(id *json.RawMessage, result interface{}, rerr *responseError) error {
+	if id == nil {
+		null := json.RawMessage("null")
+		id = &null
+	}
+
+	response := &message{ID: id, Error: rerr}
+	if rerr == nil {
+		content, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		response.Result = content
+	}
+	return writeMessage(s.out, response)
+}
+
+func (s *Server) notify(method string, params interface{}) error {
+	content, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return writeMessage(s.out, &message{Method: method, Params: content})
+}
+
+// handle returns the result of a request, notifications have theirs dropped
+func (s *Server) handle(msg *message) (interface{}, *responseError) {
+	if s.shutdown {
+		return nil, &responseError{Code: codeInvalidRequest, Message: "the server is shut down"}
+	}
+
+	var params positionParams
+	decode := func(v interface{}) *responseError {
+		if err := json.Unmarshal(msg.Params, v); err != nil {
+			return &responseError{Code: codeInvalidParams, Message: err.Error()}
+		}
+		return nil
+	}
+
+	switch msg.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":       1,
+				"hoverProvider":          true,
+				"definitionProvider":     true,
+				"referencesProvider":     true,
+				"completionProvider":     map[string]interface{}{},
+				"documentSymbolProvider": true,
+			},
+			"serverInfo": map[string]string{"name": "gorilla"},
+		}, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var open didOpenParams
+		if err := decode(&open); err != nil {
+			return nil, err
+		}
+		s.open(open.TextDocument.URI, open.TextDocument.Text)
+		return nil, nil
+	case "textDocument/didChange":
+		var change didChangeParams
+		if err := decode(&change); err != nil {
+			return nil, err
+		}
+		if len(change.ContentChanges) > 0 {
+			s.open(change.TextDocument.URI, change.ContentChanges[len(change.ContentChanges)-1].Text)
+		}
+		return nil, nil
+	case "textDocument/didClose":
+		var close didCloseParams
+		if err := decode(&close); err != nil {
+			return nil, err
+		}
+		delete(s.docs, close.TextDocument.URI)
+		s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{URI: close.TextDocument.URI, Diagnostics: []Diagnostic{}})
+		return nil, nil
+	case "textDocument/documentSymbol":
+		var symbols documentSymbolParams
+		if err := decode(&symbols); err != nil {
+			return nil, err
+		}
+		if doc, ok := s.docs[symbols.TextDocument.URI]; ok {
+			return doc.documentSymbols(), nil
+		}
+		return []DocumentSymbol{}, nil
+	case "textDocument/hover", "textDocument/definition", "textDocument/references", "textDocument/completion":
+		if err := decode(&params); err != nil {
+			return nil, err
+		}
+	default:
+		if msg.ID == nil {
+			return nil, nil
+		}
+		return nil, &responseError{Code: codeMethodNotFound, Message: fmt.Sprintf("method %s not found", msg.Method)}
+	}
+
+	doc, ok := s.docs[params.TextDocument.URI]
+	if !ok {
+		return nil, &responseError{Code: codeInvalidParams, Message: fmt.Sprintf("document %s is not open", params.TextDocument.URI)}
+	}
+	pos := doc.tokenPosition(params.Position)
+
+	switch msg.Method {
+	case "textDocument/hover":
+		return doc.hover(pos), nil
+	case "textDocument/definition":
+		return doc.definition(pos), nil
+	case "textDocument/references":
+		return doc.references(pos, params.Context.IncludeDeclaration), nil
+	default:
+		return doc.completion(pos), nil
+	}
+}
+
+// open analyzes the text of a document and publishes its diagnostics
+func (s *Server) open(uri, text string) {
+	doc := newDocument(uri, text)
+	if old, ok := s.docs[uri]; ok && doc.analysis == nil {
+		doc.completions = old.completions
+	}
+	s.docs[uri] = doc
+	s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{URI: uri, Diagnostics: doc.diagnostics})
+}
+
+type document struct {
+	uri   string
+	lines []string
+
+	// analysis is nil when the text does not parse
+	analysis    *analysis
+	diagnostics []Diagnostic
+	// completions is the analysis of the last text which parsed, names are offered
+	// from it while the text being typed does not parse
+	completions *analysis
+}
+
+func newDocument(uri, text string) *document {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+	doc := &document{uri: uri, lines: strings.Split(text, "\n"), diagnostics: []Diagnostic{}}
+
+	program, err := parser.New(text).ParseProgram()
+	if err != nil {
+		pos := token.Position{Line: 1, Column: 1}
+		var perr parser.ParserError
+		if errors.As(err, &perr) {
+			pos = perr.Pos()
+		}
+		doc.diagnostics = append(doc.diagnostics, doc.diagnostic(pos, err.Error()))
+		return doc
+	}
+
+	doc.analysis = analyze(program)
+	doc.completions = doc.analysis
+
+	if err := compiler.New().Compile(program); err != nil {
+		pos := token.Position{Line: 1, Column: 1}
+		msg := err.Error()
+		var cerr *compiler.Error
+		if errors.As(err, &cerr) {
+			pos, msg = cerr.Pos, cerr.Msg
+		}
+		doc.diagnostics = append(doc.diagnostics, doc.diagnostic(pos, msg))
+	}
+	return doc
+}
+
+// diagnostic is an error at the word starting at pos, or at the character at pos
+func (d *document) diagnostic(pos token.Position, msg string) Diagnostic {
+	end := pos
+	end.Column++
+	if pos.Line >= 1 && pos.Line <= len(d.lines) {
+		line := []rune(d.lines[pos.Line-1])
+		for i := pos.Column; i-1 < len(line) && isWord(line[i-1]); i++ {
+			end.Column = i + 1
+		}
+	}
+	return Diagnostic{Range: d.span(pos, end), Severity: SeverityError, Source: "gorilla", Message: msg}
+}
+
+func isWord(ch rune) bool {
+	return ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' || ch == '_'
+}
+
+// position converts a position of the lexer, 1-based and counting runes, to one of
+// the protocol
+func (d *document) position(pos token.Position) Position {
+	if pos.Line < 1 {
+		return Position{}
+	}
+
+	character := 0
+	if pos.Line <= len(d.lines) {
+		column := 1
+		for _, r := range d.lines[pos.Line-1] {
+			if column == pos.Column {
+				break
+			}
+			character += utf16.RuneLen(r)
+			column++
+		}
+	}
+	return Position{Line: pos.Line - 1, Character: character}
+}
+
+// tokenPosition converts a position of the protocol to one of the lexer
+func (d *document) tokenPosition(p Position) token.Position {
+	column := 1
+	if p.Line < len(d.lines) {
+		units := 0
+		for _, r := range d.lines[p.Line] {
+			if units >= p.Character {
+				break
+			}
+			units += utf16.RuneLen(r)
+			column++
+		}
+	}
+	return token.Position{Line: p.Line + 1, Column: column}
+}
+
+func (d *document) span(start, end token.Position) Range {
+	return Range{Start: d.position(start), End: d.position(end)}
+}
+
+func (d *document) identRange(ref *reference) Range {
+	end := ref.ident.Pos()
+	end.Column += identLength(ref.ident)
+	return d.span(ref.ident.Pos(), end)
+}
+
+func (d *document) hover(pos token.Position) *Hover {
+	if d.analysis == nil {
+		return nil
+	}
+	ref := d.analysis.referenceAt(pos)
+	if ref == nil {
+		return nil
+	}
+
+	text := ref.ident.Value
+	if ref.def != nil && ref.def.value != nil {
+		text += " = " + signature(ref.def.value)
+	}
+	return &Hover{
+		Contents: MarkupContent{Kind: "markdown", Value: fmt.Sprintf("```gorilla\n(%s) %s\n```", scopeName(ref.symbol.Scope), text)},
+		Range:    d.identRange(ref),
+	}
+}
+
+func (d *document) definition(pos token.Position) *Location {
+	if d.analysis == nil {
+		return nil
+	}
+	ref := d.analysis.referenceAt(pos)
+	if ref == nil || ref.def == nil {
+		return nil
+	}
+	return &Location{URI: d.uri, Range: d.identRange(&reference{ident: ref.def.ident})}
+}
+
+func (d *document) references(pos token.Position, includeDeclaration bool) []Location {
+	locations := []Location{}
+	if d.analysis == nil {
+		return locations
+	}
+	ref := d.analysis.referenceAt(pos)
+	if ref == nil {
+		return locations
+	}
+
+	for _, other := range d.analysis.references(ref, includeDeclaration) {
+		locations = append(locations, Location{URI: d.uri, Range: d.identRange(other)})
+	}
+	return locations
+}
+
+// completion offers the names visible at pos and the builtins they do not shadow
+func (d *document) completion(pos token.Position) []CompletionItem {
+	items := []CompletionItem{}
+	names := map[string]bool{}
+	if d.completions != nil {
+		for _, def := range d.completions.visible(pos) {
+			item := CompletionItem{Label: def.ident.Value, Kind: CompletionVariable, Detail: scopeName(def.symbol.Scope)}
+			if def.value != nil {
+				item.Kind, item.Detail = CompletionFunction, signature(def.value)
+			}
+			items = append(items, item)
+			names[def.ident.Value] = true
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Label < items[j].Label })
+
+	for _, b := range object.Builtins {
+		if !names[b.Name] {
+			items = append(items, CompletionItem{Label: b.Name, Kind: CompletionFunction, Detail: "builtin"})
+		}
+	}
+	return items
+}
+
+func (d *document) documentSymbols() []DocumentSymbol {
+	if d.analysis == nil {
+		return []DocumentSymbol{}
+	}
+	return d.convertSymbols(d.analysis.symbols)
+}
+
+func (d *document) convertSymbols(symbols []*documentSymbol) []DocumentSymbol {
+	converted := []DocumentSymbol{}
+	for _, symbol := range symbols {
+		end := symbol.fn.Body.End
+		end.Column++
+		converted = append(converted, DocumentSymbol{
+			Name:           symbol.let.Name.Value,
+			Detail:         signature(symbol.fn),
+			Kind:           SymbolFunction,
+			Range:          d.span(symbol.let.Pos(), end),
+			SelectionRange: d.identRange(&reference{ident: symbol.let.Name}),
+			Children:       d.convertSymbols(symbol.children),
+		})
+	}
+	return converted
+}
@@ -3,16 +3,43 @@ package main
 import (
 	"flag"
 	"fmt"
+	"object"
 	"os"
 	"os/user"
 	"repl"
 )
 
 func main() {
-	modePtr := flag.String("mode", "compiler", "compiler or interpreter")
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "build":
+			os.Exit(build(os.Args[2:]))
+		case "debug":
+			os.Exit(debugProgram(os.Args[2:]))
+		case "fmt":
+			os.Exit(formatFiles(os.Args[2:]))
+		case "lsp":
+			os.Exit(serveLanguage(os.Args[2:]))
+		case "run":
+			os.Exit(run(os.Args[2:]))
+		case "vet":
+			os.Exit(vetFiles(os.Args[2:]))
+		case "profile":
+			os.Exit(profile(os.Args[2:]))
+		}
+	}
+
+	modePtr := flag.String("mode", "compiler", "compiler, register, closure or interpreter")
+	randomHashSeed := flag.Bool("random-hash-seed", false, "hash keys of hash tables with a random seed")
+	noOptimize := flag.Bool("no-optimize", false, "compile without constant folding, dead code elimination and peephole optimization")
 
 	flag.Parse()
 
+	seed := object.HashSeed{}
+	if *randomHashSeed {
+		seed = object.RandomHashSeed()
+	}
+
 	user, err := user.Current()
 	if err != nil {
 		panic(err)
@@ -22,10 +49,15 @@ func main() {
 
 	fmt.Printf("Feel free to type in commands\n")
 
-	if *modePtr == "compiler" {
-		repl.StartWithCompiler(os.Stdin, os.Stdout)
-	} else {
-		repl.StartWithInterpreter(os.Stdin, os.Stdout)
+	switch *modePtr {
+	case "compiler":
+		repl.StartWithCompiler(os.Stdin, os.Stdout, !*noOptimize, seed)
+	case "register":
+		repl.StartWithRegisterVM(os.Stdin, os.Stdout, !*noOptimize, seed)
+	case "closure":
+		repl.StartWithClosures(os.Stdin, os.Stdout, seed)
+	default:
+		repl.StartWithInterpreter(os.Stdin, os.Stdout, seed)
 	}
 
 }
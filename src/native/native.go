@@ -0,0 +1,247 @@
+// Package native is the runtime of the go programs which golang.Translator translates
+// gorilla programs into. Its operators, calls and errors are the ones of the vm, so a
+// translated program gives the result of the vm or fails with the same error. The
+// translated source imports this package, object, code and token, so it builds with
+// go build in a GOPATH which holds them
+package native
+
+import (
+	"code"
+	"context"
+	"fmt"
+	"io"
+	"math/big"
+	"object"
+	"os"
+	"token"
+)
+
+// MaxFrames is the number of frames of the vm, the main program takes the first of them
+const MaxFrames = 1024
+
+// Error is the failure of a translated program, it is reported like a runtime error of the vm
+type Error struct {
+	Msg string
+	Pos token.Position
+	Err error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s at line: %d, column: %d", e.Msg, e.Pos.Line, e.Pos.Column)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Func is a translated function. args are the arguments of the call and self is the
+// closure called, it holds the free variables. Func returns nil when it leaves for a
+// call in tail position, the call is then made by the caller of Func
+type Func func(rt *Runtime, self *Closure, args []object.Object) object.Object
+
+// Closure is the value of a translated function expression
+type Closure struct {
+	Fn            Func
+	NumParameters int
+	Free          []object.Object
+}
+
+func (c *Closure) Type() object.ObjectType {
+	return object.CLOJURE_OBJ
+}
+
+func (c *Closure) Inspect() string {
+	return fmt.Sprintf("Closure[%p]", c)
+}
+
+// Program is a translated program, it returns the value of the statement run last
+type Program func(rt *Runtime) object.Object
+
+// Runtime runs a translated program
+type Runtime struct {
+	budget *object.Budget
+	depth  int
+
+	// tail and tailArgs are the call a function leaves for when it returns nil
+	tail     *Closure
+	tailArgs []object.Object
+}
+
+// Run runs program. It never panics, any failure is returned as an *Error
+func Run(program Program) (result object.Object, err error) {
+	rt := &Runtime{budget: object.NewBudget(context.Background(), object.Limits{})}
+
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(*Error); ok {
+				result, err = nil, e
+				return
+			}
+			result, err = nil, &Error{Msg: fmt.Sprintf("internal error: %v", r)}
+		}
+	}()
+
+	return program(rt), nil
+}
+
+// Main runs program and writes its result to stdout, or its error to stderr and exits with 1
+func Main(program Program) {
+	result, err := Run(program)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "run program failed: %s\n", err)
+		os.Exit(1)
+	}
+
+	if result != nil {
+		io.WriteString(os.Stdout, result.Inspect())
+		io.WriteString(os.Stdout, "\n")
+	}
+}
+
+// fail ends the program with err at pos
+func (rt *Runtime) fail(err error, pos token.Position) {
+	panic(&Error{Msg: err.Error(), Pos: pos, Err: err})
+}
+
+// BigInteger returns the integer constant written in decimal in s
+func BigInteger(s string) object.Object {
+	v, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		panic(fmt.Sprintf("invalid integer constant %q", s))
+	}
+	return object.NewInteger(v)
+}
+
+func Truethy(obj object.Object) bool {
+	return obj != object.FALSE && obj != object.NULL
+}
+
+// Global returns the value of the global variable index, which fails if it is not set yet
+func (rt *Runtime) Global(val object.Object, index int, pos token.Position) object.Object {
+	if val == nil {
+		rt.fail(object.Undefined("global", index), pos)
+	}
+	return val
+}
+
+// Local returns the value of the local variable index, which fails if it is not set yet
+func (rt *Runtime) Local(val object.Object, index int, pos token.Position) object.Object {
+	if val == nil {
+		rt.fail(object.Undefined("local", index), pos)
+	}
+	return val
+}
+
+func (rt *Runtime) Binary(op code.OpCode, left object.Object, right object.Object, pos token.Position) object.Object {
+	ret, err := object.Infix(code.Operators[op], left, right)
+	if err != nil {
+		rt.fail(err, pos)
+	}
+	return ret
+}
+
+func (rt *Runtime) Bang(val object.Object) object.Object {
+	return object.NativeBooleanToBooleanObj(!Truethy(val))
+}
+
+func (rt *Runtime) Minus(val object.Object, pos token.Position) object.Object {
+	ret, err := object.IntegerNegate(val)
+	if err != nil {
+		rt.fail(err, pos)
+	}
+	return ret
+}
+
+func (rt *Runtime) Index(coll object.Object, index object.Object, pos token.Position) object.Object {
+	ret, err := object.Index(coll, index)
+	if err != nil {
+		rt.fail(err, pos)
+	}
+	return ret
+}
+
+// Slice slices coll, an omitted bound is passed as object.NULL
+func (rt *Runtime) Slice(coll object.Object, start object.Object, end object.Object, pos token.Position) object.Object {
+	ret, err := object.Slice(coll, start, end)
+	if err != nil {
+		rt.fail(err, pos)
+	}
+	return ret
+}
+
+func (rt *Runtime) Array(elems ...object.Object) object.Object {
+	return &object.Array{Elements: elems}
+}
+
+// Hash makes a hash of pairs, which are keys and values in turn
+func (rt *Runtime) Hash(pos token.Position, pairs ...object.Object) object.Object {
+	hash := object.NewHashTable()
+	for i := 0; i < len(pairs); i += 2 {
+		k, v := pairs[i], pairs[i+1]
+
+		h, err := object.HashLiteralKey(object.HashSeed{}, k)
+		if err != nil {
+			rt.fail(err, pos)
+		}
+		hash.Set(h, k, v)
+	}
+	return hash
+}
+
+// Call calls callee with args. The calls a function leaves for in tail position are
+// made by this loop in place of the function, so they do not nest
+func (rt *Runtime) Call(callee object.Object, pos token.Position, args ...object.Object) object.Object {
+	switch fn := callee.(type) {
+	case *Closure:
+		rt.checkArguments(fn, args, pos)
+		if err := rt.budget.CallDepth(rt.depth+1, MaxFrames-1); err != nil {
+			rt.fail(err, pos)
+		}
+
+		rt.depth++
+		ret := fn.Fn(rt, fn, args)
+		for ret == nil {
+			fn, args = rt.tail, rt.tailArgs
+			rt.tail, rt.tailArgs = nil, nil
+			ret = fn.Fn(rt, fn, args)
+		}
+		rt.depth--
+		return ret
+	case *object.Builtin:
+		return rt.callBuiltin(fn, args, pos)
+	default:
+		rt.fail(object.NotCallable(callee), pos)
+		return nil
+	}
+}
+
+// TailCall calls callee with args in place of the function calling it, which returns
+// the result of TailCall right away. A closure is called by Call after the function
+// returned, TailCall returns nil for it
+func (rt *Runtime) TailCall(callee object.Object, pos token.Position, args ...object.Object) object.Object {
+	switch fn := callee.(type) {
+	case *Closure:
+		rt.checkArguments(fn, args, pos)
+		rt.tail, rt.tailArgs = fn, args
+		return nil
+	case *object.Builtin:
+		return rt.callBuiltin(fn, args, pos)
+	default:
+		rt.fail(object.NotCallable(callee), pos)
+		return nil
+	}
+}
+
+func (rt *Runtime) checkArguments(fn *Closure, args []object.Object, pos token.Position) {
+	if err := object.CheckArguments(fn.NumParameters, len(args)); err != nil {
+		rt.fail(err, pos)
+	}
+}
+
+func (rt *Runtime) callBuiltin(fn *object.Builtin, args []object.Object, pos token.Position) object.Object {
+	ret, _, err := object.CallBuiltin(fn, args)
+	if err != nil {
+		rt.fail(err, pos)
+	}
+	return ret
+}
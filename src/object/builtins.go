@@ -7,6 +7,7 @@ var Builtins = []struct {
 	Butiltin *Builtin
 }{
 	{"len", &Builtin{
+		Arity: 1,
 		Fn: func(args ...Object) Object {
 			if len(args) != 1 {
 				return newError(fmt.Sprintf("wrong number of arguments. expected=%d, got=%d", 1, len(args)))
@@ -23,6 +24,8 @@ var Builtins = []struct {
 		}}},
 
 	{"first", &Builtin{
+		Arity:           1,
+		ReturnsArgument: true,
 		Fn: func(args ...Object) Object {
 			if len(args) != 1 {
 				return newError(fmt.Sprintf("wrong number of arguments for function first. expected=%d, got=%d", 1, len(args)))
@@ -40,6 +43,8 @@ var Builtins = []struct {
 			return NULL
 		}}},
 	{"last", &Builtin{
+		Arity:           1,
+		ReturnsArgument: true,
 		Fn: func(args ...Object) Object {
 			if len(args) != 1 {
 				return newError(fmt.Sprintf("wrong number of arguments for function last. expected=%d, got=%d", 1, len(args)))
@@ -57,6 +62,7 @@ var Builtins = []struct {
 			return NULL
 		}}},
 	{"rest", &Builtin{
+		Arity: 1,
 		Fn: func(args ...Object) Object {
 			if len(args) != 1 {
 				return newError(fmt.Sprintf("wrong number of arguments for function rest. expected=%d, got=%d", 1, len(args)))
@@ -74,6 +80,7 @@ var Builtins = []struct {
 			return NULL
 		}}},
 	{"push", &Builtin{
+		Arity: 2,
 		Fn: func(args ...Object) Object {
 			if len(args) != 2 {
 				return newError(fmt.Sprintf("wrong number of arguments for function push. expected=%d, got=%d", 2, len(args)))
@@ -0,0 +1,51 @@
+package object
+
+import (
+	"errors"
+	"fmt"
+)
+
+// CallBuiltin calls fn with args. The error object fn returns for wrong arguments is
+// returned as an error, which fails the program like any other error. A builtin
+// returning nothing returns NULL. allocated reports whether the result is a new object
+// made by fn, which the caller accounts as allocated
+func CallBuiltin(fn *Builtin, args []Object) (ret Object, allocated bool, err error) {
+	ret = fn.Fn(args...)
+	if ret == nil {
+		return NULL, false, nil
+	}
+	if err, ok := ret.(*Error); ok {
+		return nil, false, errors.New(err.Msg)
+	}
+	return ret, !fn.ReturnsArgument, nil
+}
+
+// CheckArguments returns the error of calling a function of numParameters parameters
+// with numArgs arguments, nil when the numbers agree
+func CheckArguments(numParameters int, numArgs int) error {
+	if numParameters != numArgs {
+		return fmt.Errorf("wrong number of arguments: want=%d got=%d", numParameters, numArgs)
+	}
+	return nil
+}
+
+// NotCallable returns the error of calling callee, which is not a function
+func NotCallable(callee Object) error {
+	return fmt.Errorf("calling non-function %s", callee.Type())
+}
+
+// Undefined returns the error of reading the variable index of the given kind, global
+// or local, before its let statement set it
+func Undefined(kind string, index int) error {
+	return fmt.Errorf("%s variable %d is used before it is defined", kind, index)
+}
+
+// HashLiteralKey returns the HashKey of key under seed, key is the key of a pair of a hash
+// literal, or the error of a key which is not Hashable
+func HashLiteralKey(seed HashSeed, key Object) (HashKey, error) {
+	h, ok := seed.KeyOf(key)
+	if !ok {
+		return HashKey{}, fmt.Errorf("key type in HashLiteral is not Hashable. got %q", key.Type())
+	}
+	return h, nil
+}
@@ -0,0 +1,155 @@
+package object
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"hash/maphash"
+	"strings"
+)
+
+type Hashable interface {
+	Hash(seed HashSeed) HashKey
+}
+
+type HashKey struct {
+	Type  ObjectType
+	Value uint64
+}
+
+// HashSeed keys the hashes of the keys of a HashTable. The zero HashSeed hashes them with
+// FNV-1a, so the hash of a value is the same across processes. A random one keeps untrusted
+// input from being crafted to put many keys into the same bucket
+type HashSeed struct {
+	seed *maphash.Seed
+}
+
+// RandomHashSeed returns an unpredictable HashSeed
+func RandomHashSeed() HashSeed {
+	seed := maphash.MakeSeed()
+	return HashSeed{seed: &seed}
+}
+
+func (s HashSeed) hashBytes(bs []byte) uint64 {
+	if s.seed != nil {
+		return maphash.Bytes(*s.seed, bs)
+	}
+
+	h := fnv.New64a()
+	h.Write(bs)
+	return h.Sum64()
+}
+
+// KeyOf returns the HashKey of obj under s and whether obj can be used as a key of HashTable.
+// Arrays are hashable when all of their elements are hashable
+func (s HashSeed) KeyOf(obj Object) (HashKey, bool) {
+	switch obj := obj.(type) {
+	case Hashable:
+		return obj.Hash(s), true
+	case *Array:
+		var buf bytes.Buffer
+		var value [8]byte
+		for _, e := range obj.Elements {
+			key, ok := s.KeyOf(e)
+			if !ok {
+				return HashKey{}, false
+			}
+
+			buf.WriteString(string(key.Type))
+			binary.BigEndian.PutUint64(value[:], key.Value)
+			buf.Write(value[:])
+		}
+		return HashKey{Type: ARRAY_OBJ, Value: s.hashBytes(buf.Bytes())}, true
+	default:
+		return HashKey{}, false
+	}
+}
+
+type HashPair struct {
+	Key   Object
+	Value Object
+
+	hash HashKey
+}
+
+// HashTable keeps its pairs in insertion order. Different keys may have the same HashKey,
+// so every HashKey maps to a bucket of pairs and keys in a bucket are told apart by Equals.
+// The keys are hashed with the seed of the table, tables with different seeds can be mixed
+type HashTable struct {
+	buckets map[HashKey][]int // HashKey -> indexes of pairs
+	pairs   []HashPair
+	seed    HashSeed
+}
+
+// NewHashTable returns a table hashing its keys with the zero HashSeed
+func NewHashTable() *HashTable {
+	return NewSeededHashTable(HashSeed{})
+}
+
+func NewSeededHashTable(seed HashSeed) *HashTable {
+	return &HashTable{buckets: make(map[HashKey][]int), seed: seed}
+}
+
+// KeyOf returns the HashKey of obj in h and whether obj can be used as a key of h
+func (h *HashTable) KeyOf(obj Object) (HashKey, bool) {
+	return h.seed.KeyOf(obj)
+}
+
+func (h *HashTable) find(hash HashKey, key Object) int {
+	for _, i := range h.buckets[hash] {
+		if Equals(h.pairs[i].Key, key) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Get returns the value bound to key. hash must be the HashKey of key in h
+func (h *HashTable) Get(hash HashKey, key Object) (Object, bool) {
+	i := h.find(hash, key)
+	if i < 0 {
+		return nil, false
+	}
+	return h.pairs[i].Value, true
+}
+
+// Set binds value to key, replacing the old value when key is already in the HashTable.
+// hash must be the HashKey of key in h
+func (h *HashTable) Set(hash HashKey, key Object, value Object) {
+	if i := h.find(hash, key); i >= 0 {
+		h.pairs[i].Value = value
+		return
+	}
+
+	h.buckets[hash] = append(h.buckets[hash], len(h.pairs))
+	h.pairs = append(h.pairs, HashPair{Key: key, Value: value, hash: hash})
+}
+
+func (h *HashTable) Len() int {
+	return len(h.pairs)
+}
+
+// Pairs returns all pairs in insertion order
+func (h *HashTable) Pairs() []HashPair {
+	return h.pairs
+}
+
+func (h *HashTable) Type() ObjectType {
+	return HASHTABLE_OBJ
+}
+
+func (h *HashTable) Inspect() string {
+	var buffer bytes.Buffer
+	buffer.WriteString("{")
+
+	pairs := []string{}
+	for _, v := range h.pairs {
+		pairs = append(pairs, fmt.Sprintf("%s:%s", v.Key.Inspect(), v.Value.Inspect()))
+	}
+
+	buffer.WriteString(strings.Join(pairs, ", "))
+	buffer.WriteString("}")
+
+	return buffer.String()
+}
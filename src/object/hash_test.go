@@ -0,0 +1,102 @@
+package object
+
+import "testing"
+
+func TestHashTableCollision(t *testing.T) {
+	collided := HashKey{Type: STRING_OBJ, Value: 42}
+	first := &String{Value: "first"}
+	second := &String{Value: "second"}
+
+	h := NewHashTable()
+	h.Set(collided, first, &Integer{Value: 1})
+	h.Set(collided, second, &Integer{Value: 2})
+
+	if h.Len() != 2 {
+		t.Fatalf("colliding keys overwrite each other. want=%d pairs, got=%d", 2, h.Len())
+	}
+
+	tests := []struct {
+		key    Object
+		expect int64
+	}{
+		{first, 1},
+		{second, 2},
+		{&String{Value: "first"}, 1},
+	}
+
+	for _, test := range tests {
+		v, ok := h.Get(collided, test.key)
+		if !ok {
+			t.Fatalf("no value for key %s", test.key.Inspect())
+		}
+
+		if v.(*Integer).Value != test.expect {
+			t.Errorf("wrong value for key %s. want=%d, got=%d", test.key.Inspect(), test.expect, v.(*Integer).Value)
+		}
+	}
+
+	if _, ok := h.Get(collided, &String{Value: "third"}); ok {
+		t.Errorf("found value for a key never set")
+	}
+
+	h.Set(collided, &String{Value: "second"}, &Integer{Value: 3})
+	if v, _ := h.Get(collided, second); h.Len() != 2 || v.(*Integer).Value != 3 {
+		t.Errorf("set on existing key did not replace the value. got %s", h.Inspect())
+	}
+}
+
+func TestHashTableInsertionOrder(t *testing.T) {
+	h := NewHashTable()
+	for _, k := range []string{"z", "a", "m"} {
+		key := &String{Value: k}
+		h.Set(key.Hash(HashSeed{}), key, key)
+	}
+
+	if h.Inspect() != "{z:z, a:a, m:m}" {
+		t.Errorf("pairs not in insertion order. got %s", h.Inspect())
+	}
+}
+
+func TestHashSeed(t *testing.T) {
+	seed := RandomHashSeed()
+
+	key := &String{Value: "hello"}
+	unseeded := key.Hash(HashSeed{})
+	seeded := key.Hash(seed)
+	if seeded == unseeded {
+		t.Errorf("hash seed does not change the hash of strings")
+	}
+
+	if seeded != (&String{Value: "hello"}).Hash(seed) {
+		t.Errorf("equal strings have different hash under the same seed")
+	}
+
+	arrayKey, _ := seed.KeyOf(&Array{Elements: []Object{key}})
+	sameArrayKey, _ := seed.KeyOf(&Array{Elements: []Object{&String{Value: "hello"}}})
+	if arrayKey != sameArrayKey {
+		t.Errorf("equal arrays have different hash under the same seed")
+	}
+}
+
+func TestHashTablesOfDifferentSeeds(t *testing.T) {
+	tables := []*HashTable{NewHashTable(), NewSeededHashTable(RandomHashSeed()), NewSeededHashTable(RandomHashSeed())}
+	for _, h := range tables {
+		for _, k := range []Object{&String{Value: "a"}, &Array{Elements: []Object{&String{Value: "b"}}}} {
+			hash, _ := h.KeyOf(k)
+			h.Set(hash, k, TRUE)
+		}
+	}
+
+	for i, h := range tables {
+		v, err := Index(h, &String{Value: "a"})
+		if err != nil || v != TRUE {
+			t.Errorf("no value for a key of table %d. got %v, %v", i, v, err)
+		}
+
+		for j, other := range tables {
+			if !Equals(h, other) {
+				t.Errorf("table %d is not equal to table %d", i, j)
+			}
+		}
+	}
+}
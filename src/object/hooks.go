@@ -0,0 +1,131 @@
+package object
+
+import (
+	"token"
+)
+
+// Hooks observe a program run by the vm or the evaluator, for hosts auditing it, measuring
+// its coverage or limiting it their own way. The vm calls OnInstruction before each
+// instruction, the evaluator before each node it evaluates, with the position of the
+// source. Names of functions are the names they are bound to, or fn@line:column.
+// An error returned by a hook stops the run with that error. A run without Hooks does
+// not pay for them. Several Hooks are combined with MultiHooks
+type Hooks interface {
+	// OnStart is called when the run starts, OnEnd when it ends with result. A run
+	// which fails ends with OnError instead
+	OnStart()
+	OnEnd(result Object)
+	OnInstruction(pos token.Position) error
+	// OnCall is called when a function is called, OnReturn when it returns. result is
+	// nil when the function leaves for a call in tail position, which takes its place
+	OnCall(name string, args []Object) error
+	OnReturn(name string, result Object)
+	// OnBuiltinCall is called when a builtin is called, OnBuiltinReturn when it returns.
+	// result is nil when the builtin fails
+	OnBuiltinCall(name string, args []Object) error
+	OnBuiltinReturn(name string, result Object)
+	// OnError is called with the error the run fails with
+	OnError(err error)
+}
+
+// NopHooks implements Hooks doing nothing, to embed into hooks implementing a few of them
+type NopHooks struct{}
+
+func (NopHooks) OnStart()                                       {}
+func (NopHooks) OnEnd(result Object)                            {}
+func (NopHooks) OnInstruction(pos token.Position) error         { return nil }
+func (NopHooks) OnCall(name string, args []Object) error        { return nil }
+func (NopHooks) OnReturn(name string, result Object)            {}
+func (NopHooks) OnBuiltinCall(name string, args []Object) error { return nil }
+func (NopHooks) OnBuiltinReturn(name string, result Object)     {}
+func (NopHooks) OnError(err error)                              {}
+
+// MultiHooks combines hooks into one calling each of them in order, the nil ones are
+// left out. It returns nil for no hooks. An error of a hook is returned right away,
+// the hooks after it are not called
+func MultiHooks(hooks ...Hooks) Hooks {
+	multi := multiHooks{}
+	for _, h := range hooks {
+		if h != nil {
+			multi = append(multi, h)
+		}
+	}
+
+	switch len(multi) {
+	case 0:
+		return nil
+	case 1:
+		return multi[0]
+	default:
+		return multi
+	}
+}
+
+type multiHooks []Hooks
+
+func (m multiHooks) OnStart() {
+	for _, h := range m {
+		h.OnStart()
+	}
+}
+
+func (m multiHooks) OnEnd(result Object) {
+	for _, h := range m {
+		h.OnEnd(result)
+	}
+}
+
+func (m multiHooks) OnInstruction(pos token.Position) error {
+	for _, h := range m {
+		if err := h.OnInstruction(pos); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m multiHooks) OnCall(name string, args []Object) error {
+	for _, h := range m {
+		if err := h.OnCall(name, args); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m multiHooks) OnReturn(name string, result Object) {
+	for _, h := range m {
+		h.OnReturn(name, result)
+	}
+}
+
+func (m multiHooks) OnBuiltinCall(name string, args []Object) error {
+	for _, h := range m {
+		if err := h.OnBuiltinCall(name, args); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m multiHooks) OnBuiltinReturn(name string, result Object) {
+	for _, h := range m {
+		h.OnBuiltinReturn(name, result)
+	}
+}
+
+func (m multiHooks) OnError(err error) {
+	for _, h := range m {
+		h.OnError(err)
+	}
+}
+
+// BuiltinName returns the name of the builtin fn, empty if it is not one of Builtins
+func BuiltinName(fn *Builtin) string {
+	for _, b := range Builtins {
+		if b.Butiltin == fn {
+			return b.Name
+		}
+	}
+	return ""
+}
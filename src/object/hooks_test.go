@@ -0,0 +1,43 @@
+package object
+
+import (
+	"errors"
+	"testing"
+	"token"
+)
+
+// callCounter counts the calls it sees and fails them with err
+type callCounter struct {
+	NopHooks
+	calls int
+	err   error
+}
+
+func (c *callCounter) OnCall(name string, args []Object) error {
+	c.calls++
+	return c.err
+}
+
+func TestMultiHooks(t *testing.T) {
+	if h := MultiHooks(nil, nil); h != nil {
+		t.Errorf("expected nil for no hooks. got=%T", h)
+	}
+
+	single := &callCounter{}
+	if h := MultiHooks(nil, single); h != single {
+		t.Errorf("expected the only hooks. got=%T", h)
+	}
+
+	errFailed := errors.New("failed")
+	first, second, third := &callCounter{}, &callCounter{err: errFailed}, &callCounter{}
+	h := MultiHooks(first, second, third)
+	if err := h.OnCall("f", nil); err != errFailed {
+		t.Errorf("wrong error. want=%q, got=%v", errFailed, err)
+	}
+	if first.calls != 1 || second.calls != 1 || third.calls != 0 {
+		t.Errorf("wrong calls. got=%d, %d, %d", first.calls, second.calls, third.calls)
+	}
+	if err := h.OnInstruction(token.Position{Line: 1}); err != nil {
+		t.Errorf("wrong error of OnInstruction. got=%v", err)
+	}
+}
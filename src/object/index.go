@@ -0,0 +1,120 @@
+package object
+
+import "fmt"
+
+// Index returns the element of left at index. Elements of Array and String can be
+// counted from the end with a negative index. Strings are indexed by rune
+func Index(left Object, index Object) (Object, error) {
+	switch l := left.(type) {
+	case *Array:
+		i, err := elementIndex(index, len(l.Elements), "Array")
+		if err != nil {
+			return nil, err
+		}
+		return l.Elements[i], nil
+	case *String:
+		runes := []rune(l.Value)
+		i, err := elementIndex(index, len(runes), "String")
+		if err != nil {
+			return nil, err
+		}
+		return &String{Value: string(runes[i])}, nil
+	case *HashTable:
+		h, ok := l.KeyOf(index)
+		if !ok {
+			return nil, fmt.Errorf("expect Hashable for HashTable key, got %q", index.Type())
+		}
+
+		v, ok := l.Get(h, index)
+		if !ok {
+			return NULL, nil
+		}
+		return v, nil
+	default:
+		return nil, fmt.Errorf("unsupported type for index operator, got %q", left.Type())
+	}
+}
+
+// Slice returns the elements of left from start up to but not including end.
+// start and end can be negative like in Index. A nil or NULL start means the first
+// element and a nil or NULL end means the length of left
+func Slice(left Object, start Object, end Object) (Object, error) {
+	switch l := left.(type) {
+	case *Array:
+		from, to, err := sliceBounds(start, end, len(l.Elements))
+		if err != nil {
+			return nil, err
+		}
+
+		elems := make([]Object, to-from)
+		copy(elems, l.Elements[from:to])
+		return &Array{Elements: elems}, nil
+	case *String:
+		runes := []rune(l.Value)
+		from, to, err := sliceBounds(start, end, len(runes))
+		if err != nil {
+			return nil, err
+		}
+		return &String{Value: string(runes[from:to])}, nil
+	default:
+		return nil, fmt.Errorf("unsupported type for slice operator, got %q", left.Type())
+	}
+}
+
+func elementIndex(index Object, length int, collection string) (int, error) {
+	integer, ok := index.(*Integer)
+	if !ok {
+		return 0, fmt.Errorf("expect Integer for %s Index, got %q", collection, index.Type())
+	}
+
+	i := integer.Value
+	if i < 0 {
+		i += int64(length)
+	}
+
+	if i < 0 || i >= int64(length) {
+		return 0, fmt.Errorf("index out of range [%d] with length %d", integer.Value, length)
+	}
+	return int(i), nil
+}
+
+func sliceBounds(start Object, end Object, length int) (int, int, error) {
+	from, err := sliceBound(start, 0, length)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	to, err := sliceBound(end, int64(length), length)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if from < 0 || to > int64(length) || from > to {
+		return 0, 0, fmt.Errorf("slice bounds out of range [%s:%s] with length %d",
+			boundString(start), boundString(end), length)
+	}
+	return int(from), int(to), nil
+}
+
+func sliceBound(bound Object, omitted int64, length int) (int64, error) {
+	if bound == nil || bound == NULL {
+		return omitted, nil
+	}
+
+	integer, ok := bound.(*Integer)
+	if !ok {
+		return 0, fmt.Errorf("expect Integer for slice bound, got %q", bound.Type())
+	}
+
+	if integer.Value < 0 {
+		return integer.Value + int64(length), nil
+	}
+	return integer.Value, nil
+}
+
+func boundString(bound Object) string {
+	if bound == nil || bound == NULL {
+		return ""
+	}
+	return bound.Inspect()
+}
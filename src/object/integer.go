@@ -0,0 +1,178 @@
+package object
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+)
+
+// BigInteger holds integers which do not fit into int64. Integer arithmetic promotes
+// its result to BigInteger on overflow and demotes it back to Integer when it fits again,
+// so an integer value always has exactly one representation
+type BigInteger struct {
+	Value *big.Int
+}
+
+func (b *BigInteger) Type() ObjectType {
+	return BIG_INTEGER_OBJ
+}
+
+func (b *BigInteger) Inspect() string {
+	return b.Value.String()
+}
+
+func (b *BigInteger) Hash(seed HashSeed) HashKey {
+	bs := append([]byte{byte(b.Value.Sign() + 1)}, b.Value.Bytes()...)
+	return HashKey{Type: BIG_INTEGER_OBJ, Value: seed.hashBytes(bs)}
+}
+
+// NewInteger returns an Integer if v fits into int64, otherwise a BigInteger
+func NewInteger(v *big.Int) Object {
+	if v.IsInt64() {
+		return &Integer{Value: v.Int64()}
+	}
+	return &BigInteger{Value: v}
+}
+
+// IsInteger reports whether obj is an Integer or a BigInteger
+func IsInteger(obj Object) bool {
+	switch obj.(type) {
+	case *Integer, *BigInteger:
+		return true
+	default:
+		return false
+	}
+}
+
+func toBigInt(obj Object) *big.Int {
+	switch obj := obj.(type) {
+	case *Integer:
+		return big.NewInt(obj.Value)
+	case *BigInteger:
+		return obj.Value
+	default:
+		return nil
+	}
+}
+
+// IntegerInfix applies operator to two integers. Supported operators are
+// + - * / % and the comparison operators
+func IntegerInfix(operator string, left Object, right Object) (Object, error) {
+	if !IsInteger(left) || !IsInteger(right) {
+		return nil, fmt.Errorf("unsupported operands for integer operator %s: %s and %s", operator, left.Type(), right.Type())
+	}
+
+	if (operator == "/" || operator == "%") && isZero(right) {
+		if operator == "/" {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return nil, fmt.Errorf("modulo by zero")
+	}
+
+	l, lok := left.(*Integer)
+	r, rok := right.(*Integer)
+	if lok && rok {
+		if ret, ok := smallIntegerInfix(operator, l.Value, r.Value); ok {
+			return ret, nil
+		}
+	}
+
+	return bigIntegerInfix(operator, toBigInt(left), toBigInt(right))
+}
+
+// IntegerNegate returns -obj for an integer
+func IntegerNegate(obj Object) (Object, error) {
+	switch obj := obj.(type) {
+	case *Integer:
+		if obj.Value == math.MinInt64 {
+			return NewInteger(new(big.Int).Neg(big.NewInt(obj.Value))), nil
+		}
+		return &Integer{Value: -obj.Value}, nil
+	case *BigInteger:
+		return NewInteger(new(big.Int).Neg(obj.Value)), nil
+	default:
+		return nil, fmt.Errorf("minus operator can not be used as prefix operator for %s", obj.Type())
+	}
+}
+
+func isZero(obj Object) bool {
+	i, ok := obj.(*Integer)
+	return ok && i.Value == 0
+}
+
+// smallIntegerInfix returns false when the result overflows int64
+func smallIntegerInfix(operator string, l int64, r int64) (Object, bool) {
+	switch operator {
+	case "+":
+		ret := l + r
+		if (l > 0 && r > 0 && ret < 0) || (l < 0 && r < 0 && ret >= 0) {
+			return nil, false
+		}
+		return &Integer{Value: ret}, true
+	case "-":
+		ret := l - r
+		if (l >= 0 && r < 0 && ret < 0) || (l < 0 && r > 0 && ret >= 0) {
+			return nil, false
+		}
+		return &Integer{Value: ret}, true
+	case "*":
+		if l == 0 || r == 0 {
+			return &Integer{Value: 0}, true
+		}
+		ret := l * r
+		if ret/r != l || (l == -1 && r == math.MinInt64) || (r == -1 && l == math.MinInt64) {
+			return nil, false
+		}
+		return &Integer{Value: ret}, true
+	case "/":
+		if l == math.MinInt64 && r == -1 {
+			return nil, false
+		}
+		return &Integer{Value: l / r}, true
+	case "%":
+		return &Integer{Value: l % r}, true
+	case "<":
+		return NativeBooleanToBooleanObj(l < r), true
+	case "<=":
+		return NativeBooleanToBooleanObj(l <= r), true
+	case ">":
+		return NativeBooleanToBooleanObj(l > r), true
+	case ">=":
+		return NativeBooleanToBooleanObj(l >= r), true
+	case "==":
+		return NativeBooleanToBooleanObj(l == r), true
+	case "!=":
+		return NativeBooleanToBooleanObj(l != r), true
+	default:
+		return nil, false
+	}
+}
+
+func bigIntegerInfix(operator string, l *big.Int, r *big.Int) (Object, error) {
+	switch operator {
+	case "+":
+		return NewInteger(new(big.Int).Add(l, r)), nil
+	case "-":
+		return NewInteger(new(big.Int).Sub(l, r)), nil
+	case "*":
+		return NewInteger(new(big.Int).Mul(l, r)), nil
+	case "/":
+		return NewInteger(new(big.Int).Quo(l, r)), nil
+	case "%":
+		return NewInteger(new(big.Int).Rem(l, r)), nil
+	case "<":
+		return NativeBooleanToBooleanObj(l.Cmp(r) < 0), nil
+	case "<=":
+		return NativeBooleanToBooleanObj(l.Cmp(r) <= 0), nil
+	case ">":
+		return NativeBooleanToBooleanObj(l.Cmp(r) > 0), nil
+	case ">=":
+		return NativeBooleanToBooleanObj(l.Cmp(r) >= 0), nil
+	case "==":
+		return NativeBooleanToBooleanObj(l.Cmp(r) == 0), nil
+	case "!=":
+		return NativeBooleanToBooleanObj(l.Cmp(r) != 0), nil
+	default:
+		return nil, fmt.Errorf("unknown operator for integer: %s", operator)
+	}
+}
@@ -0,0 +1,153 @@
+package object
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Errors reported when a program exceeds one of its Limits. The errors returned
+// by the vm and the evaluator wrap them, test for them with errors.Is
+var (
+	ErrInstructionLimit = errors.New("instruction limit exceeded")
+	ErrCallDepthLimit   = errors.New("call depth limit exceeded")
+	ErrStackLimit       = errors.New("stack size limit exceeded")
+	ErrAllocationLimit  = errors.New("allocation limit exceeded")
+	ErrMemoryLimit      = errors.New("memory limit exceeded")
+	ErrTimeLimit        = errors.New("time limit exceeded")
+	ErrCanceled         = errors.New("execution canceled")
+)
+
+// Limits bounds the resources a program can use. A zero field means no limit
+type Limits struct {
+	// MaxInstructions is the number of vm instructions, of ast nodes evaluated by the evaluator,
+	// or of statements and calls run by the closures
+	MaxInstructions int64
+	// MaxCallDepth is the number of nested function calls
+	MaxCallDepth int
+	// MaxStackSize is the number of values on the vm stack. The evaluator has no value stack and ignores it
+	MaxStackSize int
+	// MaxAllocations is the number of objects allocated
+	MaxAllocations int64
+	// MaxAllocatedBytes is the estimated size of all objects allocated, see SizeOf
+	MaxAllocatedBytes int64
+	// Timeout is the wall-clock time the program can run
+	Timeout time.Duration
+}
+
+// checkInterval is the number of steps between checks of the context and the clock
+const checkInterval = 1024
+
+// Budget accounts the resources used by one run of a program against its Limits
+type Budget struct {
+	limits   Limits
+	ctx      context.Context
+	deadline time.Time
+
+	steps          int64
+	allocations    int64
+	allocatedBytes int64
+}
+
+func NewBudget(ctx context.Context, limits Limits) *Budget {
+	b := &Budget{limits: limits, ctx: ctx}
+	if limits.Timeout > 0 {
+		b.deadline = time.Now().Add(limits.Timeout)
+	}
+	return b
+}
+
+// Step accounts one instruction. The context and the clock are checked every checkInterval steps
+func (b *Budget) Step() error {
+	b.steps++
+	if b.limits.MaxInstructions > 0 && b.steps > b.limits.MaxInstructions {
+		return fmt.Errorf("%w: more than %d instructions", ErrInstructionLimit, b.limits.MaxInstructions)
+	}
+
+	if b.steps%checkInterval == 0 {
+		return b.check()
+	}
+	return nil
+}
+
+func (b *Budget) check() error {
+	if err := b.ctx.Err(); err != nil {
+		return fmt.Errorf("%w: %s", ErrCanceled, err)
+	}
+
+	if !b.deadline.IsZero() && time.Now().After(b.deadline) {
+		return fmt.Errorf("%w: running longer than %s", ErrTimeLimit, b.limits.Timeout)
+	}
+	return nil
+}
+
+// Alloc accounts a newly allocated obj. TRUE, FALSE and NULL are never allocated
+func (b *Budget) Alloc(obj Object) error {
+	if obj == nil || obj == TRUE || obj == FALSE || obj == NULL {
+		return nil
+	}
+
+	b.allocations++
+	if b.limits.MaxAllocations > 0 && b.allocations > b.limits.MaxAllocations {
+		return fmt.Errorf("%w: more than %d objects", ErrAllocationLimit, b.limits.MaxAllocations)
+	}
+
+	b.allocatedBytes += SizeOf(obj)
+	if b.limits.MaxAllocatedBytes > 0 && b.allocatedBytes > b.limits.MaxAllocatedBytes {
+		return fmt.Errorf("%w: more than %d bytes", ErrMemoryLimit, b.limits.MaxAllocatedBytes)
+	}
+	return nil
+}
+
+// CallDepth checks depth nested calls against the limit. max is the limit of the
+// backend itself, it is used when it is lower than the configured one
+func (b *Budget) CallDepth(depth int, max int) error {
+	if b.limits.MaxCallDepth > 0 && b.limits.MaxCallDepth < max {
+		max = b.limits.MaxCallDepth
+	}
+
+	if depth > max {
+		return fmt.Errorf("%w: more than %d nested calls", ErrCallDepthLimit, max)
+	}
+	return nil
+}
+
+// StackSize checks size values on stack against the limit. max is the limit of the
+// backend itself, it is used when it is lower than the configured one
+func (b *Budget) StackSize(size int, max int) error {
+	if b.limits.MaxStackSize > 0 && b.limits.MaxStackSize < max {
+		max = b.limits.MaxStackSize
+	}
+
+	if size > max {
+		return fmt.Errorf("%w: more than %d values on stack", ErrStackLimit, max)
+	}
+	return nil
+}
+
+// SizeOf estimates the bytes allocated for obj itself, objects it refers to are not counted
+func SizeOf(obj Object) int64 {
+	const word = 8
+	const iface = 2 * word
+
+	switch obj := obj.(type) {
+	case *Integer:
+		return word
+	case *BigInteger:
+		return 4*word + int64(len(obj.Value.Bits()))*word
+	case *String:
+		return 2*word + int64(len(obj.Value))
+	case *Array:
+		return 3*word + int64(len(obj.Elements))*iface
+	case *HashTable:
+		// a pair holds the key, the value and the HashKey, the bucket holds its index
+		return 4*word + int64(len(obj.pairs))*(2*iface+3*word+word)
+	case *Closure:
+		return word + 3*word + int64(len(obj.Free))*iface
+	case *RegisterClosure:
+		return word + 3*word + int64(len(obj.Free))*iface
+	default:
+		return 2 * word
+	}
+}
@@ -5,14 +5,15 @@ import (
 	"bytes"
 	"code"
 	"fmt"
-	"hash/fnv"
 	"strings"
+	"token"
 )
 
 type ObjectType string
 
 const (
 	INTEGER_OBJ           = "INTEGER"
+	BIG_INTEGER_OBJ       = "BIG_INTEGER"
 	BOOLEAN_OBJ           = "BOOLEAN"
 	STRING_OBJ            = "STRING"
 	NULL_OBJ              = "NULL"
@@ -31,13 +32,60 @@ type Object interface {
 	Inspect() string
 }
 
-type Hashable interface {
-	Hash() HashKey
-}
+// Equals compares two objects structurally. Arrays and HashTables are equal when
+// all of their elements are equal. Other objects without a value are compared by identity
+func Equals(left Object, right Object) bool {
+	if left == right {
+		return true
+	}
 
-type HashKey struct {
-	Type  ObjectType
-	Value uint64
+	if left == nil || right == nil || left.Type() != right.Type() {
+		return false
+	}
+
+	switch l := left.(type) {
+	case *Integer:
+		return l.Value == right.(*Integer).Value
+	case *BigInteger:
+		return l.Value.Cmp(right.(*BigInteger).Value) == 0
+	case *Boolean:
+		return l.Value == right.(*Boolean).Value
+	case *String:
+		return l.Value == right.(*String).Value
+	case *Internal_Null:
+		return true
+	case *Array:
+		r := right.(*Array)
+		if len(l.Elements) != len(r.Elements) {
+			return false
+		}
+
+		for i, e := range l.Elements {
+			if !Equals(e, r.Elements[i]) {
+				return false
+			}
+		}
+		return true
+	case *HashTable:
+		r := right.(*HashTable)
+		if l.Len() != r.Len() {
+			return false
+		}
+
+		for _, lp := range l.pairs {
+			hash := lp.hash
+			if l.seed != r.seed {
+				hash, _ = r.KeyOf(lp.Key)
+			}
+			rv, ok := r.Get(hash, lp.Key)
+			if !ok || !Equals(lp.Value, rv) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
 }
 
 type Integer struct {
@@ -52,7 +100,7 @@ func (i *Integer) Inspect() string {
 	return fmt.Sprintf("%d", i.Value)
 }
 
-func (i *Integer) Hash() HashKey {
+func (i *Integer) Hash(seed HashSeed) HashKey {
 	return HashKey{Type: INTEGER_OBJ, Value: uint64(i.Value)}
 }
 
@@ -68,7 +116,7 @@ func (b *Boolean) Inspect() string {
 	return fmt.Sprintf("%t", b.Value)
 }
 
-func (b *Boolean) Hash() HashKey {
+func (b *Boolean) Hash(seed HashSeed) HashKey {
 	var v uint64
 	if b.Value {
 		v = 1
@@ -104,10 +152,8 @@ func (s *String) Inspect() string {
 	return fmt.Sprintf("%s", s.Value)
 }
 
-func (s *String) Hash() HashKey {
-	h := fnv.New64a()
-	h.Write([]byte(s.Value))
-	return HashKey{Type: STRING_OBJ, Value: h.Sum64()}
+func (s *String) Hash(seed HashSeed) HashKey {
+	return HashKey{Type: STRING_OBJ, Value: seed.hashBytes([]byte(s.Value))}
 }
 
 type Internal_Null struct {
@@ -137,8 +183,12 @@ func (r *ReturnValue) Inspect() string {
 	return fmt.Sprintf("return %s", r.Value.Inspect())
 }
 
+// Error is the result of a failed evaluation. Pos is the position of the node which failed,
+// it is zero if unknown. Err is the go error the evaluation failed with, if any
 type Error struct {
 	Msg string
+	Pos token.Position
+	Err error
 }
 
 func (e *Error) Type() ObjectType {
@@ -146,13 +196,44 @@ func (e *Error) Type() ObjectType {
 }
 
 func (e *Error) Inspect() string {
-	return fmt.Sprintf("error: %s", e.Msg)
+	if e.Pos.Line == 0 {
+		return fmt.Sprintf("error: %s", e.Msg)
+	}
+	return fmt.Sprintf("error: %s at line: %d, column: %d", e.Msg, e.Pos.Line, e.Pos.Column)
+}
+
+// Error makes e an error, like the runtime errors of the vm, for the hooks of a run
+func (e *Error) Error() string {
+	if e.Pos.Line == 0 {
+		return e.Msg
+	}
+	return fmt.Sprintf("%s at line: %d, column: %d", e.Msg, e.Pos.Line, e.Pos.Column)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// VariableTable holds the addresses of the variables of a program. The evaluator keeps the
+// table the resolver made for the program a function is in in the function
+type VariableTable interface {
+	// NumSlots returns the number of the parameters and the locals of node
+	NumSlots(node *ast.FunctionExpression) int
 }
 
 type Function struct {
 	Parameters []*ast.Identifier
 	Body       *ast.BlockExpression
 	Env        *Environment
+	// NumSlots is the number of the slots of the environment of a call. Table holds the
+	// addresses of the variables of the program the function is in
+	NumSlots int
+	Table    VariableTable
+
+	// Name is the name the function is bound to by a let statement, empty for other
+	// functions. Pos is the position of the function expression
+	Name string
+	Pos  token.Position
 }
 
 func (f *Function) Type() ObjectType {
@@ -198,37 +279,15 @@ func (a *Array) Inspect() string {
 	return buffer.String()
 }
 
-type HashPair struct {
-	Key   Object
-	Value Object
-}
-type HashTable struct {
-	Pair map[HashKey]HashPair
-}
-
-func (h *HashTable) Type() ObjectType {
-	return HASHTABLE_OBJ
-}
-
-func (h *HashTable) Inspect() string {
-	var buffer bytes.Buffer
-	buffer.WriteString("{")
-
-	pairs := []string{}
-	for _, v := range h.Pair {
-		pairs = append(pairs, fmt.Sprintf("%s:%s", v.Key.Inspect(), v.Value.Inspect()))
-	}
-
-	buffer.WriteString(strings.Join(pairs, ", "))
-	buffer.WriteString("}")
-
-	return buffer.String()
-}
-
 type BuiltinFunction func(args ...Object) Object
 
 type Builtin struct {
 	Fn BuiltinFunction
+	// Arity is the number of arguments Fn takes
+	Arity int
+	// ReturnsArgument reports whether Fn returns an argument or an element of one, which
+	// is not a new object and is not accounted as allocated by the call
+	ReturnsArgument bool
 }
 
 func (f *Builtin) Type() ObjectType {
@@ -239,42 +298,106 @@ func (f *Builtin) Inspect() string {
 	return "builtin function"
 }
 
+// NewEnvironment returns an environment for the globals, they are known by name
 func NewEnvironment() *Environment {
-	return &Environment{storage: make(map[string]Object)}
+	return &Environment{names: make(map[string]int)}
 }
 
-func NewNestedEnvironment(outer *Environment) *Environment {
-	env := NewEnvironment()
-	env.outer = outer
-	return env
+// NewNestedEnvironment returns the environment of a call with size slots, outer is the
+// environment the function is defined in
+func NewNestedEnvironment(outer *Environment, size int) *Environment {
+	return &Environment{slots: make([]Object, size), outer: outer}
 }
 
+// Environment holds the variables in slots. The evaluator finds a variable by the
+// address the resolver gave its identifier, the depth of the environment out from the
+// current one and the slot in it
 type Environment struct {
-	storage map[string]Object
-	outer   *Environment
+	slots []Object
+	outer *Environment
+
+	// names maps the names of the globals to their slots
+	names map[string]int
 }
 
-func (e *Environment) Set(key string, val Object) Object {
-	e.storage[key] = val
+// Define returns the slot of the global name, a new one if name is not defined yet
+func (e *Environment) Define(name string) int {
+	if slot, ok := e.names[name]; ok {
+		return slot
+	}
+
+	if e.names == nil {
+		e.names = make(map[string]int)
+	}
+	slot := len(e.names)
+	e.names[name] = slot
+	return slot
+}
+
+// Lookup returns the slot of the global name
+func (e *Environment) Lookup(name string) (int, bool) {
+	slot, ok := e.names[name]
+	return slot, ok
+}
+
+// GetAt returns the value in slot of the environment depth out from e, nil if the
+// variable has no value yet
+func (e *Environment) GetAt(depth int, slot int) Object {
+	for ; depth > 0; depth-- {
+		e = e.outer
+	}
+
+	if slot < len(e.slots) {
+		return e.slots[slot]
+	}
+	return nil
+}
+
+// SetAt sets the value in slot of the environment depth out from e
+func (e *Environment) SetAt(depth int, slot int, val Object) Object {
+	for ; depth > 0; depth-- {
+		e = e.outer
+	}
+
+	for slot >= len(e.slots) {
+		e.slots = append(e.slots, nil)
+	}
+	e.slots[slot] = val
 	return val
 }
 
+// Set sets the global key
+func (e *Environment) Set(key string, val Object) Object {
+	return e.SetAt(0, e.Define(key), val)
+}
+
+// Get returns the value of the global key
 func (e *Environment) Get(key string) (Object, bool) {
-	val, ok := e.storage[key]
-	if ok {
-		return val, ok
+	slot, ok := e.names[key]
+	if !ok {
+		return nil, false
 	}
 
-	if e.outer != nil {
-		return e.outer.Get(key)
-	}
-	return nil, false
+	val := e.GetAt(0, slot)
+	return val, val != nil
 }
 
 type CompiledFunction struct {
 	Instructions  code.Instructions
+	SourceMap     code.SourceMap
+	Lines         code.LineTable
 	NumLocals     int
 	NumParameters int
+
+	// LocalNames are the names of the locals by their indexes, parameters first.
+	// FreeNames are the names of the free variables of the closures of the function
+	LocalNames []string
+	FreeNames  []string
+
+	// Name is the name the function is bound to by a let statement, empty for other
+	// functions. Pos is the position of the function expression
+	Name string
+	Pos  token.Position
 }
 
 func (cf *CompiledFunction) Type() ObjectType {
@@ -282,7 +405,7 @@ func (cf *CompiledFunction) Type() ObjectType {
 }
 
 func (cf *CompiledFunction) Inspect() string {
-	return fmt.Sprint("CompiledFunction[%p]", cf)
+	return fmt.Sprintf("CompiledFunction[%p]", cf)
 }
 
 type Closure struct {
@@ -295,5 +418,36 @@ func (c *Closure) Type() ObjectType {
 }
 
 func (c *Closure) Inspect() string {
-	return fmt.Sprint("Closure[%p]", c)
+	return fmt.Sprintf("Closure[%p]", c)
+}
+
+// RegisterFunction is a function compiled for the register vm. Its parameters are
+// its first registers
+type RegisterFunction struct {
+	Instructions  code.RegisterInstructions
+	SourceMap     code.SourceMap
+	NumRegisters  int
+	NumParameters int
+	NumFree       int
+}
+
+func (rf *RegisterFunction) Type() ObjectType {
+	return COMPILED_FUNCTION_OBJ
+}
+
+func (rf *RegisterFunction) Inspect() string {
+	return fmt.Sprintf("RegisterFunction[%p]", rf)
+}
+
+type RegisterClosure struct {
+	Fn   *RegisterFunction
+	Free []Object
+}
+
+func (c *RegisterClosure) Type() ObjectType {
+	return CLOJURE_OBJ
+}
+
+func (c *RegisterClosure) Inspect() string {
+	return fmt.Sprintf("Closure[%p]", c)
 }
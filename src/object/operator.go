@@ -0,0 +1,27 @@
+package object
+
+import "fmt"
+
+// Infix applies the infix operator of the vm to left and right: the operators of
+// IntegerInfix on integers, + on strings, and == and != on any objects, compared by
+// Equals. The vm, its register version and the translated programs all run their
+// operators with it, so they give the same results and the same errors
+func Infix(operator string, left Object, right Object) (Object, error) {
+	if IsInteger(left) && IsInteger(right) {
+		return IntegerInfix(operator, left, right)
+	}
+
+	switch operator {
+	case "==":
+		return NativeBooleanToBooleanObj(Equals(left, right)), nil
+	case "!=":
+		return NativeBooleanToBooleanObj(!Equals(left, right)), nil
+	}
+
+	l, lok := left.(*String)
+	r, rok := right.(*String)
+	if lok && rok && operator == "+" {
+		return &String{Value: l.Value + r.Value}, nil
+	}
+	return nil, fmt.Errorf("unsupported operator %s for %s and %s", operator, left.Type(), right.Type())
+}
@@ -3,6 +3,7 @@ package parser
 import (
 	"ast"
 	"fmt"
+	"io"
 	"lexer"
 	"strconv"
 	"token"
@@ -23,12 +24,21 @@ func (p ParserError) Error() string {
 	return fmt.Sprintf("%s at line: %d, column: %d", p.msg, p.pos.Line, p.pos.Column)
 }
 
+// Pos returns the position of the source the parser failed at
+func (p ParserError) Pos() token.Position {
+	return p.pos
+}
+
 type Parser struct {
 	lex         *lexer.Lexer
 	initialized bool
+	// lexer reads the first rune in lexer.New, an error of it is kept here
+	// and returned by ParseProgram
+	newErr error
 
 	tracing     bool
 	traceIndent int
+	traceOut    io.Writer
 
 	currentToken token.Token
 	peekToken    token.Token
@@ -38,12 +48,18 @@ type Parser struct {
 }
 
 func New(input string) *Parser {
+	p := Parser{prefixFns: make(map[token.TokenType]prefixParseFn),
+		infixFns: make(map[token.TokenType]infixParseFn)}
 	handler := func(pos token.Position, msg string) {
-		panic(fmt.Sprintf("%s at line: %d, column: %d", msg, pos.Line, pos.Column))
+		if p.lex == nil {
+			if p.newErr == nil {
+				p.newErr = ParserError{msg: msg, pos: pos}
+			}
+			return
+		}
+		panic(ParserError{msg: msg, pos: pos})
 	}
-	p := Parser{lex: lexer.New(input, handler),
-		prefixFns: make(map[token.TokenType]prefixParseFn),
-		infixFns:  make(map[token.TokenType]infixParseFn)}
+	p.lex = lexer.New(input, handler)
 
 	p.registerPrefixParseFn(token.IDENT, p.parseIdentifier)
 	p.registerPrefixParseFn(token.INT, p.parseInteger)
@@ -75,6 +91,12 @@ func New(input string) *Parser {
 	return &p
 }
 
+// SetTracing makes the parser write the productions it parses, indented by their
+// nesting, to w. A nil w turns tracing off
+func (p *Parser) SetTracing(w io.Writer) {
+	p.tracing, p.traceOut = w != nil, w
+}
+
 func (p *Parser) registerPrefixParseFn(tokenType token.TokenType, prefixFn prefixParseFn) {
 	p.prefixFns[tokenType] = prefixFn
 }
@@ -100,16 +122,17 @@ func (p *Parser) currentTokenPrecedence() int {
 func (p *Parser) printTrace(a ...interface{}) {
 	const dots = ". . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . "
 	const n = len(dots)
+	out := p.traceOut
 	pos := p.currentToken.Pos
-	fmt.Printf("%5d:%3d: ", pos.Line, pos.Column)
+	fmt.Fprintf(out, "%5d:%3d: ", pos.Line, pos.Column)
 	i := 2 * p.traceIndent
 	for i > n {
-		fmt.Print(dots)
+		fmt.Fprint(out, dots)
 		i -= n
 	}
 	// i <= n
-	fmt.Print(dots[0:i])
-	fmt.Println(a...)
+	fmt.Fprint(out, dots[0:i])
+	fmt.Fprintln(out, a...)
 }
 
 func trace(p *Parser, msg string) *Parser {
@@ -219,7 +242,7 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 
 	prefixFn := p.prefixFns[p.currentToken.Type]
 	if prefixFn == nil {
-		panic(ParserError{msg: fmt.Sprintf("can not parse token type %q", p.currentToken.Type), errorToken: p.currentToken})
+		panic(ParserError{msg: fmt.Sprintf("can not parse token type %q", p.currentToken.Type), errorToken: p.currentToken, pos: p.currentToken.Pos})
 	}
 
 	left := prefixFn()
@@ -261,7 +284,7 @@ func (p *Parser) assertCurrentTokenType(expect token.TokenType) {
 
 func (p *Parser) assertTokenType(expect token.TokenType, actual token.Token) {
 	if actual.Type != expect {
-		panic(ParserError{msg: fmt.Sprintf("expectd token type is %q, got %q", expect, actual.Type), errorToken: actual})
+		panic(ParserError{msg: fmt.Sprintf("expectd token type is %q, got %q", expect, actual.Type), errorToken: actual, pos: actual.Pos})
 	} else {
 		p.nextToken()
 	}
@@ -278,7 +301,7 @@ func (p *Parser) parseIdentifier() ast.Expression {
 func (p *Parser) parseInteger() ast.Expression {
 	value, err := strconv.ParseInt(p.currentToken.Literal, 0, 64)
 	if err != nil {
-		panic(ParserError{msg: fmt.Sprintf("could not parse %q as intger", p.currentToken.Literal), errorToken: p.currentToken})
+		panic(ParserError{msg: fmt.Sprintf("could not parse %q as intger", p.currentToken.Literal), errorToken: p.currentToken, pos: p.currentToken.Pos})
 	}
 
 	return &ast.Integer{Token: p.currentToken, Value: value}
@@ -291,7 +314,7 @@ func (p *Parser) parseBoolean() ast.Expression {
 	} else if p.currentToken.Type == token.FALSE {
 		value = false
 	} else {
-		panic(ParserError{msg: fmt.Sprintf("could not parse %q as boolean", p.currentToken.Literal), errorToken: p.currentToken})
+		panic(ParserError{msg: fmt.Sprintf("could not parse %q as boolean", p.currentToken.Literal), errorToken: p.currentToken, pos: p.currentToken.Pos})
 	}
 
 	return &ast.Boolean{Token: p.currentToken, Value: value}
@@ -367,7 +390,7 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	ifExpress := &ast.IfExpression{Token: p.currentToken}
 
 	if !p.currentTokenTypeIs(token.IF) {
-		panic(ParserError{msg: fmt.Sprintf("expectd token type is %q, got %q", token.IF, p.currentToken.Type), errorToken: p.currentToken})
+		panic(ParserError{msg: fmt.Sprintf("expectd token type is %q, got %q", token.IF, p.currentToken.Type), errorToken: p.currentToken, pos: p.currentToken.Pos})
 	}
 
 	p.assertNextTokenType(token.LPAREN)
@@ -412,8 +435,9 @@ func (p *Parser) parseBlockExpression() *ast.BlockExpression {
 	}
 
 	if !p.currentTokenTypeIs(token.RBRACE) {
-		panic(ParserError{msg: fmt.Sprintf("expectd token type is %q, got %q", token.RBRACE, p.currentToken.Type), errorToken: p.currentToken})
+		panic(ParserError{msg: fmt.Sprintf("expectd token type is %q, got %q", token.RBRACE, p.currentToken.Type), errorToken: p.currentToken, pos: p.currentToken.Pos})
 	}
+	block.End = p.currentToken.Pos
 
 	return block
 }
@@ -450,10 +474,6 @@ func (p *Parser) parseArrayLiteral() ast.Expression {
 	array := &ast.ArrayLiteral{Token: p.currentToken}
 
 	p.assertCurrentTokenType(token.LBRACKET)
-	if p.peekTokenTypeIs(token.RBRACKET) {
-		p.nextToken()
-		return array
-	}
 
 	for p.currentToken.Type != token.RBRACKET && p.currentToken.Type != token.EOF {
 		array.Elements = append(array.Elements, p.parseExpression(token.LOWEST_PRECEDENCE))
@@ -464,8 +484,9 @@ func (p *Parser) parseArrayLiteral() ast.Expression {
 	}
 
 	if p.currentToken.Type != token.RBRACKET {
-		panic(ParserError{msg: fmt.Sprintf("expectd token type is %q, got %q", token.RBRACKET, p.currentToken.Type), errorToken: p.currentToken})
+		panic(ParserError{msg: fmt.Sprintf("expectd token type is %q, got %q", token.RBRACKET, p.currentToken.Type), errorToken: p.currentToken, pos: p.currentToken.Pos})
 	}
+	array.End = p.currentToken.Pos
 	return array
 }
 
@@ -486,7 +507,7 @@ func (p *Parser) parseParameters() []*ast.Identifier {
 	}
 
 	if !p.currentTokenTypeIs(token.RPAREN) {
-		panic(ParserError{msg: fmt.Sprintf("expectd token type is %q, got %q", token.RPAREN, p.currentToken.Type), errorToken: p.currentToken})
+		panic(ParserError{msg: fmt.Sprintf("expectd token type is %q, got %q", token.RPAREN, p.currentToken.Type), errorToken: p.currentToken, pos: p.currentToken.Pos})
 	}
 
 	return params
@@ -497,13 +518,9 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 		defer un(trace(p, "HashLiteral"))
 	}
 
-	hash := &ast.HashLiteral{Token: p.currentToken, Pair: make(map[ast.Expression]ast.Expression)}
+	hash := &ast.HashLiteral{Token: p.currentToken}
 
 	p.assertCurrentTokenType(token.LBRACE)
-	if p.peekTokenTypeIs(token.RBRACE) {
-		p.nextToken()
-		return hash
-	}
 
 	for p.currentToken.Type != token.RBRACE && p.currentToken.Type != token.EOF {
 		k := p.parseExpression(token.LOWEST_PRECEDENCE)
@@ -511,7 +528,7 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 		p.nextToken()
 		v := p.parseExpression(token.LOWEST_PRECEDENCE)
 
-		hash.Pair[k] = v
+		hash.Pairs = append(hash.Pairs, &ast.HashPair{Key: k, Value: v})
 		p.nextToken()
 		if p.currentTokenTypeIs(token.COMMA) {
 			p.nextToken()
@@ -519,8 +536,9 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 	}
 
 	if p.currentToken.Type != token.RBRACE {
-		panic(ParserError{msg: fmt.Sprintf("expectd token type is %q, got %q", token.RBRACE, p.currentToken.Type), errorToken: p.currentToken})
+		panic(ParserError{msg: fmt.Sprintf("expectd token type is %q, got %q", token.RBRACE, p.currentToken.Type), errorToken: p.currentToken, pos: p.currentToken.Pos})
 	}
+	hash.End = p.currentToken.Pos
 	return hash
 }
 
@@ -543,6 +561,7 @@ func (p *Parser) parseCallExpression(fun ast.Expression) ast.Expression {
 		p.nextToken()
 	}
 	call.Arguments = args
+	call.End = p.currentToken.Pos
 	return call
 }
 
@@ -551,15 +570,33 @@ func (p *Parser) parseIndexExpression(ex ast.Expression) ast.Expression {
 		defer un(trace(p, "IndexExpression"))
 	}
 
-	indexEx := &ast.InfixExpression{Token: p.currentToken, Left: ex, Operator: p.currentToken.Literal}
+	lbracket := p.currentToken
 
 	p.assertCurrentTokenType(token.LBRACKET)
 
-	index := p.parseExpression(token.LOWEST_PRECEDENCE)
-	indexEx.Right = index
+	var start ast.Expression
+	if !p.currentTokenTypeIs(token.COLON) {
+		start = p.parseExpression(token.LOWEST_PRECEDENCE)
+		if !p.peekTokenTypeIs(token.COLON) {
+			p.assertNextTokenType(token.RBRACKET)
+			return &ast.InfixExpression{Token: lbracket, Left: ex, Operator: lbracket.Literal, Right: start}
+		}
+
+		p.nextToken()
+	}
+
+	// p.currentToken points to the COLON of a slice expression
+	slice := &ast.SliceExpression{Token: lbracket, Left: ex, Start: start}
+	if p.peekTokenTypeIs(token.RBRACKET) {
+		p.nextToken()
+		return slice
+	}
+
+	p.nextToken()
+	slice.End = p.parseExpression(token.LOWEST_PRECEDENCE)
 
 	p.assertNextTokenType(token.RBRACKET)
-	return indexEx
+	return slice
 }
 
 func (p *Parser) ParseProgram() (program *ast.Program, err error) {
@@ -569,11 +606,19 @@ func (p *Parser) ParseProgram() (program *ast.Program, err error) {
 
 	defer func() {
 		if r := recover(); r != nil {
-			err = r.(error)
+			if e, ok := r.(error); ok {
+				err = e
+			} else {
+				err = fmt.Errorf("%v", r)
+			}
 			program = nil
 		}
 	}()
 
+	if p.newErr != nil {
+		return nil, p.newErr
+	}
+
 	if !p.initialized {
 		p.nextToken()
 		p.nextToken()
@@ -589,6 +634,7 @@ func (p *Parser) ParseProgram() (program *ast.Program, err error) {
 
 		p.nextToken()
 	}
+	program.Comments = p.lex.Comments
 
 	return program, nil
 }
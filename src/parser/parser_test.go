@@ -2,7 +2,10 @@ package parser
 
 import (
 	"ast"
+	"bytes"
+	"io/ioutil"
 	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -97,6 +100,33 @@ func TestParseHashLiteral(t *testing.T) {
 	}
 }
 
+func TestParseHashLiteralKeepsSourceOrder(t *testing.T) {
+	input := `{"z": 1, "a": 2, "m": 3, 10: 4, 2: 5}`
+
+	program := parseTestingProgram(t, input, 1)
+	express, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("statement not *ast.ExpressionStatement. got '%T'", program.Statements[0])
+	}
+
+	hash := express.Value.(*ast.HashLiteral)
+	expectKeys := []string{"z", "a", "m", "10", "2"}
+	if len(hash.Pairs) != len(expectKeys) {
+		t.Fatalf("hash pairs length is not %d. got %d", len(expectKeys), len(hash.Pairs))
+	}
+
+	for i, key := range expectKeys {
+		if hash.Pairs[i].Key.String() != key {
+			t.Errorf("key %d is not %q. got %q", i, key, hash.Pairs[i].Key.String())
+		}
+	}
+
+	expectString := "{z:1, a:2, m:3, 10:4, 2:5}"
+	if hash.String() != expectString {
+		t.Errorf("parsed not expected statement %q. got %q", expectString, hash.String())
+	}
+}
+
 func TestIndexExpression(t *testing.T) {
 	tests := []struct {
 		input                    string
@@ -105,6 +135,13 @@ func TestIndexExpression(t *testing.T) {
 		{"array[111]", "(array [ 111)"},
 		{"(x + y)[a + b]", "((x + y) [ (a + b))"},
 		{"(fn(x){return x})(100)[44]", "((fn (x) {return x; })(100) [ 44)"},
+		{"a[[]]", "(a [ [])"},
+		{"a[[[1], []]]", "(a [ [[1], []])"},
+		{"a[1:3]", "(a [ 1:3)"},
+		{"a[x + 1:]", "(a [ (x + 1):)"},
+		{"a[:-1]", "(a [ :(-1))"},
+		{"a[:]", "(a [ :)"},
+		{"a[1:2][0]", "((a [ 1:2) [ 0)"},
 	}
 
 	for _, test := range tests {
@@ -331,6 +368,31 @@ func TestFunctionExpression(t *testing.T) {
 	}
 }
 
+func TestSetTracing(t *testing.T) {
+	var buf bytes.Buffer
+	par := New("let x = 5;")
+	par.SetTracing(&buf)
+	if _, err := par.ParseProgram(); err != nil {
+		t.Fatalf("parse program failed. error is: %q", err.Error())
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) < 2 || !strings.HasSuffix(lines[0], " (") || !strings.HasSuffix(lines[len(lines)-1], ")") {
+		t.Fatalf("wrong trace. got=%q", buf.String())
+	}
+
+	buf.Reset()
+	par = New("let x = 5;")
+	par.SetTracing(&buf)
+	par.SetTracing(nil)
+	if _, err := par.ParseProgram(); err != nil {
+		t.Fatalf("parse program failed. error is: %q", err.Error())
+	}
+	if buf.Len() != 0 {
+		t.Errorf("traced after tracing was turned off. got=%q", buf.String())
+	}
+}
+
 func TestCallExpression(t *testing.T) {
 	input := `hello(x, y) + a;`
 
@@ -452,7 +514,7 @@ func testBooleanExpression(t *testing.T, expression ast.Expression, v bool) bool
 
 func parseTestingProgram(t *testing.T, input string, expectedStatementCount int) *ast.Program {
 	par := New(input)
-	par.tracing = true
+	par.SetTracing(ioutil.Discard)
 
 	program, err := par.ParseProgram()
 	if err != nil {
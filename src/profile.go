@@ -0,0 +1,127 @@
+package main
+
+import (
+	"ast"
+	"compiler"
+	"evaluator"
+	"flag"
+	"fmt"
+	"instrument"
+	"io/ioutil"
+	"object"
+	"os"
+	"parser"
+	"time"
+	"vm"
+)
+
+// profile runs a gorilla program on the vm and reports where it spends its time
+func profile(args []string) int {
+	flags := flag.NewFlagSet("profile", flag.ExitOnError)
+	folded := flags.String("folded", "", "also write the stacks of calls to this file, in the folded format of flame graph tools")
+	compare := flags.Bool("compare", false, "also run the program with the interpreter and report how much slower it is")
+	noOptimize := flags.Bool("no-optimize", false, "compile without constant folding, dead code elimination and peephole optimization")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: gorilla profile [--folded file] [--compare] file.gor\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	input, err := ioutil.ReadFile(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "read program failed: %s\n", err)
+		return 1
+	}
+	source := string(input)
+
+	program, err := parser.New(source).ParseProgram()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "parse program failed: %s\n", err)
+		return 1
+	}
+
+	bytecode, _, err := compile(program, !*noOptimize)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 1
+	}
+
+	p := instrument.NewProfiler()
+	machine := vm.New(bytecode)
+	machine.SetProfiler(p)
+	if err := machine.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "vm run program failed: %s\n", err)
+		return 1
+	}
+
+	p.WriteReport(os.Stdout, source)
+
+	if *folded != "" {
+		f, err := os.Create(*folded)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "write folded stacks failed: %s\n", err)
+			return 1
+		}
+		p.WriteFolded(f)
+		if err := f.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "write folded stacks failed: %s\n", err)
+			return 1
+		}
+	}
+
+	if *compare {
+		return compareWithInterpreter(program, bytecode)
+	}
+	return 0
+}
+
+// compileProgram compiles source for the vm, it also returns the names of the globals
+func compileProgram(source string, optimize bool) (*compiler.Bytecode, []string, error) {
+	program, err := parser.New(source).ParseProgram()
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse program failed: %s", err)
+	}
+	return compile(program, optimize)
+}
+
+// compile compiles program for the vm, it also returns the names of the globals
+func compile(program *ast.Program, optimize bool) (*compiler.Bytecode, []string, error) {
+	c := compiler.New()
+	if !optimize {
+		c.DisableOptimizations()
+	}
+	if err := c.Compile(program); err != nil {
+		return nil, nil, fmt.Errorf("compile program failed: %s", err)
+	}
+	return c.Bytecode(), c.GlobalNames(), nil
+}
+
+// compareWithInterpreter times the program on the vm, without the profiler slowing it
+// down, and with the interpreter
+func compareWithInterpreter(program *ast.Program, bytecode *compiler.Bytecode) int {
+	start := time.Now()
+	if err := vm.New(bytecode).Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "vm run program failed: %s\n", err)
+		return 1
+	}
+	vmTime := time.Since(start)
+
+	start = time.Now()
+	result := evaluator.Eval(program, object.NewEnvironment())
+	evalTime := time.Since(start)
+	if evaluator.IsError(result) {
+		fmt.Fprintf(os.Stderr, "evaluate program failed: %s\n", result.Inspect())
+		return 1
+	}
+
+	fmt.Printf("\ncompare:\n%14s  vm\n%14s  interpreter\n", vmTime, evalTime)
+	if vmTime > 0 {
+		fmt.Printf("the interpreter is %.2fx slower than the vm\n", float64(evalTime)/float64(vmTime))
+	}
+	return 0
+}
@@ -0,0 +1,194 @@
+package repl
+
+import (
+	"bufio"
+	"compiler"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"vm"
+)
+
+// DEBUG_PROMPT is the prompt of Debug while the program is stopped
+const DEBUG_PROMPT = "(debug) "
+
+const debugHelp = `commands:
+  break LINE [if CONDITION]  stop at LINE, when CONDITION is true if given (b)
+  delete ID                  delete the breakpoint ID
+  breakpoints                list the breakpoints
+  continue                   run until a breakpoint (c)
+  step                       stop at the next statement, in a called function too (s)
+  next                       stop at the next statement of this function (n)
+  out                        stop once this function returns (o)
+  stack                      list the calls of the stack (bt)
+  frame N                    select the frame N of the stack for locals and print (f)
+  locals                     list the variables of the selected frame
+  print EXPRESSION           evaluate EXPRESSION in the selected frame (p)
+  list                       show the source around the current line (l)
+  quit                       stop the program (q)
+an empty line repeats the last command
+`
+
+// debugSession reads the commands of a user while the program is stopped
+type debugSession struct {
+	scanner *bufio.Scanner
+	out     io.Writer
+	lines   []string
+
+	// frame is the selected frame, 0 is the current call
+	frame int
+	line  int
+	last  string
+}
+
+// Debug runs bytecode, compiled from source, on the vm and stops it at its first
+// statement, at breakpoints and after steps to read commands from in
+func Debug(in io.Reader, out io.Writer, source string, bytecode *compiler.Bytecode, globalNames []string) error {
+	s := &debugSession{scanner: bufio.NewScanner(in), out: out, lines: strings.Split(source, "\n")}
+
+	machine := vm.New(bytecode)
+	vm.NewDebugger(machine, globalNames, s.stopped)
+	if err := machine.Run(); err != nil {
+		return err
+	}
+
+	result := "null"
+	if top := machine.StackLastTop(); top != nil {
+		result = top.Inspect()
+	}
+	fmt.Fprintf(out, "program finished: %s\n", result)
+	return nil
+}
+
+func (s *debugSession) stopped(d *vm.Debugger, stop vm.Stop) error {
+	s.frame, s.line = 0, stop.Pos.Line
+	if stop.Err != nil {
+		fmt.Fprintf(s.out, "%s\n", stop.Err)
+	}
+	if stop.Breakpoint != nil {
+		fmt.Fprintf(s.out, "breakpoint %d at line %d\n", stop.Breakpoint.ID, stop.Pos.Line)
+	} else {
+		fmt.Fprintf(s.out, "stopped at line %d\n", stop.Pos.Line)
+	}
+	s.writeLine(s.line, true)
+
+	for {
+		fmt.Fprintf(s.out, DEBUG_PROMPT)
+		if !s.scanner.Scan() {
+			return vm.ErrQuit
+		}
+
+		command := strings.TrimSpace(s.scanner.Text())
+		if command == "" {
+			command = s.last
+		}
+		s.last = command
+
+		resume, err := s.command(d, command)
+		if err != nil {
+			return err
+		}
+		if resume {
+			return nil
+		}
+	}
+}
+
+// command runs a command, it reports whether the program goes on
+func (s *debugSession) command(d *vm.Debugger, command string) (bool, error) {
+	name, arg, _ := strings.Cut(command, " ")
+	arg = strings.TrimSpace(arg)
+
+	switch name {
+	case "":
+	case "continue", "c":
+		d.Continue()
+		return true, nil
+	case "step", "s":
+		d.StepIn()
+		return true, nil
+	case "next", "n":
+		d.StepOver()
+		return true, nil
+	case "out", "o":
+		d.StepOut()
+		return true, nil
+	case "quit", "q":
+		return false, vm.ErrQuit
+	case "break", "b":
+		lineArg, condition, _ := strings.Cut(arg, " if ")
+		line, err := strconv.Atoi(strings.TrimSpace(lineArg))
+		if err != nil {
+			fmt.Fprintf(s.out, "usage: break LINE [if CONDITION]\n")
+			break
+		}
+		b, err := d.Break(line, strings.TrimSpace(condition))
+		if err != nil {
+			fmt.Fprintf(s.out, "break failed: %s\n", err)
+			break
+		}
+		fmt.Fprintf(s.out, "breakpoint %d at line %d\n", b.ID, b.Line)
+	case "delete":
+		id, err := strconv.Atoi(arg)
+		if err != nil || !d.Delete(id) {
+			fmt.Fprintf(s.out, "no breakpoint %s\n", arg)
+		}
+	case "breakpoints":
+		for _, b := range d.Breakpoints {
+			fmt.Fprintf(s.out, "%3d  line %d", b.ID, b.Line)
+			if b.Condition != "" {
+				fmt.Fprintf(s.out, " if %s", b.Condition)
+			}
+			fmt.Fprintf(s.out, ", hit %d times\n", b.Hits)
+		}
+	case "stack", "bt":
+		for i, f := range d.Stack() {
+			marker := " "
+			if i == s.frame {
+				marker = "*"
+			}
+			fmt.Fprintf(s.out, "%s%3d  %s at line: %d, column: %d\n", marker, i, f.Name, f.Pos.Line, f.Pos.Column)
+		}
+	case "frame", "f":
+		stack := d.Stack()
+		i, err := strconv.Atoi(arg)
+		if err != nil || i < 0 || i >= len(stack) {
+			fmt.Fprintf(s.out, "no frame %s, the stack has %d\n", arg, len(stack))
+			break
+		}
+		s.frame, s.line = i, stack[i].Pos.Line
+		fmt.Fprintf(s.out, "%3d  %s at line: %d, column: %d\n", i, stack[i].Name, stack[i].Pos.Line, stack[i].Pos.Column)
+	case "locals":
+		for _, v := range d.Variables(s.frame) {
+			fmt.Fprintf(s.out, "%s %s = %s\n", v.Kind, v.Name, v.Value.Inspect())
+		}
+	case "print", "p":
+		value, err := d.Evaluate(s.frame, arg)
+		if err != nil {
+			fmt.Fprintf(s.out, "evaluate failed: %s\n", err)
+			break
+		}
+		fmt.Fprintf(s.out, "%s\n", value.Inspect())
+	case "list", "l":
+		for line := s.line - 3; line <= s.line+3; line++ {
+			s.writeLine(line, line == s.line)
+		}
+	case "help", "h":
+		io.WriteString(s.out, debugHelp)
+	default:
+		fmt.Fprintf(s.out, "unknown command %q, help lists the commands\n", name)
+	}
+	return false, nil
+}
+
+func (s *debugSession) writeLine(line int, current bool) {
+	if line < 1 || line > len(s.lines) {
+		return
+	}
+	marker := " "
+	if current {
+		marker = ">"
+	}
+	fmt.Fprintf(s.out, "%s%4d  %s\n", marker, line, s.lines[line-1])
+}
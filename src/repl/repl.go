@@ -1,10 +1,14 @@
 package repl
 
 import (
+	"ast"
 	"bufio"
+	"closure"
 	"compiler"
+	"context"
 	"evaluator"
 	"fmt"
+	"instrument"
 	"io"
 	"object"
 	"parser"
@@ -13,72 +17,159 @@ import (
 
 const PROMPT = ">>"
 
-func StartWithInterpreter(in io.Reader, out io.Writer) {
+// runner runs a program read by the repl and returns its result. The programs are run
+// one after another by the same runner, which keeps the globals they share
+type runner func(program *ast.Program) (object.Object, error)
+
+// loop reads the lines of in, parses each into a program, runs it with run and writes
+// its result or its error to out. A line naming one of commands runs the command instead
+func loop(in io.Reader, out io.Writer, commands map[string]func(out io.Writer), run runner) {
 	scanner := bufio.NewScanner(in)
-	env := object.NewEnvironment()
 	for {
-		fmt.Printf(PROMPT)
-		scanned := scanner.Scan()
-		if !scanned {
+		fmt.Fprintf(out, PROMPT)
+		if !scanner.Scan() {
 			return
 		}
 
 		line := scanner.Text()
-		parser := parser.New(line)
-		program, err := parser.ParseProgram()
+		if command, ok := commands[line]; ok {
+			command(out)
+			continue
+		}
+
+		program, err := parser.New(line).ParseProgram()
 		if err != nil {
-			fmt.Printf("parse program failed: %s", err)
+			fmt.Fprintf(out, "parse program failed: %s\n", err)
 			continue
 		}
 
-		obj := evaluator.Eval(program, env)
-		if evaluator.IsError(obj) {
-			fmt.Printf("evaluate program failed: %s", err)
+		obj, err := run(program)
+		if err != nil {
+			fmt.Fprintf(out, "%s\n", err)
 			continue
 		}
 
-		fmt.Printf("%+v\n", obj.Inspect())
+		io.WriteString(out, obj.Inspect())
+		io.WriteString(out, "\n")
 	}
 }
 
-func StartWithCompiler(in io.Reader, out io.Writer) {
-	scanner := bufio.NewScanner(in)
+// StartWithInterpreter evaluates the programs in one environment. The programs of all the
+// Start functions hash the keys of their hashes with seed
+func StartWithInterpreter(in io.Reader, out io.Writer, seed object.HashSeed) {
+	env := object.NewEnvironment()
+	ev := evaluator.New()
+	ev.SetHashSeed(seed)
+	loop(in, out, nil, func(program *ast.Program) (object.Object, error) {
+		obj := ev.EvalContext(context.Background(), program, env, object.Limits{})
+		if evaluator.IsError(obj) {
+			return nil, fmt.Errorf("evaluate program failed: %s", obj.Inspect())
+		}
+		return obj, nil
+	})
+}
+
+// StartWithClosures runs the programs compiled into closures, they share their globals
+func StartWithClosures(in io.Reader, out io.Writer, seed object.HashSeed) {
+	globals := closure.NewGlobals()
+	loop(in, out, nil, func(program *ast.Program) (object.Object, error) {
+		compiled, err := closure.Compile(program, globals)
+		if err != nil {
+			return nil, fmt.Errorf("compile program failed: %s", err)
+		}
+
+		compiled.SetHashSeed(seed)
+		obj := compiled.Run()
+		if evaluator.IsError(obj) {
+			return nil, fmt.Errorf("run program failed: %s", obj.Inspect())
+		}
+		return obj, nil
+	})
+}
+
+// MEM_COMMAND makes StartWithCompiler report the objects the globals hold. The first
+// one starts counting the objects the programs allocate, the next ones report them too
+const MEM_COMMAND = ":mem"
 
+func StartWithCompiler(in io.Reader, out io.Writer, optimize bool, seed object.HashSeed) {
 	constants := []object.Object{}
 	globalSymbalTable := compiler.NewSymbolTable()
-	globals := make([]object.Object, vm.GlobalSize)
+	globals := make([]vm.Value, vm.GlobalSize)
 
-	for {
-		fmt.Printf(PROMPT)
-		scanned := scanner.Scan()
-		if !scanned {
-			return
+	// the programs are run without a memory profiler until it is asked for, inputs
+	// counts them to tell their allocation sites apart
+	var memProfiler *instrument.MemProfiler
+	inputs := 0
+
+	commands := map[string]func(out io.Writer){
+		MEM_COMMAND: func(out io.Writer) {
+			heap := vm.NewWithGlobals(&compiler.Bytecode{}, globals).Heap(globalSymbalTable.Names())
+			heap.WriteReport(out)
+			io.WriteString(out, "\n")
+			if memProfiler == nil {
+				memProfiler = instrument.NewMemProfiler()
+				io.WriteString(out, "allocations are counted from now on\n")
+				return
+			}
+			memProfiler.WriteReport(out)
+		},
+	}
+	loop(in, out, commands, func(program *ast.Program) (object.Object, error) {
+		inputs++
+		c := compiler.NewWithStates(constants, globalSymbalTable)
+		if !optimize {
+			c.DisableOptimizations()
+		}
+		err := c.Compile(program)
+		if err != nil {
+			return nil, fmt.Errorf("compile program failed: %s", err)
 		}
 
-		line := scanner.Text()
-		parser := parser.New(line)
-		program, err := parser.ParseProgram()
+		bytecode := c.Bytecode()
+		constants = bytecode.Constants
+
+		vm := vm.NewWithGlobals(bytecode, globals)
+		vm.SetHashSeed(seed)
+		if memProfiler != nil {
+			memProfiler.Input = inputs
+			vm.SetMemProfiler(memProfiler)
+		}
+		err = vm.Run()
 		if err != nil {
-			fmt.Fprintf(out, "parse program failed: %s", err)
-			continue
+			return nil, fmt.Errorf("vm run program failed: %s", err)
 		}
+		return vm.StackLastTop(), nil
+	})
+}
 
-		c := compiler.NewWithStates(constants, globalSymbalTable)
-		err = c.Compile(program)
+// StartWithRegisterVM is like StartWithCompiler but runs the programs on the register vm
+func StartWithRegisterVM(in io.Reader, out io.Writer, optimize bool, seed object.HashSeed) {
+	constants := []object.Object{}
+	globalSymbalTable := compiler.NewSymbolTable()
+	globals := make([]vm.Value, vm.GlobalSize)
+
+	loop(in, out, nil, func(program *ast.Program) (object.Object, error) {
+		c := compiler.NewRegisterCompilerWithStates(constants, globalSymbalTable)
+		if !optimize {
+			c.DisableOptimizations()
+		}
+		err := c.Compile(program)
 		if err != nil {
-			fmt.Fprintf(out, "compile program failed: %s", err)
-			continue
+			return nil, fmt.Errorf("compile program failed: %s", err)
 		}
 
-		vm := vm.NewWithGlobals(c.Bytecode(), globals)
-		err = vm.Run()
+		bytecode, err := c.Bytecode()
 		if err != nil {
-			fmt.Fprintf(out, "vm run program failed: %s", err)
-			continue
+			return nil, fmt.Errorf("compile program failed: %s", err)
 		}
+		constants = bytecode.Constants
 
-		top := vm.StackLastTop()
-		io.WriteString(out, top.Inspect())
-		io.WriteString(out, "\n")
-	}
+		vm := vm.NewRegisterWithGlobals(bytecode, globals)
+		vm.SetHashSeed(seed)
+		err = vm.Run()
+		if err != nil {
+			return nil, fmt.Errorf("vm run program failed: %s", err)
+		}
+		return vm.StackLastTop(), nil
+	})
 }
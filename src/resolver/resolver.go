@@ -0,0 +1,341 @@
+// Package resolver gives each identifier of a program the address of the variable it
+// names before the program is run, so the evaluator and the closure backend do not look
+// up variables by name. The addresses are kept in a Table owned by the run, the ast is
+// left as it is, so one program can be resolved by several runs at the same time
+package resolver
+
+import (
+	"ast"
+	"fmt"
+	"token"
+)
+
+// Address locates a variable: the slot Slot of the environment Depth environments
+// out from the one the identifier is evaluated in
+type Address struct {
+	Depth int
+	Slot  int
+}
+
+// Table holds the addresses of the identifiers of a program and the number of the
+// slots of its functions
+type Table struct {
+	addresses map[*ast.Identifier]Address
+	numSlots  map[*ast.FunctionExpression]int
+}
+
+// Address returns the address of the variable node names, false for a name which is
+// not a variable, like a builtin
+func (t *Table) Address(node *ast.Identifier) (Address, bool) {
+	addr, ok := t.addresses[node]
+	return addr, ok
+}
+
+// NumSlots returns the number of the parameters and the locals of node
+func (t *Table) NumSlots(node *ast.FunctionExpression) int {
+	return t.numSlots[node]
+}
+
+// Globals keeps the slots of the global variables, it is shared by the programs run one
+// after another like in the repl, so they can use the variables of each other
+type Globals interface {
+	// Define returns the slot of the global name, a new one if name is not defined yet
+	Define(name string) int
+	// Lookup returns the slot of the global name
+	Lookup(name string) (int, bool)
+}
+
+// Error is a name which is neither a variable nor a builtin
+type Error struct {
+	Msg string
+	Pos token.Position
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s at line: %d, column: %d", e.Msg, e.Pos.Line, e.Pos.Column)
+}
+
+// scope holds the slots of the variables of a function, or of the globals for the
+// global scope, whose slots are kept by globals
+type scope struct {
+	outer   *scope
+	globals Globals
+
+	slots    map[string]int
+	numSlots int
+
+	// pending holds the names whose let statement is not resolved yet. Only the functions
+	// in the scope can read them before, like they could the environments looked up by name
+	pending map[string]bool
+	// captured holds the variables of a function read by the functions in it
+	captured map[string]bool
+}
+
+func newScope(outer *scope, globals Globals) *scope {
+	return &scope{outer: outer, globals: globals, slots: map[string]int{}, pending: map[string]bool{}, captured: map[string]bool{}}
+}
+
+// define returns the slot of name, a new one if name is not defined in s yet
+func (s *scope) define(name string) int {
+	if s.globals != nil {
+		return s.globals.Define(name)
+	}
+
+	if slot, ok := s.slots[name]; ok {
+		return slot
+	}
+
+	slot := s.numSlots
+	s.slots[name] = slot
+	s.numSlots++
+	return slot
+}
+
+func (s *scope) lookup(name string) (int, bool) {
+	if s.globals != nil {
+		return s.globals.Lookup(name)
+	}
+
+	slot, ok := s.slots[name]
+	return slot, ok
+}
+
+type resolver struct {
+	scope     *scope
+	table     *Table
+	isBuiltin func(name string) bool
+	err       *Error
+}
+
+// Resolve resolves the identifiers of node evaluated with globals. isBuiltin tells the
+// names of the builtins, which are not variables. It returns an error for the first
+// name which is neither a variable nor a builtin
+func Resolve(node ast.Node, globals Globals, isBuiltin func(name string) bool) (*Table, error) {
+	global := newScope(nil, globals)
+	for _, name := range letNames(node) {
+		if _, ok := globals.Lookup(name); !ok {
+			// not defined by a program run before
+			global.pending[name] = true
+		}
+		global.define(name)
+	}
+
+	r := &resolver{
+		scope:     global,
+		table:     &Table{addresses: map[*ast.Identifier]Address{}, numSlots: map[*ast.FunctionExpression]int{}},
+		isBuiltin: isBuiltin,
+	}
+	r.resolve(node)
+	if r.err != nil {
+		return nil, r.err
+	}
+	return r.table, nil
+}
+
+// letNames returns the names of the let statements of node, except the ones in the functions in it
+func letNames(node ast.Node) []string {
+	names := []string{}
+	walk(node, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.LetStatement:
+			names = append(names, node.Name.Value)
+		case *ast.FunctionExpression:
+			return false
+		}
+		return true
+	})
+	return names
+}
+
+func (r *resolver) resolve(node ast.Node) {
+	if r.err != nil {
+		return
+	}
+
+	switch node := node.(type) {
+	case *ast.Identifier:
+		r.identifier(node)
+	case *ast.LetStatement:
+		name := node.Name.Value
+		r.resolve(node.Value)
+		if r.err == nil && !r.scope.pending[name] && r.scope.captured[name] {
+			r.err = &Error{Msg: fmt.Sprintf("can not define %s again, it is captured by a function", name), Pos: node.Name.Pos()}
+			return
+		}
+		delete(r.scope.pending, name)
+		r.table.addresses[node.Name] = Address{Depth: 0, Slot: r.scope.define(name)}
+	case *ast.InfixExpression:
+		if node.Operator == "=" {
+			r.assign(node)
+			return
+		}
+		r.resolve(node.Left)
+		r.resolve(node.Right)
+	case *ast.FunctionExpression:
+		r.function(node)
+	default:
+		walk(node, func(child ast.Node) bool {
+			if child == node {
+				return true
+			}
+			r.resolve(child)
+			return false
+		})
+	}
+}
+
+func (r *resolver) function(node *ast.FunctionExpression) {
+	r.scope = newScope(r.scope, nil)
+	defer func() { r.scope = r.scope.outer }()
+
+	for _, param := range node.Parameters {
+		r.table.addresses[param] = Address{Depth: 0, Slot: r.scope.define(param.Value)}
+	}
+	for _, name := range letNames(node.Body) {
+		if _, ok := r.scope.lookup(name); !ok {
+			r.scope.pending[name] = true
+		}
+		r.scope.define(name)
+	}
+
+	r.resolve(node.Body)
+	r.table.numSlots[node] = r.scope.numSlots
+}
+
+func (r *resolver) identifier(node *ast.Identifier) {
+	name := node.Value
+
+	depth := 0
+	for s := r.scope; s != nil; s = s.outer {
+		if slot, ok := s.lookup(name); ok && !(s == r.scope && s.pending[name]) {
+			r.table.addresses[node] = Address{Depth: depth, Slot: slot}
+			if depth > 0 && s.globals == nil {
+				s.captured[name] = true
+			}
+			return
+		}
+		depth++
+	}
+
+	if r.isBuiltin(name) {
+		return
+	}
+
+	if slot, ok := r.scope.lookup(name); ok {
+		// read before its let statement, it has no value yet
+		r.table.addresses[node] = Address{Depth: 0, Slot: slot}
+		return
+	}
+
+	r.err = &Error{Msg: fmt.Sprintf("unbound identifier: %s", name), Pos: node.Pos()}
+}
+
+// assign resolves an assignment. A variable can be assigned like in the vm, which gives
+// a function copies of the variables of the functions it is in: a global, or a local
+// of the function the assignment is in, which no function has captured before
+func (r *resolver) assign(node *ast.InfixExpression) {
+	ident, ok := node.Left.(*ast.Identifier)
+	if !ok {
+		// not evaluated, assigning to it fails
+		r.resolve(node.Right)
+		return
+	}
+
+	// checked after the value, a function in it capturing the variable would not see the assignment
+	r.resolve(node.Right)
+	if r.err != nil {
+		return
+	}
+
+	local := r.scope.globals == nil
+	if _, ok := r.scope.lookup(ident.Value); local && ok && r.scope.captured[ident.Value] {
+		r.err = &Error{Msg: fmt.Sprintf("can not assign to %s, it is captured by a function", ident.Value), Pos: ident.Pos()}
+		return
+	}
+
+	r.identifier(ident)
+	addr, ok := r.table.addresses[ident]
+	switch {
+	case r.err != nil:
+	case !ok:
+		r.err = &Error{Msg: fmt.Sprintf("can not assign to builtin %s", ident.Value), Pos: ident.Pos()}
+	case addr.Depth > 0 && !r.isGlobal(addr.Depth):
+		r.err = &Error{Msg: fmt.Sprintf("can not assign to %s, it is captured by a function", ident.Value), Pos: ident.Pos()}
+	}
+}
+
+// isGlobal reports whether the scope depth scopes out from the current one is the global scope
+func (r *resolver) isGlobal(depth int) bool {
+	s := r.scope
+	for ; depth > 0; depth-- {
+		s = s.outer
+	}
+	return s.globals != nil
+}
+
+// walk calls fn for node and then for its sub nodes, in source order. The sub nodes of
+// a node for which fn returns false are skipped
+func walk(node ast.Node, fn func(ast.Node) bool) {
+	if node == nil || !fn(node) {
+		return
+	}
+
+	switch node := node.(type) {
+	case *ast.Program:
+		for _, stmt := range node.Statements {
+			walk(stmt, fn)
+		}
+	case *ast.BlockExpression:
+		for _, stmt := range node.Statements {
+			walk(stmt, fn)
+		}
+	case *ast.ExpressionStatement:
+		walk(node.Value, fn)
+	case *ast.LetStatement:
+		walk(node.Value, fn)
+	case *ast.ReturnStatement:
+		if node.Value != nil {
+			walk(node.Value, fn)
+		}
+	case *ast.PrefixExpression:
+		walk(node.Value, fn)
+	case *ast.InfixExpression:
+		walk(node.Left, fn)
+		walk(node.Right, fn)
+	case *ast.PostfixExpression:
+		walk(node.Left, fn)
+	case *ast.SliceExpression:
+		walk(node.Left, fn)
+		if node.Start != nil {
+			walk(node.Start, fn)
+		}
+		if node.End != nil {
+			walk(node.End, fn)
+		}
+	case *ast.IfExpression:
+		walk(node.Condition, fn)
+		walk(node.ThenBody, fn)
+		if node.ElseBody != nil {
+			walk(node.ElseBody, fn)
+		}
+	case *ast.FunctionExpression:
+		for _, param := range node.Parameters {
+			walk(param, fn)
+		}
+		walk(node.Body, fn)
+	case *ast.CallExpression:
+		walk(node.Function, fn)
+		for _, arg := range node.Arguments {
+			walk(arg, fn)
+		}
+	case *ast.ArrayLiteral:
+		for _, elem := range node.Elements {
+			walk(elem, fn)
+		}
+	case *ast.HashLiteral:
+		for _, pair := range node.Pairs {
+			walk(pair.Key, fn)
+			walk(pair.Value, fn)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package resolver
+
+import (
+	"ast"
+	"parser"
+	"testing"
+	"token"
+)
+
+// globals keeps the slots of the globals by name
+type globals map[string]int
+
+func (g globals) Define(name string) int {
+	if slot, ok := g[name]; ok {
+		return slot
+	}
+	g[name] = len(g)
+	return g[name]
+}
+
+func (g globals) Lookup(name string) (int, bool) {
+	slot, ok := g[name]
+	return slot, ok
+}
+
+func isBuiltin(name string) bool {
+	return name == "len"
+}
+
+func TestResolveAddresses(t *testing.T) {
+	input := `
+	let a = 1;
+	let f = fn(x) {
+		let y = x + a;
+		fn(z) { x + y + z + len([]) }
+	};
+	`
+	program, err := parser.New(input).ParseProgram()
+	if err != nil {
+		t.Fatalf("parse program failed. error is: %q", err.Error())
+	}
+
+	table, err := Resolve(program, globals{}, isBuiltin)
+	if err != nil {
+		t.Fatalf("resolve failed. error is: %q", err)
+	}
+
+	addresses := map[string][]*Address{}
+	walk(program, func(node ast.Node) bool {
+		if ident, ok := node.(*ast.Identifier); ok {
+			var addr *Address
+			if a, ok := table.Address(ident); ok {
+				addr = &a
+			}
+			addresses[ident.Value] = append(addresses[ident.Value], addr)
+		}
+		return true
+	})
+
+	tests := []struct {
+		name   string
+		expect []*Address
+	}{
+		// the parameters and then the uses, in source order
+		{"x", []*Address{{Depth: 0, Slot: 0}, {Depth: 0, Slot: 0}, {Depth: 1, Slot: 0}}},
+		{"a", []*Address{{Depth: 1, Slot: 0}}},
+		{"y", []*Address{{Depth: 1, Slot: 1}}},
+		{"z", []*Address{{Depth: 0, Slot: 0}, {Depth: 0, Slot: 0}}},
+		{"len", []*Address{nil}},
+	}
+
+	for _, test := range tests {
+		actual := addresses[test.name]
+		if len(actual) != len(test.expect) {
+			t.Fatalf("wrong number of uses of %s. want=%d, got=%d", test.name, len(test.expect), len(actual))
+		}
+
+		for i, addr := range test.expect {
+			if (addr == nil) != (actual[i] == nil) || (addr != nil && *addr != *actual[i]) {
+				t.Errorf("wrong address of use %d of %s. want=%+v, got=%+v", i, test.name, addr, actual[i])
+			}
+		}
+	}
+}
+
+func TestResolveNumSlots(t *testing.T) {
+	program, err := parser.New("let f = fn(a, b) { let c = a; if (b) { let d = c }; fn() { let e = 1 } };").ParseProgram()
+	if err != nil {
+		t.Fatalf("parse program failed. error is: %q", err.Error())
+	}
+
+	table, err := Resolve(program, globals{}, isBuiltin)
+	if err != nil {
+		t.Fatalf("resolve failed. error is: %q", err)
+	}
+
+	expect := []int{4, 1}
+	actual := []int{}
+	walk(program, func(node ast.Node) bool {
+		if fn, ok := node.(*ast.FunctionExpression); ok {
+			actual = append(actual, table.NumSlots(fn))
+		}
+		return true
+	})
+
+	if len(actual) != len(expect) || actual[0] != expect[0] || actual[1] != expect[1] {
+		t.Errorf("wrong number of slots. want=%v, got=%v", expect, actual)
+	}
+}
+
+func TestResolveError(t *testing.T) {
+	program, err := parser.New("let f = fn() { g };").ParseProgram()
+	if err != nil {
+		t.Fatalf("parse program failed. error is: %q", err.Error())
+	}
+
+	_, err = Resolve(program, globals{}, isBuiltin)
+	expect := &Error{Msg: "unbound identifier: g", Pos: token.Position{Line: 1, Column: 16}}
+	if actual, ok := err.(*Error); !ok || *actual != *expect {
+		t.Errorf("wrong error. want=%v, got=%v", expect, err)
+	}
+}
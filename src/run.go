@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"instrument"
+	"io"
+	"io/ioutil"
+	"os"
+	"vm"
+)
+
+// run runs a gorilla program on the vm and writes its result
+func run(args []string) int {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	memprofile := flags.String("memprofile", "", "write the objects allocated and the objects still live when the program ends to this file")
+	noOptimize := flags.Bool("no-optimize", false, "compile without constant folding, dead code elimination and peephole optimization")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: gorilla run [--memprofile file] file.gor\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	input, err := ioutil.ReadFile(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "read program failed: %s\n", err)
+		return 1
+	}
+
+	bytecode, globalNames, err := compileProgram(string(input), !*noOptimize)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 1
+	}
+
+	machine := vm.New(bytecode)
+	var memProfiler *instrument.MemProfiler
+	if *memprofile != "" {
+		memProfiler = instrument.NewMemProfiler()
+		machine.SetMemProfiler(memProfiler)
+	}
+
+	runErr := machine.Run()
+
+	// a failed program is profiled too, its profile can tell why it failed
+	if memProfiler != nil {
+		f, err := os.Create(*memprofile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "write memory profile failed: %s\n", err)
+			return 1
+		}
+		machine.Heap(globalNames).WriteReport(f)
+		io.WriteString(f, "\n")
+		memProfiler.WriteReport(f)
+		if err := f.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "write memory profile failed: %s\n", err)
+			return 1
+		}
+	}
+
+	if runErr != nil {
+		fmt.Fprintf(os.Stderr, "vm run program failed: %s\n", runErr)
+		return 1
+	}
+
+	if result := machine.StackLastTop(); result != nil {
+		fmt.Println(result.Inspect())
+	}
+	return 0
+}
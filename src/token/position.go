@@ -13,3 +13,15 @@ func (p *Position) AddLine() {
 func (p *Position) AddColumn() {
 	p.Column++
 }
+
+// Before tells whether p is before other in the input
+func (p Position) Before(other Position) bool {
+	return p.Line < other.Line || p.Line == other.Line && p.Column < other.Column
+}
+
+// Comment is a comment of the input, from // to the end of the line. The lexer skips
+// comments like white spaces and keeps them aside
+type Comment struct {
+	Pos  Position
+	Text string
+}
@@ -161,6 +161,10 @@ func GetLiteral(tp TokenType) string {
 	return tokenLiteral[tp]
 }
 
+func (t TokenType) String() string {
+	return GetLiteral(t)
+}
+
 func LookupIdent(ident string) TokenType {
 	if tok, ok := keywords[ident]; ok {
 		return tok
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"parser"
+	"vet"
+)
+
+// vetFiles reports the mistakes vet finds in gorilla programs, each check can be
+// turned off by its flag
+func vetFiles(args []string) int {
+	flags := flag.NewFlagSet("vet", flag.ExitOnError)
+	enabled := map[string]*bool{}
+	for _, check := range vet.Checks {
+		enabled[check.Name] = flags.Bool(check.Name, true, check.Doc)
+	}
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: gorilla vet [--check=false ...] files...\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	skip := map[string]bool{}
+	for name, on := range enabled {
+		skip[name] = !*on
+	}
+
+	status := 0
+	for _, file := range flags.Args() {
+		input, err := ioutil.ReadFile(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "read program failed: %s\n", err)
+			status = 1
+			continue
+		}
+
+		program, err := parser.New(string(input)).ParseProgram()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: parse program failed: %s\n", file, err)
+			status = 1
+			continue
+		}
+
+		for _, w := range vet.Program(program, skip) {
+			fmt.Fprintf(os.Stderr, "%s:%s\n", file, w)
+			status = 1
+		}
+	}
+	return status
+}
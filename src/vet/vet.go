@@ -0,0 +1,373 @@
+// Package vet reports mistakes of gorilla programs which parse and compile but fail or
+// misbehave when they run. It resolves names with the symbol tables of the compiler
+package vet
+
+import (
+	"ast"
+	"compiler"
+	"fmt"
+	"object"
+	"sort"
+	"strings"
+	"token"
+)
+
+// Warning is a mistake found by Check at Pos
+type Warning struct {
+	Pos   token.Position
+	Check string
+	Msg   string
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("%d:%d: %s (%s)", w.Pos.Line, w.Pos.Column, w.Msg, w.Check)
+}
+
+// Check is a kind of mistake vet looks for, it can be skipped by its Name
+type Check struct {
+	Name string
+	Doc  string
+}
+
+var Checks = []Check{
+	{"unused", "report let bindings which are never used"},
+	{"shadow", "report names hiding a name of an enclosing scope or a builtin"},
+	{"args", "report calls to known functions and builtins with the wrong number of arguments"},
+	{"unreachable", "report statements after a return"},
+	{"compare", "report comparisons of literals of incompatible types"},
+}
+
+// Program returns the warnings of program sorted by position, the checks named in
+// skip are not run
+func Program(program *ast.Program, skip map[string]bool) []Warning {
+	table := compiler.NewSymbolTable()
+	for i, b := range object.Builtins {
+		table.DefineBuiltin(i, b.Name)
+	}
+
+	v := &vetter{scope: &scope{table: table, defs: map[string]*binding{}}, skip: skip}
+	v.walk(program)
+	v.checkCalls()
+	v.checkUnused()
+
+	sort.SliceStable(v.warnings, func(i, j int) bool {
+		return v.warnings[i].Pos.Before(v.warnings[j].Pos)
+	})
+	return v.warnings
+}
+
+// scope is a symbol table of the compiler with the bindings of its names. self is the
+// binding of the function the scope is the body of, if it is named
+type scope struct {
+	table *compiler.SymbolTable
+	defs  map[string]*binding
+	outer *scope
+	self  *binding
+}
+
+// binding is a name defined by a let or a parameter. fn is the function bound, known
+// while the name is not assigned another value
+type binding struct {
+	ident      *ast.Identifier
+	let        bool
+	fn         *ast.FunctionExpression
+	used       bool
+	reassigned bool
+}
+
+// call is a call to a binding, or to a builtin when binding is nil
+type call struct {
+	node    *ast.CallExpression
+	binding *binding
+	builtin string
+}
+
+type vetter struct {
+	scope    *scope
+	bindings []*binding
+	calls    []call
+	warnings []Warning
+	skip     map[string]bool
+}
+
+func (v *vetter) warn(check string, pos token.Position, format string, args ...interface{}) {
+	if v.skip[check] {
+		return
+	}
+	v.warnings = append(v.warnings, Warning{Pos: pos, Check: check, Msg: fmt.Sprintf(format, args...)})
+}
+
+func (v *vetter) walk(node ast.Node) {
+	switch node := node.(type) {
+	case *ast.Program:
+		v.statements(node.Statements)
+	case *ast.BlockExpression:
+		v.statements(node.Statements)
+	case *ast.ExpressionStatement:
+		v.walk(node.Value)
+	case *ast.ReturnStatement:
+		if node.Value != nil {
+			v.walk(node.Value)
+		}
+	case *ast.LetStatement:
+		fn, ok := node.Value.(*ast.FunctionExpression)
+		if !ok {
+			// the value reads what the name was bound to before, as in let a = a + 1
+			v.walk(node.Value)
+			v.define(node.Name, true, nil)
+			break
+		}
+		v.define(node.Name, true, fn)
+		v.walk(fn)
+	case *ast.Identifier:
+		v.use(node)
+	case *ast.PrefixExpression:
+		v.walk(node.Value)
+	case *ast.InfixExpression:
+		v.compare(node)
+		if ident, ok := node.Left.(*ast.Identifier); ok && node.Operator == "=" {
+			// assigning a name does not use it, and makes its function unknown
+			if b := v.lookup(ident.Value); b != nil {
+				b.reassigned = true
+			}
+			v.walk(node.Right)
+			break
+		}
+		v.walk(node.Left)
+		v.walk(node.Right)
+	case *ast.PostfixExpression:
+		v.walk(node.Left)
+	case *ast.SliceExpression:
+		v.walk(node.Left)
+		if node.Start != nil {
+			v.walk(node.Start)
+		}
+		if node.End != nil {
+			v.walk(node.End)
+		}
+	case *ast.IfExpression:
+		v.walk(node.Condition)
+		v.walk(node.ThenBody)
+		if node.ElseBody != nil {
+			v.walk(node.ElseBody)
+		}
+	case *ast.FunctionExpression:
+		v.function(node)
+	case *ast.CallExpression:
+		v.walk(node.Function)
+		for _, argument := range node.Arguments {
+			v.walk(argument)
+		}
+		v.recordCall(node)
+	case *ast.ArrayLiteral:
+		for _, element := range node.Elements {
+			v.walk(element)
+		}
+	case *ast.HashLiteral:
+		for _, pair := range node.Pairs {
+			v.walk(pair.Key)
+			v.walk(pair.Value)
+		}
+	}
+}
+
+// statements walks a list of statements and reports the first one after a return
+func (v *vetter) statements(statements []ast.Statement) {
+	for i, statement := range statements {
+		v.walk(statement)
+		if i+1 < len(statements) && returns(statement) && (i == 0 || !returns(statements[i-1])) {
+			v.warn("unreachable", statements[i+1].Pos(), "unreachable code")
+		}
+	}
+}
+
+// returns reports whether statement always returns, it is a return statement or an if
+// expression both bodies of which return
+func returns(statement ast.Statement) bool {
+	switch statement := statement.(type) {
+	case *ast.ReturnStatement:
+		return true
+	case *ast.ExpressionStatement:
+		node, ok := statement.Value.(*ast.IfExpression)
+		return ok && node.ElseBody != nil && blockReturns(node.ThenBody) && blockReturns(node.ElseBody)
+	}
+	return false
+}
+
+func blockReturns(block *ast.BlockExpression) bool {
+	for _, statement := range block.Statements {
+		if returns(statement) {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *vetter) function(node *ast.FunctionExpression) {
+	outer := v.scope
+	v.scope = &scope{table: compiler.NewEnclosedSymbolTable(outer.table), defs: map[string]*binding{}, outer: outer}
+
+	if node.Name != nil {
+		v.scope.table.DefineFunctionName(node.Name.Value)
+		if b, ok := outer.defs[node.Name.Value]; ok && b.ident == node.Name {
+			// the name of a let-bound function is bound by the let
+			v.scope.defs[node.Name.Value] = b
+			v.scope.self = b
+		} else {
+			v.scope.defs[node.Name.Value] = &binding{ident: node.Name, fn: node}
+			v.scope.self = v.scope.defs[node.Name.Value]
+		}
+	}
+	for _, parameter := range node.Parameters {
+		v.define(parameter, false, nil)
+	}
+
+	v.walk(node.Body)
+	v.scope = outer
+}
+
+// define binds ident in the current scope, after reporting the name it shadows
+func (v *vetter) define(ident *ast.Identifier, let bool, fn *ast.FunctionExpression) {
+	name := ident.Value
+	if _, ok := v.scope.defs[name]; !ok {
+		shadowed := false
+		for s := v.scope.outer; s != nil; s = s.outer {
+			if b, ok := s.defs[name]; ok {
+				v.warn("shadow", ident.Pos(), "%s shadows the %s defined at line %d", name, name, b.ident.Pos().Line)
+				shadowed = true
+				break
+			}
+		}
+		if !shadowed && object.FindBuiltinByName(name) != nil {
+			v.warn("shadow", ident.Pos(), "%s shadows the builtin %s", name, name)
+		}
+	}
+
+	v.scope.table.Define(name)
+	b := &binding{ident: ident, let: let, fn: fn}
+	v.scope.defs[name] = b
+	v.bindings = append(v.bindings, b)
+}
+
+func (v *vetter) lookup(name string) *binding {
+	for s := v.scope; s != nil; s = s.outer {
+		if b, ok := s.defs[name]; ok {
+			return b
+		}
+	}
+	return nil
+}
+
+// use marks the binding of ident used, unless ident is a call of a function to itself
+func (v *vetter) use(ident *ast.Identifier) {
+	if _, ok := v.scope.table.Resolve(ident.Value); !ok {
+		// the compiler reports undefined names
+		return
+	}
+
+	b := v.lookup(ident.Value)
+	if b == nil {
+		return
+	}
+	for s := v.scope; s != nil; s = s.outer {
+		if s.self == b {
+			return
+		}
+	}
+	b.used = true
+}
+
+func (v *vetter) recordCall(node *ast.CallExpression) {
+	switch function := node.Function.(type) {
+	case *ast.FunctionExpression:
+		v.calls = append(v.calls, call{node: node, binding: &binding{fn: function}})
+	case *ast.Identifier:
+		symbol, ok := v.scope.table.Resolve(function.Value)
+		if !ok {
+			return
+		}
+		if symbol.Scope == compiler.BuiltinScope {
+			v.calls = append(v.calls, call{node: node, builtin: function.Value})
+		} else if b := v.lookup(function.Value); b != nil && b.fn != nil {
+			v.calls = append(v.calls, call{node: node, binding: b})
+		}
+	}
+}
+
+// checkCalls reports the calls with the wrong number of arguments, once all the
+// assignments which make functions unknown are seen
+func (v *vetter) checkCalls() {
+	for _, c := range v.calls {
+		var name string
+		var expected int
+		if c.binding != nil {
+			if c.binding.reassigned {
+				continue
+			}
+			name, expected = "function", len(c.binding.fn.Parameters)
+			if c.binding.ident != nil {
+				name = c.binding.ident.Value
+			}
+		} else if builtin := object.FindBuiltinByName(c.builtin); builtin != nil {
+			name, expected = c.builtin, builtin.Arity
+		} else {
+			continue
+		}
+
+		if got := len(c.node.Arguments); got != expected {
+			v.warn("args", c.node.Function.Pos(), "wrong number of arguments to %s. expected=%d, got=%d", name, expected, got)
+		}
+	}
+}
+
+func (v *vetter) checkUnused() {
+	for _, b := range v.bindings {
+		if b.let && !b.used && !strings.HasPrefix(b.ident.Value, "_") {
+			v.warn("unused", b.ident.Pos(), "%s is never used", b.ident.Value)
+		}
+	}
+}
+
+// compare reports comparisons of literals of different types, == and != of them have
+// a known result and ordering them fails
+func (v *vetter) compare(node *ast.InfixExpression) {
+	left, right := literalType(node.Left), literalType(node.Right)
+	if left == "" || right == "" || left == right {
+		return
+	}
+
+	switch node.Operator {
+	case "==":
+		v.warn("compare", node.Pos(), "comparison of %s and %s literals is always false", left, right)
+	case "!=":
+		v.warn("compare", node.Pos(), "comparison of %s and %s literals is always true", left, right)
+	case "<", ">", "<=", ">=":
+		v.warn("compare", node.Pos(), "comparison of %s and %s literals fails", left, right)
+	}
+}
+
+// literalType is the type of a literal, or empty for other expressions
+func literalType(node ast.Expression) string {
+	switch node := node.(type) {
+	case *ast.Integer:
+		return "integer"
+	case *ast.String:
+		return "string"
+	case *ast.Boolean:
+		return "boolean"
+	case *ast.ArrayLiteral:
+		return "array"
+	case *ast.HashLiteral:
+		return "hash"
+	case *ast.FunctionExpression:
+		return "function"
+	case *ast.PrefixExpression:
+		if node.Operator == "!" {
+			return "boolean"
+		}
+		if literalType(node.Value) == "integer" {
+			return "integer"
+		}
+	}
+	return ""
+}
@@ -0,0 +1,84 @@
+package vet
+
+import (
+	"parser"
+	"testing"
+)
+
+func TestChecks(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"let a = 1; a", nil},
+		{"let a = 1; let _b = 2; 3", []string{"1:5: a is never used (unused)"}},
+		// a function calling only itself is not used
+		{"let f = fn(n) { f(n - 1) }; 1", []string{"1:5: f is never used (unused)"}},
+		{"let a = 1; let a = a + 1; a", nil},
+		{"let x = 1; let f = fn(x) { let y = x; y }; f(x)", []string{"1:23: x shadows the x defined at line 1 (shadow)"}},
+		{"let len = fn(x) { 0 }; len([])", []string{"1:5: len shadows the builtin len (shadow)"}},
+		{
+			"let f = fn(a, b) { a + b }; f(1); len(1, 2); push([], 1); fn(x) { x }(); f(1, 2)",
+			[]string{
+				"1:29: wrong number of arguments to f. expected=2, got=1 (args)",
+				"1:35: wrong number of arguments to len. expected=1, got=2 (args)",
+				"1:59: wrong number of arguments to function. expected=1, got=0 (args)",
+			},
+		},
+		// a function assigned another one is not known any more
+		{"let f = fn(a) { a }; f = fn() { 1 }; f()", nil},
+		{
+			"let f = fn(x) { return x; x + 1; x + 2 }; let g = fn(x) { if (x) { return 1; } else { return 2; } 3 }; f(g(1))",
+			[]string{"1:27: unreachable code (unreachable)", "1:99: unreachable code (unreachable)"},
+		},
+		{"return 1; 2", []string{"1:11: unreachable code (unreachable)"}},
+		{"fn(x) { if (x) { return 1; } 2 }(1)", nil},
+		{
+			`1 == "1"; 1 != true; -1 < "a"; [] == {}; 1 == 2; "a" == "b"; x == 1`,
+			[]string{
+				"1:3: comparison of integer and string literals is always false (compare)",
+				"1:13: comparison of integer and boolean literals is always true (compare)",
+				"1:25: comparison of integer and string literals fails (compare)",
+				"1:35: comparison of array and hash literals is always false (compare)",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		program, err := parser.New(tt.input).ParseProgram()
+		if err != nil {
+			t.Fatalf("parse %q failed. error is: %q", tt.input, err)
+		}
+
+		warnings := Program(program, nil)
+		if len(warnings) != len(tt.expected) {
+			t.Errorf("wrong warnings of %q. want=%q, got=%v", tt.input, tt.expected, warnings)
+			continue
+		}
+		for i, w := range warnings {
+			if w.String() != tt.expected[i] {
+				t.Errorf("wrong warning of %q. want=%q, got=%q", tt.input, tt.expected[i], w.String())
+			}
+		}
+	}
+}
+
+func TestSkip(t *testing.T) {
+	program, err := parser.New(`let a = 1 == "1"; let len = 2; return 3; 4`).ParseProgram()
+	if err != nil {
+		t.Fatalf("parse failed. error is: %q", err)
+	}
+
+	all := Program(program, nil)
+	if len(all) != 5 {
+		t.Fatalf("wrong number of warnings. want=5, got=%v", all)
+	}
+
+	for _, check := range Checks {
+		for _, w := range Program(program, map[string]bool{check.Name: true}) {
+			if w.Check == check.Name {
+				t.Errorf("check %s is not skipped, got %s", check.Name, w)
+			}
+		}
+	}
+}
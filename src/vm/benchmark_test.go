@@ -0,0 +1,120 @@
+package vm
+
+import (
+	"compiler"
+	"testing"
+)
+
+var benchmarks = []struct {
+	name   string
+	input  string
+	expect int64
+}{
+	{
+		name: "fib",
+		input: `
+		let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } };
+		fib(20)`,
+		expect: 6765,
+	},
+	{
+		name: "loop",
+		input: `
+		let loop = fn(i, sum) { if (i == 0) { sum } else { loop(i - 1, sum + i) } };
+		loop(100000, 0)`,
+		expect: 5000050000,
+	},
+	{
+		name: "strings",
+		input: `
+		let build = fn(i, s) { if (i > 0) { build(i - 1, s + "ab") } else { s } };
+		len(build(2000, ""))`,
+		expect: 4000,
+	},
+}
+
+func BenchmarkRun(b *testing.B) {
+	for _, bench := range benchmarks {
+		for _, optimize := range []bool{true, false} {
+			name := bench.name
+			if !optimize {
+				name += "/unoptimized"
+			}
+
+			b.Run(name, func(b *testing.B) {
+				program, err := parse(bench.input)
+				if err != nil {
+					b.Fatalf("parse failed: %s", err)
+				}
+
+				c := compiler.New()
+				if !optimize {
+					c.DisableOptimizations()
+				}
+				err = c.Compile(program)
+				if err != nil {
+					b.Fatalf("compile failed: %s", err)
+				}
+				bytecode := c.Bytecode()
+
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					v := New(bytecode)
+					err := v.Run()
+					if err != nil {
+						b.Fatalf("run failed: %s", err)
+					}
+
+					if err := testIntegerObject(bench.expect, v.StackLastTop()); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkRunRegister runs the programs of BenchmarkRun on the register vm
+func BenchmarkRunRegister(b *testing.B) {
+	for _, bench := range benchmarks {
+		for _, optimize := range []bool{true, false} {
+			name := bench.name
+			if !optimize {
+				name += "/unoptimized"
+			}
+
+			b.Run(name, func(b *testing.B) {
+				program, err := parse(bench.input)
+				if err != nil {
+					b.Fatalf("parse failed: %s", err)
+				}
+
+				c := compiler.NewRegisterCompiler()
+				if !optimize {
+					c.DisableOptimizations()
+				}
+				err = c.Compile(program)
+				if err != nil {
+					b.Fatalf("compile failed: %s", err)
+				}
+				bytecode, err := c.Bytecode()
+				if err != nil {
+					b.Fatalf("compile failed: %s", err)
+				}
+
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					v := NewRegister(bytecode)
+					err := v.Run()
+					if err != nil {
+						b.Fatalf("run failed: %s", err)
+					}
+
+					if err := testIntegerObject(bench.expect, v.StackLastTop()); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package vm
+
+import (
+	"compiler"
+	"context"
+	"errors"
+	"fmt"
+	"object"
+	"parser"
+	"token"
+)
+
+// ErrQuit is returned by Run when the program is quit from the debugger
+var ErrQuit = errors.New("quit by the debugger")
+
+// Debugger stops a vm at breakpoints and after steps, at the statements of the line
+// tables of the functions. The vm calls the stopped function of the debugger, which
+// inspects the program and tells how to go on by calling Continue or one of the steps
+type Debugger struct {
+	vm          *VM
+	globalNames []string
+	Breakpoints []*Breakpoint
+	lastID      int
+
+	stopped func(d *Debugger, stop Stop) error
+	mode    stepMode
+	// depth is the frame index the last step started in
+	depth int
+}
+
+type stepMode int
+
+const (
+	stepNone stepMode = iota
+	stepIn
+	stepOver
+	stepOut
+)
+
+// Breakpoint stops the vm at the statements starting at Line, when Condition, if not
+// empty, evaluates to a truthy value in the frame of the statement
+type Breakpoint struct {
+	ID        int
+	Line      int
+	Condition string
+	Hits      int
+
+	// compiled is Condition compiled for the frames of each function it is evaluated in
+	compiled map[*object.CompiledFunction]*expression
+}
+
+// expression is code compiled to run in the frames of a function, see Debugger.compile
+type expression struct {
+	fn        *object.CompiledFunction
+	constants []Value
+}
+
+// Stop is the statement the vm stopped at. Breakpoint is nil after a step, Err is the
+// failure to evaluate the condition of Breakpoint
+type Stop struct {
+	Pos        token.Position
+	Breakpoint *Breakpoint
+	Err        error
+}
+
+// StackFrame is a call of the stack, Pos is where its function is running
+type StackFrame struct {
+	Name string
+	Pos  token.Position
+}
+
+// Variable is a name visible in a frame, Kind is local, free or global
+type Variable struct {
+	Name  string
+	Kind  string
+	Value object.Object
+}
+
+// NewDebugger makes a debugger of v which stops at the first statement of the program.
+// globalNames, which can be nil, names the globals by their indexes. The debugger is
+// attached to v, which stops once it runs
+func NewDebugger(v *VM, globalNames []string, stopped func(d *Debugger, stop Stop) error) *Debugger {
+	d := &Debugger{vm: v, globalNames: globalNames, stopped: stopped, mode: stepIn}
+	v.debugger = d
+	return d
+}
+
+// Continue runs until a breakpoint
+func (d *Debugger) Continue() {
+	d.mode = stepNone
+}
+
+// StepIn stops at the next statement, in a function called or not
+func (d *Debugger) StepIn() {
+	d.mode = stepIn
+}
+
+// StepOver stops at the next statement of the current function or of its callers
+func (d *Debugger) StepOver() {
+	d.mode, d.depth = stepOver, d.vm.frameIndex
+}
+
+// StepOut stops at the next statement once the current function returns
+func (d *Debugger) StepOut() {
+	d.mode, d.depth = stepOut, d.vm.frameIndex
+}
+
+// Break adds a breakpoint at line, a statement of the program must start there
+func (d *Debugger) Break(line int, condition string) (*Breakpoint, error) {
+	if condition != "" {
+		if _, err := parser.New(condition).ParseProgram(); err != nil {
+			return nil, fmt.Errorf("parse condition failed: %s", err)
+		}
+	}
+
+	found := d.vm.frames[0].clo.Fn.Lines.Has(line)
+	for _, c := range d.vm.constants {
+		if fn, ok := c.obj.(*object.CompiledFunction); ok && fn.Lines.Has(line) {
+			found = true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("no statement starts at line %d", line)
+	}
+
+	d.lastID++
+	b := &Breakpoint{ID: d.lastID, Line: line, Condition: condition}
+	d.Breakpoints = append(d.Breakpoints, b)
+	return b, nil
+}
+
+// Delete removes the breakpoint id, it reports whether there was one
+func (d *Debugger) Delete(id int) bool {
+	for i, b := range d.Breakpoints {
+		if b.ID == id {
+			d.Breakpoints = append(d.Breakpoints[:i], d.Breakpoints[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// instruction stops the vm before the instruction at ip when it starts a statement
+// and a breakpoint or the step asks for it
+func (d *Debugger) instruction(frame *Frame, ip int) error {
+	line, ok := frame.clo.Fn.Lines.Line(ip)
+	if !ok {
+		return nil
+	}
+
+	stop := Stop{Pos: token.Position{Line: line}}
+	if pos, ok := frame.clo.Fn.SourceMap.Lookup(ip); ok && pos.Line == line {
+		stop.Pos = pos
+	}
+
+	stepped := d.mode == stepIn ||
+		d.mode == stepOver && d.vm.frameIndex <= d.depth ||
+		d.mode == stepOut && d.vm.frameIndex < d.depth
+	for _, b := range d.Breakpoints {
+		if b.Line != line {
+			continue
+		}
+		if b.Condition != "" {
+			value, err := d.evaluateCondition(b, frame)
+			if err != nil {
+				stop.Err = fmt.Errorf("evaluate condition of breakpoint %d failed: %s", b.ID, err)
+			} else if !isTruethy(ValueOf(value)) {
+				continue
+			}
+		}
+		b.Hits++
+		stop.Breakpoint = b
+		break
+	}
+	if !stepped && stop.Breakpoint == nil {
+		return nil
+	}
+
+	d.mode = stepNone
+	return d.stopped(d, stop)
+}
+
+// Stack returns the calls of the stack, the current one first and the main program last
+func (d *Debugger) Stack() []StackFrame {
+	stack := []StackFrame{}
+	for i := d.vm.frameIndex; i >= 0; i-- {
+		frame := d.vm.frames[i]
+		pos, _ := frame.clo.Fn.SourceMap.Lookup(frame.ip)
+		stack = append(stack, StackFrame{Name: functionName(frame.clo.Fn, i == 0), Pos: pos})
+	}
+	return stack
+}
+
+// Variables returns the locals and the free variables of the frame at depth, the
+// globals for the main program. Locals not defined yet are left out
+func (d *Debugger) Variables(depth int) []Variable {
+	vars := []Variable{}
+	index := d.vm.frameIndex - depth
+	if index < 0 || index > d.vm.frameIndex {
+		return vars
+	}
+
+	if index == 0 {
+		for i, name := range d.globalNames {
+			if val := d.vm.globals[i]; name != "" && !val.IsUndefined() {
+				vars = append(vars, Variable{Name: name, Kind: "global", Value: val.Object()})
+			}
+		}
+		return vars
+	}
+
+	frame := d.vm.frames[index]
+	for i, name := range frame.clo.Fn.LocalNames {
+		if val := d.vm.stack[frame.basePointer+i+1]; name != "" && !val.IsUndefined() {
+			vars = append(vars, Variable{Name: name, Kind: "local", Value: val.Object()})
+		}
+	}
+	for i, name := range frame.clo.Fn.FreeNames {
+		if i < len(frame.clo.Free) {
+			vars = append(vars, Variable{Name: name, Kind: "free", Value: frame.clo.Free[i]})
+		}
+	}
+	return vars
+}
+
+// Evaluate evaluates expr on the vm, with the variables of the frame at depth and the
+// globals. What expr assigns is not seen by the program
+func (d *Debugger) Evaluate(depth int, expr string) (object.Object, error) {
+	index := d.vm.frameIndex - depth
+	if index < 0 || index > d.vm.frameIndex {
+		return nil, fmt.Errorf("no frame at depth %d", depth)
+	}
+
+	frame := d.vm.frames[index]
+	compiled, err := d.compile(expr, frame.clo.Fn)
+	if err != nil {
+		return nil, err
+	}
+	return d.run(compiled, frame)
+}
+
+// evaluateCondition evaluates the condition of b in frame, compiled once for the function of frame
+func (d *Debugger) evaluateCondition(b *Breakpoint, frame *Frame) (object.Object, error) {
+	compiled, ok := b.compiled[frame.clo.Fn]
+	if !ok {
+		var err error
+		compiled, err = d.compile(b.Condition, frame.clo.Fn)
+		if err != nil {
+			return nil, err
+		}
+
+		if b.compiled == nil {
+			b.compiled = map[*object.CompiledFunction]*expression{}
+		}
+		b.compiled[frame.clo.Fn] = compiled
+	}
+	return d.run(compiled, frame)
+}
+
+// compile compiles expr as a function with the locals and the free variables of fn,
+// which runs in place of fn in its frames
+func (d *Debugger) compile(expr string, fn *object.CompiledFunction) (*expression, error) {
+	program, err := parser.New(expr).ParseProgram()
+	if err != nil {
+		return nil, err
+	}
+
+	globals := compiler.NewSymbolTable()
+	for i, v := range object.Builtins {
+		globals.DefineBuiltin(i, v.Name)
+	}
+	globals.DefineNames(d.globalNames)
+	table := compiler.NewFunctionSymbolTable(globals, fn.LocalNames, fn.FreeNames)
+
+	c := compiler.NewWithStates(boxValues(d.vm.constants), table)
+	if err := c.Compile(program); err != nil {
+		return nil, err
+	}
+
+	bytecode := c.Bytecode()
+	compiled := &object.CompiledFunction{Instructions: bytecode.Instructions, SourceMap: bytecode.SourceMap,
+		Lines: bytecode.Lines, NumLocals: table.NumDefinitions()}
+	return &expression{fn: compiled, constants: valuesOf(bytecode.Constants)}, nil
+}
+
+// run runs compiled on a vm of its own, with copies of the locals of frame and of the globals
+func (d *Debugger) run(compiled *expression, frame *Frame) (object.Object, error) {
+	v := &VM{
+		frames:    make([]*Frame, MaxFrames),
+		constants: compiled.constants,
+		stack:     make([]Value, StackSize),
+		sp:        compiled.fn.NumLocals,
+		globals:   append([]Value{}, d.vm.globals[:len(d.globalNames)]...),
+		seed:      d.vm.seed,
+	}
+	v.frames[0] = NewFrame(&object.Closure{Fn: compiled.fn, Free: frame.clo.Free}, 0)
+	copy(v.stack[1:], d.vm.stack[frame.basePointer+1:frame.basePointer+1+frame.clo.Fn.NumLocals])
+
+	if err := v.RunContext(context.Background(), object.Limits{}); err != nil {
+		return nil, err
+	}
+	return v.StackLastTop(), nil
+}
@@ -0,0 +1,231 @@
+package vm
+
+import (
+	"compiler"
+	"errors"
+	"fmt"
+	"object"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const addProgram = `let add = fn(a, b) {
+	let sum = a + b;
+	sum
+};
+let x = add(1, 2);
+let y = add(x, 10);
+y`
+
+// debug runs input on a vm stopped by a debugger, stopped decides how to go on at each stop
+func debug(t *testing.T, input string, setup func(d *Debugger), stopped func(d *Debugger, stop Stop) error) (*VM, error) {
+	t.Helper()
+
+	program, err := parse(input)
+	if err != nil {
+		t.Fatalf("parse error: %s", err)
+	}
+
+	c := compiler.New()
+	c.DisableOptimizations()
+	if err := c.Compile(program); err != nil {
+		t.Fatalf("compile error: %s", err)
+	}
+
+	vm := New(c.Bytecode())
+	d := NewDebugger(vm, c.GlobalNames(), stopped)
+	if setup != nil {
+		setup(d)
+	}
+	return vm, vm.Run()
+}
+
+func TestDebuggerSteps(t *testing.T) {
+	tests := []struct {
+		step     func(d *Debugger)
+		expected []int
+	}{
+		{(*Debugger).StepIn, []int{1, 5, 2, 3, 6, 2, 3, 7}},
+		{(*Debugger).StepOver, []int{1, 5, 6, 7}},
+		// stepping out of the main program runs it to its end
+		{(*Debugger).StepOut, []int{1}},
+	}
+
+	for _, tt := range tests {
+		lines := []int{}
+		vm, err := debug(t, addProgram, nil, func(d *Debugger, stop Stop) error {
+			lines = append(lines, stop.Pos.Line)
+			tt.step(d)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		if !reflect.DeepEqual(lines, tt.expected) {
+			t.Errorf("wrong lines stopped at. want=%v, got=%v", tt.expected, lines)
+		}
+		if result := vm.StackLastTop().Inspect(); result != "13" {
+			t.Errorf("wrong result. want=13, got=%s", result)
+		}
+	}
+}
+
+func TestDebuggerStepOut(t *testing.T) {
+	lines := []int{}
+	_, err := debug(t, addProgram, nil, func(d *Debugger, stop Stop) error {
+		lines = append(lines, stop.Pos.Line)
+		if stop.Pos.Line == 2 {
+			d.StepOut()
+		} else {
+			d.StepIn()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	expected := []int{1, 5, 2, 6, 2, 7}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("wrong lines stopped at. want=%v, got=%v", expected, lines)
+	}
+}
+
+func TestDebuggerBreakpoints(t *testing.T) {
+	stops := []string{}
+	_, err := debug(t, addProgram, func(d *Debugger) {
+		if _, err := d.Break(4, ""); err == nil {
+			t.Errorf("expected an error for a line without statements")
+		}
+		if _, err := d.Break(2, "a == 3"); err != nil {
+			t.Fatalf("break failed: %s", err)
+		}
+		if _, err := d.Break(7, ""); err != nil {
+			t.Fatalf("break failed: %s", err)
+		}
+	}, func(d *Debugger, stop Stop) error {
+		if stop.Breakpoint == nil {
+			// the first statement
+			d.Continue()
+			return nil
+		}
+
+		vars := []string{}
+		for _, v := range d.Variables(0) {
+			value := v.Value.Inspect()
+			if v.Value.Type() == object.CLOJURE_OBJ {
+				// closures are inspected by their addresses
+				value = "closure"
+			}
+			vars = append(vars, fmt.Sprintf("%s %s=%s", v.Kind, v.Name, value))
+		}
+		frames := []string{}
+		for _, f := range d.Stack() {
+			frames = append(frames, fmt.Sprintf("%s@%d", f.Name, f.Pos.Line))
+		}
+		stops = append(stops, fmt.Sprintf("%d: %s; %s", stop.Pos.Line, strings.Join(vars, ", "), strings.Join(frames, " ")))
+		d.Continue()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	expected := []string{
+		"2: local a=3, local b=10; add@2 main@6",
+		"7: global add=closure, global x=3, global y=13; main@7",
+	}
+	if !reflect.DeepEqual(stops, expected) {
+		t.Errorf("wrong stops.\nwant=%q\ngot=%q", expected, stops)
+	}
+}
+
+func TestDebuggerFreeVariables(t *testing.T) {
+	input := `let make = fn(n) {
+	fn(m) {
+		n + m
+	}
+};
+make(1)(2)`
+
+	var vars []Variable
+	var sum string
+	_, err := debug(t, input, func(d *Debugger) {
+		d.Continue()
+		d.Break(3, "")
+	}, func(d *Debugger, stop Stop) error {
+		vars = d.Variables(0)
+		result, err := d.Evaluate(0, "n * 10 + m")
+		if err != nil {
+			t.Fatalf("evaluate failed: %s", err)
+		}
+		sum = result.Inspect()
+		d.Continue()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	if len(vars) != 2 || vars[0].Name != "m" || vars[0].Kind != "local" || vars[1].Name != "n" || vars[1].Kind != "free" {
+		t.Fatalf("wrong variables. got=%+v", vars)
+	}
+	if sum != "12" {
+		t.Errorf("wrong value of n * 10 + m. want=12, got=%s", sum)
+	}
+}
+
+func TestDebuggerEvaluate(t *testing.T) {
+	input := `let big = fn(n) { n > 2 };
+let total = 0;
+let add = fn(n) {
+	total = total + n
+};
+add(1);
+add(3);
+total`
+
+	var hits []string
+	vm, err := debug(t, input, func(d *Debugger) {
+		d.Continue()
+		// the condition calls a function of the program
+		if _, err := d.Break(4, "big(n)"); err != nil {
+			t.Fatalf("break failed: %s", err)
+		}
+	}, func(d *Debugger, stop Stop) error {
+		if stop.Err != nil {
+			t.Fatalf("stop error: %s", stop.Err)
+		}
+
+		for _, expr := range []string{"n", "total = 100; n = 0; let x = 1; [total, n, x]"} {
+			result, err := d.Evaluate(0, expr)
+			if err != nil {
+				t.Fatalf("evaluate %s failed: %s", expr, err)
+			}
+			hits = append(hits, result.Inspect())
+		}
+		d.Continue()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	if !reflect.DeepEqual(hits, []string{"3", "[100, 0, 1]"}) {
+		t.Errorf("wrong values. got=%q", hits)
+	}
+	// the assignments of the evaluated expressions are not seen by the program
+	if result := vm.StackLastTop().Inspect(); result != "4" {
+		t.Errorf("wrong result. want=4, got=%s", result)
+	}
+}
+
+func TestDebuggerQuit(t *testing.T) {
+	_, err := debug(t, addProgram, nil, func(d *Debugger, stop Stop) error {
+		return ErrQuit
+	})
+	if !errors.Is(err, ErrQuit) {
+		t.Errorf("wrong error. want=%q, got=%v", ErrQuit, err)
+	}
+}
@@ -0,0 +1,198 @@
+package vm
+
+import (
+	"compiler"
+	"errors"
+	"fmt"
+	"instrument"
+	"object"
+	"reflect"
+	"strings"
+	"testing"
+	"token"
+)
+
+// recorder records the start, the end, the calls, the returns and the errors of a run and fails the instruction
+// number failAt, if not 0
+type recorder struct {
+	object.NopHooks
+	events       []string
+	instructions int
+	failAt       int
+}
+
+var errStopped = errors.New("stopped by the hooks")
+
+func (r *recorder) OnStart() {
+	r.events = append(r.events, "start")
+}
+
+func (r *recorder) OnEnd(result object.Object) {
+	r.events = append(r.events, fmt.Sprintf("end %s", result.Inspect()))
+}
+
+func (r *recorder) OnInstruction(pos token.Position) error {
+	r.instructions++
+	if r.instructions == r.failAt {
+		return errStopped
+	}
+	return nil
+}
+
+func (r *recorder) OnCall(name string, args []object.Object) error {
+	r.events = append(r.events, fmt.Sprintf("call %s (%s)", name, inspectAll(args)))
+	return nil
+}
+
+func (r *recorder) OnReturn(name string, result object.Object) {
+	if result == nil {
+		r.events = append(r.events, fmt.Sprintf("leave %s", name))
+		return
+	}
+	r.events = append(r.events, fmt.Sprintf("return %s %s", name, result.Inspect()))
+}
+
+func (r *recorder) OnBuiltinCall(name string, args []object.Object) error {
+	r.events = append(r.events, fmt.Sprintf("builtin %s (%s)", name, inspectAll(args)))
+	return nil
+}
+
+func (r *recorder) OnBuiltinReturn(name string, result object.Object) {
+	r.events = append(r.events, fmt.Sprintf("builtin return %s %s", name, result.Inspect()))
+}
+
+func (r *recorder) OnError(err error) {
+	r.events = append(r.events, fmt.Sprintf("error %s", err))
+}
+
+func inspectAll(objs []object.Object) string {
+	inspected := []string{}
+	for _, obj := range objs {
+		inspected = append(inspected, obj.Inspect())
+	}
+	return strings.Join(inspected, ", ")
+}
+
+func runHooked(t *testing.T, input string, h object.Hooks) error {
+	t.Helper()
+
+	program, err := parse(input)
+	if err != nil {
+		t.Fatalf("parse error: %s", err)
+	}
+
+	c := compiler.New()
+	if err := c.Compile(program); err != nil {
+		t.Fatalf("compile error: %s", err)
+	}
+
+	vm := New(c.Bytecode())
+	vm.SetHooks(h)
+	return vm.Run()
+}
+
+func TestHooks(t *testing.T) {
+	r := &recorder{}
+	err := runHooked(t, traceInput, r)
+	if err == nil {
+		t.Fatalf("expected the program to fail")
+	}
+
+	expected := []string{
+		"start",
+		"call add (1, 2)",
+		"return add 3",
+		"call size ([1, two])",
+		"builtin len ([1, two])",
+		"builtin return len 2",
+		"return size 2",
+		"call loop (2)",
+		"leave loop",
+		"call loop (1)",
+		"leave loop",
+		"call loop (0)",
+		"return loop 0",
+		"call fn@8:1 ()",
+		"error " + err.Error(),
+	}
+	if !reflect.DeepEqual(r.events, expected) {
+		t.Errorf("wrong events.\nwant=%q\ngot=%q", expected, r.events)
+	}
+	if r.instructions == 0 {
+		t.Errorf("no instructions seen by the hooks")
+	}
+}
+
+func TestHooksStop(t *testing.T) {
+	r := &recorder{failAt: 10}
+	err := runHooked(t, traceInput, r)
+	if !errors.Is(err, errStopped) {
+		t.Fatalf("wrong error. want=%q, got=%v", errStopped, err)
+	}
+	if r.instructions != 10 {
+		t.Errorf("the run went on after the hooks failed. got=%d instructions", r.instructions)
+	}
+	if last := r.events[len(r.events)-1]; last != "error "+err.Error() {
+		t.Errorf("wrong last event. want=%q, got=%q", "error "+err.Error(), last)
+	}
+}
+
+func TestHooksEnd(t *testing.T) {
+	r := &recorder{}
+	if err := runHooked(t, "let f = fn(x) { x * 2 }; f(3)", r); err != nil {
+		t.Fatalf("run failed. error is: %q", err)
+	}
+
+	expected := []string{"start", "call f (3)", "return f 6", "end 6"}
+	if !reflect.DeepEqual(r.events, expected) {
+		t.Errorf("wrong events.\nwant=%q\ngot=%q", expected, r.events)
+	}
+}
+
+func TestHooksCombined(t *testing.T) {
+	program, err := parse("let f = fn(x) { [x] }; f(3)")
+	if err != nil {
+		t.Fatalf("parse error: %s", err)
+	}
+
+	c := compiler.New()
+	if err := c.Compile(program); err != nil {
+		t.Fatalf("compile error: %s", err)
+	}
+
+	first, second := &recorder{}, &recorder{}
+	p := instrument.NewProfiler()
+	m := instrument.NewMemProfiler()
+	stops := 0
+	vm := New(c.Bytecode())
+	vm.SetHooks(object.MultiHooks(first, nil, second))
+	vm.SetProfiler(p)
+	vm.SetMemProfiler(m)
+	NewDebugger(vm, c.GlobalNames(), func(d *Debugger, stop Stop) error {
+		stops++
+		d.Continue()
+		return nil
+	})
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	expected := []string{"start", "call f (3)", "return f [3]", "end [3]"}
+	for _, r := range []*recorder{first, second} {
+		if !reflect.DeepEqual(r.events, expected) {
+			t.Errorf("wrong events.\nwant=%q\ngot=%q", expected, r.events)
+		}
+	}
+	if first.instructions == 0 || first.instructions != second.instructions {
+		t.Errorf("wrong instructions seen by the hooks. got=%d and %d", first.instructions, second.instructions)
+	}
+	if p.Functions["f"] == nil || p.Functions["f"].Calls != 1 || p.Instructions != int64(first.instructions) {
+		t.Errorf("wrong profile. got=%+v, %d instructions", p.Functions, p.Instructions)
+	}
+	if m.Types[object.ARRAY_OBJ] == nil {
+		t.Errorf("array allocation not counted. got=%+v", m.Types)
+	}
+	if stops != 1 {
+		t.Errorf("wrong stops of the debugger. want=1, got=%d", stops)
+	}
+}
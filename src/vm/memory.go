@@ -0,0 +1,165 @@
+package vm
+
+import (
+	"fmt"
+	"instrument"
+	"io"
+	"object"
+	"sort"
+)
+
+// maxHeapObjects is the number of arrays, hashes and closures the report of a Heap shows
+const maxHeapObjects = 10
+
+// Heap is a snapshot of the objects a vm can still reach from its globals, its stack
+// and the closures of its frames
+type Heap struct {
+	Types    map[object.ObjectType]*instrument.Allocations
+	Arrays   []HeapObject
+	Hashes   []HeapObject
+	Closures []HeapClosures
+}
+
+// HeapObject is an array or a hash of a Heap. Bytes is the size of the objects reachable
+// through it, an object reachable in several ways is counted for each of them. Root is
+// where the vm first reached it from
+type HeapObject struct {
+	Object object.Object
+	Len    int
+	Bytes  int64
+	Root   string
+}
+
+// HeapClosures are the closures of a Heap made of the same function. Retained is the
+// size of the objects reachable through their free variables
+type HeapClosures struct {
+	Name     string
+	Count    int
+	Retained int64
+}
+
+// Heap takes a snapshot of the objects the vm can reach. globalNames, which can be nil,
+// names the globals by their indexes in the report
+func (v *VM) Heap(globalNames []string) *Heap {
+	h := &Heap{Types: map[object.ObjectType]*instrument.Allocations{}}
+	closures := map[*object.CompiledFunction]*HeapClosures{}
+
+	// visit accounts the objects reachable through obj and returns their size. The sizes
+	// are computed after the objects each one holds and kept, so every object is walked once
+	sizes := map[object.Object]int64{}
+	var visit func(obj object.Object, root string) int64
+	visit = func(obj object.Object, root string) int64 {
+		if obj == nil || obj == object.TRUE || obj == object.FALSE || obj == object.NULL {
+			return 0
+		}
+		if size, ok := sizes[obj]; ok {
+			return size
+		}
+		// an object reachable through itself is counted once
+		sizes[obj] = 0
+
+		a, ok := h.Types[obj.Type()]
+		if !ok {
+			a = &instrument.Allocations{}
+			h.Types[obj.Type()] = a
+		}
+		a.Add(obj)
+
+		size := object.SizeOf(obj)
+		switch obj := obj.(type) {
+		case *object.Array:
+			for _, elem := range obj.Elements {
+				size += visit(elem, root)
+			}
+			h.Arrays = append(h.Arrays, HeapObject{Object: obj, Len: len(obj.Elements), Bytes: size, Root: root})
+		case *object.HashTable:
+			for _, pair := range obj.Pairs() {
+				size += visit(pair.Key, root) + visit(pair.Value, root)
+			}
+			h.Hashes = append(h.Hashes, HeapObject{Object: obj, Len: obj.Len(), Bytes: size, Root: root})
+		case *object.Closure:
+			c, ok := closures[obj.Fn]
+			if !ok {
+				c = &HeapClosures{Name: functionName(obj.Fn, false)}
+				closures[obj.Fn] = c
+			}
+			c.Count++
+			for _, free := range obj.Free {
+				retained := visit(free, root)
+				c.Retained += retained
+				size += retained
+			}
+		}
+		sizes[obj] = size
+		return size
+	}
+
+	for i, val := range v.globals {
+		if val.obj == nil || val.isInteger() {
+			continue
+		}
+		root := fmt.Sprintf("global %d", i)
+		if i < len(globalNames) && globalNames[i] != "" {
+			root = "global " + globalNames[i]
+		}
+		visit(val.obj, root)
+	}
+	for i := 0; i <= v.sp; i++ {
+		if !v.stack[i].isInteger() {
+			visit(v.stack[i].obj, "stack")
+		}
+	}
+	for i := 1; i <= v.frameIndex; i++ {
+		visit(v.frames[i].clo, "frame")
+	}
+	if !v.lastPop.isInteger() {
+		visit(v.lastPop.obj, "result")
+	}
+
+	sortHeapObjects(h.Arrays)
+	sortHeapObjects(h.Hashes)
+	for _, c := range closures {
+		h.Closures = append(h.Closures, *c)
+	}
+	sort.Slice(h.Closures, func(i, j int) bool {
+		if h.Closures[i].Retained != h.Closures[j].Retained {
+			return h.Closures[i].Retained > h.Closures[j].Retained
+		}
+		return h.Closures[i].Name < h.Closures[j].Name
+	})
+	return h
+}
+
+func sortHeapObjects(objects []HeapObject) {
+	sort.SliceStable(objects, func(i, j int) bool {
+		return objects[i].Bytes > objects[j].Bytes
+	})
+}
+
+// WriteReport writes the live objects by type, the largest arrays and hashes and the
+// closures which retain the most
+func (h *Heap) WriteReport(w io.Writer) {
+	fmt.Fprintf(w, "live objects:\n")
+	instrument.WriteAllocations(w, h.Types)
+
+	for _, objects := range []struct {
+		title   string
+		objects []HeapObject
+	}{{"largest arrays", h.Arrays}, {"largest hashes", h.Hashes}} {
+		fmt.Fprintf(w, "\n%s:\n%10s %12s  %s\n", objects.title, "len", "bytes", "root")
+		for i, obj := range objects.objects {
+			if i == maxHeapObjects {
+				break
+			}
+			fmt.Fprintf(w, "%10d %12d  %s\n", obj.Len, obj.Bytes, obj.Root)
+		}
+	}
+
+	fmt.Fprintf(w, "\nretained closures:\n%10s %12s  %s\n", "count", "retained", "function")
+	for i, c := range h.Closures {
+		if i == maxHeapObjects {
+			break
+		}
+		fmt.Fprintf(w, "%10d %12d  %s\n", c.Count, c.Retained, c.Name)
+	}
+}
@@ -0,0 +1,189 @@
+package vm
+
+import (
+	"bytes"
+	"compiler"
+	"instrument"
+	"object"
+	"strings"
+	"testing"
+	"token"
+)
+
+func runMemProfiled(t *testing.T, input string) (*VM, *instrument.MemProfiler, []string) {
+	t.Helper()
+
+	program, err := parse(input)
+	if err != nil {
+		t.Fatalf("parse error: %s", err)
+	}
+
+	c := compiler.New()
+	if err := c.Compile(program); err != nil {
+		t.Fatalf("compile error: %s", err)
+	}
+
+	m := instrument.NewMemProfiler()
+	vm := New(c.Bytecode())
+	vm.SetMemProfiler(m)
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	return vm, m, c.GlobalNames()
+}
+
+func TestMemProfiler(t *testing.T) {
+	_, m, _ := runMemProfiled(t, `
+let xs = [1, 2, 3];
+let ys = push(xs, 4);
+let h = {"a": xs};
+let f = fn(x) { fn() { x } };
+f(1); f(2);
+1 + 2;
+`)
+
+	tests := []struct {
+		typ   object.ObjectType
+		count int64
+	}{
+		{object.ARRAY_OBJ, 2},
+		{object.HASHTABLE_OBJ, 1},
+		{object.CLOJURE_OBJ, 3},
+	}
+	for _, tt := range tests {
+		a := m.Types[tt.typ]
+		if a == nil || a.Count != tt.count {
+			t.Errorf("wrong allocations of %s. want=%d, got=%+v", tt.typ, tt.count, a)
+		}
+	}
+	if _, ok := m.Types[object.INTEGER_OBJ]; ok {
+		t.Errorf("unboxed integers are counted as allocated")
+	}
+
+	// the inner function is made at the same position by both calls
+	inner := m.Sites[instrument.Site{Function: "f", Pos: token.Position{Line: 5, Column: 17}}]
+	if inner == nil || inner.Count != 2 {
+		t.Errorf("wrong allocations at the inner function. want=2, got=%+v in %v", inner, m.Sites)
+	}
+	if push := m.Sites[instrument.Site{Function: "main", Pos: token.Position{Line: 3, Column: 14}}]; push == nil || push.Count != 1 {
+		t.Errorf("wrong allocations at the call of push. want=1, got=%+v in %v", push, m.Sites)
+	}
+}
+
+func TestMemProfilerInputs(t *testing.T) {
+	m := instrument.NewMemProfiler()
+	for _, input := range []int{1, 2} {
+		program, err := parse("[1]")
+		if err != nil {
+			t.Fatalf("parse error: %s", err)
+		}
+
+		c := compiler.New()
+		if err := c.Compile(program); err != nil {
+			t.Fatalf("compile error: %s", err)
+		}
+
+		m.Input = input
+		vm := New(c.Bytecode())
+		vm.SetMemProfiler(m)
+		if err := vm.Run(); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+	}
+
+	// the inputs allocate at the same position, they are different sites
+	for _, input := range []int{1, 2} {
+		site := instrument.Site{Input: input, Function: "main", Pos: token.Position{Line: 1, Column: 1}}
+		if a := m.Sites[site]; a == nil || a.Count != 1 {
+			t.Errorf("wrong allocations of input %d. want=1, got=%+v in %v", input, a, m.Sites)
+		}
+	}
+
+	var report bytes.Buffer
+	m.WriteReport(&report)
+	if !strings.Contains(report.String(), "input 2, main at line: 1, column: 1") {
+		t.Errorf("report does not name the input. got=%q", report.String())
+	}
+}
+
+func TestHeapShared(t *testing.T) {
+	// each array holds the one before it twice
+	input := "let a = [1]; "
+	for i := 0; i < 40; i++ {
+		input += "let a = [a, a]; "
+	}
+	vm, _, names := runMemProfiled(t, input+"0")
+
+	heap := vm.Heap(names)
+	if arrays := heap.Types[object.ARRAY_OBJ]; arrays == nil || arrays.Count != 41 {
+		t.Fatalf("wrong live arrays. want=41, got=%+v", arrays)
+	}
+
+	// the size of an array counts the one it holds for each of its elements
+	one := object.SizeOf(&object.Array{Elements: []object.Object{nil}}) + object.SizeOf(&object.Integer{Value: 1})
+	pair := object.SizeOf(&object.Array{Elements: []object.Object{nil, nil}})
+	want := one
+	for i := 0; i < 40; i++ {
+		want = pair + 2*want
+	}
+	if heap.Arrays[0].Bytes != want {
+		t.Errorf("wrong size of the largest array. want=%d, got=%d", want, heap.Arrays[0].Bytes)
+	}
+}
+
+func TestHeap(t *testing.T) {
+	vm, _, names := runMemProfiled(t, `
+let big = [1, 2, 3, 4, 5, 6, 7, 8];
+let small = [1];
+let h = {"a": small, "b": [2, 3]};
+let keep = fn(x) { fn() { x } };
+let kept = [keep(big), keep(small)];
+let dropped = fn() { [1, 2, 3] }();
+0
+`)
+
+	heap := vm.Heap(names)
+
+	// the array made by dropped is reachable as its value
+	if arrays := heap.Types[object.ARRAY_OBJ]; arrays == nil || arrays.Count != 5 {
+		t.Errorf("wrong live arrays. want=5, got=%+v", arrays)
+	}
+	if hashes := heap.Types[object.HASHTABLE_OBJ]; hashes == nil || hashes.Count != 1 {
+		t.Errorf("wrong live hashes. want=1, got=%+v", hashes)
+	}
+
+	if len(heap.Arrays) == 0 || heap.Arrays[0].Root != "global kept" {
+		t.Errorf("the largest array is not kept. got=%+v", heap.Arrays)
+	}
+	if len(heap.Hashes) != 1 || heap.Hashes[0].Len != 2 || heap.Hashes[0].Root != "global h" {
+		t.Errorf("wrong hashes. got=%+v", heap.Hashes)
+	}
+
+	var closures *HeapClosures
+	for i := range heap.Closures {
+		if heap.Closures[i].Name == "fn@5:20" {
+			closures = &heap.Closures[i]
+		}
+	}
+	if closures == nil || closures.Count != 2 {
+		t.Fatalf("wrong closures of the inner function. got=%+v", heap.Closures)
+	}
+	// the closures hold big and small, the arrays of the first two globals
+	want := int64(0)
+	for _, array := range heap.Arrays {
+		if array.Object == vm.globals[0].obj || array.Object == vm.globals[1].obj {
+			want += array.Bytes
+		}
+	}
+	if want == 0 || closures.Retained != want {
+		t.Errorf("wrong retained size. want=%d, got=%d", want, closures.Retained)
+	}
+
+	var report bytes.Buffer
+	heap.WriteReport(&report)
+	for _, want := range []string{"live objects:", "largest arrays:", "largest hashes:", "retained closures:", "global big"} {
+		if !strings.Contains(report.String(), want) {
+			t.Errorf("report does not contain %q. got=%q", want, report.String())
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package vm
+
+import (
+	"bytes"
+	"code"
+	"compiler"
+	"instrument"
+	"strings"
+	"testing"
+)
+
+func runProfiled(t *testing.T, input string) *instrument.Profiler {
+	t.Helper()
+
+	program, err := parse(input)
+	if err != nil {
+		t.Fatalf("parse error: %s", err)
+	}
+
+	c := compiler.New()
+	if err := c.Compile(program); err != nil {
+		t.Fatalf("compile error: %s", err)
+	}
+
+	p := instrument.NewProfiler()
+	vm := New(c.Bytecode())
+	vm.SetProfiler(p)
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	return p
+}
+
+func functionProfile(p *instrument.Profiler, name string) *instrument.FunctionProfile {
+	for _, fn := range p.Functions {
+		if fn.Name == name {
+			return fn
+		}
+	}
+	return nil
+}
+
+func names(p *instrument.Profiler) []string {
+	names := []string{}
+	for _, fn := range p.Functions {
+		names = append(names, fn.Name)
+	}
+	return names
+}
+
+func TestProfilerCalls(t *testing.T) {
+	p := runProfiled(t, `
+let fib = fn(n) {
+	if (n < 2) { return n; }
+	fib(n - 1) + fib(n - 2)
+};
+let loop = fn(n, acc) { if (n == 0) { acc } else { loop(n - 1, acc + 1) } };
+let twice = fn(f) { f(); f() };
+fib(10);
+loop(5, 0);
+twice(fn() { 1 });
+`)
+
+	tests := []struct {
+		name  string
+		calls int64
+	}{
+		{"main", 1},
+		{"fib", 177},
+		// the tail calls are calls too
+		{"loop", 6},
+		{"twice", 1},
+		{"fn@10:7", 2},
+	}
+
+	for _, tt := range tests {
+		fn := functionProfile(p, tt.name)
+		if fn == nil {
+			t.Errorf("no profile of %s in %v", tt.name, names(p))
+			continue
+		}
+		if fn.Calls != tt.calls {
+			t.Errorf("wrong calls of %s. want=%d, got=%d", tt.name, tt.calls, fn.Calls)
+		}
+		if fn.Exclusive > fn.Inclusive {
+			t.Errorf("exclusive time of %s is more than its inclusive time. exclusive=%s, inclusive=%s", tt.name, fn.Exclusive, fn.Inclusive)
+		}
+		// the time of a recursive call is only accounted once, to the outermost call
+		if fn.Inclusive > p.Total {
+			t.Errorf("inclusive time of %s is more than the total time. inclusive=%s, total=%s", tt.name, fn.Inclusive, p.Total)
+		}
+	}
+
+	main := functionProfile(p, "main")
+	if main.Inclusive != p.Total {
+		t.Errorf("inclusive time of main is not the total time. want=%s, got=%s", p.Total, main.Inclusive)
+	}
+}
+
+func TestProfilerInstructions(t *testing.T) {
+	p := runProfiled(t, `
+let add = fn(a, b) { a + b };
+add(1, 2);
+add(3, 4);
+`)
+
+	if got := p.OpCodes[code.OpCall].Count; got != 2 {
+		t.Errorf("wrong count of OpCall. want=2, got=%d", got)
+	}
+	if got := p.OpCodes[code.OpAdd].Count; got != 2 {
+		t.Errorf("wrong count of OpAdd. want=2, got=%d", got)
+	}
+
+	var total int64
+	for _, c := range p.OpCodes {
+		total += c.Count
+	}
+	if total != p.Instructions {
+		t.Errorf("counts of opcodes do not add up to the instructions. want=%d, got=%d", p.Instructions, total)
+	}
+
+	// line 2 runs the body of add for each call
+	if p.Lines[2] == nil || p.Lines[2].Count < 4 {
+		t.Errorf("wrong count of line 2. got=%+v", p.Lines[2])
+	}
+	if p.Lines[3] == nil || p.Lines[4] == nil {
+		t.Errorf("lines 3 and 4 are not counted. got=%+v, %+v", p.Lines[3], p.Lines[4])
+	}
+}
+
+func TestProfilerOutput(t *testing.T) {
+	p := runProfiled(t, `
+let g = fn() { 1 };
+let f = fn() { g() + 1 };
+f();
+`)
+
+	var folded bytes.Buffer
+	p.WriteFolded(&folded)
+
+	stacks := map[string]bool{}
+	for _, line := range strings.Split(strings.TrimSpace(folded.String()), "\n") {
+		i := strings.LastIndex(line, " ")
+		if i < 0 {
+			t.Fatalf("wrong folded line %q", line)
+		}
+		stacks[line[:i]] = true
+	}
+	for _, stack := range []string{"main", "main;f", "main;f;g"} {
+		if !stacks[stack] {
+			t.Errorf("no stack %q in folded output %q", stack, folded.String())
+		}
+	}
+
+	var report bytes.Buffer
+	p.WriteReport(&report, "")
+	for _, want := range []string{"functions:", "opcodes:", "hot lines:", "OpCall"} {
+		if !strings.Contains(report.String(), want) {
+			t.Errorf("report does not contain %q. got=%q", want, report.String())
+		}
+	}
+}
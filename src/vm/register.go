@@ -0,0 +1,380 @@
+package vm
+
+import (
+	"code"
+	"compiler"
+	"context"
+	"fmt"
+	"object"
+)
+
+// RegisterFileSize is the number of registers shared by all frames of a RegisterVM
+const RegisterFileSize = 1 << 14
+
+type registerFrame struct {
+	clo *object.RegisterClosure
+	ip  int
+	// base is the index of the first register of the frame in the register file
+	base int
+}
+
+// RegisterVM runs the bytecode of compiler.RegisterCompiler. It keeps values like VM
+// and fails with the same errors, so both can be compared on the same programs
+type RegisterVM struct {
+	frames     []*registerFrame
+	frameIndex int
+
+	constants []Value
+	registers []Value
+	globals   []Value
+
+	result Value
+
+	budget *object.Budget
+	seed   object.HashSeed
+}
+
+func NewRegister(bytecode *compiler.RegisterBytecode) *RegisterVM {
+	fn := &object.RegisterFunction{Instructions: bytecode.Instructions, SourceMap: bytecode.SourceMap,
+		NumRegisters: bytecode.NumRegisters}
+	mainFrame := &registerFrame{clo: &object.RegisterClosure{Fn: fn}}
+
+	frames := make([]*registerFrame, MaxFrames)
+	frames[0] = mainFrame
+
+	return &RegisterVM{
+		frames:    frames,
+		constants: valuesOf(bytecode.Constants),
+		registers: make([]Value, RegisterFileSize),
+		globals:   make([]Value, GlobalSize),
+		budget:    object.NewBudget(context.Background(), object.Limits{}),
+	}
+}
+
+// NewRegisterWithGlobals makes a vm which keeps its globals in globals, they can be
+// shared with the programs run before like in the repl
+func NewRegisterWithGlobals(bytecode *compiler.RegisterBytecode, globals []Value) *RegisterVM {
+	vm := NewRegister(bytecode)
+	vm.globals = globals
+	return vm
+}
+
+// SetHashSeed makes the hashes made by the runs of v hash their keys with seed
+func (v *RegisterVM) SetHashSeed(seed object.HashSeed) {
+	v.seed = seed
+}
+
+// StackLastTop returns the result of the program boxed into an object. It is named
+// like the method of VM, the result is the value of the last expression statement
+func (v *RegisterVM) StackLastTop() object.Object {
+	return v.result.Object()
+}
+
+// alloc accounts val computed by the current instruction, only boxed values are allocated
+func (v *RegisterVM) alloc(val Value) error {
+	if val.isInteger() {
+		return nil
+	}
+	return v.budget.Alloc(val.obj)
+}
+
+// operatorOf returns the operator of the stack vm which op applies to registers
+func operatorOf(op code.RegisterOpCode) code.OpCode {
+	switch op {
+	case code.ROpAdd, code.ROpAddConstant:
+		return code.OpAdd
+	case code.ROpSubtraction, code.ROpSubtractConstant:
+		return code.OpSubtraction
+	case code.ROpMultiply:
+		return code.OpMultiply
+	case code.ROpDivide:
+		return code.OpDivide
+	case code.ROpModulo:
+		return code.OpModulo
+	case code.ROpEqual, code.ROpJumpNotEqual:
+		return code.OpEqual
+	case code.ROpNotEqual, code.ROpJumpEqual:
+		return code.OpNotEqual
+	case code.ROpGreaterThan, code.ROpJumpNotGreaterThan:
+		return code.OpGreaterThan
+	case code.ROpLessThan, code.ROpJumpNotLessThan:
+		return code.OpLessThan
+	case code.ROpLessEqual, code.ROpJumpNotLessEqual:
+		return code.OpLessEqual
+	default:
+		return code.OpGreaterEqual
+	}
+}
+
+func (v *RegisterVM) runtimeError(err error, frame *registerFrame, ip int) *RuntimeError {
+	pos, _ := frame.clo.Fn.SourceMap.Lookup(ip)
+	return &RuntimeError{Msg: err.Error(), Pos: pos, Err: err}
+}
+
+// Run executes the bytecode until the main function finishes or an instruction fails.
+// It never panics, any failure is reported as a *RuntimeError
+func (v *RegisterVM) Run() error {
+	return v.RunContext(context.Background(), object.Limits{})
+}
+
+// RunContext is like Run but stops when ctx is done or the program exceeds limits
+func (v *RegisterVM) RunContext(ctx context.Context, limits object.Limits) (err error) {
+	v.budget = object.NewBudget(ctx, limits)
+	// a program without expression statements results in null
+	v.result = Null
+
+	frame := v.frames[v.frameIndex]
+	var ip int
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = v.runtimeError(fmt.Errorf("internal error: %v", r), frame, ip)
+		}
+	}()
+
+	if err = v.budget.StackSize(frame.clo.Fn.NumRegisters, len(v.registers)); err != nil {
+		return v.runtimeError(err, frame, 0)
+	}
+
+	for {
+		frame = v.frames[v.frameIndex]
+		ins := frame.clo.Fn.Instructions
+		ip = frame.ip
+		if ip >= len(ins) {
+			break
+		}
+		frame.ip++
+
+		if err = v.budget.Step(); err != nil {
+			return v.runtimeError(err, frame, ip)
+		}
+
+		in := ins[ip]
+		regs := v.registers[frame.base:]
+
+		switch in.Op {
+		case code.ROpLoadConstant:
+			regs[in.A] = v.constants[in.B]
+		case code.ROpLoadTrue:
+			regs[in.A] = True
+		case code.ROpLoadFalse:
+			regs[in.A] = False
+		case code.ROpLoadNull:
+			regs[in.A] = Null
+		case code.ROpMove:
+			if regs[in.B].IsUndefined() {
+				err = object.Undefined("local", int(in.B))
+				break
+			}
+			regs[in.A] = regs[in.B]
+		case code.ROpGetGlobal:
+			globalV := v.globals[in.B]
+			if globalV.IsUndefined() {
+				err = object.Undefined("global", int(in.B))
+				break
+			}
+			regs[in.A] = globalV
+		case code.ROpSetGlobal:
+			v.globals[in.A] = regs[in.B]
+		case code.ROpGetBuiltin:
+			builtin := object.FindBuiltinByIndex(int(in.B))
+			if builtin == nil {
+				err = fmt.Errorf("unknown builtin function %d", in.B)
+				break
+			}
+			regs[in.A] = ValueOf(builtin)
+		case code.ROpGetFree:
+			frees := frame.clo.Free
+			if int(in.B) >= len(frees) {
+				err = fmt.Errorf("free variable %d is out of range of %d free variables", in.B, len(frees))
+				break
+			}
+			regs[in.A] = ValueOf(frees[in.B])
+		case code.ROpCurrentClosure:
+			regs[in.A] = ValueOf(frame.clo)
+		case code.ROpAdd, code.ROpSubtraction, code.ROpMultiply, code.ROpDivide, code.ROpModulo,
+			code.ROpEqual, code.ROpNotEqual, code.ROpGreaterThan, code.ROpGreaterEqual, code.ROpLessThan, code.ROpLessEqual:
+			var result Value
+			result, err = binaryOperation(operatorOf(in.Op), regs[in.B], regs[in.C])
+			if err == nil {
+				err = v.alloc(result)
+			}
+			regs[in.A] = result
+		case code.ROpAddConstant, code.ROpSubtractConstant:
+			var result Value
+			result, err = binaryOperation(operatorOf(in.Op), regs[in.B], v.constants[in.C])
+			if err == nil {
+				err = v.alloc(result)
+			}
+			regs[in.A] = result
+		case code.ROpMinus:
+			if regs[in.B].IsUndefined() {
+				err = fmt.Errorf("minus operator need one operand")
+				break
+			}
+
+			var result Value
+			result, err = negate(regs[in.B])
+			if err == nil {
+				err = v.alloc(result)
+			}
+			regs[in.A] = result
+		case code.ROpBang:
+			if regs[in.B].IsUndefined() {
+				err = fmt.Errorf("bang operator need one operand")
+				break
+			}
+			regs[in.A] = BooleanValue(!isTruethy(regs[in.B]))
+		case code.ROpIndex:
+			coll, index := regs[in.B], regs[in.C]
+
+			// an element of an array is read without boxing the index
+			if arr, ok := coll.obj.(*object.Array); ok && index.isInteger() && index.i >= 0 && index.i < int64(len(arr.Elements)) {
+				regs[in.A] = ValueOf(arr.Elements[index.i])
+				break
+			}
+
+			var elem object.Object
+			elem, err = object.Index(coll.Object(), index.Object())
+			if err == nil && coll.Type() == object.STRING_OBJ {
+				err = v.budget.Alloc(elem)
+			}
+			regs[in.A] = ValueOf(elem)
+		case code.ROpSlice:
+			var slice object.Object
+			slice, err = object.Slice(regs[in.B].Object(), regs[in.C].Object(), regs[in.C+1].Object())
+			if err == nil {
+				err = v.budget.Alloc(slice)
+			}
+			regs[in.A] = ValueOf(slice)
+		case code.ROpArray:
+			arr := &object.Array{Elements: boxValues(regs[in.B : in.B+in.C])}
+			err = v.budget.Alloc(arr)
+			regs[in.A] = ValueOf(arr)
+		case code.ROpHash:
+			var hash *object.HashTable
+			hash, err = newHash(regs[in.B:in.B+2*in.C], v.seed)
+			if err == nil {
+				err = v.budget.Alloc(hash)
+			}
+			regs[in.A] = Value{obj: hash}
+		case code.ROpClosure:
+			fn, ok := v.constants[in.B].obj.(*object.RegisterFunction)
+			if !ok {
+				err = fmt.Errorf("not a function: %+v", v.constants[in.B].Object())
+				break
+			}
+
+			clo := &object.RegisterClosure{Fn: fn, Free: boxValues(regs[in.C : int(in.C)+fn.NumFree])}
+			err = v.budget.Alloc(clo)
+			regs[in.A] = Value{obj: clo}
+		case code.ROpCall, code.ROpTailCall:
+			callee := regs[in.A]
+			switch fn := callee.obj.(type) {
+			case *object.RegisterClosure:
+				if in.Op == code.ROpTailCall {
+					err = v.tailCall(fn, frame, int(in.A), int(in.B))
+				} else {
+					err = v.call(fn, frame.base+int(in.A)+1, int(in.B))
+				}
+			case *object.Builtin:
+				// a builtin does not push a frame, a tail call of it returns its result with the following instructions
+				var ret object.Object
+				var allocated bool
+				ret, allocated, err = object.CallBuiltin(fn, boxValues(regs[in.A+1:in.A+1+in.B]))
+				if err != nil {
+					break
+				}
+				if allocated {
+					err = v.budget.Alloc(ret)
+				}
+				regs[in.A] = ValueOf(ret)
+			default:
+				err = object.NotCallable(callee)
+			}
+		case code.ROpReturn, code.ROpReturnNull:
+			ret := Null
+			if in.Op == code.ROpReturn {
+				ret = regs[in.A]
+			}
+
+			if v.frameIndex == 0 {
+				// return at top level ends the program with ret as the result
+				v.result = ret
+				return nil
+			}
+
+			// the result replaces the callee in the register before the first register of the frame
+			v.frameIndex--
+			v.registers[frame.base-1] = ret
+		case code.ROpJump:
+			frame.ip = int(in.A)
+		case code.ROpJumpNotTruethy:
+			if !isTruethy(regs[in.A]) {
+				frame.ip = int(in.B)
+			}
+		case code.ROpJumpNotEqual, code.ROpJumpEqual, code.ROpJumpNotGreaterThan, code.ROpJumpNotGreaterEqual,
+			code.ROpJumpNotLessThan, code.ROpJumpNotLessEqual:
+			var result Value
+			result, err = binaryOperation(operatorOf(in.Op), regs[in.A], regs[in.B])
+			if err == nil && !isTruethy(result) {
+				frame.ip = int(in.C)
+			}
+		case code.ROpResult:
+			v.result = regs[in.A]
+		default:
+			err = fmt.Errorf("unknown register instruction %d", in.Op)
+		}
+
+		if err != nil {
+			return v.runtimeError(err, frame, ip)
+		}
+	}
+
+	return nil
+}
+
+// call enters a frame for clo whose first register is base, the arguments are already in place
+func (v *RegisterVM) call(clo *object.RegisterClosure, base int, numArgs int) error {
+	if err := object.CheckArguments(clo.Fn.NumParameters, numArgs); err != nil {
+		return err
+	}
+
+	if err := v.budget.StackSize(base+clo.Fn.NumRegisters, len(v.registers)); err != nil {
+		return err
+	}
+	if err := v.budget.CallDepth(v.frameIndex+1, len(v.frames)-1); err != nil {
+		return err
+	}
+
+	// clear registers left by former calls so locals can not be read before defined
+	clear(v.registers[base+numArgs : base+clo.Fn.NumRegisters])
+
+	v.frameIndex++
+	frame := v.frames[v.frameIndex]
+	if frame == nil {
+		frame = &registerFrame{}
+		v.frames[v.frameIndex] = frame
+	}
+	frame.clo, frame.ip, frame.base = clo, 0, base
+	return nil
+}
+
+// tailCall calls clo in frame, whose function returns the result of the call right away.
+// The arguments in the registers after fn become the first registers of frame
+func (v *RegisterVM) tailCall(clo *object.RegisterClosure, frame *registerFrame, fn int, numArgs int) error {
+	if err := object.CheckArguments(clo.Fn.NumParameters, numArgs); err != nil {
+		return err
+	}
+
+	if err := v.budget.StackSize(frame.base+clo.Fn.NumRegisters, len(v.registers)); err != nil {
+		return err
+	}
+
+	args := frame.base + fn + 1
+	copy(v.registers[frame.base:], v.registers[args:args+numArgs])
+	clear(v.registers[frame.base+numArgs : frame.base+clo.Fn.NumRegisters])
+
+	frame.clo, frame.ip = clo, 0
+	return nil
+}
@@ -0,0 +1,230 @@
+package vm
+
+import (
+	"compiler"
+	"conformance"
+	"context"
+	"errors"
+	"object"
+	"strings"
+	"testing"
+)
+
+func runRegisterProgram(input string, optimize bool, limits object.Limits) (object.Object, error) {
+	program, err := parse(input)
+	if err != nil {
+		return nil, err
+	}
+
+	c := compiler.NewRegisterCompiler()
+	if !optimize {
+		c.DisableOptimizations()
+	}
+	err = c.Compile(program)
+	if err != nil {
+		return nil, err
+	}
+
+	bytecode, err := c.Bytecode()
+	if err != nil {
+		return nil, err
+	}
+
+	v := NewRegister(bytecode)
+	err = v.RunContext(context.Background(), limits)
+	if err != nil {
+		return nil, err
+	}
+	return v.StackLastTop(), nil
+}
+
+func TestRegisterVM(t *testing.T) {
+	tests := []vmTestCase{
+		{"1 + 2 * 3 - 4 / 2 % 3", 5},
+		{"let a = 1; let b = a + 1; a + b", 3},
+		{"if (1 < 2) { 10 } else { 20 }", 10},
+		{"if (1 > 2) { 10 }", nil},
+		{`"a" + "b"`, "ab"},
+		{"[1, 2 + 3, [4]][1]", 5},
+		{"[1, 2, 3][1:]", []interface{}{2, 3}},
+		{`{"a": 1, "b": 2}["b"]`, 2},
+		{"let f = fn(a, b) { let c = a + b; c * 2 }; f(1, 2)", 6},
+		{"let f = fn() { }; f()", nil},
+		{"let f = fn() { return; 1 }; f()", nil},
+		{"let add = fn(a) { fn(b) { fn(c) { a + b + c } } }; add(1)(2)(3)", 6},
+		{"let f = fn(n) { if (n < 2) { n } else { f(n - 1) + f(n - 2) } }; f(15)", 610},
+		{"let f = fn() { let g = fn(n) { if (n == 0) { 0 } else { g(n - 1) } }; g(3) }; f()", 0},
+		{"len(push([1, 2], 3))", 3},
+		{"-(1 - 2) * -3", -3},
+		{"!(1 == 2)", true},
+		{"let a = 1; a", 1},
+		{"let f = fn(x) { let y = x; let z = y; z }; f(7)", 7},
+		{"let f = fn(a, b) { [b, a] }; let a = 1; f(a + 1, a)", []interface{}{1, 2}},
+		{"let a = 1; a = a + 1; a", 2},
+		{"let f = fn(x) { x = x * 2; x }; f(3)", 6},
+		{"let f = fn(x) { x + (x = 5) }; f(1)", 6},
+		{"let f = fn(x) { [x, x = 2, x] }; f(1)", []interface{}{1, 2, 2}},
+		{"let f = fn(x) { (x = 2) <= x }; f(1)", true},
+		{"let f = fn(x) { if ((x = 2) < 3) { x } }; f(1)", 2},
+		{"let f = fn(x) { [1, 2, 3][x:(x = 2)] }; f(1)", []interface{}{2}},
+	}
+
+	for _, test := range tests {
+		for _, optimize := range []bool{false, true} {
+			actual, err := runRegisterProgram(test.input, optimize, object.Limits{})
+			if err != nil {
+				t.Fatalf("run program for input: %q failed with optimize=%t. error is: %q", test.input, optimize, err)
+			}
+			testExpectedObject(t, test.input, test.expected, actual)
+		}
+	}
+}
+
+// TestRegisterVMMatchesVM runs the programs of the other tests on both vms
+func TestRegisterVMMatchesVM(t *testing.T) {
+	tests := []string{
+		"9223372036854775807 + 1 - 1",
+		`"a" + "b" == "ab"`,
+		`!(1 < 2) != "a"`,
+		"[1, 2] == [1, 2]",
+		"if (!false) { let a = 1; a }",
+		"if (0) { 1 } else { 2 }",
+		"let f = fn(x) { if (x) { return 1; } else { return 2; } 3 }; [f(true), f(false)]",
+		"let f = fn(x) { if (x > 2 * 5) { x } else { f(x + 10 / 2) } }; f(1)",
+		"return 1 + 1; 3",
+		"if (true) { return 1; }; 2",
+		"{1 + 1: 2 * 2}[2]",
+		"let f = fn(x) { [x + 1, x - 1] }; [f(9223372036854775807), f(-9223372036854775807 - 1), f(0)]",
+		`let f = fn(x, y) { [if (x == y) { 1 } else { 2 }, if (x != y) { 1 } else { 2 }] }; [f(1, 1), f("a", "b"), f(true, true), f([1], [1])]`,
+		"let f = fn(x, y) { [if (x > y) { 1 } else { 2 }, if (x >= y) { 1 } else { 2 }] }; [f(1, 2), f(2, 2), f(9223372036854775807 + 1, 1)]",
+		"let f = fn(x, y) { [if (x < y) { 1 } else { 2 }, if (x <= y) { 1 } else { 2 }] }; [f(1, 2), f(2, 2), f(3, 2)]",
+		`let s = "abc"; [s[0], s[1:], s[:-1], len(s)]`,
+		"let f = fn(arr) { len(arr) }; f([1, 2]) + 1",
+		"let outer = fn(x) { let inner = fn(y) { x + y }; inner(1) }; [outer(1), outer(2)]",
+		"let countDown = fn(n) { if (n == 0) { return 0; } countDown(n - 1) }; countDown(100000)",
+		"let f = fn(x) { let g = fn() { x }; g }; f(1)() + f(2)()",
+		"let f = fn(n, acc) { if (n == 0) { acc } else { f(n - 1, push(acc, n)) } }; f(5, [])",
+		"if (true) { let a = 1; }",
+		"let f = fn() { if (true) { let a = 1; } }; f()",
+		`{"a": [1, {"b": 2}]}["a"][1]["b"]`,
+	}
+
+	for _, input := range tests {
+		expect, err := runProgram(input, true)
+		if err != nil {
+			t.Fatalf("run program for input: %q failed. error is: %q", input, err)
+		}
+
+		for _, optimize := range []bool{false, true} {
+			actual, err := runRegisterProgram(input, optimize, object.Limits{})
+			if err != nil {
+				t.Fatalf("run program for input: %q failed with optimize=%t. error is: %q", input, optimize, err)
+			}
+
+			if actual.Inspect() != expect.Inspect() {
+				t.Errorf("wrong result for input: %q with optimize=%t. want=%s, got=%s",
+					input, optimize, expect.Inspect(), actual.Inspect())
+			}
+		}
+	}
+}
+
+// TestRegisterVMErrors checks that both vms fail the programs of the crash corpus with the same errors
+func TestRegisterVMErrors(t *testing.T) {
+	for _, input := range conformance.CrashCorpus {
+		program, err := parse(input)
+		if err != nil {
+			continue
+		}
+
+		c := compiler.New()
+		if err := c.Compile(program); err != nil {
+			continue
+		}
+		expect := New(c.Bytecode()).RunContext(context.Background(), crashLimits)
+		if errors.Is(expect, object.ErrInstructionLimit) {
+			// the vms count instructions of their own
+			continue
+		}
+
+		for _, optimize := range []bool{false, true} {
+			_, err := runRegisterProgram(input, optimize, crashLimits)
+			if (err == nil) != (expect == nil) {
+				t.Errorf("wrong error for input: %q with optimize=%t. want=%v, got=%v", input, optimize, expect, err)
+				continue
+			}
+			if err == nil {
+				continue
+			}
+
+			rerr, ok := err.(*RuntimeError)
+			if !ok {
+				t.Fatalf("expect *RuntimeError for input: %q, got %T (%v)", input, err, err)
+			}
+			if strings.HasPrefix(rerr.Msg, "internal error") {
+				t.Errorf("run program for input: %q panicked: %s", input, rerr)
+			}
+			// the errors name the closure types of the vms
+			want := strings.Replace(expect.(*RuntimeError).Msg, "*object.Closure", "*object.RegisterClosure", -1)
+			if rerr.Msg != want {
+				t.Errorf("wrong error for input: %q with optimize=%t. want=%q, got=%q", input, optimize, want, rerr.Msg)
+			}
+		}
+	}
+}
+
+func TestRegisterVMLimits(t *testing.T) {
+	exponential := "let f = fn(n) { if (n == 0) { 0 } else { f(n - 1) + f(n - 1) } }; f(40)"
+
+	tests := []struct {
+		input  string
+		limits object.Limits
+		expect error
+	}{
+		{"let f = fn() { 1 + f() }; f()", object.Limits{}, object.ErrCallDepthLimit},
+		{"let f = fn(n) { 1 + f(n) }; f(1)", object.Limits{MaxCallDepth: 10}, object.ErrCallDepthLimit},
+		{"[1, 2, 3, 4, 5, 6]", object.Limits{MaxStackSize: 5}, object.ErrStackLimit},
+		{exponential, object.Limits{MaxInstructions: 1000}, object.ErrInstructionLimit},
+		{"[[1], [2], [3]]", object.Limits{MaxAllocations: 3}, object.ErrAllocationLimit},
+		{`let f = fn(s) { s + s + s }; f("abc")`, object.Limits{MaxAllocatedBytes: 50}, object.ErrMemoryLimit},
+	}
+
+	for _, test := range tests {
+		_, err := runRegisterProgram(test.input, true, test.limits)
+		if !errors.Is(err, test.expect) {
+			t.Errorf("wrong error for input: %q. want=%q, got=%v", test.input, test.expect, err)
+		}
+	}
+}
+
+func TestRegisterVMWithGlobals(t *testing.T) {
+	constants := []object.Object{}
+	symbolTable := compiler.NewSymbolTable()
+	globals := make([]Value, GlobalSize)
+
+	for _, test := range []vmTestCase{{"let a = 1; let f = fn(x) { x + a }; a", 1}, {"f(2)", 3}} {
+		program, err := parse(test.input)
+		if err != nil {
+			t.Fatalf("parse program failed. %s", err)
+		}
+
+		c := compiler.NewRegisterCompilerWithStates(constants, symbolTable)
+		err = c.Compile(program)
+		if err != nil {
+			t.Fatalf("compile program for input: %q failed. error is: %q", test.input, err)
+		}
+
+		bytecode, err := c.Bytecode()
+		if err != nil {
+			t.Fatalf("compile program for input: %q failed. error is: %q", test.input, err)
+		}
+		constants = bytecode.Constants
+
+		v := NewRegisterWithGlobals(bytecode, globals)
+		err = v.Run()
+		if err != nil {
+			t.Fatalf("run program for input: %q failed. error is: %q", test.input, err)
+		}
+		testExpectedObject(t, test.input, test.expected, v.StackLastTop())
+	}
+}
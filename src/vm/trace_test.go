@@ -0,0 +1,97 @@
+package vm
+
+import (
+	"bytes"
+	"compiler"
+	"encoding/json"
+	"fmt"
+	"instrument"
+	"reflect"
+	"testing"
+)
+
+// traceInput calls a function, a builtin in a function, a function in tail position
+// and a function which fails, in this order
+const traceInput = `
+let add = fn(a, b) { a + b };
+let size = fn(xs) { len(xs) + 0 };
+let loop = fn(n) { if (n == 0) { 0 } else { loop(n - 1) } };
+add(1, 2);
+size([1, "two"]);
+loop(2);
+fn() { 1 + true }();
+`
+
+// traceSpans is the trace of traceInput, a line for the begin of each span with its
+// name and arguments and a line for each end
+var traceSpans = []string{
+	"B main ()",
+	"B add (1, 2)",
+	"E",
+	"B size ([1, two])",
+	"B len ([1, two])",
+	"E",
+	"E",
+	"B loop (2)",
+	"E",
+	"B loop (1)",
+	"E",
+	"B loop (0)",
+	"E",
+	"B fn@8:1 ()",
+	"E",
+	"E",
+}
+
+func spansOf(t *testing.T, trace []byte) []string {
+	t.Helper()
+
+	events := []struct {
+		Ph   string
+		Name string
+		Args map[string]string
+	}{}
+	if err := json.Unmarshal(trace, &events); err != nil {
+		t.Fatalf("trace is not a JSON array of events. error is: %q, trace is %q", err, trace)
+	}
+
+	spans := []string{}
+	for _, event := range events {
+		if event.Ph == "E" {
+			spans = append(spans, "E")
+			continue
+		}
+		spans = append(spans, fmt.Sprintf("%s %s (%s)", event.Ph, event.Name, event.Args["args"]))
+	}
+	return spans
+}
+
+func TestTrace(t *testing.T) {
+	program, err := parse(traceInput)
+	if err != nil {
+		t.Fatalf("parse error: %s", err)
+	}
+
+	c := compiler.New()
+	if err := c.Compile(program); err != nil {
+		t.Fatalf("compile error: %s", err)
+	}
+
+	var buf bytes.Buffer
+	tracer := instrument.NewTracer(&buf)
+	vm := New(c.Bytecode())
+	vm.SetHooks(tracer)
+	if err := vm.Run(); err == nil {
+		t.Fatalf("expected the program to fail")
+	}
+	if tracer.Depth() != 0 {
+		t.Errorf("spans left open by the run. got=%d", tracer.Depth())
+	}
+	if err := tracer.Close(); err != nil {
+		t.Fatalf("close failed. error is: %q", err)
+	}
+
+	if spans := spansOf(t, buf.Bytes()); !reflect.DeepEqual(spans, traceSpans) {
+		t.Errorf("wrong spans.\nwant=%q\ngot=%q", traceSpans, spans)
+	}
+}
@@ -0,0 +1,117 @@
+package vm
+
+import (
+	"object"
+)
+
+// Value is what the vm keeps on its stack, in globals and in its constants. Integers
+// which fit into int64 are held unboxed, null and booleans are held as their shared
+// objects, so computing with them allocates nothing. Any other value is held as an
+// object.Object. Values are boxed into objects only where they leave the vm: when they
+// are passed to builtins, stored into arrays, hashes or closures, or returned to the
+// embedding program. The zero Value is undefined, it is held by globals and locals
+// before they are set
+type Value struct {
+	// obj is the value, or integerTag when the value is the integer in i
+	obj object.Object
+	i   int64
+}
+
+// integerTag marks a Value holding an unboxed integer
+var integerTag = &object.Integer{}
+
+var (
+	Null  = Value{obj: object.NULL}
+	True  = Value{obj: object.TRUE}
+	False = Value{obj: object.FALSE}
+)
+
+func IntegerValue(i int64) Value {
+	return Value{obj: integerTag, i: i}
+}
+
+func BooleanValue(b bool) Value {
+	if b {
+		return True
+	}
+	return False
+}
+
+// ValueOf unboxes obj. A nil obj is the undefined Value
+func ValueOf(obj object.Object) Value {
+	switch obj := obj.(type) {
+	case nil:
+		return Value{}
+	case *object.Integer:
+		return IntegerValue(obj.Value)
+	case *object.Boolean:
+		return BooleanValue(obj.Value)
+	default:
+		return Value{obj: obj}
+	}
+}
+
+// Object boxes v. The undefined Value is boxed to nil
+func (v Value) Object() object.Object {
+	if v.obj == integerTag {
+		return &object.Integer{Value: v.i}
+	}
+	return v.obj
+}
+
+func (v Value) IsUndefined() bool {
+	return v.obj == nil
+}
+
+func (v Value) isInteger() bool {
+	return v.obj == integerTag
+}
+
+func (v Value) isBoolean() bool {
+	return v.obj == object.TRUE || v.obj == object.FALSE
+}
+
+func (v Value) Type() object.ObjectType {
+	switch v.obj {
+	case nil:
+		return "nil"
+	case integerTag:
+		return object.INTEGER_OBJ
+	default:
+		return v.obj.Type()
+	}
+}
+
+func (v Value) Inspect() string {
+	if v.obj == nil {
+		return "nil"
+	}
+	return v.Object().Inspect()
+}
+
+func isTruethy(v Value) bool {
+	switch v.obj {
+	case object.TRUE:
+		return true
+	case object.FALSE, object.NULL, nil:
+		return false
+	default:
+		return true
+	}
+}
+
+func boxValues(values []Value) []object.Object {
+	ret := make([]object.Object, len(values))
+	for i, v := range values {
+		ret[i] = v.Object()
+	}
+	return ret
+}
+
+func valuesOf(objs []object.Object) []Value {
+	ret := make([]Value, len(objs))
+	for i, obj := range objs {
+		ret[i] = ValueOf(obj)
+	}
+	return ret
+}
@@ -0,0 +1,62 @@
+package vm
+
+import (
+	"compiler"
+	"math/big"
+	"object"
+	"testing"
+)
+
+func TestValueOf(t *testing.T) {
+	tests := []object.Object{
+		&object.Integer{Value: -7},
+		&object.BigInteger{Value: new(big.Int).Lsh(big.NewInt(1), 70)},
+		object.TRUE,
+		object.FALSE,
+		object.NULL,
+		&object.String{Value: "gorilla"},
+		&object.Array{Elements: []object.Object{&object.Integer{Value: 1}}},
+	}
+
+	for _, obj := range tests {
+		boxed := ValueOf(obj).Object()
+		if !object.Equals(obj, boxed) {
+			t.Errorf("boxing the value of %s gives %s", obj.Inspect(), boxed.Inspect())
+		}
+	}
+
+	if !ValueOf(&object.Integer{Value: 1}).isInteger() {
+		t.Errorf("integer is not unboxed")
+	}
+
+	if ValueOf(nil).Object() != nil || !ValueOf(nil).IsUndefined() {
+		t.Errorf("nil is not the undefined value")
+	}
+}
+
+func TestIntegersDoNotAllocate(t *testing.T) {
+	allocs := func(n string) float64 {
+		program, err := parse("let f = fn(i, sum) { if (i == 0) { sum } else { f(i - 1, sum + i * 2 % 7) } }; f(" + n + ", 0) > 0")
+		if err != nil {
+			t.Fatalf("parse program failed. %s", err)
+		}
+
+		c := compiler.New()
+		err = c.Compile(program)
+		if err != nil {
+			t.Fatalf("compile program failed. %s", err)
+		}
+		bytecode := c.Bytecode()
+
+		return testing.AllocsPerRun(5, func() {
+			if err := New(bytecode).Run(); err != nil {
+				t.Fatalf("run program failed. %s", err)
+			}
+		})
+	}
+
+	few, many := allocs("10"), allocs("10000")
+	if many > few {
+		t.Errorf("integer arithmetic allocates. %v allocations for 10 calls, %v for 10000 calls", few, many)
+	}
+}
@@ -3,30 +3,67 @@ package vm
 import (
 	"code"
 	"compiler"
+	"context"
 	"fmt"
+	"instrument"
+	"math"
 	"object"
+	"token"
 )
 
 const MaxFrames = 1024
 const StackSize = 2048
 const GlobalSize = 65535
 
+// RuntimeError is returned by Run when executing an instruction fails. Pos is the
+// position of the source which the failed instruction is compiled from
+type RuntimeError struct {
+	Msg string
+	Pos token.Position
+	Err error
+}
+
+func (e *RuntimeError) Error() string {
+	return fmt.Sprintf("%s at line: %d, column: %d", e.Msg, e.Pos.Line, e.Pos.Column)
+}
+
+// Unwrap returns the error the instruction failed with, so limit errors such as
+// object.ErrInstructionLimit can be tested with errors.Is
+func (e *RuntimeError) Unwrap() error {
+	return e.Err
+}
+
 type VM struct {
 	frames     []*Frame
 	frameIndex int
 
-	constants []object.Object
+	constants []Value
 
-	stack []object.Object
+	stack []Value
 	sp    int
 
-	lastPop object.Object
+	lastPop Value
+
+	globals []Value
 
-	globals []object.Object
+	budget *object.Budget
+	// seed hashes the keys of the hashes the program makes, see SetHashSeed
+	seed object.HashSeed
+
+	// hooks are the Hooks set by SetHooks. The profiler, the memory profiler and the
+	// debugger are told about instructions and allocations directly, calls are the
+	// hooks told about the calls, the profiler among them
+	hooks       object.Hooks
+	profiler    *instrument.Profiler
+	memProfiler *instrument.MemProfiler
+	debugger    *Debugger
+	calls       object.Hooks
+	// instrumented tells whether onInstruction has anything to tell
+	instrumented bool
 }
 
 func New(bytecode *compiler.Bytecode) *VM {
-	fn := &object.CompiledFunction{Instructions: bytecode.Instructions}
+	fn := &object.CompiledFunction{Instructions: bytecode.Instructions, SourceMap: bytecode.SourceMap, Lines: bytecode.Lines}
 	clo := &object.Closure{Fn: fn, Free: make([]object.Object, 0)}
 	mainFrame := NewFrame(clo, 0)
 
@@ -36,26 +73,61 @@ func New(bytecode *compiler.Bytecode) *VM {
 	return &VM{
 		frames:     frames,
 		frameIndex: 0,
-		constants:  bytecode.Constants,
-		stack:      make([]object.Object, StackSize),
+		constants:  valuesOf(bytecode.Constants),
+		stack:      make([]Value, StackSize),
 		sp:         -1,
-		globals:    make([]object.Object, GlobalSize),
+		globals:    make([]Value, GlobalSize),
+		budget:     object.NewBudget(context.Background(), object.Limits{}),
 	}
 }
 
-func NewWithGlobals(bytecode *compiler.Bytecode, globals []object.Object) *VM {
+// NewWithGlobals makes a vm which keeps its globals in globals, so they can be shared
+// by the programs run one after another like in the repl
+func NewWithGlobals(bytecode *compiler.Bytecode, globals []Value) *VM {
 	vm := New(bytecode)
 	vm.globals = globals
 	return vm
 }
 
+// SetHooks makes the runs of v call h, nil removes them. object.MultiHooks combines
+// several Hooks into one
+func (v *VM) SetHooks(h object.Hooks) {
+	v.hooks = h
+}
+
+// SetHashSeed makes the hashes made by the runs of v hash their keys with seed
+func (v *VM) SetHashSeed(seed object.HashSeed) {
+	v.seed = seed
+}
+
+// SetProfiler profiles the runs of v into p, nil stops profiling them
+func (v *VM) SetProfiler(p *instrument.Profiler) {
+	v.profiler = p
+}
+
+// SetMemProfiler counts the objects the runs of v allocate into m, nil stops counting them
+func (v *VM) SetMemProfiler(m *instrument.MemProfiler) {
+	v.memProfiler = m
+}
+
 func (v *VM) currentFrame() *Frame {
 	return v.frames[v.frameIndex]
 }
 
-func (v *VM) pushFrame(f *Frame) {
+// pushFrame enters a frame for clo. Frames are reused once they are popped, so calls do not allocate
+func (v *VM) pushFrame(clo *object.Closure, basePointer int) (*Frame, error) {
+	if err := v.budget.CallDepth(v.frameIndex+1, len(v.frames)-1); err != nil {
+		return nil, err
+	}
+
 	v.frameIndex++
-	v.frames[v.frameIndex] = f
+	frame := v.frames[v.frameIndex]
+	if frame == nil {
+		frame = &Frame{}
+		v.frames[v.frameIndex] = frame
+	}
+	frame.clo, frame.ip, frame.basePointer = clo, 0, basePointer
+	return frame, nil
 }
 
 func (v *VM) popFrame() *Frame {
@@ -65,196 +137,356 @@ func (v *VM) popFrame() *Frame {
 	return frame
 }
 
-func (v *VM) pushStack(o object.Object) error {
-	if v.sp >= len(v.stack) {
-		return fmt.Errorf("stack full")
+func (v *VM) pushStack(val Value) error {
+	if err := v.budget.StackSize(v.sp+2, len(v.stack)); err != nil {
+		return err
 	}
 
 	v.sp++
-	v.stack[v.sp] = o
+	v.stack[v.sp] = val
 	return nil
 }
 
-func (v *VM) popStack() object.Object {
-	if v.sp < 0 {
+// alloc accounts val computed by the current instruction, only boxed values are allocated
+func (v *VM) alloc(val Value) error {
+	if val.isInteger() {
 		return nil
 	}
+	if v.memProfiler != nil && val.obj != object.TRUE && val.obj != object.FALSE && val.obj != object.NULL {
+		frame := v.currentFrame()
+		pos, _ := frame.clo.Fn.SourceMap.Lookup(frame.ip)
+		v.memProfiler.Record(val.obj, functionName(frame.clo.Fn, v.frameIndex == 0), pos)
+	}
+	return v.budget.Alloc(val.obj)
+}
+
+// pushAllocated pushes o which is allocated by the current instruction
+func (v *VM) pushAllocated(o object.Object) error {
+	val := ValueOf(o)
+	if err := v.alloc(val); err != nil {
+		return err
+	}
+	return v.pushStack(val)
+}
 
-	o := v.stack[v.sp]
-	v.lastPop = o
+func (v *VM) popStack() Value {
+	if v.sp < 0 {
+		return Value{}
+	}
+
+	val := v.stack[v.sp]
+	v.lastPop = val
 	v.sp--
-	return o
+	return val
 }
 
+// StackTop returns the value on top of the stack boxed into an object, nil when the stack is empty
 func (v *VM) StackTop() object.Object {
 	if v.sp < 0 {
 		return nil
 	}
 
-	return v.stack[v.sp]
+	return v.stack[v.sp].Object()
 }
 
+// StackLastTop returns the value popped last boxed into an object
 func (v *VM) StackLastTop() object.Object {
-	return v.lastPop
+	return v.lastPop.Object()
 }
 
-func (v *VM) executeBinaryOperatorOnInteger(op code.OpCode, l int64, r int64) (object.Object, error) {
-	var result object.Object
+// integerFastPath applies op to two unboxed integers. It returns false when the
+// result does not fit into int64 or fails, those are left to object.IntegerInfix
+func integerFastPath(op code.OpCode, l int64, r int64) (Value, bool) {
 	switch op {
 	case code.OpAdd:
-		result = &object.Integer{Value: l + r}
+		ret := l + r
+		if (ret > l) == (r > 0) {
+			return IntegerValue(ret), true
+		}
 	case code.OpSubtraction:
-		result = &object.Integer{Value: l - r}
+		ret := l - r
+		if (ret < l) == (r > 0) {
+			return IntegerValue(ret), true
+		}
 	case code.OpMultiply:
-		result = &object.Integer{Value: l * r}
+		if l == 0 || r == 0 {
+			return IntegerValue(0), true
+		}
+		ret := l * r
+		if ret/r == l && !(l == -1 && r == math.MinInt64) && !(r == -1 && l == math.MinInt64) {
+			return IntegerValue(ret), true
+		}
 	case code.OpDivide:
-		result = &object.Integer{Value: l / r}
+		if r != 0 && !(l == math.MinInt64 && r == -1) {
+			return IntegerValue(l / r), true
+		}
+	case code.OpModulo:
+		if r != 0 {
+			return IntegerValue(l % r), true
+		}
 	case code.OpEqual:
-		result = &object.Boolean{Value: l == r}
+		return BooleanValue(l == r), true
 	case code.OpNotEqual:
-		result = &object.Boolean{Value: l != r}
-	case code.OpGreaterEqual:
-		result = &object.Boolean{Value: l >= r}
+		return BooleanValue(l != r), true
 	case code.OpGreaterThan:
-		result = &object.Boolean{Value: l > r}
-	default:
-		return nil, fmt.Errorf("unsupportted operator on integer: %d", op)
+		return BooleanValue(l > r), true
+	case code.OpGreaterEqual:
+		return BooleanValue(l >= r), true
+	case code.OpLessThan:
+		return BooleanValue(l < r), true
+	case code.OpLessEqual:
+		return BooleanValue(l <= r), true
+	}
+	return Value{}, false
+}
+
+// binaryOperation applies op to left and right. Unboxed integers and booleans are
+// computed in place, other values are boxed for the operators of the object package
+func binaryOperation(op code.OpCode, left Value, right Value) (Value, error) {
+	if left.isInteger() && right.isInteger() {
+		if result, ok := integerFastPath(op, left.i, right.i); ok {
+			return result, nil
+		}
+	}
+
+	if left.isBoolean() && right.isBoolean() {
+		switch op {
+		case code.OpEqual:
+			return BooleanValue(left.obj == right.obj), nil
+		case code.OpNotEqual:
+			return BooleanValue(left.obj != right.obj), nil
+		}
+	}
+
+	if left.IsUndefined() || right.IsUndefined() {
+		return Value{}, fmt.Errorf("binary operator need two operands")
 	}
 
-	return result, nil
+	result, err := object.Infix(code.Operators[op], left.Object(), right.Object())
+	if err != nil {
+		return Value{}, err
+	}
+	return ValueOf(result), nil
 }
 
-func (v *VM) executeBinaryOperatorOnBoolean(op code.OpCode, l bool, r bool) (object.Object, error) {
-	var result object.Object
-	switch op {
-	case code.OpEqual:
-		result = &object.Boolean{Value: l == r}
-	case code.OpNotEqual:
-		result = &object.Boolean{Value: l != r}
-	default:
-		return nil, fmt.Errorf("unsupportted operator on boolean: %d", op)
+// executeBinaryOperator replaces the two values on top of the stack with the result of op
+func (v *VM) executeBinaryOperator(op code.OpCode) error {
+	if v.sp < 1 {
+		return fmt.Errorf("binary operator need two operands")
 	}
 
-	return result, nil
+	result, err := binaryOperation(op, v.stack[v.sp-1], v.stack[v.sp])
+	if err != nil {
+		return err
+	}
+
+	if err := v.alloc(result); err != nil {
+		return err
+	}
+	v.sp--
+	v.stack[v.sp] = result
+	return nil
 }
 
-func (v *VM) executeBinaryOperatorOnString(op code.OpCode, l string, r string) (object.Object, error) {
-	var result object.Object
+// comparisonOfJump returns the comparison a jump superinstruction makes, it jumps when the comparison is false
+func comparisonOfJump(op code.OpCode) code.OpCode {
 	switch op {
-	case code.OpEqual:
-		result = &object.Boolean{Value: l == r}
-	case code.OpNotEqual:
-		result = &object.Boolean{Value: l != r}
-	case code.OpAdd:
-		result = &object.String{Value: l + r}
+	case code.OpJumpNotEqual:
+		return code.OpEqual
+	case code.OpJumpEqual:
+		return code.OpNotEqual
+	case code.OpJumpNotGreaterThan:
+		return code.OpGreaterThan
+	case code.OpJumpNotLessThan:
+		return code.OpLessThan
+	case code.OpJumpNotLessEqual:
+		return code.OpLessEqual
 	default:
-		return nil, fmt.Errorf("unsupportted operator on string: %d", op)
+		return code.OpGreaterEqual
 	}
-
-	return result, nil
 }
 
-func (v *VM) executeBinaryOperator(op code.OpCode) error {
-	right := v.popStack()
-	left := v.popStack()
-
-	var result object.Object
-	var err error
-	if left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ {
-		l := left.(*object.Integer).Value
-		r := right.(*object.Integer).Value
-		result, err = v.executeBinaryOperatorOnInteger(op, l, r)
-		if err != nil {
-			return err
-		}
-	} else if left.Type() == object.BOOLEAN_OBJ && right.Type() == object.BOOLEAN_OBJ {
-		l := left.(*object.Boolean).Value
-		r := right.(*object.Boolean).Value
-		result, err = v.executeBinaryOperatorOnBoolean(op, l, r)
-		if err != nil {
-			return err
-		}
-	} else if left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ {
-		l := left.(*object.String).Value
-		r := right.(*object.String).Value
-		result, err = v.executeBinaryOperatorOnString(op, l, r)
-		if err != nil {
-			return err
-		}
-	} else {
-		return fmt.Errorf("unsupportted binary operator %d with %T and %T as operands", op, left, right)
+// executeComparison pops two values and reports whether comparing them with op is truethy
+func (v *VM) executeComparison(op code.OpCode) (bool, error) {
+	if v.sp < 1 {
+		return false, fmt.Errorf("binary operator need two operands")
 	}
 
-	err = v.pushStack(result)
-	return err
+	result, err := binaryOperation(op, v.stack[v.sp-1], v.stack[v.sp])
+	if err != nil {
+		return false, err
+	}
+
+	v.sp -= 2
+	return isTruethy(result), nil
 }
 
 func (v *VM) executeBangOperator() error {
 	val := v.popStack()
-	if val == nil {
+	if val.IsUndefined() {
 		return fmt.Errorf("bang operator need one operand")
 	}
 
-	switch val {
-	case object.TRUE:
-		return v.pushStack(object.FALSE)
-	case object.FALSE, object.NULL:
-		return v.pushStack(object.TRUE)
-	default:
-		return v.pushStack(object.FALSE)
-	}
+	return v.pushStack(BooleanValue(!isTruethy(val)))
 }
 
 func (v *VM) executeMinusOperator() error {
 	val := v.popStack()
 
-	if val == nil {
+	if val.IsUndefined() {
 		return fmt.Errorf("minus operator need one operand")
 	}
 
-	if val.Type() == object.INTEGER_OBJ {
-		intV := val.(*object.Integer).Value
-		return v.pushStack(&object.Integer{Value: -intV})
+	ret, err := negate(val)
+	if err != nil {
+		return err
+	}
+	if err := v.alloc(ret); err != nil {
+		return err
+	}
+	return v.pushStack(ret)
+}
+
+// negate returns -val, an unboxed integer is negated in place
+func negate(val Value) (Value, error) {
+	if val.isInteger() && val.i != math.MinInt64 {
+		return IntegerValue(-val.i), nil
+	}
+
+	ret, err := object.IntegerNegate(val.Object())
+	if err != nil {
+		return Value{}, err
+	}
+	return ValueOf(ret), nil
+}
+
+// newHash makes a hash of pairs, which are keys and values in turn, hashing the keys with seed
+func newHash(pairs []Value, seed object.HashSeed) (*object.HashTable, error) {
+	hash := object.NewSeededHashTable(seed)
+	for i := 0; i < len(pairs); i += 2 {
+		newK := pairs[i].Object()
+		newV := pairs[i+1].Object()
+
+		h, err := object.HashLiteralKey(seed, newK)
+		if err != nil {
+			return nil, err
+		}
+		hash.Set(h, newK, newV)
 	}
+	return hash, nil
+}
 
-	return fmt.Errorf("unsupportted minus operator on %T value", val)
+func (v *VM) setLocal(index int, localV Value) {
+	v.stack[v.currentFrame().basePointer+index+1] = localV
 }
 
-func isTruethy(obj object.Object) bool {
-	if obj == nil {
-		return false
+func (v *VM) getLocal(index int) (Value, error) {
+	localV := v.stack[v.currentFrame().basePointer+index+1]
+	if localV.IsUndefined() {
+		return Value{}, object.Undefined("local", index)
 	}
+	return localV, nil
+}
 
-	switch obj := obj.(type) {
-	case *object.Boolean:
-		return obj.Value
-	case *object.Internal_Null:
-		return false
+// functionName names fn by the name it is bound to, or by its position
+func functionName(fn *object.CompiledFunction, main bool) string {
+	switch {
+	case main:
+		return "main"
+	case fn.Name != "":
+		return fn.Name
 	default:
-		return true
+		return fmt.Sprintf("fn@%d:%d", fn.Pos.Line, fn.Pos.Column)
 	}
 }
 
-func (v *VM) setLocal(index int, localV object.Object) {
-	v.stack[v.currentFrame().basePointer+index+1] = localV
+// onInstruction tells the profiler, the debugger and the hooks about the instruction
+// at ip of frame, which is about to run
+func (v *VM) onInstruction(frame *Frame, ip int, op code.OpCode) error {
+	if v.profiler != nil {
+		pos, _ := frame.clo.Fn.SourceMap.Lookup(ip)
+		v.profiler.Instruction(op, pos.Line)
+	}
+	if v.debugger != nil {
+		if err := v.debugger.instruction(frame, ip); err != nil {
+			return err
+		}
+	}
+	if v.hooks != nil {
+		pos, _ := frame.clo.Fn.SourceMap.Lookup(ip)
+		return v.hooks.OnInstruction(pos)
+	}
+	return nil
 }
 
-func (v *VM) getLocal(index int) object.Object {
-	return v.stack[v.currentFrame().basePointer+index+1]
+func (v *VM) runtimeError(err error, frame *Frame, ip int) *RuntimeError {
+	pos, _ := frame.clo.Fn.SourceMap.Lookup(ip)
+	return &RuntimeError{Msg: err.Error(), Pos: pos, Err: err}
 }
 
+// Run executes the bytecode until the main function finishes or an instruction fails.
+// It never panics, any failure is reported as a *RuntimeError
 func (v *VM) Run() error {
-	var err error
+	return v.RunContext(context.Background(), object.Limits{})
+}
+
+// RunContext is like Run but stops when ctx is done or the program exceeds limits.
+// The returned *RuntimeError then wraps object.ErrCanceled or the error of the limit
+func (v *VM) RunContext(ctx context.Context, limits object.Limits) (err error) {
+	v.budget = object.NewBudget(ctx, limits)
+
 	var ip int
 	var skip int
 	var ins code.Instructions
+	frame := v.currentFrame()
+
+	v.calls = v.hooks
+	if v.profiler != nil {
+		v.calls = object.MultiHooks(v.profiler, v.hooks)
+	}
+	v.instrumented = v.calls != nil || v.debugger != nil
+	if v.calls != nil {
+		v.calls.OnStart()
+		// deferred first, so it sees the errors of panics too
+		defer func() {
+			if err != nil {
+				v.calls.OnError(err)
+			} else {
+				v.calls.OnEnd(v.lastPop.Object())
+			}
+		}()
+	}
 
-	for v.currentFrame().ip < len(v.currentFrame().Instructions()) {
-		ip = v.currentFrame().ip
+	defer func() {
+		if r := recover(); r != nil {
+			err = v.runtimeError(fmt.Errorf("internal error: %v", r), frame, ip)
+		}
+	}()
+
+	for {
+		// the frame changes with calls and returns, so it is looked up once per instruction
+		frame = v.currentFrame()
+		ip = frame.ip
+		ins = frame.Instructions()
+		if ip >= len(ins) {
+			break
+		}
 		skip = 1
-		ins = v.currentFrame().Instructions()
+
+		if err = v.budget.Step(); err != nil {
+			return v.runtimeError(err, frame, ip)
+		}
 
 		c := code.OpCode(ins[ip])
+		if v.instrumented {
+			if err = v.onInstruction(frame, ip, c); err != nil {
+				// quitting the debugger is not a failure of the instruction, it is returned as it is
+				if err == ErrQuit {
+					return err
+				}
+				return v.runtimeError(err, frame, ip)
+			}
+		}
 
 		switch c {
 		case code.OpConstant:
@@ -267,19 +499,23 @@ func (v *VM) Run() error {
 			skip = 4
 
 			constant := v.constants[constIndex]
-			fn, ok := constant.(*object.CompiledFunction)
+			fn, ok := constant.obj.(*object.CompiledFunction)
 			if !ok {
-				err = fmt.Errorf("not a function: %+v", constant)
+				err = fmt.Errorf("not a function: %+v", constant.Object())
 			} else {
-				numFrees := code.ReadUint8(ins[ip+3:])
+				numFrees := int(code.ReadUint8(ins[ip+3:]))
+				if v.sp+1 < numFrees {
+					err = fmt.Errorf("not enough free variables on stack for closure. want=%d, got=%d", numFrees, v.sp+1)
+					break
+				}
+
+				// free variables are pushed in the order of their indexes
 				frees := make([]object.Object, numFrees)
-				// 检查 stack 上有没有足够的值
-				for i := 0; i < int(numFrees); i++ {
-					f := v.popStack()
-					frees[i] = f
+				for i := numFrees - 1; i >= 0; i-- {
+					frees[i] = v.popStack().Object()
 				}
 
-				err = v.pushStack(&object.Closure{Fn: fn, Free: frees})
+				err = v.pushAllocated(&object.Closure{Fn: fn, Free: frees})
 			}
 		case code.OpSetGlobal:
 			index := code.ReadUint16(ins[ip+1:])
@@ -290,83 +526,103 @@ func (v *VM) Run() error {
 			index := code.ReadUint16(ins[ip+1:])
 			skip = 3
 			globalV := v.globals[index]
+			if globalV.IsUndefined() {
+				err = object.Undefined("global", int(index))
+				break
+			}
 			err = v.pushStack(globalV)
 		case code.OpGetBuiltin:
 			index := code.ReadUint8(ins[ip+1:])
 			skip = 2
 			builtin := object.FindBuiltinByIndex(int(index))
-			err = v.pushStack(builtin)
+			if builtin == nil {
+				err = fmt.Errorf("unknown builtin function %d", index)
+				break
+			}
+			err = v.pushStack(ValueOf(builtin))
 		case code.OpGetFree:
 			index := code.ReadUint8(ins[ip+1:])
 			skip = 2
-			free := v.currentFrame().clo.Free[int(index)]
-			err = v.pushStack(free)
+			frees := v.currentFrame().clo.Free
+			if int(index) >= len(frees) {
+				err = fmt.Errorf("free variable %d is out of range of %d free variables", index, len(frees))
+				break
+			}
+			err = v.pushStack(ValueOf(frees[index]))
 		case code.OpNull:
-			err = v.pushStack(object.NULL)
+			err = v.pushStack(Null)
 		case code.OpBang:
 			err = v.executeBangOperator()
 		case code.OpMinus:
 			err = v.executeMinusOperator()
-		case code.OpAdd, code.OpSubtraction, code.OpMultiply, code.OpDivide,
-			code.OpEqual, code.OpNotEqual, code.OpGreaterEqual, code.OpGreaterThan:
+		case code.OpAdd, code.OpSubtraction, code.OpMultiply, code.OpDivide, code.OpModulo,
+			code.OpEqual, code.OpNotEqual, code.OpGreaterEqual, code.OpGreaterThan, code.OpLessThan, code.OpLessEqual:
 			err = v.executeBinaryOperator(c)
 		case code.OpIndex:
 			index := v.popStack()
 			coll := v.popStack()
 
-			switch coll := coll.(type) {
-			case *object.Array:
-				i, ok := index.(*object.Integer)
-				if !ok {
-					err = fmt.Errorf("index must be Integer for array, got: %v", index)
-					break
-				}
-				err = v.pushStack(coll.Elements[i.Value])
-			case *object.HashTable:
-				i, ok := index.(object.Hashable)
-				if !ok {
-					err = fmt.Errorf("index must be Hashable for Hash, got: %v", index)
-					break
-				}
-				ret, ok := coll.Pair[i.Hash()]
-				if !ok {
-					err = v.pushStack(object.NULL)
-				} else {
-					err = v.pushStack(ret.Value)
-				}
+			// an element of an array is read without boxing the index
+			if arr, ok := coll.obj.(*object.Array); ok && index.isInteger() && index.i >= 0 && index.i < int64(len(arr.Elements)) {
+				err = v.pushStack(ValueOf(arr.Elements[index.i]))
+				break
+			}
+
+			var elem object.Object
+			elem, err = object.Index(coll.Object(), index.Object())
+			if err == nil && coll.Type() == object.STRING_OBJ {
+				err = v.pushAllocated(elem)
+			} else if err == nil {
+				err = v.pushStack(ValueOf(elem))
+			}
+		case code.OpSlice:
+			end := v.popStack()
+			start := v.popStack()
+			coll := v.popStack()
+
+			var slice object.Object
+			slice, err = object.Slice(coll.Object(), start.Object(), end.Object())
+			if err == nil {
+				err = v.pushAllocated(slice)
 			}
 		case code.OpTrue:
-			err = v.pushStack(object.TRUE)
+			err = v.pushStack(True)
 		case code.OpFalse:
-			err = v.pushStack(object.FALSE)
+			err = v.pushStack(False)
 		case code.OpArray:
 			length := int(code.ReadUint16(ins[ip+1:]))
 			skip = 3
+			if v.sp+1 < length {
+				err = fmt.Errorf("not enough values on stack for array. want=%d, got=%d", length, v.sp+1)
+				break
+			}
 
 			elems := make([]object.Object, length)
 			for i := length - 1; i >= 0; i-- {
 				newV := v.popStack()
-				elems[i] = newV
+				elems[i] = newV.Object()
 			}
 
-			err = v.pushStack(&object.Array{Elements: elems})
+			err = v.pushAllocated(&object.Array{Elements: elems})
 		case code.OpHash:
 			length := int(code.ReadUint16(ins[ip+1:]))
 			skip = 3
 
-			pairs := make(map[object.HashKey]object.HashPair)
-			for i := length - 1; i >= 0; i-- {
-				newK := v.popStack()
-				newV := v.popStack()
+			// pairs are pushed in source order as key, value, key, value...
+			start := v.sp - 2*length + 1
+			if start < 0 {
+				err = fmt.Errorf("not enough values on stack for hash. want=%d, got=%d", 2*length, v.sp+1)
+				break
+			}
 
-				h, ok := newK.(object.Hashable)
-				if !ok {
-					err = fmt.Errorf("key type in HashLiteral is not Hashable. got %q", newK.Type())
-				}
-				pairs[h.Hash()] = object.HashPair{Key: newK, Value: newV}
+			var hash *object.HashTable
+			hash, err = newHash(v.stack[start:v.sp+1], v.seed)
+			if err != nil {
+				break
 			}
 
-			err = v.pushStack(&object.HashTable{Pair: pairs})
+			v.sp = start - 1
+			err = v.pushAllocated(hash)
 		case code.OpPop:
 			v.popStack()
 		case code.OpJumptNotTruethy:
@@ -378,17 +634,46 @@ func (v *VM) Run() error {
 				v.currentFrame().ip = targetPos - 1
 				skip = 1
 			}
+		case code.OpJumpNotEqual, code.OpJumpEqual, code.OpJumpNotGreaterThan, code.OpJumpNotGreaterEqual,
+			code.OpJumpNotLessThan, code.OpJumpNotLessEqual:
+			targetPos := int(code.ReadUint16(ins[ip+1:]))
+			skip = 3
+
+			var truethy bool
+			truethy, err = v.executeComparison(comparisonOfJump(c))
+			if err == nil && !truethy {
+				v.currentFrame().ip = targetPos - 1
+				skip = 1
+			}
 		case code.OpJump:
 			targetPos := int(code.ReadUint16(ins[ip+1:]))
 			v.currentFrame().ip = targetPos - 1
 		case code.OpReturnValue:
 			ret := v.popStack()
-			v.popFrame()
+			if v.frameIndex == 0 {
+				// return at top level ends the program with ret as the result
+				v.sp = -1
+				return nil
+			}
+
+			returned := v.popFrame()
+			if v.calls != nil {
+				v.calls.OnReturn(functionName(returned.clo.Fn, false), ret.Object())
+			}
 			err = v.pushStack(ret)
 			skip = 2
 		case code.OpReturn:
-			v.popFrame()
-			err = v.pushStack(object.NULL)
+			if v.frameIndex == 0 {
+				v.sp = -1
+				v.lastPop = Null
+				return nil
+			}
+
+			returned := v.popFrame()
+			if v.calls != nil {
+				v.calls.OnReturn(functionName(returned.clo.Fn, false), object.NULL)
+			}
+			err = v.pushStack(Null)
 			skip = 2
 		case code.OpSetLocal:
 			index := code.ReadUint8(ins[ip+1:])
@@ -398,33 +683,83 @@ func (v *VM) Run() error {
 		case code.OpGetLocal:
 			index := code.ReadUint8(ins[ip+1:])
 			skip = 2
-			localV := v.getLocal(int(index))
-			err = v.pushStack(localV)
+			var localV Value
+			localV, err = v.getLocal(int(index))
+			if err == nil {
+				err = v.pushStack(localV)
+			}
+		case code.OpAddLocalConstant, code.OpSubtractLocalConstant:
+			index := code.ReadUint8(ins[ip+1:])
+			constIndex := code.ReadUint16(ins[ip+2:])
+			skip = 4
+
+			var localV Value
+			localV, err = v.getLocal(int(index))
+			if err != nil {
+				break
+			}
+
+			op := code.OpAdd
+			if c == code.OpSubtractLocalConstant {
+				op = code.OpSubtraction
+			}
+
+			var result Value
+			result, err = binaryOperation(op, localV, v.constants[constIndex])
+			if err == nil {
+				err = v.alloc(result)
+			}
+			if err == nil {
+				err = v.pushStack(result)
+			}
 		case code.OpCall:
 			args := code.ReadUint8(ins[ip+1:])
+			if v.sp-int(args) < 0 {
+				err = fmt.Errorf("not enough values on stack to call function with %d arguments", args)
+				break
+			}
 
 			callee := v.stack[v.sp-int(args)]
-			switch callee := callee.(type) {
+			switch fn := callee.obj.(type) {
+
+			case *object.Closure:
+				err = v.callClosure(fn, int(args))
+				skip = 0
+			case *object.Builtin:
+				err = v.callBuiltin(fn, int(args))
+				skip = 2
+			default:
+				err = object.NotCallable(callee)
+			}
+		case code.OpTailCall:
+			args := code.ReadUint8(ins[ip+1:])
+			if v.sp-int(args) < 0 {
+				err = fmt.Errorf("not enough values on stack to call function with %d arguments", args)
+				break
+			}
 
+			callee := v.stack[v.sp-int(args)]
+			switch fn := callee.obj.(type) {
 			case *object.Closure:
-				err = v.callClosure(callee, int(args))
+				err = v.tailCallClosure(fn, int(args))
 				skip = 0
 			case *object.Builtin:
-				err = v.callBuiltin(callee, int(args))
+				// a builtin does not push a frame, the following OpReturnValue returns its result
+				err = v.callBuiltin(fn, int(args))
 				skip = 2
 			default:
-				err = fmt.Errorf("calling non-function %T", callee)
+				err = object.NotCallable(callee)
 			}
 		case code.OpCurrentClosure:
 			cl := v.currentFrame().clo
-			err = v.pushStack(cl)
+			err = v.pushStack(ValueOf(cl))
 		}
 
-		v.currentFrame().ip += skip
-
 		if err != nil {
-			return err
+			return v.runtimeError(err, frame, ip)
 		}
+
+		v.currentFrame().ip += skip
 	}
 
 	return nil
@@ -432,25 +767,82 @@ func (v *VM) Run() error {
 
 func (v *VM) callClosure(clo *object.Closure, numArgs int) error {
 
-	if clo.Fn.NumParameters != numArgs {
-		return fmt.Errorf("wrong number of arguments: want=%d got=%d", clo.Fn.NumParameters, numArgs)
+	if err := object.CheckArguments(clo.Fn.NumParameters, numArgs); err != nil {
+		return err
 	}
 
 	basePointer := v.sp - numArgs
+	if err := v.budget.StackSize(basePointer+clo.Fn.NumLocals+1, len(v.stack)); err != nil {
+		return err
+	}
+	if v.calls != nil {
+		if err := v.calls.OnCall(functionName(clo.Fn, false), boxValues(v.stack[v.sp-numArgs+1:v.sp+1])); err != nil {
+			return err
+		}
+	}
+
+	frame, err := v.pushFrame(clo, basePointer)
+	if err != nil {
+		return err
+	}
 
-	frame := NewFrame(clo, basePointer)
+	// clear locals left on stack by former calls so they can not be read before defined
+	for i := v.sp + 1; i <= basePointer+clo.Fn.NumLocals; i++ {
+		v.stack[i] = Value{}
+	}
 	v.sp = frame.basePointer + clo.Fn.NumLocals
-	v.pushFrame(frame)
+	return nil
+}
+
+// tailCallClosure calls clo in the frame of the current function, which returns
+// the result of the call right away. The callee and the arguments replace the
+// current function and its locals on stack, so the stack does not grow
+func (v *VM) tailCallClosure(clo *object.Closure, numArgs int) error {
+	if err := object.CheckArguments(clo.Fn.NumParameters, numArgs); err != nil {
+		return err
+	}
+
+	frame := v.currentFrame()
+	basePointer := frame.basePointer
+	if err := v.budget.StackSize(basePointer+clo.Fn.NumLocals+1, len(v.stack)); err != nil {
+		return err
+	}
+	if v.calls != nil {
+		// the callee takes the place of the caller, which has returned
+		v.calls.OnReturn(functionName(frame.clo.Fn, false), nil)
+		if err := v.calls.OnCall(functionName(clo.Fn, false), boxValues(v.stack[v.sp-numArgs+1:v.sp+1])); err != nil {
+			return err
+		}
+	}
+
+	copy(v.stack[basePointer:], v.stack[v.sp-numArgs:v.sp+1])
+	for i := basePointer + numArgs + 1; i <= basePointer+clo.Fn.NumLocals; i++ {
+		v.stack[i] = Value{}
+	}
+
+	frame.clo = clo
+	frame.ip = 0
+	v.sp = basePointer + clo.Fn.NumLocals
 	return nil
 }
 
 func (v *VM) callBuiltin(fn *object.Builtin, numArgs int) error {
-	args := v.stack[v.sp-numArgs+1 : v.sp+1]
-	ret := fn.Fn(args...)
+	args := boxValues(v.stack[v.sp-numArgs+1 : v.sp+1])
+	if v.calls != nil {
+		if err := v.calls.OnBuiltinCall(object.BuiltinName(fn), args); err != nil {
+			return err
+		}
+	}
+	ret, allocated, err := object.CallBuiltin(fn, args)
+	if v.calls != nil {
+		v.calls.OnBuiltinReturn(object.BuiltinName(fn), ret)
+	}
+	if err != nil {
+		return err
+	}
 	v.sp = v.sp - numArgs - 1
-	if ret != nil {
-		return v.pushStack(ret)
-	} else {
-		return v.pushStack(object.NULL)
+	if !allocated {
+		return v.pushStack(ValueOf(ret))
 	}
+	return v.pushAllocated(ret)
 }
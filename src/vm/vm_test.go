@@ -3,10 +3,18 @@ package vm
 import (
 	"ast"
 	"compiler"
+	"conformance"
+	"context"
+	"errors"
+	"evaluator"
 	"fmt"
+	"math/big"
 	"object"
 	"parser"
+	"strings"
 	"testing"
+	"time"
+	"token"
 )
 
 type vmTestCase struct {
@@ -71,6 +79,19 @@ func testNilObject(actual object.Object) error {
 	return nil
 }
 
+func keyObjectOf(key interface{}) (object.Object, error) {
+	switch key := key.(type) {
+	case int:
+		return &object.Integer{Value: int64(key)}, nil
+	case bool:
+		return object.NativeBooleanToBooleanObj(key), nil
+	case string:
+		return &object.String{Value: key}, nil
+	default:
+		return nil, fmt.Errorf("unhashable key type %T", key)
+	}
+}
+
 func testExpectedObject(t *testing.T, input string, expcet interface{}, actual object.Object) {
 	t.Helper()
 
@@ -80,6 +101,15 @@ func testExpectedObject(t *testing.T, input string, expcet interface{}, actual o
 		if err != nil {
 			t.Errorf("test integer object failed for input: %s. %s", input, err)
 		}
+	case *big.Int:
+		r, ok := actual.(*object.BigInteger)
+		if !ok {
+			t.Errorf("object is not object.BigInteger for input: %s. got=%T (%+v)", input, actual, actual)
+			return
+		}
+		if r.Value.Cmp(expected) != 0 {
+			t.Errorf("assert failed for input: %s. want=%s, got=%s", input, expected, r.Value)
+		}
 	case bool:
 		err := testBooleanObject(expected, actual)
 		if err != nil {
@@ -109,13 +139,25 @@ func testExpectedObject(t *testing.T, input string, expcet interface{}, actual o
 			t.Errorf("object is not object.HashTable. got=%T (%+v)", actual, actual)
 		}
 
-		if len(expected) != len(r.Pair) {
-			t.Errorf("hash length is not equal. want=%d got=%d", len(expected), len(r.Pair))
+		if len(expected) != r.Len() {
+			t.Errorf("hash length is not equal. want=%d got=%d", len(expected), r.Len())
 		}
 
-		// Hash 的测试有点麻烦，我不想让实现出来的 Map 仅仅是为了好测试而搞成有序的，非常丑
-		// 但不搞成有序的，actual 里面的 k v 都是 object.Object，在不知道对象类型的情况下无法去跟 expected 做对比
-		// 所以我决定不测了
+		for k, e := range expected {
+			key, err := keyObjectOf(k)
+			if err != nil {
+				t.Errorf("invalid expected key for input: %s. %s", input, err)
+				continue
+			}
+
+			hash, _ := r.KeyOf(key)
+			value, ok := r.Get(hash, key)
+			if !ok {
+				t.Errorf("no pair for key %v in hash for input: %s", k, input)
+				continue
+			}
+			testExpectedObject(t, input, e, value)
+		}
 	case nil:
 		err := testNilObject(actual)
 		if err != nil {
@@ -164,6 +206,43 @@ func runTests(t *testing.T, tests []vmTestCase) {
 	}
 }
 
+type vmErrorTestCase struct {
+	input         string
+	expectedError string
+}
+
+func runErrorTests(t *testing.T, tests []vmErrorTestCase) {
+	t.Helper()
+
+	for _, test := range tests {
+		program, err := parse(test.input)
+		if err != nil {
+			t.Fatalf("parse program failed. %s", err)
+		}
+
+		c := compiler.New()
+		err = c.Compile(program)
+		if err != nil {
+			t.Fatalf("compile program for input: %q failed. error is: %q", test.input, err)
+		}
+
+		v := New(c.Bytecode())
+		err = v.Run()
+		if err == nil {
+			t.Fatalf("expect error for input: %q, but run succeed", test.input)
+		}
+
+		rerr, ok := err.(*RuntimeError)
+		if !ok {
+			t.Fatalf("expect *RuntimeError for input: %q, got %T (%s)", test.input, err, err)
+		}
+
+		if rerr.Msg != test.expectedError {
+			t.Errorf("wrong error for input: %q. want=%q, got=%q", test.input, test.expectedError, rerr.Msg)
+		}
+	}
+}
+
 func TestIntegerArithmetic(t *testing.T) {
 	tests := []vmTestCase{
 		{"2", 2},
@@ -175,6 +254,43 @@ func TestIntegerArithmetic(t *testing.T) {
 	runTests(t, tests)
 }
 
+func bigInt(s string) *big.Int {
+	v, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		panic("invalid big integer " + s)
+	}
+	return v
+}
+
+func TestBigIntegerArithmetic(t *testing.T) {
+	tests := []vmTestCase{
+		{"9223372036854775807 + 1", bigInt("9223372036854775808")},
+		{"-9223372036854775807 - 2", bigInt("-9223372036854775809")},
+		{"4611686018427387904 * 4", bigInt("18446744073709551616")},
+		{"-(-9223372036854775807 - 1)", bigInt("9223372036854775808")},
+		{"(-9223372036854775807 - 1) / -1", bigInt("9223372036854775808")},
+		{"9223372036854775807 + 1 - 1", 9223372036854775807},
+		{"(9223372036854775807 * 3) / 3", 9223372036854775807},
+		{"(9223372036854775807 * 10 + 7) % 10", 7},
+		{"9223372036854775807 * 2 > 9223372036854775807", true},
+		{"9223372036854775807 + 1 == 9223372036854775807 + 1", true},
+		{"{9223372036854775807 + 1: 1}[9223372036854775807 + 1]", 1},
+		{"7 % 3", 1},
+		{"-7 % 3", -1},
+	}
+	runTests(t, tests)
+}
+
+func TestArithmeticErrors(t *testing.T) {
+	tests := []vmErrorTestCase{
+		{"1 / 0", "division by zero"},
+		{"1 % 0", "modulo by zero"},
+		{"(9223372036854775807 + 1) / 0", "division by zero"},
+		{"-true", `minus operator can not be used as prefix operator for BOOLEAN`},
+	}
+	runErrorTests(t, tests)
+}
+
 func TestStringOperator(t *testing.T) {
 	tests := []vmTestCase{
 		{"\"hello\" == \"hello\" ", true},
@@ -215,6 +331,22 @@ func TestLetStatement(t *testing.T) {
 	tests := []vmTestCase{
 		{"let a = 1; a;", 1},
 		{"let a = 1; let b = a;  b;", 1},
+		{"let a = 1; let a = a + 1; a", 2},
+		{"let f = fn() { let a = 1; let a = a * 10; a }; f()", 10},
+	}
+
+	runTests(t, tests)
+}
+
+func TestAssignment(t *testing.T) {
+	tests := []vmTestCase{
+		{"let a = 1; a = 2; a", 2},
+		{"let a = 1; a = a + 1", 2},
+		{"let a = 1; let f = fn() { a = a + 1 }; f(); f(); a", 3},
+		{"let f = fn(n) { n = n * 2; let g = fn() { n }; g() }; f(3)", 6},
+		{"let f = fn() { f = 1; 2 }; [f(), f]", []interface{}{2, 1}},
+		{"let a = 0; [(a = 1) < (a = 2), a]", []interface{}{true, 2}},
+		{"let f = fn(x) { x + (x = 5) }; f(1)", 6},
 	}
 
 	runTests(t, tests)
@@ -233,11 +365,77 @@ func TestArray(t *testing.T) {
 	runTests(t, tests)
 }
 
+func TestIndexAndSlice(t *testing.T) {
+	tests := []vmTestCase{
+		{"[1, 2, 3][-1]", 3},
+		{"[1, 2, 3][-3]", 1},
+		{"[1, 2, 3, 4][1:3]", []interface{}{2, 3}},
+		{"[1, 2, 3, 4][-2:]", []interface{}{3, 4}},
+		{"[1, 2, 3, 4][:0]", []interface{}{}},
+		{"[1, 2, 3, 4][:]", []interface{}{1, 2, 3, 4}},
+		{`"hello"[1]`, "e"},
+		{`"hello"[-1]`, "o"},
+		{`"hello"[2:]`, "llo"},
+		{`"hello"[:-2]`, "hel"},
+		{`"hello"[5:]`, ""},
+		{`"你好世界"[1]`, "好"},
+		{`"你好世界"[1:3]`, "好世"},
+	}
+
+	runTests(t, tests)
+}
+
+func TestIndexAndSliceErrors(t *testing.T) {
+	tests := []vmErrorTestCase{
+		{"[1, 2, 3][3]", "index out of range [3] with length 3"},
+		{"[1, 2, 3][-4]", "index out of range [-4] with length 3"},
+		{"[][0]", "index out of range [0] with length 0"},
+		{`"abc"[10]`, "index out of range [10] with length 3"},
+		{`"abc"["a"]`, `expect Integer for String Index, got "STRING"`},
+		{"[1, 2, 3][2:1]", "slice bounds out of range [2:1] with length 3"},
+		{"[1, 2, 3][:5]", "slice bounds out of range [:5] with length 3"},
+		{"[1, 2, 3][-5:]", "slice bounds out of range [-5:] with length 3"},
+		{"1[0:1]", `unsupported type for slice operator, got "INTEGER"`},
+		{"1[0]", `unsupported type for index operator, got "INTEGER"`},
+	}
+
+	runErrorTests(t, tests)
+}
+
 func TestHash(t *testing.T) {
 	tests := []vmTestCase{
 		{"{}", map[interface{}]interface{}{}},
-		// {`{1:"hello", 666 + 100:2 + 15, "haha":false, "s":"hello" + "world"}`, map[interface{}]interface{}{1: "hello", 766: 17, "haha": false, "s": "helloworld"}},
-		// {`{1:"hello", 666 + 100:2 + 15, "haha":false, "s":"hello" + "world"}[266 + 500]`, 17},
+		{`{1:"hello", 666 + 100:2 + 15, "haha":false, "s":"hello" + "world"}`, map[interface{}]interface{}{1: "hello", 766: 17, "haha": false, "s": "helloworld"}},
+		{`{1:"hello", 666 + 100:2 + 15, "haha":false, "s":"hello" + "world"}[266 + 500]`, 17},
+		{`{1:"first", 1:"second"}[1]`, "second"},
+	}
+
+	runTests(t, tests)
+}
+
+func TestStructuralEquality(t *testing.T) {
+	tests := []vmTestCase{
+		{"[1, 2, 3] == [1, 2, 3]", true},
+		{"[1, 2, 3] == [1, 2]", false},
+		{`[1, [2, "a"]] == [1, [2, "a"]]`, true},
+		{`[1, [2, "a"]] != [1, [2, "b"]]`, true},
+		{`{1: [1], "a": true} == {"a": true, 1: [1]}`, true},
+		{`{1: [1], "a": true} == {"a": false, 1: [1]}`, false},
+		{"{1: 2} == {2: 2}", false},
+		{"[1] == {1: 1}", false},
+		{"1 == true", false},
+	}
+
+	runTests(t, tests)
+}
+
+func TestCompositeHashKey(t *testing.T) {
+	tests := []vmTestCase{
+		{`{[1, 2]: "a", [2, 1]: "b"}[[1, 2]]`, "a"},
+		{`{[1, 2]: "a", [2, 1]: "b"}[[2, 1]]`, "b"},
+		{`let k = ["x", [true, 3]]; {k: 42}[["x", [true, 3]]]`, 42},
+		{`{[]: 1}[[]]`, 1},
+		{`{[1, 2]: "a"}[[1, "2"]]`, nil},
 	}
 
 	runTests(t, tests)
@@ -336,32 +534,30 @@ func TestBuiltinFunctions(t *testing.T) {
 		{`len("")`, 0},
 		{`len("four")`, 4},
 		{`len("hello world")`, 11},
-		{
-			`len(1)`,
-			&object.Error{Msg: "argument to `len` not supported, got INTEGER"},
-		},
-		{`len("one", "two")`,
-			&object.Error{Msg: "wrong number of arguments. expected=1, got=2"}},
 		{`len([1, 2, 3])`, 3},
 		{`len([])`, 0},
 		{`first([1, 2, 3])`, 1},
 		{`first([])`, nil},
-		{`first(1)`,
-			&object.Error{Msg: "wrong argument passed to function first. expected Array, got=\"INTEGER\""},
-		},
 		{`last([1, 2, 3])`, 3},
 		{`last([])`, nil},
-		{`last(1)`,
-			&object.Error{Msg: "wrong argument passed to function last. expected Array, got=\"INTEGER\""}},
 		{`rest([1, 2, 3])`, []interface{}{2, 3}},
 		{`rest([])`, nil},
 		{`push([], 1)`, []interface{}{1}},
-		{`push(1, 1)`,
-			&object.Error{Msg: "wrong argument passed to function push. expected Array, got=\"INTEGER\""}},
 	}
 	runTests(t, tests)
 }
 
+func TestBuiltinFunctionErrors(t *testing.T) {
+	tests := []vmErrorTestCase{
+		{`len(1)`, "argument to `len` not supported, got INTEGER"},
+		{`len("one", "two")`, "wrong number of arguments. expected=1, got=2"},
+		{`first(1)`, `wrong argument passed to function first. expected Array, got="INTEGER"`},
+		{`last(1)`, `wrong argument passed to function last. expected Array, got="INTEGER"`},
+		{`push(1, 1)`, `wrong argument passed to function push. expected Array, got="INTEGER"`},
+	}
+	runErrorTests(t, tests)
+}
+
 func TestClosures(t *testing.T) {
 	tests := []vmTestCase{
 		{
@@ -436,6 +632,15 @@ func TestClosures(t *testing.T) {
            `,
 			expected: 99,
 		},
+		{
+			input: `
+           let newSub = fn(a, b) {
+               fn() { a - b };
+           };
+           newSub(10, 1)();
+           `,
+			expected: 9,
+		},
 	}
 	runTests(t, tests)
 }
@@ -491,3 +696,357 @@ func TestRecursiveFunctions(t *testing.T) {
 
 	runTests(t, tests)
 }
+
+func TestRuntimeErrors(t *testing.T) {
+	tests := []vmErrorTestCase{
+		{"fn(a, b) { a }(1)", "wrong number of arguments: want=2 got=1"},
+		{"fn(a) { a }(1, 2)", "wrong number of arguments: want=1 got=2"},
+		{"1()", "calling non-function INTEGER"},
+		{"{[1, fn(x){x}]: 1}", "key type in HashLiteral is not Hashable. got \"ARRAY\""},
+		{"let f = fn() { 1 + f() }; f()", "call depth limit exceeded: more than 1023 nested calls"},
+	}
+	runErrorTests(t, tests)
+}
+
+func TestRuntimeErrorPosition(t *testing.T) {
+	tests := []struct {
+		input string
+		pos   token.Position
+	}{
+		{"1 / 0", token.Position{Line: 1, Column: 3}},
+		{"let a = 1;\nlet b = [1, 2];\nb[a + 2]", token.Position{Line: 3, Column: 2}},
+		{"let f = fn(x) {\n  x + true\n};\nf(1)", token.Position{Line: 2, Column: 5}},
+		{"let f = fn(x) {\n  x + 1\n};\nf(true)", token.Position{Line: 2, Column: 5}},
+		{"let f = fn(x) {\n  if (x > 1) { x }\n};\nf(true)", token.Position{Line: 2, Column: 9}},
+	}
+
+	for _, test := range tests {
+		program, err := parse(test.input)
+		if err != nil {
+			t.Fatalf("parse program failed. %s", err)
+		}
+
+		c := compiler.New()
+		err = c.Compile(program)
+		if err != nil {
+			t.Fatalf("compile program for input: %q failed. error is: %q", test.input, err)
+		}
+
+		err = New(c.Bytecode()).Run()
+		rerr, ok := err.(*RuntimeError)
+		if !ok {
+			t.Fatalf("expect *RuntimeError for input: %q, got %T (%v)", test.input, err, err)
+		}
+
+		if rerr.Pos != test.pos {
+			t.Errorf("wrong error position for input: %q. want=%+v, got=%+v", test.input, test.pos, rerr.Pos)
+		}
+	}
+}
+
+var crashLimits = object.Limits{MaxInstructions: 1000000}
+
+func runWithoutPanic(input string) error {
+	program, err := parse(input)
+	if err != nil {
+		return nil
+	}
+
+	c := compiler.New()
+	if err := c.Compile(program); err != nil {
+		return nil
+	}
+
+	// a tail call loops forever without a limit
+	err = New(c.Bytecode()).RunContext(context.Background(), crashLimits)
+	if err == nil || errors.Is(err, object.ErrInstructionLimit) {
+		return nil
+	}
+
+	rerr, ok := err.(*RuntimeError)
+	if !ok {
+		return fmt.Errorf("expect *RuntimeError for input: %q, got %T (%v)", input, err, err)
+	}
+	if strings.HasPrefix(rerr.Msg, "internal error") {
+		return fmt.Errorf("run program for input: %q panicked: %s", input, rerr)
+	}
+	return nil
+}
+
+func TestCrashCorpus(t *testing.T) {
+	for _, input := range conformance.CrashCorpus {
+		if err := runWithoutPanic(input); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func FuzzRun(f *testing.F) {
+	for _, input := range conformance.CrashCorpus {
+		f.Add(input)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		if err := runWithoutPanic(input); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestLimits(t *testing.T) {
+	exponential := "let f = fn(n) { if (n == 0) { 0 } else { f(n - 1) + f(n - 1) } }; f(40)"
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		input  string
+		ctx    context.Context
+		limits object.Limits
+		expect error
+	}{
+		{"let f = fn() { 1 + f() }; f()", context.Background(), object.Limits{}, object.ErrCallDepthLimit},
+		{"let f = fn(n) { 1 + f(n) }; f(1)", context.Background(), object.Limits{MaxCallDepth: 10}, object.ErrCallDepthLimit},
+		{"[1, 2, 3, 4, 5, 6]", context.Background(), object.Limits{MaxStackSize: 5}, object.ErrStackLimit},
+		{exponential, context.Background(), object.Limits{MaxInstructions: 1000}, object.ErrInstructionLimit},
+		{"[[1], [2], [3]]", context.Background(), object.Limits{MaxAllocations: 3}, object.ErrAllocationLimit},
+		{`let f = fn(s) { s + s + s }; f("abc")`, context.Background(), object.Limits{MaxAllocatedBytes: 50}, object.ErrMemoryLimit},
+		{exponential, context.Background(), object.Limits{Timeout: 10 * time.Millisecond}, object.ErrTimeLimit},
+		{exponential, canceled, object.Limits{}, object.ErrCanceled},
+	}
+
+	for _, test := range tests {
+		program, err := parse(test.input)
+		if err != nil {
+			t.Fatalf("parse program failed. %s", err)
+		}
+
+		c := compiler.New()
+		err = c.Compile(program)
+		if err != nil {
+			t.Fatalf("compile program for input: %q failed. error is: %q", test.input, err)
+		}
+
+		err = New(c.Bytecode()).RunContext(test.ctx, test.limits)
+		if !errors.Is(err, test.expect) {
+			t.Errorf("wrong error for input: %q. want=%q, got=%v", test.input, test.expect, err)
+		}
+	}
+}
+
+func TestLimitsNotExceeded(t *testing.T) {
+	input := "let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(100)"
+	program, err := parse(input)
+	if err != nil {
+		t.Fatalf("parse program failed. %s", err)
+	}
+
+	c := compiler.New()
+	err = c.Compile(program)
+	if err != nil {
+		t.Fatalf("compile program for input: %q failed. error is: %q", input, err)
+	}
+
+	v := New(c.Bytecode())
+	limits := object.Limits{MaxInstructions: 100000, MaxCallDepth: 101, MaxAllocations: 1000, Timeout: time.Minute}
+	err = v.RunContext(context.Background(), limits)
+	if err != nil {
+		t.Fatalf("run program for input: %q failed. error is: %q", input, err)
+	}
+	testExpectedObject(t, input, 100, v.StackLastTop())
+}
+
+func TestBuiltinResultsNotAllocated(t *testing.T) {
+	// first and last return an element of their argument, which is not a new object
+	input := "let a = [1]; let f = fn(n) { if (n == 0) { 0 } else { first(a) + last(a) + f(n - 1) } }; f(100)"
+	limits := object.Limits{MaxAllocations: 10}
+
+	program, err := parse(input)
+	if err != nil {
+		t.Fatalf("parse program failed. %s", err)
+	}
+
+	c := compiler.New()
+	err = c.Compile(program)
+	if err != nil {
+		t.Fatalf("compile program for input: %q failed. error is: %q", input, err)
+	}
+
+	v := New(c.Bytecode())
+	err = v.RunContext(context.Background(), limits)
+	if err != nil {
+		t.Fatalf("run program for input: %q failed. error is: %q", input, err)
+	}
+	testExpectedObject(t, input, 200, v.StackLastTop())
+
+	actual, err := runRegisterProgram(input, true, limits)
+	if err != nil {
+		t.Fatalf("run program for input: %q on the register vm failed. error is: %q", input, err)
+	}
+	testExpectedObject(t, input, 200, actual)
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `
+          let sum = fn(arr, acc) {
+              if (len(arr) == 0) {
+                  acc
+              } else {
+                  sum(rest(arr), acc + first(arr))
+              }
+          };
+          let build = fn(arr, n) {
+              if (n == 0) { return arr; }
+              build(push(arr, n), n - 1)
+          };
+          sum(build([], 5000), 0);
+          `,
+			expected: 12502500,
+		},
+		{
+			input: `
+          let countDown = fn(n) { if (n == 0) { return 0; } countDown(n - 1) };
+          countDown(100000);
+          `,
+			expected: 0,
+		},
+		{
+			input: `
+          let outer = fn(x) {
+              let inner = fn(y) { x + y };
+              inner(1)
+          };
+          [outer(1), outer(2)]
+          `,
+			expected: []interface{}{2, 3},
+		},
+		{"let f = fn(arr) { len(arr) }; f([1, 2]) + 1", 3},
+	}
+	runTests(t, tests)
+}
+
+func runProgram(input string, optimize bool) (object.Object, error) {
+	program, err := parse(input)
+	if err != nil {
+		return nil, err
+	}
+
+	c := compiler.New()
+	if !optimize {
+		c.DisableOptimizations()
+	}
+	err = c.Compile(program)
+	if err != nil {
+		return nil, err
+	}
+
+	v := New(c.Bytecode())
+	err = v.Run()
+	if err != nil {
+		return nil, err
+	}
+	return v.StackLastTop(), nil
+}
+
+func TestOptimizationKeepsResults(t *testing.T) {
+	tests := []string{
+		"1 + 2 * 3 - 4 / 2 % 3",
+		"-(1 - 2) * -3",
+		"9223372036854775807 + 1 - 1",
+		`"a" + "b" == "ab"`,
+		`!(1 < 2) != "a"`,
+		"[1, 2] == [1, 2]",
+		"if (true) { 10 } else { 20 }",
+		"if (1 > 2) { 10 }",
+		"if (!false) { let a = 1; a }",
+		"if (0) { 1 } else { 2 }",
+		"let f = fn(x) { if (x) { return 1; } else { return 2; } 3 }; [f(true), f(false)]",
+		"let f = fn() { return 1; 2 }; f()",
+		"let f = fn(x) { if (x > 2 * 5) { x } else { f(x + 10 / 2) } }; f(1)",
+		"let a = 1 + 1; let b = a * (3 - 1); b",
+		"return 1 + 1; 3",
+		"if (true) { return 1; }; 2",
+		"{1 + 1: 2 * 2}[2]",
+		"let f = fn(x) { [x + 1, x - 1] }; [f(9223372036854775807), f(-9223372036854775807 - 1), f(0)]",
+		`let f = fn(x) { x + "b" }; f("a")`,
+		`let f = fn(x, y) { [if (x == y) { 1 } else { 2 }, if (x != y) { 1 } else { 2 }] }; [f(1, 1), f("a", "b"), f(true, true), f([1], [1])]`,
+		"let f = fn(x, y) { [if (x > y) { 1 } else { 2 }, if (x >= y) { 1 } else { 2 }] }; [f(1, 2), f(2, 2), f(9223372036854775807 + 1, 1)]",
+		"!(1 == 2)",
+	}
+
+	for _, input := range tests {
+		program, err := parse(input)
+		if err != nil {
+			t.Fatalf("parse program failed. %s", err)
+		}
+
+		expect := evaluator.Eval(program, object.NewEnvironment())
+		if evaluator.IsError(expect) {
+			t.Fatalf("evaluate program for input: %q failed. %s", input, expect.Inspect())
+		}
+
+		for _, optimize := range []bool{false, true} {
+			actual, err := runProgram(input, optimize)
+			if err != nil {
+				t.Fatalf("run program for input: %q failed with optimize=%t. error is: %q", input, optimize, err)
+			}
+
+			if actual.Inspect() != expect.Inspect() {
+				t.Errorf("wrong result for input: %q with optimize=%t. want=%s, got=%s",
+					input, optimize, expect.Inspect(), actual.Inspect())
+			}
+		}
+	}
+}
+
+func TestOptimizationKeepsErrors(t *testing.T) {
+	tests := []string{
+		"1 / 0",
+		"let f = fn(x) { x / (2 - 2) }; f(1)",
+		"if (true) { 1 + true }",
+		"-true",
+	}
+
+	for _, input := range tests {
+		_, unoptimizedErr := runProgram(input, false)
+		_, optimizedErr := runProgram(input, true)
+		if unoptimizedErr == nil || optimizedErr == nil {
+			t.Fatalf("expect error for input: %q. got %v and %v", input, unoptimizedErr, optimizedErr)
+		}
+
+		if unoptimizedErr.Error() != optimizedErr.Error() {
+			t.Errorf("wrong error for input: %q. want=%q, got=%q", input, unoptimizedErr, optimizedErr)
+		}
+	}
+}
+
+func TestHashSeedWithGlobals(t *testing.T) {
+	constants := []object.Object{}
+	symbolTable := compiler.NewSymbolTable()
+	globals := make([]Value, GlobalSize)
+
+	// the hash made under the first seed is read under the second one
+	for _, test := range []vmTestCase{{`let h = {"a": 1, [2]: 3}; h["a"]`, 1}, {`h[[2]] + h["a"]`, 4}} {
+		program, err := parse(test.input)
+		if err != nil {
+			t.Fatalf("parse program failed. %s", err)
+		}
+
+		c := compiler.NewWithStates(constants, symbolTable)
+		err = c.Compile(program)
+		if err != nil {
+			t.Fatalf("compile program for input: %q failed. error is: %q", test.input, err)
+		}
+		bytecode := c.Bytecode()
+		constants = bytecode.Constants
+
+		v := NewWithGlobals(bytecode, globals)
+		v.SetHashSeed(object.RandomHashSeed())
+		err = v.Run()
+		if err != nil {
+			t.Fatalf("run program for input: %q failed. error is: %q", test.input, err)
+		}
+		testExpectedObject(t, test.input, test.expected, v.StackLastTop())
+	}
+}